@@ -0,0 +1,242 @@
+// Package dnsconsistency contains the DNS consistency network experiment. We
+// resolve the input domain using the system resolver and a list of control
+// resolvers, then we compare the results to detect DNS based censorship.
+package dnsconsistency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ooni/probe-engine/experiment/urlgetter"
+	"github.com/ooni/probe-engine/geoiplookup/mmdblookup"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/httptransport"
+	"github.com/ooni/probe-engine/netx/resolver"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+const (
+	testName    = "dns_consistency"
+	testVersion = "0.1.0"
+
+	// SystemResolverURL is the URL describing the system resolver.
+	SystemResolverURL = "system:///"
+
+	// DefaultControlResolvers is the default list of control resolvers.
+	DefaultControlResolvers = ("udp://8.8.8.8:53 tcp://8.8.8.8:53 " +
+		"dot://dns.google:853 https://cloudflare-dns.com/dns-query")
+)
+
+// Config contains the experiment config.
+type Config struct {
+	ControlResolvers string `ooni:"Space separated list of control resolver URLs"`
+}
+
+// ResolverResult contains the result of resolving the input
+// domain using a specific resolver.
+type ResolverResult struct {
+	Addresses     []string                   `json:"addresses"`
+	Class         string                     `json:"class,omitempty"`
+	Failure       *string                    `json:"failure"`
+	NetworkEvents []archival.NetworkEvent    `json:"network_events"`
+	Queries       []archival.DNSQueryEntry   `json:"queries"`
+	ResolverURL   string                     `json:"resolver_url"`
+	TCPConnect    []archival.TCPConnectEntry `json:"tcp_connect"`
+	TLSHandshakes []archival.TLSHandshake    `json:"tls_handshakes"`
+}
+
+// TestKeys contains the experiment test keys.
+type TestKeys struct {
+	Control []ResolverResult `json:"control"`
+	Result  string           `json:"result"`
+	System  ResolverResult   `json:"system"`
+}
+
+const (
+	classConsistentSameASN          = "consistent.same_asn"
+	classConsistentSameFailure      = "consistent.same_failure"
+	classConsistentSameIP           = "consistent.same_ip"
+	classInconsistentBogon          = "inconsistent.bogon"
+	classInconsistentDifferentASN   = "inconsistent.different_asn"
+	classInconsistentDifferentError = "inconsistent.different_failure"
+	classAnomalyControlFailure      = "anomaly.control_failure"
+
+	// ResultConsistent indicates that at least a control agrees
+	// with the system resolver.
+	ResultConsistent = "consistent"
+
+	// ResultInconsistent indicates that no control agrees with
+	// the system resolver and at least one disagrees.
+	ResultInconsistent = "inconsistent"
+
+	// ResultAnomaly indicates that we could not compare the
+	// system resolver with any control.
+	ResultAnomaly = "anomaly"
+)
+
+// Classify compares the result of a control resolver with the result of
+// the system resolver and returns the class of the comparison.
+func Classify(system, control ResolverResult, lookupASN mmdblookup.ASNLookupFunc) string {
+	if control.Failure != nil {
+		if system.Failure != nil && *system.Failure == *control.Failure {
+			return classConsistentSameFailure
+		}
+		if system.Failure != nil {
+			return classInconsistentDifferentError
+		}
+		return classAnomalyControlFailure
+	}
+	if system.Failure != nil {
+		return classInconsistentDifferentError
+	}
+	controlAddrs := make(map[string]bool)
+	controlASNs := make(map[uint]bool)
+	for _, addr := range control.Addresses {
+		controlAddrs[addr] = true
+		if asn, err := lookupASN(addr); err == nil && asn != 0 {
+			controlASNs[asn] = true
+		}
+	}
+	for _, addr := range system.Addresses {
+		if resolver.IsBogon(addr) && !controlAddrs[addr] {
+			return classInconsistentBogon
+		}
+	}
+	for _, addr := range system.Addresses {
+		if controlAddrs[addr] {
+			return classConsistentSameIP
+		}
+	}
+	for _, addr := range system.Addresses {
+		if asn, err := lookupASN(addr); err == nil && asn != 0 && controlASNs[asn] {
+			return classConsistentSameASN
+		}
+	}
+	return classInconsistentDifferentASN
+}
+
+// Summarize returns the overall result given the classes
+// of all the control resolvers.
+func Summarize(controls []ResolverResult) string {
+	result := ResultAnomaly
+	for _, control := range controls {
+		if strings.HasPrefix(control.Class, "consistent.") {
+			return ResultConsistent
+		}
+		if strings.HasPrefix(control.Class, "inconsistent.") {
+			result = ResultInconsistent
+		}
+	}
+	return result
+}
+
+// Lookup resolves domain using the resolver described by resolverURL. We
+// construct the resolver like urlgetter does, hence we support the same
+// resolver URLs and we save the same network events.
+func Lookup(
+	ctx context.Context, begin time.Time, logger model.Logger,
+	resolverURL, domain string,
+) ResolverResult {
+	result := ResolverResult{ResolverURL: resolverURL}
+	saver := new(trace.Saver)
+	configuration, err := urlgetter.Configurer{
+		Config: urlgetter.Config{ResolverURL: resolverURL},
+		Logger: logger,
+		Saver:  saver,
+	}.NewConfiguration()
+	if err != nil {
+		result.Failure = archival.NewFailure(err)
+		return result
+	}
+	defer configuration.CloseIdleConnections()
+	reso := httptransport.NewResolver(configuration.HTTPConfig)
+	result.Addresses, err = reso.LookupHost(ctx, domain)
+	result.Failure = archival.NewFailure(err)
+	configuration.WaitLateReplies()
+	events := saver.Read()
+	result.NetworkEvents = archival.NewNetworkEventsList(begin, events)
+	result.Queries = archival.NewDNSQueriesList(begin, events)
+	result.TCPConnect = archival.NewTCPConnectList(begin, events)
+	result.TLSHandshakes = archival.NewTLSHandshakesList(begin, events)
+	return result
+}
+
+var (
+	// ErrNoInput indicates that no input was provided.
+	ErrNoInput = errors.New("no input provided")
+
+	// ErrNoControlResolvers indicates there are no control resolvers.
+	ErrNoControlResolvers = errors.New("no control resolvers")
+)
+
+type measurer struct {
+	config Config
+}
+
+func (m *measurer) ExperimentName() string {
+	return testName
+}
+
+func (m *measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+func registerExtensions(m *model.Measurement) {
+	archival.ExtDNS.AddTo(m)
+	archival.ExtNetevents.AddTo(m)
+	archival.ExtTCPConnect.AddTo(m)
+	archival.ExtTLSHandshake.AddTo(m)
+}
+
+func (m *measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	if measurement.Input == "" {
+		return ErrNoInput
+	}
+	controls := strings.Fields(m.config.ControlResolvers)
+	if len(controls) <= 0 {
+		return ErrNoControlResolvers
+	}
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	registerExtensions(measurement)
+	domain := string(measurement.Input)
+	begin := measurement.MeasurementStartTimeSaved
+	if begin.IsZero() {
+		begin = time.Now()
+	}
+	tk := new(TestKeys)
+	measurement.TestKeys = tk
+	total := float64(len(controls) + 1)
+	tk.System = Lookup(ctx, begin, sess.Logger(), SystemResolverURL, domain)
+	callbacks.OnProgress(1/total, fmt.Sprintf(
+		"dns_consistency: %s: %s", SystemResolverURL, errString(tk.System.Failure)))
+	for idx, resolverURL := range controls {
+		result := Lookup(ctx, begin, sess.Logger(), resolverURL, domain)
+		result.Class = Classify(tk.System, result, sess.LookupASN)
+		tk.Control = append(tk.Control, result)
+		callbacks.OnProgress(float64(idx+2)/total, fmt.Sprintf(
+			"dns_consistency: %s: %s", resolverURL, result.Class))
+	}
+	tk.Result = Summarize(tk.Control)
+	return nil
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return &measurer{config: config}
+}
+
+func errString(failure *string) (s string) {
+	s = "success"
+	if failure != nil {
+		s = *failure
+	}
+	return
+}
@@ -0,0 +1,247 @@
+package dnsconsistency_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/dnsconsistency"
+	"github.com/ooni/probe-engine/experiment/handler"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestUnitNewExperimentMeasurer(t *testing.T) {
+	measurer := dnsconsistency.NewExperimentMeasurer(dnsconsistency.Config{})
+	if measurer.ExperimentName() != "dns_consistency" {
+		t.Fatal("unexpected name")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected version")
+	}
+}
+
+func TestUnitMeasureWithNoInput(t *testing.T) {
+	measurer := dnsconsistency.NewExperimentMeasurer(dnsconsistency.Config{
+		ControlResolvers: dnsconsistency.DefaultControlResolvers,
+	})
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		new(model.Measurement),
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if !errors.Is(err, dnsconsistency.ErrNoInput) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitMeasureWithNoControlResolvers(t *testing.T) {
+	measurer := dnsconsistency.NewExperimentMeasurer(dnsconsistency.Config{})
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		&model.Measurement{Input: "example.com"},
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if !errors.Is(err, dnsconsistency.ErrNoControlResolvers) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitMeasureWithLocalhost(t *testing.T) {
+	measurer := dnsconsistency.NewExperimentMeasurer(dnsconsistency.Config{
+		ControlResolvers: "system:/// antani://",
+	})
+	measurement := &model.Measurement{Input: "localhost"}
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*dnsconsistency.TestKeys)
+	if tk.System.Failure != nil {
+		t.Fatal(*tk.System.Failure)
+	}
+	if len(tk.System.Queries) <= 0 {
+		t.Fatal("expected some queries")
+	}
+	if len(tk.Control) != 2 {
+		t.Fatal("unexpected number of controls")
+	}
+	if tk.Control[0].Class != "consistent.same_ip" {
+		t.Fatal("unexpected class for first control")
+	}
+	if tk.Control[1].Class != "anomaly.control_failure" {
+		t.Fatal("unexpected class for second control")
+	}
+	if tk.Result != dnsconsistency.ResultConsistent {
+		t.Fatal("unexpected result")
+	}
+}
+
+func TestUnitLookupSavesNetworkEvents(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		// close each connection without answering the query
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	result := dnsconsistency.Lookup(context.Background(), time.Now(), log.Log,
+		"tcp://"+listener.Addr().String(), "example.com")
+	if result.Failure == nil {
+		t.Fatal("expected a failure here")
+	}
+	if len(result.Queries) <= 0 {
+		t.Fatal("expected some queries")
+	}
+	if len(result.TCPConnect) <= 0 || result.TCPConnect[0].Status.Failure != nil {
+		t.Fatal("expected a successful connect")
+	}
+	if len(result.NetworkEvents) <= 0 {
+		t.Fatal("expected some network events")
+	}
+}
+
+func TestUnitLookupWithInvalidResolverURL(t *testing.T) {
+	for _, URL := range []string{"antani://", "\t"} {
+		result := dnsconsistency.Lookup(
+			context.Background(), time.Now(), log.Log, URL, "example.com")
+		if result.Failure == nil {
+			t.Fatal("expected a failure here")
+		}
+		if result.ResolverURL != URL {
+			t.Fatal("unexpected ResolverURL")
+		}
+	}
+}
+
+func failure(s string) *string {
+	return &s
+}
+
+func TestUnitClassify(t *testing.T) {
+	asns := mockable.ASNDatabase{
+		"93.184.216.34": 15133,
+		"93.184.216.35": 15133,
+		"1.1.1.1":       13335,
+	}
+	var tests = []struct {
+		name    string
+		system  dnsconsistency.ResolverResult
+		control dnsconsistency.ResolverResult
+		class   string
+	}{{
+		name:    "same failure",
+		system:  dnsconsistency.ResolverResult{Failure: failure("dns_nxdomain_error")},
+		control: dnsconsistency.ResolverResult{Failure: failure("dns_nxdomain_error")},
+		class:   "consistent.same_failure",
+	}, {
+		name:    "different failure",
+		system:  dnsconsistency.ResolverResult{Failure: failure("dns_nxdomain_error")},
+		control: dnsconsistency.ResolverResult{Failure: failure("generic_timeout_error")},
+		class:   "inconsistent.different_failure",
+	}, {
+		name:    "only system failure",
+		system:  dnsconsistency.ResolverResult{Failure: failure("dns_nxdomain_error")},
+		control: dnsconsistency.ResolverResult{Addresses: []string{"93.184.216.34"}},
+		class:   "inconsistent.different_failure",
+	}, {
+		name:    "only control failure",
+		system:  dnsconsistency.ResolverResult{Addresses: []string{"93.184.216.34"}},
+		control: dnsconsistency.ResolverResult{Failure: failure("generic_timeout_error")},
+		class:   "anomaly.control_failure",
+	}, {
+		name:    "bogon",
+		system:  dnsconsistency.ResolverResult{Addresses: []string{"10.10.34.35"}},
+		control: dnsconsistency.ResolverResult{Addresses: []string{"93.184.216.34"}},
+		class:   "inconsistent.bogon",
+	}, {
+		name:    "same IP",
+		system:  dnsconsistency.ResolverResult{Addresses: []string{"93.184.216.34"}},
+		control: dnsconsistency.ResolverResult{Addresses: []string{"93.184.216.34"}},
+		class:   "consistent.same_ip",
+	}, {
+		name:    "same ASN",
+		system:  dnsconsistency.ResolverResult{Addresses: []string{"93.184.216.35"}},
+		control: dnsconsistency.ResolverResult{Addresses: []string{"93.184.216.34"}},
+		class:   "consistent.same_asn",
+	}, {
+		name:    "different ASN",
+		system:  dnsconsistency.ResolverResult{Addresses: []string{"1.1.1.1"}},
+		control: dnsconsistency.ResolverResult{Addresses: []string{"93.184.216.34"}},
+		class:   "inconsistent.different_asn",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if class := dnsconsistency.Classify(tt.system, tt.control, asns.LookupASN); class != tt.class {
+				t.Fatalf("expected %s but got %s", tt.class, class)
+			}
+		})
+	}
+}
+
+func TestUnitSummarize(t *testing.T) {
+	if dnsconsistency.Summarize(nil) != dnsconsistency.ResultAnomaly {
+		t.Fatal("unexpected result with no controls")
+	}
+	if dnsconsistency.Summarize([]dnsconsistency.ResolverResult{
+		{Class: "anomaly.control_failure"},
+		{Class: "inconsistent.bogon"},
+	}) != dnsconsistency.ResultInconsistent {
+		t.Fatal("unexpected result with inconsistent controls")
+	}
+	if dnsconsistency.Summarize([]dnsconsistency.ResolverResult{
+		{Class: "inconsistent.different_asn"},
+		{Class: "consistent.same_asn"},
+	}) != dnsconsistency.ResultConsistent {
+		t.Fatal("unexpected result with a consistent control")
+	}
+}
+
+func TestIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	measurer := dnsconsistency.NewExperimentMeasurer(dnsconsistency.Config{
+		ControlResolvers: dnsconsistency.DefaultControlResolvers,
+	})
+	measurement := &model.Measurement{
+		Input:                     "www.example.com",
+		MeasurementStartTimeSaved: time.Now(),
+	}
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*dnsconsistency.TestKeys)
+	for _, control := range tk.Control {
+		if control.Failure != nil {
+			t.Fatalf("%s: %s", control.ResolverURL, *control.Failure)
+		}
+	}
+	if tk.Result != dnsconsistency.ResultConsistent {
+		t.Fatal("unexpected result")
+	}
+}
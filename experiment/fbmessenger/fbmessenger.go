@@ -1,23 +1,184 @@
-// Package fbmessenger contains the Facebook Messenger network experiment.
+// Package fbmessenger contains the Facebook Messenger network experiment. This
+// file in particular is a pure-Go implementation of that.
+//
+// See https://github.com/ooni/spec/blob/master/nettests/ts-019-facebook-messenger.md.
 package fbmessenger
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"time"
 
-	"github.com/ooni/probe-engine/experiment/mkrunner"
-	"github.com/ooni/probe-engine/measurementkit"
+	"github.com/ooni/probe-engine/atomicx"
+	"github.com/ooni/probe-engine/experiment/urlgetter"
+	"github.com/ooni/probe-engine/geoiplookup/mmdblookup"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/archival"
 )
 
 const (
 	testName    = "facebook_messenger"
-	testVersion = "0.0.2"
+	testVersion = "0.1.0"
+
+	// FacebookASN is Facebook's ASN.
+	FacebookASN = 32934
+
+	// ServiceSTUN is the STUN service. We only check whether its
+	// DNS is consistent because we cannot easily TCP connect to it.
+	ServiceSTUN = "dnslookup://stun.fbsbx.com"
+
+	// ServiceBAPI is the b-api service.
+	ServiceBAPI = "tcpconnect://b-api.facebook.com:443"
+
+	// ServiceBGraph is the b-graph service.
+	ServiceBGraph = "tcpconnect://b-graph.facebook.com:443"
+
+	// ServiceEdge is the edge service.
+	ServiceEdge = "tcpconnect://edge-mqtt.facebook.com:443"
+
+	// ServiceExternalCDN is the external CDN service.
+	ServiceExternalCDN = "tcpconnect://external.xx.fbcdn.net:443"
+
+	// ServiceScontentCDN is the scontent CDN service.
+	ServiceScontentCDN = "tcpconnect://scontent.xx.fbcdn.net:443"
+
+	// ServiceStar is the star service.
+	ServiceStar = "tcpconnect://star.c10r.facebook.com:443"
 )
 
+// Services contains all the services we measure.
+var Services = []string{
+	ServiceSTUN, ServiceBAPI, ServiceBGraph, ServiceEdge,
+	ServiceExternalCDN, ServiceScontentCDN, ServiceStar,
+}
+
 // Config contains the experiment config.
-type Config struct {
-	// LogLevel is the MK log level. Empty implies "WARNING".
-	LogLevel string
+type Config struct{}
+
+// TestKeys contains the experiment results.
+type TestKeys struct {
+	Agent         string                     `json:"agent"`
+	Queries       []archival.DNSQueryEntry   `json:"queries"`
+	TCPConnect    []archival.TCPConnectEntry `json:"tcp_connect"`
+	NetworkEvents []archival.NetworkEvent    `json:"network_events"`
+
+	FacebookBAPIDNSConsistent        *bool `json:"facebook_b_api_dns_consistent"`
+	FacebookBAPIReachable            *bool `json:"facebook_b_api_reachable"`
+	FacebookBGraphDNSConsistent      *bool `json:"facebook_b_graph_dns_consistent"`
+	FacebookBGraphReachable          *bool `json:"facebook_b_graph_reachable"`
+	FacebookEdgeDNSConsistent        *bool `json:"facebook_edge_dns_consistent"`
+	FacebookEdgeReachable            *bool `json:"facebook_edge_reachable"`
+	FacebookExternalCDNDNSConsistent *bool `json:"facebook_external_cdn_dns_consistent"`
+	FacebookExternalCDNReachable     *bool `json:"facebook_external_cdn_reachable"`
+	FacebookScontentCDNDNSConsistent *bool `json:"facebook_scontent_cdn_dns_consistent"`
+	FacebookScontentCDNReachable     *bool `json:"facebook_scontent_cdn_reachable"`
+	FacebookStarDNSConsistent        *bool `json:"facebook_star_dns_consistent"`
+	FacebookStarReachable            *bool `json:"facebook_star_reachable"`
+	FacebookSTUNDNSConsistent        *bool `json:"facebook_stun_dns_consistent"`
+	FacebookSTUNReachable            *bool `json:"facebook_stun_reachable"`
+	FacebookDNSBlocking              *bool `json:"facebook_dns_blocking"`
+	FacebookTCPBlocking              *bool `json:"facebook_tcp_blocking"`
+}
+
+// MeasurementResult is the result of measuring a single service.
+type MeasurementResult struct {
+	Target   string
+	TestKeys urlgetter.TestKeys
+	Err      error
+}
+
+func (tk *TestKeys) fields(target string) (dnsConsistent, reachable **bool) {
+	switch target {
+	case ServiceSTUN:
+		return &tk.FacebookSTUNDNSConsistent, &tk.FacebookSTUNReachable
+	case ServiceBAPI:
+		return &tk.FacebookBAPIDNSConsistent, &tk.FacebookBAPIReachable
+	case ServiceBGraph:
+		return &tk.FacebookBGraphDNSConsistent, &tk.FacebookBGraphReachable
+	case ServiceEdge:
+		return &tk.FacebookEdgeDNSConsistent, &tk.FacebookEdgeReachable
+	case ServiceExternalCDN:
+		return &tk.FacebookExternalCDNDNSConsistent, &tk.FacebookExternalCDNReachable
+	case ServiceScontentCDN:
+		return &tk.FacebookScontentCDNDNSConsistent, &tk.FacebookScontentCDNReachable
+	case ServiceStar:
+		return &tk.FacebookStarDNSConsistent, &tk.FacebookStarReachable
+	default:
+		return nil, nil
+	}
+}
+
+// Update updates the TestKeys using the given MeasurementResult. The
+// lookupASN function is used to check whether the resolved addresses
+// actually belong to Facebook. When we cannot map the addresses to
+// their ASN, the DNS consistency of the service is unknown (nil).
+func (tk *TestKeys) Update(v MeasurementResult, lookupASN mmdblookup.ASNLookupFunc) {
+	tk.Agent = "redirect"
+	tk.Queries = append(tk.Queries, v.TestKeys.Queries...)
+	tk.TCPConnect = append(tk.TCPConnect, v.TestKeys.TCPConnect...)
+	tk.NetworkEvents = append(tk.NetworkEvents, v.TestKeys.NetworkEvents...)
+	dnsConsistent, reachable := tk.fields(v.Target)
+	if dnsConsistent == nil || reachable == nil {
+		return
+	}
+	consistent := dnsConsistency(v.TestKeys.Queries, lookupASN)
+	*dnsConsistent = consistent
+	if consistent != nil && !*consistent {
+		tk.FacebookDNSBlocking = boolPointer(true)
+	}
+	if v.Target == ServiceSTUN {
+		return
+	}
+	// When the DNS is not consistent, connecting means connecting
+	// to some other host, hence it does not count as reachable. When
+	// we don't know, we only consider whether we could connect.
+	ok := (consistent == nil || *consistent) && v.Err == nil
+	*reachable = &ok
+	if !ok {
+		tk.FacebookTCPBlocking = boolPointer(true)
+	}
+}
+
+// dnsConsistency returns true when there is at least an address and all
+// the addresses we resolved belong to Facebook's ASN, false when some
+// address does not belong to Facebook's ASN or we have no addresses, and
+// nil when we cannot map some address to its ASN.
+func dnsConsistency(queries []archival.DNSQueryEntry, lookupASN mmdblookup.ASNLookupFunc) *bool {
+	var (
+		count   int
+		unknown bool
+	)
+	for _, query := range queries {
+		for _, answer := range query.Answers {
+			for _, addr := range []string{answer.IPv4, answer.IPv6} {
+				if addr == "" {
+					continue
+				}
+				asn, err := lookupASN(addr)
+				if err != nil {
+					unknown = true
+					continue
+				}
+				if asn != FacebookASN {
+					return boolPointer(false)
+				}
+				count++
+			}
+		}
+	}
+	if unknown {
+		return nil
+	}
+	return boolPointer(count > 0)
+}
+
+// NewTestKeys returns a new instance of the test keys.
+func NewTestKeys() *TestKeys {
+	return &TestKeys{
+		FacebookDNSBlocking: boolPointer(false),
+		FacebookTCPBlocking: boolPointer(false),
+	}
 }
 
 type measurer struct {
@@ -32,22 +193,63 @@ func (m *measurer) ExperimentVersion() string {
 	return testVersion
 }
 
+func registerExtensions(m *model.Measurement) {
+	archival.ExtDNS.AddTo(m)
+	archival.ExtNetevents.AddTo(m)
+	archival.ExtTCPConnect.AddTo(m)
+}
+
 func (m *measurer) Run(
 	ctx context.Context, sess model.ExperimentSession,
 	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
 ) error {
-	settings := measurementkit.NewSettings(
-		"FacebookMessenger", sess.SoftwareName(), sess.SoftwareVersion(),
-		sess.CABundlePath(), sess.ProbeASNString(), sess.ProbeCC(),
-		sess.ProbeIP(), sess.ProbeNetworkName(), m.config.LogLevel,
-	)
-	settings.Options.GeoIPASNPath = sess.ASNDatabasePath()
-	return mkrunner.Do(
-		settings, sess, measurement, callbacks, measurementkit.StartEx,
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	registerExtensions(measurement)
+	results := make([]MeasurementResult, len(Services))
+	var (
+		completed = atomicx.NewInt64()
+		waitgroup sync.WaitGroup
 	)
+	waitgroup.Add(len(Services))
+	for idx, target := range Services {
+		go func(idx int, target string) {
+			defer waitgroup.Done()
+			tk, err := urlgetter.Getter{
+				Begin:   measurement.MeasurementStartTimeSaved,
+				Session: sess,
+				Target:  target,
+			}.Get(ctx)
+			results[idx] = MeasurementResult{Target: target, TestKeys: tk, Err: err}
+			sofar := completed.Add(1)
+			percentage := float64(sofar) / float64(len(Services))
+			callbacks.OnProgress(percentage, fmt.Sprintf(
+				"facebook_messenger: access %s: %s", target, errString(err),
+			))
+		}(idx, target)
+	}
+	waitgroup.Wait()
+	testkeys := NewTestKeys()
+	for _, result := range results {
+		testkeys.Update(result, sess.LookupASN)
+	}
+	measurement.TestKeys = testkeys
+	return nil
 }
 
 // NewExperimentMeasurer creates a new ExperimentMeasurer.
 func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
 	return &measurer{config: config}
 }
+
+func boolPointer(v bool) *bool {
+	return &v
+}
+
+func errString(err error) (s string) {
+	s = "success"
+	if err != nil {
+		s = err.Error()
+	}
+	return
+}
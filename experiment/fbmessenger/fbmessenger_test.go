@@ -1,18 +1,188 @@
 package fbmessenger_test
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"testing"
 
+	"github.com/apex/log"
 	"github.com/ooni/probe-engine/experiment/fbmessenger"
-	"github.com/ooni/probe-engine/experiment/mktesting"
+	"github.com/ooni/probe-engine/experiment/handler"
+	"github.com/ooni/probe-engine/experiment/urlgetter"
+	"github.com/ooni/probe-engine/internal/mockable"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/archival"
 )
 
+func TestUnitNewExperimentMeasurer(t *testing.T) {
+	measurer := fbmessenger.NewExperimentMeasurer(fbmessenger.Config{})
+	if measurer.ExperimentName() != "facebook_messenger" {
+		t.Fatal("unexpected name")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected version")
+	}
+}
+
+var asnDatabase = mockable.ASNDatabase{
+	"10.0.0.1":    0,
+	"157.240.1.1": fbmessenger.FacebookASN,
+}
+
+func newQueries(addrs ...string) []archival.DNSQueryEntry {
+	var answers []archival.DNSAnswerEntry
+	for _, addr := range addrs {
+		answers = append(answers, archival.DNSAnswerEntry{
+			AnswerType: "A",
+			IPv4:       addr,
+		})
+	}
+	return []archival.DNSQueryEntry{{Answers: answers, QueryType: "A"}}
+}
+
+func TestUnitTestKeysAllGood(t *testing.T) {
+	tk := fbmessenger.NewTestKeys()
+	for _, target := range fbmessenger.Services {
+		tk.Update(fbmessenger.MeasurementResult{
+			Target:   target,
+			TestKeys: urlgetter.TestKeys{Queries: newQueries("157.240.1.1")},
+		}, asnDatabase.LookupASN)
+	}
+	if *tk.FacebookDNSBlocking != false {
+		t.Fatal("unexpected DNS blocking")
+	}
+	if *tk.FacebookTCPBlocking != false {
+		t.Fatal("unexpected TCP blocking")
+	}
+	if *tk.FacebookBAPIDNSConsistent != true || *tk.FacebookBAPIReachable != true {
+		t.Fatal("unexpected b_api result")
+	}
+	if *tk.FacebookSTUNDNSConsistent != true || tk.FacebookSTUNReachable != nil {
+		t.Fatal("unexpected stun result")
+	}
+}
+
+func TestUnitTestKeysDNSInconsistent(t *testing.T) {
+	tk := fbmessenger.NewTestKeys()
+	tk.Update(fbmessenger.MeasurementResult{
+		Target:   fbmessenger.ServiceEdge,
+		TestKeys: urlgetter.TestKeys{Queries: newQueries("10.0.0.1")},
+	}, asnDatabase.LookupASN)
+	if *tk.FacebookDNSBlocking != true {
+		t.Fatal("expected DNS blocking")
+	}
+	if *tk.FacebookEdgeDNSConsistent != false {
+		t.Fatal("expected inconsistent DNS")
+	}
+	if *tk.FacebookEdgeReachable != false {
+		t.Fatal("expected not reachable")
+	}
+}
+
+func TestUnitTestKeysTCPBlocking(t *testing.T) {
+	tk := fbmessenger.NewTestKeys()
+	failure := "connection_refused"
+	tk.Update(fbmessenger.MeasurementResult{
+		Target: fbmessenger.ServiceStar,
+		TestKeys: urlgetter.TestKeys{
+			Failure: &failure,
+			Queries: newQueries("157.240.1.1"),
+		},
+		Err: errors.New(failure),
+	}, asnDatabase.LookupASN)
+	if *tk.FacebookDNSBlocking != false {
+		t.Fatal("unexpected DNS blocking")
+	}
+	if *tk.FacebookTCPBlocking != true {
+		t.Fatal("expected TCP blocking")
+	}
+	if *tk.FacebookStarDNSConsistent != true || *tk.FacebookStarReachable != false {
+		t.Fatal("unexpected star result")
+	}
+}
+
+func TestUnitTestKeysNoAddresses(t *testing.T) {
+	tk := fbmessenger.NewTestKeys()
+	failure := "dns_nxdomain_error"
+	tk.Update(fbmessenger.MeasurementResult{
+		Target:   fbmessenger.ServiceSTUN,
+		TestKeys: urlgetter.TestKeys{Failure: &failure},
+		Err:      errors.New(failure),
+	}, asnDatabase.LookupASN)
+	if *tk.FacebookDNSBlocking != true {
+		t.Fatal("expected DNS blocking")
+	}
+	if *tk.FacebookSTUNDNSConsistent != false {
+		t.Fatal("expected inconsistent DNS")
+	}
+}
+
+func TestUnitTestKeysASNLookupFailure(t *testing.T) {
+	tk := fbmessenger.NewTestKeys()
+	for _, target := range fbmessenger.Services {
+		tk.Update(fbmessenger.MeasurementResult{
+			Target:   target,
+			TestKeys: urlgetter.TestKeys{Queries: newQueries("157.240.1.2")},
+		}, asnDatabase.LookupASN)
+	}
+	if *tk.FacebookDNSBlocking != false {
+		t.Fatal("unexpected DNS blocking")
+	}
+	if *tk.FacebookTCPBlocking != false {
+		t.Fatal("unexpected TCP blocking")
+	}
+	if tk.FacebookBAPIDNSConsistent != nil || *tk.FacebookBAPIReachable != true {
+		t.Fatal("unexpected b_api result")
+	}
+	if tk.FacebookSTUNDNSConsistent != nil || tk.FacebookSTUNReachable != nil {
+		t.Fatal("unexpected stun result")
+	}
+}
+
+func TestUnitMeasureWithCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // fail immediately
+	measurer := fbmessenger.NewExperimentMeasurer(fbmessenger.Config{})
+	measurement := new(model.Measurement)
+	err := measurer.Run(
+		ctx,
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*fbmessenger.TestKeys)
+	if *tk.FacebookDNSBlocking != true {
+		t.Fatal("expected DNS blocking")
+	}
+	if *tk.FacebookTCPBlocking != true {
+		t.Fatal("expected TCP blocking")
+	}
+}
+
 func TestIntegration(t *testing.T) {
-	err := mktesting.Run("", func() model.ExperimentMeasurer {
-		return fbmessenger.NewExperimentMeasurer(fbmessenger.Config{})
-	})
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	measurer := fbmessenger.NewExperimentMeasurer(fbmessenger.Config{})
+	measurement := new(model.Measurement)
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{
+			MockableHTTPClient: http.DefaultClient,
+			MockableLogger:     log.Log,
+		},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
 	if err != nil {
 		t.Fatal(err)
 	}
+	tk := measurement.TestKeys.(*fbmessenger.TestKeys)
+	if tk.FacebookBAPIDNSConsistent == nil {
+		t.Fatal("expected to see a b_api DNS result")
+	}
 }
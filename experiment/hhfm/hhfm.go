@@ -1,26 +1,90 @@
-// Package hhfm contains the HTTP Header Field Manipulation network experiment.
+// Package hhfm contains the HTTP Header Field Manipulation network experiment. This
+// file in particular is a pure-Go implementation of that.
+//
+// We cannot use net/http to send the request because it normalises the
+// header names, while we need to control their casing byte-for-byte. So
+// we write the request ourselves on top of a netx dialer.
+//
+// See https://github.com/ooni/spec/blob/master/nettests/ts-010-http-header-field-manipulation.md.
 package hhfm
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
 
-	"github.com/ooni/probe-engine/experiment/mkhelper"
-	"github.com/ooni/probe-engine/experiment/mkrunner"
-	"github.com/ooni/probe-engine/measurementkit"
+	"github.com/ooni/probe-engine/experiment/httpheader"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/httptransport"
 )
 
 const (
 	testName    = "http_header_field_manipulation"
-	testVersion = "0.0.1"
+	testVersion = "0.1.0"
+
+	// requestLine is the request line we send.
+	requestLine = "GET / HTTP/1.1"
+
+	// maxBodySize is the maximum body size we read.
+	maxBodySize = 1 << 17
 )
 
 // Config contains the experiment config.
-type Config struct {
-	// LogLevel is the MK log level. Empty implies "WARNING".
-	LogLevel string
+type Config struct{}
+
+// Tampering describes the detected forms of tampering.
+//
+// The meaning of the fields is described in the specification.
+type Tampering struct {
+	HeaderFieldName           bool     `json:"header_field_name"`
+	HeaderFieldNumber         bool     `json:"header_field_number"`
+	HeaderFieldValue          bool     `json:"header_field_value"`
+	HeaderNameCapitalization  bool     `json:"header_name_capitalization"`
+	HeaderNameDiff            []string `json:"header_name_diff"`
+	RequestLineCapitalization bool     `json:"request_line_capitalization"`
+	Total                     bool     `json:"total"`
+}
+
+// TestKeys contains the experiment test keys.
+type TestKeys struct {
+	Agent     string                  `json:"agent"`
+	Failure   *string                 `json:"failure"`
+	Requests  []archival.RequestEntry `json:"requests"`
+	Tampering Tampering               `json:"tampering"`
+}
+
+// header is a header we send with its exact casing.
+type header struct {
+	Key   string
+	Value string
 }
 
+// jsonHeaders is the response body of the test helper.
+type jsonHeaders struct {
+	HeadersDict map[string][]string `json:"headers_dict"`
+	RequestLine string              `json:"request_line"`
+}
+
+var (
+	// ErrNoAvailableTestHelpers is emitted when there are no available test helpers.
+	ErrNoAvailableTestHelpers = errors.New("no available helpers")
+
+	// ErrInvalidHelperType is emitted when the helper type is invalid.
+	ErrInvalidHelperType = errors.New("invalid helper type")
+)
+
 type measurer struct {
 	config Config
 }
@@ -33,25 +97,213 @@ func (m *measurer) ExperimentVersion() string {
 	return testVersion
 }
 
+func registerExtensions(m *model.Measurement) {
+	archival.ExtHTTP.AddTo(m)
+}
+
 func (m *measurer) Run(
 	ctx context.Context, sess model.ExperimentSession,
 	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
 ) error {
-	settings := measurementkit.NewSettings(
-		"HttpHeaderFieldManipulation", sess.SoftwareName(), sess.SoftwareVersion(),
-		sess.CABundlePath(), sess.ProbeASNString(), sess.ProbeCC(),
-		sess.ProbeIP(), sess.ProbeNetworkName(), m.config.LogLevel,
-	)
-	err := mkhelper.Set(sess, "http-return-json-headers", "legacy", &settings)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	registerExtensions(measurement)
+	tk := &TestKeys{Agent: "agent"}
+	measurement.TestKeys = tk
+	helpers, ok := sess.GetTestHelpersByName("http-return-json-headers")
+	if !ok || len(helpers) < 1 {
+		return ErrNoAvailableTestHelpers
+	}
+	helper := helpers[0]
+	if helper.Type != "legacy" {
+		return ErrInvalidHelperType
+	}
+	measurement.TestHelpers = map[string]interface{}{
+		"backend": helper.Address,
+	}
+	URL, err := url.Parse(helper.Address)
 	if err != nil {
 		return err
 	}
-	return mkrunner.Do(
-		settings, sess, measurement, callbacks, measurementkit.StartEx,
-	)
+	gen := rand.New(rand.NewSource(time.Now().UnixNano()))
+	headers := []header{
+		{Key: randomCase(gen, "Host"), Value: URL.Host},
+		{Key: randomCase(gen, "Accept"), Value: httpheader.RandomAccept()},
+		{Key: randomCase(gen, "Accept-Language"), Value: httpheader.RandomAcceptLanguage()},
+		{Key: randomCase(gen, "User-Agent"), Value: httpheader.RandomUserAgent()},
+		{Key: randomCase(gen, "Connection"), Value: "close"},
+	}
+	dialer := httptransport.NewDialer(httptransport.Config{
+		Logger:   sess.Logger(),
+		ProxyURL: sess.ProxyURL(),
+	})
+	begin := measurement.MeasurementStartTimeSaved
+	if begin.IsZero() {
+		begin = time.Now()
+	}
+	entry := newRequestEntry(begin, URL, headers)
+	resp, data, err := transact(ctx, dialer, endpoint(URL), headers)
+	callbacks.OnProgress(1, fmt.Sprintf("hhfm: %s: %s", URL, errString(err)))
+	if err != nil {
+		tk.Failure = archival.NewFailure(err)
+		entry.Failure = tk.Failure
+		tk.Requests = append(tk.Requests, entry)
+		return nil
+	}
+	entry.Response.Body.Value = string(data)
+	entry.Response.Code = int64(resp.StatusCode)
+	entry.Response.Headers = make(map[string]archival.MaybeBinaryValue)
+	for key, values := range resp.Header {
+		for index, value := range values {
+			if index == 0 {
+				entry.Response.Headers[key] = archival.MaybeBinaryValue{Value: value}
+			}
+			entry.Response.HeadersList = append(entry.Response.HeadersList,
+				archival.HTTPHeader{Key: key, Value: archival.MaybeBinaryValue{Value: value}})
+		}
+	}
+	tk.Requests = append(tk.Requests, entry)
+	var jh jsonHeaders
+	if err := json.Unmarshal(data, &jh); err != nil {
+		// The body is not what the helper sends, so someone in
+		// the middle must have modified it.
+		tk.Tampering = Tampering{
+			HeaderFieldName:           true,
+			HeaderFieldNumber:         true,
+			HeaderFieldValue:          true,
+			HeaderNameCapitalization:  true,
+			HeaderNameDiff:            []string{},
+			RequestLineCapitalization: true,
+			Total:                     true,
+		}
+		return nil
+	}
+	tk.Tampering = analyze(headers, jh)
+	return nil
+}
+
+// transact sends the request and reads the response.
+func transact(
+	ctx context.Context, dialer httptransport.Dialer, address string, headers []header,
+) (*http.Response, []byte, error) {
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(serialize(headers)); err != nil {
+		return nil, nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: maxBodySize})
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, data, nil
+}
+
+// serialize serializes the request preserving the headers casing.
+func serialize(headers []header) []byte {
+	var builder strings.Builder
+	builder.WriteString(requestLine + "\r\n")
+	for _, h := range headers {
+		builder.WriteString(h.Key + ": " + h.Value + "\r\n")
+	}
+	builder.WriteString("\r\n")
+	return []byte(builder.String())
+}
+
+// analyze compares the headers we sent with the ones the helper received.
+func analyze(headers []header, jh jsonHeaders) (tampering Tampering) {
+	tampering.HeaderNameDiff = []string{}
+	tampering.RequestLineCapitalization = jh.RequestLine != requestLine
+	tampering.HeaderFieldNumber = len(headers) != len(jh.HeadersDict)
+	sent := make(map[string]string)
+	sentLower := make(map[string]bool)
+	for _, h := range headers {
+		sent[h.Key] = h.Value
+		sentLower[strings.ToLower(h.Key)] = true
+	}
+	for key, values := range jh.HeadersDict {
+		value, found := sent[key]
+		if !found {
+			tampering.HeaderNameDiff = append(tampering.HeaderNameDiff, key)
+			if sentLower[strings.ToLower(key)] {
+				tampering.HeaderNameCapitalization = true
+			}
+			continue
+		}
+		if len(values) != 1 || values[0] != value {
+			tampering.HeaderFieldValue = true
+		}
+	}
+	for key := range sent {
+		if _, found := jh.HeadersDict[key]; !found {
+			tampering.HeaderNameDiff = append(tampering.HeaderNameDiff, key)
+		}
+	}
+	sort.Strings(tampering.HeaderNameDiff)
+	tampering.HeaderFieldName = len(tampering.HeaderNameDiff) > 0
+	tampering.Total = (tampering.HeaderFieldName ||
+		tampering.HeaderFieldNumber ||
+		tampering.HeaderFieldValue ||
+		tampering.HeaderNameCapitalization ||
+		tampering.RequestLineCapitalization)
+	return
+}
+
+// randomCase returns s with a random casing for each letter.
+func randomCase(gen *rand.Rand, s string) string {
+	out := []rune(s)
+	for idx, r := range out {
+		if gen.Intn(2) == 0 {
+			out[idx] = []rune(strings.ToUpper(string(r)))[0]
+		} else {
+			out[idx] = []rune(strings.ToLower(string(r)))[0]
+		}
+	}
+	return string(out)
+}
+
+// endpoint returns the TCP endpoint to connect to.
+func endpoint(URL *url.URL) string {
+	if URL.Port() != "" {
+		return URL.Host
+	}
+	return net.JoinHostPort(URL.Hostname(), "80")
+}
+
+func newRequestEntry(begin time.Time, URL *url.URL, headers []header) archival.RequestEntry {
+	var entry archival.RequestEntry
+	entry.T = time.Now().Sub(begin).Seconds()
+	entry.Request.Method = "GET"
+	entry.Request.URL = URL.String()
+	entry.Request.Headers = make(map[string]archival.MaybeBinaryValue)
+	for _, h := range headers {
+		value := archival.MaybeBinaryValue{Value: h.Value}
+		entry.Request.Headers[h.Key] = value
+		entry.Request.HeadersList = append(entry.Request.HeadersList,
+			archival.HTTPHeader{Key: h.Key, Value: value})
+	}
+	return entry
 }
 
 // NewExperimentMeasurer creates a new ExperimentMeasurer.
 func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
 	return &measurer{config: config}
 }
+
+func errString(err error) (s string) {
+	s = "success"
+	if err != nil {
+		s = err.Error()
+	}
+	return
+}
@@ -0,0 +1,78 @@
+package hhfm
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestUnitRandomCase(t *testing.T) {
+	gen := rand.New(rand.NewSource(0))
+	out := randomCase(gen, "Accept-Language")
+	if strings.ToLower(out) != "accept-language" {
+		t.Fatal("randomCase changed more than the casing")
+	}
+}
+
+func TestUnitSerialize(t *testing.T) {
+	out := serialize([]header{{Key: "hOsT", Value: "example.com"}})
+	if string(out) != "GET / HTTP/1.1\r\nhOsT: example.com\r\n\r\n" {
+		t.Fatal("unexpected serialization")
+	}
+}
+
+func TestUnitAnalyze(t *testing.T) {
+	headers := []header{
+		{Key: "hOsT", Value: "example.com"},
+		{Key: "aCcEpT", Value: "*/*"},
+	}
+	t.Run("no tampering", func(t *testing.T) {
+		tampering := analyze(headers, jsonHeaders{
+			HeadersDict: map[string][]string{
+				"hOsT":   {"example.com"},
+				"aCcEpT": {"*/*"},
+			},
+			RequestLine: requestLine,
+		})
+		if tampering.Total {
+			t.Fatalf("unexpected tampering: %+v", tampering)
+		}
+	})
+	t.Run("capitalization", func(t *testing.T) {
+		tampering := analyze(headers, jsonHeaders{
+			HeadersDict: map[string][]string{
+				"Host":   {"example.com"},
+				"aCcEpT": {"*/*"},
+			},
+			RequestLine: "get / HTTP/1.1",
+		})
+		if !tampering.HeaderNameCapitalization || !tampering.HeaderFieldName {
+			t.Fatalf("unexpected tampering: %+v", tampering)
+		}
+		if len(tampering.HeaderNameDiff) != 2 {
+			t.Fatal("unexpected header name diff")
+		}
+		if !tampering.RequestLineCapitalization || !tampering.Total {
+			t.Fatalf("unexpected tampering: %+v", tampering)
+		}
+	})
+	t.Run("value and number", func(t *testing.T) {
+		tampering := analyze(headers, jsonHeaders{
+			HeadersDict: map[string][]string{
+				"hOsT":   {"example.com"},
+				"aCcEpT": {"text/html"},
+				"Via":    {"proxy"},
+			},
+			RequestLine: requestLine,
+		})
+		if !tampering.HeaderFieldValue || !tampering.HeaderFieldNumber {
+			t.Fatalf("unexpected tampering: %+v", tampering)
+		}
+		if tampering.HeaderNameCapitalization {
+			t.Fatalf("unexpected tampering: %+v", tampering)
+		}
+		if len(tampering.HeaderNameDiff) != 1 || tampering.HeaderNameDiff[0] != "Via" {
+			t.Fatal("unexpected header name diff")
+		}
+	})
+}
@@ -1,18 +1,195 @@
 package hhfm_test
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
 	"testing"
 
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/handler"
 	"github.com/ooni/probe-engine/experiment/hhfm"
-	"github.com/ooni/probe-engine/experiment/mktesting"
+	"github.com/ooni/probe-engine/internal/mockable"
 	"github.com/ooni/probe-engine/model"
 )
 
-func TestIntegration(t *testing.T) {
-	err := mktesting.Run("", func() model.ExperimentMeasurer {
-		return hhfm.NewExperimentMeasurer(hhfm.Config{})
+func TestUnitNewExperimentMeasurer(t *testing.T) {
+	measurer := hhfm.NewExperimentMeasurer(hhfm.Config{})
+	if measurer.ExperimentName() != "http_header_field_manipulation" {
+		t.Fatal("unexpected name")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected version")
+	}
+}
+
+func TestUnitNoAvailableTestHelpers(t *testing.T) {
+	measurer := hhfm.NewExperimentMeasurer(hhfm.Config{})
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		new(model.Measurement),
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if !errors.Is(err, hhfm.ErrNoAvailableTestHelpers) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitInvalidHelperType(t *testing.T) {
+	measurer := hhfm.NewExperimentMeasurer(hhfm.Config{})
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{
+			MockableLogger: log.Log,
+			MockableTestHelpers: map[string][]model.Service{
+				"http-return-json-headers": {{Address: "http://127.0.0.1", Type: "https"}},
+			},
+		},
+		new(model.Measurement),
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if !errors.Is(err, hhfm.ErrInvalidHelperType) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+// startHelper starts a fake http-return-json-headers helper that
+// reads the request verbatim and calls mangle on the headers.
+func startHelper(t *testing.T, mangle func(map[string][]string)) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			reader := bufio.NewReader(conn)
+			requestLine, _ := reader.ReadString('\n')
+			headers := make(map[string][]string)
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil || line == "\r\n" {
+					break
+				}
+				v := strings.SplitN(strings.TrimRight(line, "\r\n"), ": ", 2)
+				if len(v) == 2 {
+					headers[v[0]] = append(headers[v[0]], v[1])
+				}
+			}
+			mangle(headers)
+			data, _ := json.Marshal(map[string]interface{}{
+				"headers_dict": headers,
+				"request_line": strings.TrimRight(requestLine, "\r\n"),
+			})
+			fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n", len(data))
+			conn.Write(data)
+			conn.Close()
+		}
+	}()
+	return listener
+}
+
+func run(t *testing.T, address string) *hhfm.TestKeys {
+	measurer := hhfm.NewExperimentMeasurer(hhfm.Config{})
+	measurement := new(model.Measurement)
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{
+			MockableLogger: log.Log,
+			MockableTestHelpers: map[string][]model.Service{
+				"http-return-json-headers": {{
+					Address: "http://" + address,
+					Type:    "legacy",
+				}},
+			},
+		},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return measurement.TestKeys.(*hhfm.TestKeys)
+}
+
+func TestUnitNoTampering(t *testing.T) {
+	listener := startHelper(t, func(map[string][]string) {})
+	defer listener.Close()
+	tk := run(t, listener.Addr().String())
+	if tk.Failure != nil {
+		t.Fatal(*tk.Failure)
+	}
+	if len(tk.Requests) != 1 {
+		t.Fatal("unexpected number of requests")
+	}
+	if tk.Tampering.Total != false {
+		t.Fatalf("unexpected tampering: %+v", tk.Tampering)
+	}
+}
+
+func TestUnitHeaderNameNormalisation(t *testing.T) {
+	listener := startHelper(t, func(headers map[string][]string) {
+		for key, values := range headers {
+			delete(headers, key)
+			headers[strings.ToLower(key)] = values
+		}
 	})
+	defer listener.Close()
+	tk := run(t, listener.Addr().String())
+	if tk.Failure != nil {
+		t.Fatal(*tk.Failure)
+	}
+	// Note: the random casing may produce an all-lowercase name, hence we
+	// cannot be sure that every header shows up in the diff.
+	if tk.Tampering.Total != (len(tk.Tampering.HeaderNameDiff) > 0) {
+		t.Fatalf("unexpected tampering: %+v", tk.Tampering)
+	}
+}
+
+func TestUnitNotJSON(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		const body = "<html>blocked</html>"
+		fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+		conn.Close()
+	}()
+	tk := run(t, listener.Addr().String())
+	if tk.Failure != nil {
+		t.Fatal(*tk.Failure)
+	}
+	if tk.Tampering.Total != true {
+		t.Fatal("expected to see tampering")
+	}
+}
+
+func TestUnitConnectionRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	tk := run(t, address)
+	if tk.Failure == nil || *tk.Failure != "connection_refused" {
+		t.Fatal("unexpected failure")
+	}
+	if len(tk.Requests) != 1 || tk.Requests[0].Failure == nil {
+		t.Fatal("expected a failed request")
+	}
 }
@@ -1,26 +1,101 @@
-// Package hirl contains the HTTP Invalid Request Line network experiment.
+// Package hirl contains the HTTP Invalid Request Line network experiment. This
+// file in particular is a pure-Go implementation of that.
+//
+// See https://github.com/ooni/spec/blob/master/nettests/ts-007-http-invalid-request-line.md.
 package hirl
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"strings"
+	"time"
 
-	"github.com/ooni/probe-engine/experiment/mkhelper"
-	"github.com/ooni/probe-engine/experiment/mkrunner"
-	"github.com/ooni/probe-engine/measurementkit"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/httptransport"
+	"github.com/ooni/probe-engine/netx/trace"
 )
 
 const (
 	testName    = "http_invalid_request_line"
-	testVersion = "0.0.3"
+	testVersion = "0.1.0"
+
+	// readTimeout is the maximum time we wait for the echo.
+	readTimeout = 5 * time.Second
 )
 
 // Config contains the experiment config.
-type Config struct {
-	// LogLevel is the MK log level. Empty implies "WARNING".
-	LogLevel string
+type Config struct{}
+
+// TestKeys contains the experiment test keys.
+type TestKeys struct {
+	FailureList   []*string                   `json:"failure_list"`
+	NetworkEvents []archival.NetworkEvent     `json:"network_events"`
+	Received      []archival.MaybeBinaryValue `json:"received"`
+	Sent          []string                    `json:"sent"`
+	Tampering     bool                        `json:"tampering"`
+	TamperingList []bool                      `json:"tampering_list"`
+}
+
+// Method is a method for generating an invalid request line.
+type Method struct {
+	Name string
+	Run  func(gen *rand.Rand) string
+}
+
+const (
+	lowercase = "abcdefghijklmnopqrstuvwxyz"
+	uppercase = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+)
+
+func randomString(gen *rand.Rand, charset string, n int) string {
+	out := make([]byte, n)
+	for idx := range out {
+		out[idx] = charset[gen.Intn(len(charset))]
+	}
+	return string(out)
 }
 
+// Methods contains the classic set of invalid request lines.
+var Methods = []Method{{
+	Name: "random_invalid_method",
+	Run: func(gen *rand.Rand) string {
+		return randomString(gen, uppercase, 4) + " / HTTP/1.1\n\r"
+	},
+}, {
+	Name: "random_invalid_field_count",
+	Run: func(gen *rand.Rand) string {
+		var fields []string
+		for idx := 0; idx < 4; idx++ {
+			fields = append(fields, randomString(gen, lowercase+uppercase, 5))
+		}
+		return strings.Join(fields, " ") + "\n\r"
+	},
+}, {
+	Name: "random_big_request_method",
+	Run: func(gen *rand.Rand) string {
+		return randomString(gen, uppercase, 1024) + " / HTTP/1.1\n\r"
+	},
+}, {
+	Name: "random_invalid_version_number",
+	Run: func(gen *rand.Rand) string {
+		return "GET / HTTP/" + randomString(gen, lowercase+uppercase, 3) + "\n\r"
+	},
+}}
+
+var (
+	// ErrNoAvailableTestHelpers is emitted when there are no available test helpers.
+	ErrNoAvailableTestHelpers = errors.New("no available helpers")
+
+	// ErrInvalidHelperType is emitted when the helper type is invalid.
+	ErrInvalidHelperType = errors.New("invalid helper type")
+)
+
 type measurer struct {
 	config Config
 }
@@ -33,25 +108,124 @@ func (m *measurer) ExperimentVersion() string {
 	return testVersion
 }
 
+func registerExtensions(m *model.Measurement) {
+	archival.ExtNetevents.AddTo(m)
+}
+
 func (m *measurer) Run(
 	ctx context.Context, sess model.ExperimentSession,
 	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
 ) error {
-	settings := measurementkit.NewSettings(
-		"HttpInvalidRequestLine", sess.SoftwareName(), sess.SoftwareVersion(),
-		sess.CABundlePath(), sess.ProbeASNString(), sess.ProbeCC(),
-		sess.ProbeIP(), sess.ProbeNetworkName(), m.config.LogLevel,
-	)
-	err := mkhelper.Set(sess, "tcp-echo", "legacy", &settings)
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	registerExtensions(measurement)
+	tk := new(TestKeys)
+	measurement.TestKeys = tk
+	helpers, ok := sess.GetTestHelpersByName("tcp-echo")
+	if !ok || len(helpers) < 1 {
+		return ErrNoAvailableTestHelpers
+	}
+	helper := helpers[0]
+	if helper.Type != "legacy" {
+		return ErrInvalidHelperType
+	}
+	measurement.TestHelpers = map[string]interface{}{
+		"backend": helper.Address,
+	}
+	address, err := endpoint(helper.Address)
 	if err != nil {
 		return err
 	}
-	return mkrunner.Do(
-		settings, sess, measurement, callbacks, measurementkit.StartEx,
-	)
+	saver := new(trace.Saver)
+	dialer := httptransport.NewDialer(httptransport.Config{
+		DialSaver:      saver,
+		Logger:         sess.Logger(),
+		ProxyURL:       sess.ProxyURL(),
+		ReadWriteSaver: saver,
+	})
+	gen := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for idx, method := range Methods {
+		sent := method.Run(gen)
+		received, err := transact(ctx, dialer, address, sent)
+		tampering := err == nil && received != sent
+		tk.FailureList = append(tk.FailureList, archival.NewFailure(err))
+		tk.Received = append(tk.Received, archival.MaybeBinaryValue{Value: received})
+		tk.Sent = append(tk.Sent, sent)
+		tk.TamperingList = append(tk.TamperingList, tampering)
+		tk.Tampering = tk.Tampering || tampering
+		callbacks.OnProgress(float64(idx+1)/float64(len(Methods)), fmt.Sprintf(
+			"hirl: %s: tampering: %+v; failure: %s", method.Name, tampering, errString(err)))
+	}
+	begin := measurement.MeasurementStartTimeSaved
+	if begin.IsZero() {
+		begin = time.Now()
+	}
+	tk.NetworkEvents = archival.NewNetworkEventsList(begin, saver.Read())
+	return nil
+}
+
+// transact sends the request line to the echo server and returns what
+// we have read back. Reading stops when we have read as many bytes as
+// we have sent, when the server closes the connection, or on timeout.
+func transact(
+	ctx context.Context, dialer httptransport.Dialer, address, sent string,
+) (string, error) {
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(sent)); err != nil {
+		return "", err
+	}
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
+	var received []byte
+	buffer := make([]byte, 4096)
+	for len(received) < len(sent) {
+		count, err := conn.Read(buffer)
+		received = append(received, buffer[:count]...)
+		if err != nil {
+			// A timeout or an EOF mean the server is not going to
+			// send us anything else, and the received bytes are
+			// what we should compare with what we sent.
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return string(received), err
+		}
+	}
+	return string(received), nil
+}
+
+// endpoint returns the TCP endpoint of the helper, whose address
+// could either be an URL or a domain with an optional port.
+func endpoint(address string) (string, error) {
+	if strings.Contains(address, "://") {
+		URL, err := url.Parse(address)
+		if err != nil {
+			return "", err
+		}
+		address = URL.Host
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "80")
+	}
+	return address, nil
 }
 
 // NewExperimentMeasurer creates a new ExperimentMeasurer.
 func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
 	return &measurer{config: config}
 }
+
+func errString(err error) (s string) {
+	s = "success"
+	if err != nil {
+		s = err.Error()
+	}
+	return
+}
@@ -1,18 +1,197 @@
 package hirl_test
 
 import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
 	"testing"
 
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/handler"
 	"github.com/ooni/probe-engine/experiment/hirl"
-	"github.com/ooni/probe-engine/experiment/mktesting"
+	"github.com/ooni/probe-engine/internal/mockable"
 	"github.com/ooni/probe-engine/model"
 )
 
-func TestIntegration(t *testing.T) {
-	err := mktesting.Run("", func() model.ExperimentMeasurer {
-		return hirl.NewExperimentMeasurer(hirl.Config{})
-	})
+func TestUnitNewExperimentMeasurer(t *testing.T) {
+	measurer := hirl.NewExperimentMeasurer(hirl.Config{})
+	if measurer.ExperimentName() != "http_invalid_request_line" {
+		t.Fatal("unexpected name")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected version")
+	}
+}
+
+func TestUnitNoAvailableTestHelpers(t *testing.T) {
+	measurer := hirl.NewExperimentMeasurer(hirl.Config{})
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		new(model.Measurement),
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if !errors.Is(err, hirl.ErrNoAvailableTestHelpers) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitInvalidHelperType(t *testing.T) {
+	measurer := hirl.NewExperimentMeasurer(hirl.Config{})
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{
+			MockableLogger: log.Log,
+			MockableTestHelpers: map[string][]model.Service{
+				"tcp-echo": {{Address: "127.0.0.1", Type: "https"}},
+			},
+		},
+		new(model.Measurement),
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if !errors.Is(err, hirl.ErrInvalidHelperType) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+// startEchoServer starts a TCP echo server that passes what it
+// reads through the mangle function before echoing it back.
+func startEchoServer(t *testing.T, mangle func(string) string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		t.Fatal(err)
 	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				buffer := make([]byte, 4096)
+				for {
+					count, err := conn.Read(buffer)
+					if err != nil {
+						return
+					}
+					io.WriteString(conn, mangle(string(buffer[:count])))
+				}
+			}(conn)
+		}
+	}()
+	return listener
+}
+
+func run(t *testing.T, address string) *hirl.TestKeys {
+	measurer := hirl.NewExperimentMeasurer(hirl.Config{})
+	measurement := new(model.Measurement)
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{
+			MockableLogger: log.Log,
+			MockableTestHelpers: map[string][]model.Service{
+				"tcp-echo": {{Address: address, Type: "legacy"}},
+			},
+		},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return measurement.TestKeys.(*hirl.TestKeys)
+}
+
+func TestUnitNoTampering(t *testing.T) {
+	listener := startEchoServer(t, func(s string) string { return s })
+	defer listener.Close()
+	tk := run(t, listener.Addr().String())
+	if len(tk.Sent) != len(hirl.Methods) || len(tk.Received) != len(hirl.Methods) {
+		t.Fatal("unexpected number of entries")
+	}
+	for idx := range tk.Sent {
+		if tk.FailureList[idx] != nil {
+			t.Fatal(*tk.FailureList[idx])
+		}
+		if tk.Sent[idx] != tk.Received[idx].Value {
+			t.Fatal("sent and received differ")
+		}
+	}
+	if tk.Tampering != false {
+		t.Fatal("unexpected tampering")
+	}
+	if len(tk.NetworkEvents) <= 0 {
+		t.Fatal("expected some network events")
+	}
+}
+
+func TestUnitTampering(t *testing.T) {
+	listener := startEchoServer(t, strings.ToLower)
+	defer listener.Close()
+	tk := run(t, "http://"+listener.Addr().String())
+	if tk.Tampering != true {
+		t.Fatal("expected to see tampering")
+	}
+	for _, tampering := range tk.TamperingList {
+		if tampering != true {
+			t.Fatal("expected to see tampering for each request")
+		}
+	}
+}
+
+func TestUnitConnectionRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	tk := run(t, address)
+	for _, failure := range tk.FailureList {
+		if failure == nil || *failure != "connection_refused" {
+			t.Fatal("unexpected failure")
+		}
+	}
+	if tk.Tampering != false {
+		t.Fatal("unexpected tampering")
+	}
+}
+
+func TestUnitWithProxy(t *testing.T) {
+	listener := startEchoServer(t, func(s string) string { return s })
+	defer listener.Close()
+	// the proxy is not listening, hence we fail if we use it
+	proxy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyURL := &url.URL{Scheme: "socks5", Host: proxy.Addr().String()}
+	proxy.Close()
+	measurer := hirl.NewExperimentMeasurer(hirl.Config{})
+	measurement := new(model.Measurement)
+	err = measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{
+			MockableLogger:   log.Log,
+			MockableProxyURL: proxyURL,
+			MockableTestHelpers: map[string][]model.Service{
+				"tcp-echo": {{Address: listener.Addr().String(), Type: "legacy"}},
+			},
+		},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*hirl.TestKeys)
+	for _, failure := range tk.FailureList {
+		if failure == nil || *failure != "connection_refused" {
+			t.Fatal("unexpected failure")
+		}
+	}
 }
@@ -0,0 +1,174 @@
+// Package signal contains the Signal network experiment. This file
+// in particular is a pure-Go implementation of that.
+//
+// Signal's servers use certificates issued by a private CA that the
+// official clients pin. Therefore, we verify the servers' certificates
+// using such CA rather than using the system roots (see signalca.go).
+package signal
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/atomicx"
+	"github.com/ooni/probe-engine/experiment/urlgetter"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/archival"
+)
+
+const (
+	testName    = "signal"
+	testVersion = "0.1.0"
+)
+
+// Targets contains the Signal endpoints we measure.
+var Targets = []string{
+	// the main service endpoint
+	"https://textsecure-service.whispersystems.org/",
+	// the storage service
+	"https://storage.signal.org/",
+	// the contact discovery service
+	"https://api.directory.signal.org/",
+	// the CDNs used for attachments and profiles
+	"https://cdn.signal.org/",
+	"https://cdn2.signal.org/",
+	// the service used for group calls
+	"https://sfu.voip.signal.org/",
+	// the domain used by clients to check service status
+	"dnslookup://uptime.signal.org",
+}
+
+// Config contains the experiment config.
+type Config struct{}
+
+// TestKeys contains the experiment results.
+type TestKeys struct {
+	Agent                string                     `json:"agent"`
+	Queries              []archival.DNSQueryEntry   `json:"queries"`
+	Requests             []archival.RequestEntry    `json:"requests"`
+	SignalBackendFailure *string                    `json:"signal_backend_failure"`
+	SignalBackendStatus  string                     `json:"signal_backend_status"`
+	TCPConnect           []archival.TCPConnectEntry `json:"tcp_connect"`
+	TLSHandshakes        []archival.TLSHandshake    `json:"tls_handshakes"`
+}
+
+// NewTestKeys returns a new instance of the test keys.
+func NewTestKeys() *TestKeys {
+	return &TestKeys{
+		Agent:               "redirect",
+		SignalBackendStatus: "ok",
+	}
+}
+
+// MeasurementResult is the result of measuring a single target.
+type MeasurementResult struct {
+	Target   string
+	TestKeys urlgetter.TestKeys
+	Err      error
+}
+
+// Update updates the TestKeys using the given MeasurementResult. The
+// backend is blocked as soon as a single target fails, and we only keep
+// track of the first failure that we have seen.
+func (tk *TestKeys) Update(v MeasurementResult) {
+	tk.Queries = append(tk.Queries, v.TestKeys.Queries...)
+	tk.Requests = append(tk.Requests, v.TestKeys.Requests...)
+	tk.TCPConnect = append(tk.TCPConnect, v.TestKeys.TCPConnect...)
+	tk.TLSHandshakes = append(tk.TLSHandshakes, v.TestKeys.TLSHandshakes...)
+	if v.Err != nil && tk.SignalBackendFailure == nil {
+		tk.SignalBackendFailure = v.TestKeys.Failure
+		tk.SignalBackendStatus = "blocked"
+	}
+}
+
+// ErrInvalidSignalCA indicates that we cannot load Signal's pinned CA.
+var ErrInvalidSignalCA = errors.New("signal: cannot load pinned CA")
+
+// NewCertPool returns a certificate pool containing only the
+// certificates contained inside the PEM-encoded bundle.
+func NewCertPool(bundle string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(bundle)) {
+		return nil, ErrInvalidSignalCA
+	}
+	return pool, nil
+}
+
+type measurer struct {
+	config Config
+}
+
+func (m *measurer) ExperimentName() string {
+	return testName
+}
+
+func (m *measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+func registerExtensions(m *model.Measurement) {
+	archival.ExtHTTP.AddTo(m)
+	archival.ExtDNS.AddTo(m)
+	archival.ExtTCPConnect.AddTo(m)
+	archival.ExtTLSHandshake.AddTo(m)
+}
+
+func (m *measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	registerExtensions(measurement)
+	pool, err := NewCertPool(signalCA)
+	if err != nil {
+		return err
+	}
+	results := make([]MeasurementResult, len(Targets))
+	var (
+		completed = atomicx.NewInt64()
+		waitgroup sync.WaitGroup
+	)
+	waitgroup.Add(len(Targets))
+	for idx, target := range Targets {
+		go func(idx int, target string) {
+			defer waitgroup.Done()
+			tk, err := urlgetter.Getter{
+				Begin:    measurement.MeasurementStartTimeSaved,
+				CertPool: pool,
+				Session:  sess,
+				Target:   target,
+			}.Get(ctx)
+			results[idx] = MeasurementResult{Target: target, TestKeys: tk, Err: err}
+			sofar := completed.Add(1)
+			percentage := float64(sofar) / float64(len(Targets))
+			callbacks.OnProgress(percentage, fmt.Sprintf(
+				"signal: access %s: %s", target, errString(err),
+			))
+		}(idx, target)
+	}
+	waitgroup.Wait()
+	testkeys := NewTestKeys()
+	for _, result := range results {
+		testkeys.Update(result)
+	}
+	measurement.TestKeys = testkeys
+	return nil
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return &measurer{config: config}
+}
+
+func errString(err error) (s string) {
+	s = "success"
+	if err != nil {
+		s = err.Error()
+	}
+	return
+}
@@ -0,0 +1,103 @@
+package signal
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/handler"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestUnitSignalCA(t *testing.T) {
+	if _, err := NewCertPool(signalCA); err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode([]byte(signalCA))
+	if block == nil {
+		t.Fatal("cannot decode the PEM block")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != "Signal Messenger" || !cert.IsCA {
+		t.Fatal("not the certificate we expected")
+	}
+	if err := cert.CheckSignatureFrom(cert); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnitMeasureWithInvalidCA(t *testing.T) {
+	saved := signalCA
+	defer func() { signalCA = saved }()
+	signalCA = "antani"
+	measurer := NewExperimentMeasurer(Config{})
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		new(model.Measurement),
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if !errors.Is(err, ErrInvalidSignalCA) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitMeasureWithCancelledContext(t *testing.T) {
+	server := httptest.NewTLSServer(nil)
+	defer server.Close()
+	saved := signalCA
+	defer func() { signalCA = saved }()
+	signalCA = string(pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	measurer := NewExperimentMeasurer(Config{})
+	measurement := new(model.Measurement)
+	err := measurer.Run(
+		ctx,
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*TestKeys)
+	if tk.SignalBackendStatus != "blocked" {
+		t.Fatal("unexpected backend status")
+	}
+	if tk.SignalBackendFailure == nil {
+		t.Fatal("expected a backend failure")
+	}
+}
+
+func TestIntegrationMeasure(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	measurer := NewExperimentMeasurer(Config{})
+	measurement := new(model.Measurement)
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*TestKeys)
+	if tk.SignalBackendStatus != "ok" {
+		t.Fatal("unexpected backend status")
+	}
+}
@@ -0,0 +1,76 @@
+package signal_test
+
+import (
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ooni/probe-engine/experiment/signal"
+	"github.com/ooni/probe-engine/experiment/urlgetter"
+)
+
+func TestUnitNewExperimentMeasurer(t *testing.T) {
+	measurer := signal.NewExperimentMeasurer(signal.Config{})
+	if measurer.ExperimentName() != "signal" {
+		t.Fatal("unexpected name")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected version")
+	}
+}
+
+func TestUnitNewCertPool(t *testing.T) {
+	server := httptest.NewTLSServer(nil)
+	defer server.Close()
+	bundle := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	})
+	pool, err := signal.NewCertPool(string(bundle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.Subjects()) != 1 {
+		t.Fatal("unexpected number of certificates")
+	}
+	if _, err := signal.NewCertPool("antani"); !errors.Is(err, signal.ErrInvalidSignalCA) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitUpdateSuccess(t *testing.T) {
+	tk := signal.NewTestKeys()
+	for _, target := range signal.Targets {
+		tk.Update(signal.MeasurementResult{Target: target})
+	}
+	if tk.SignalBackendStatus != "ok" {
+		t.Fatal("unexpected backend status")
+	}
+	if tk.SignalBackendFailure != nil {
+		t.Fatal("unexpected backend failure")
+	}
+}
+
+func TestUnitUpdateFailure(t *testing.T) {
+	tk := signal.NewTestKeys()
+	first, second := "connection_reset", "generic_timeout_error"
+	tk.Update(signal.MeasurementResult{Target: signal.Targets[0]})
+	tk.Update(signal.MeasurementResult{
+		Target:   signal.Targets[1],
+		TestKeys: urlgetter.TestKeys{Failure: &first},
+		Err:      io.EOF,
+	})
+	tk.Update(signal.MeasurementResult{
+		Target:   signal.Targets[2],
+		TestKeys: urlgetter.TestKeys{Failure: &second},
+		Err:      io.EOF,
+	})
+	if tk.SignalBackendStatus != "blocked" {
+		t.Fatal("unexpected backend status")
+	}
+	if tk.SignalBackendFailure == nil || *tk.SignalBackendFailure != first {
+		t.Fatal("unexpected backend failure")
+	}
+}
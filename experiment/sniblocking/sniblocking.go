@@ -17,6 +17,7 @@ import (
 	"github.com/ooni/probe-engine/internal/oonidatamodel"
 	"github.com/ooni/probe-engine/internal/oonitemplates"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/dialer"
 	"github.com/ooni/probe-engine/netx/modelx"
 )
 
@@ -27,6 +28,12 @@ const (
 
 // Config contains the experiment config.
 type Config struct {
+	// ClientHelloSplit is the strategy for splitting the ClientHello
+	// into several TCP segments (e.g. "1,20/100ms"). See the docs
+	// of dialer.ParseSplitStrategy for more information. When it's
+	// empty, we send the ClientHello as a single write.
+	ClientHelloSplit string
+
 	// ControlSNI is the SNI to be used for the control.
 	ControlSNI string
 
@@ -37,16 +44,17 @@ type Config struct {
 // Subresult contains the keys of a single measurement
 // that targets either the target or the control.
 type Subresult struct {
-	Agent         string                          `json:"agent"`
-	Cached        bool                            `json:"-"`
-	Failure       *string                         `json:"failure"`
-	NetworkEvents oonidatamodel.NetworkEventsList `json:"network_events"`
-	Queries       oonidatamodel.DNSQueriesList    `json:"queries"`
-	Requests      oonidatamodel.RequestList       `json:"requests"`
-	SNI           string                          `json:"sni"`
-	TCPConnect    oonidatamodel.TCPConnectList    `json:"tcp_connect"`
-	THAddress     string                          `json:"th_address"`
-	TLSHandshakes oonidatamodel.TLSHandshakesList `json:"tls_handshakes"`
+	Agent            string                          `json:"agent"`
+	Cached           bool                            `json:"-"`
+	ClientHelloSplit string                          `json:"client_hello_split,omitempty"`
+	Failure          *string                         `json:"failure"`
+	NetworkEvents    oonidatamodel.NetworkEventsList `json:"network_events"`
+	Queries          oonidatamodel.DNSQueriesList    `json:"queries"`
+	Requests         oonidatamodel.RequestList       `json:"requests"`
+	SNI              string                          `json:"sni"`
+	TCPConnect       oonidatamodel.TCPConnectList    `json:"tcp_connect"`
+	THAddress        string                          `json:"th_address"`
+	TLSHandshakes    oonidatamodel.TLSHandshakesList `json:"tls_handshakes"`
 }
 
 func registerExtensions(m *model.Measurement) {
@@ -123,6 +131,7 @@ func (m *measurer) measureone(
 	beginning time.Time,
 	sni string,
 	thaddr string,
+	split *dialer.SplitStrategy,
 ) Subresult {
 	// slightly delay the measurement
 	gen := rand.New(rand.NewSource(time.Now().UnixNano()))
@@ -138,21 +147,23 @@ func (m *measurer) measureone(
 	}
 	// perform the measurement
 	result := oonitemplates.TLSConnect(ctx, oonitemplates.TLSConnectConfig{
-		Address:   thaddr,
-		Beginning: beginning,
-		Handler:   handler,
-		SNI:       sni,
+		Address:          thaddr,
+		Beginning:        beginning,
+		ClientHelloSplit: split,
+		Handler:          handler,
+		SNI:              sni,
 	})
 	// assemble and publish the results
 	smk := Subresult{
-		Agent:         "redirect",
-		NetworkEvents: oonidatamodel.NewNetworkEventsList(result.TestKeys),
-		Queries:       oonidatamodel.NewDNSQueriesList(result.TestKeys),
-		Requests:      oonidatamodel.NewRequestList(result.TestKeys),
-		SNI:           sni,
-		TCPConnect:    oonidatamodel.NewTCPConnectList(result.TestKeys),
-		THAddress:     thaddr,
-		TLSHandshakes: oonidatamodel.NewTLSHandshakesList(result.TestKeys),
+		Agent:            "redirect",
+		ClientHelloSplit: split.String(),
+		NetworkEvents:    oonidatamodel.NewNetworkEventsList(result.TestKeys),
+		Queries:          oonidatamodel.NewDNSQueriesList(result.TestKeys),
+		Requests:         oonidatamodel.NewRequestList(result.TestKeys),
+		SNI:              sni,
+		TCPConnect:       oonidatamodel.NewTCPConnectList(result.TestKeys),
+		THAddress:        thaddr,
+		TLSHandshakes:    oonidatamodel.NewTLSHandshakesList(result.TestKeys),
 	}
 	if result.Error != nil {
 		s := result.Error.Error()
@@ -168,6 +179,7 @@ func (m *measurer) measureonewithcache(
 	beginning time.Time,
 	sni string,
 	thaddr string,
+	split *dialer.SplitStrategy,
 ) {
 	cachekey := sni + thaddr
 	m.mu.Lock()
@@ -177,7 +189,7 @@ func (m *measurer) measureonewithcache(
 		output <- smk
 		return
 	}
-	smk = m.measureone(ctx, handler, beginning, sni, thaddr)
+	smk = m.measureone(ctx, handler, beginning, sni, thaddr, split)
 	output <- smk
 	smk.Cached = true
 	m.mu.Lock()
@@ -188,13 +200,14 @@ func (m *measurer) measureonewithcache(
 func (m *measurer) startall(
 	ctx context.Context, sess model.ExperimentSession,
 	measurement *model.Measurement, inputs []string,
+	split *dialer.SplitStrategy,
 ) <-chan Subresult {
 	outputs := make(chan Subresult, len(inputs))
 	for _, input := range inputs {
 		go m.measureonewithcache(
 			ctx, outputs, netxlogger.NewHandler(sess.Logger()),
 			measurement.MeasurementStartTimeSaved,
-			input, m.config.TestHelperAddress,
+			input, m.config.TestHelperAddress, split,
 		)
 	}
 	return outputs
@@ -263,6 +276,10 @@ func (m *measurer) Run(
 	if measurement.Input == "" {
 		return errors.New("Experiment requires measurement.Input")
 	}
+	split, err := dialer.ParseSplitStrategy(m.config.ClientHelloSplit)
+	if err != nil {
+		return err
+	}
 	if m.config.TestHelperAddress == "" {
 		m.config.TestHelperAddress = net.JoinHostPort(
 			m.config.ControlSNI, "443",
@@ -285,7 +302,7 @@ func (m *measurer) Run(
 	}
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second*time.Duration(len(inputs)))
 	defer cancel()
-	outputs := m.startall(ctx, sess, measurement, inputs)
+	outputs := m.startall(ctx, sess, measurement, inputs, split)
 	measurement.TestKeys = processall(
 		outputs, measurement, callbacks, inputs, sess, m.config.ControlSNI,
 	)
@@ -2,6 +2,10 @@ package sniblocking
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,7 +14,9 @@ import (
 	"github.com/ooni/probe-engine/internal/mockable"
 	"github.com/ooni/probe-engine/internal/netxlogger"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/dialer"
 	"github.com/ooni/probe-engine/netx/modelx"
+	"github.com/ooni/probe-engine/netx/netsim"
 )
 
 const (
@@ -139,6 +145,47 @@ func TestUnitMeasurerMeasureNoMeasurementInput(t *testing.T) {
 	}
 }
 
+func TestUnitMeasurerMeasureWithInvalidClientHelloSplit(t *testing.T) {
+	measurer := NewExperimentMeasurer(Config{
+		ClientHelloSplit: "antani",
+		ControlSNI:       "example.com",
+	})
+	measurement := &model.Measurement{
+		Input: "kernel.org",
+	}
+	err := measurer.Run(
+		context.Background(),
+		newsession(),
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if !errors.Is(err, dialer.ErrInvalidSplitStrategy) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitMeasureoneWithClientHelloSplit(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	result := new(measurer).measureone(
+		context.Background(),
+		netxlogger.NewHandler(log.Log),
+		time.Now(),
+		"kernel.org",
+		strings.TrimPrefix(server.URL, "https://"),
+		&dialer.SplitStrategy{Chunks: []int{1, 20}},
+	)
+	if result.ClientHelloSplit != "1,20" {
+		t.Fatal("unexpected ClientHelloSplit")
+	}
+	// The server received the split ClientHello and replied with a
+	// certificate that is not valid for the SNI we used.
+	if result.Failure == nil || *result.Failure != modelx.FailureSSLInvalidHostname {
+		t.Fatal("unexpected failure")
+	}
+}
+
 func TestUnitMeasurerMeasureWithInvalidInput(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel() // immediately cancel the context
@@ -188,6 +235,7 @@ func TestUnitMeasureoneCancelledContext(t *testing.T) {
 		time.Now(),
 		"kernel.org",
 		"example.com:443",
+		nil,
 	)
 	if *result.Failure != modelx.FailureGenericTimeoutError {
 		t.Fatal("unexpected failure")
@@ -204,6 +252,7 @@ func TestUnitMeasureoneSuccess(t *testing.T) {
 		time.Now(),
 		"kernel.org",
 		"example.com:443",
+		nil,
 	)
 	if *result.Failure != modelx.FailureSSLInvalidHostname {
 		t.Fatal("unexpected failure")
@@ -224,6 +273,7 @@ func TestUnitMeasureonewithcacheWorks(t *testing.T) {
 			time.Now(),
 			"kernel.org",
 			"example.com:443",
+			nil,
 		)
 	}
 	for _, expected := range []bool{false, true} {
@@ -240,6 +290,62 @@ func TestUnitMeasureonewithcacheWorks(t *testing.T) {
 	}
 }
 
+func TestUnitMeasurerMeasureWithSimulatedNetwork(t *testing.T) {
+	// Without censorship, the test helper replies with a certificate
+	// that is not valid for kernel.org, meaning we got the ServerHello.
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	var tests = []struct {
+		name   string
+		policy netsim.Policy
+		result string
+		split  string
+	}{{
+		name:   "with no censorship",
+		result: classSuccessGotServerHello,
+	}, {
+		name:   "with RST on SNI",
+		policy: netsim.Policy{Action: netsim.ActionReset, Host: "kernel.org"},
+		result: classInterferenceReset,
+	}, {
+		name:   "with RST on SNI and ClientHello split",
+		policy: netsim.Policy{Action: netsim.ActionReset, Host: "kernel.org"},
+		result: classSuccessGotServerHello,
+		split:  "1,20",
+	}, {
+		name:   "with NXDOMAIN for the test helper",
+		policy: netsim.Policy{Action: netsim.ActionNXDOMAIN, Host: "example.com"},
+		result: classAnomalyTestHelperUnreachable,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network := new(netsim.Network)
+			network.AddHost("example.com", "93.184.216.34")
+			network.AddEndpoint(
+				"93.184.216.34:443", server.Listener.Addr().String())
+			network.AddPolicy(tt.policy)
+			measurer := NewExperimentMeasurer(Config{
+				ClientHelloSplit: tt.split,
+				ControlSNI:       "example.com",
+			})
+			measurement := &model.Measurement{Input: "kernel.org"}
+			err := measurer.Run(
+				netsim.WithNetwork(context.Background(), network),
+				newsession(),
+				measurement,
+				handler.NewPrinterCallbacks(log.Log),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tk := measurement.TestKeys.(*TestKeys)
+			if tk.Result != tt.result {
+				t.Fatal("unexpected result", tk.Result)
+			}
+		})
+	}
+}
+
 func TestUnitProcessallPanicsIfInvalidSNI(t *testing.T) {
 	defer func() {
 		panicdata := recover()
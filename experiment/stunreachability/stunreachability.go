@@ -0,0 +1,313 @@
+// Package stunreachability contains the STUN reachability network
+// experiment. We send a STUN Binding Request to the STUN server given
+// as input and we check whether we receive a valid response. Because
+// STUN is used by WebRTC and by VoIP applications to discover the public
+// address of a client, blocking it breaks such applications.
+//
+// See https://tools.ietf.org/html/rfc5389.
+package stunreachability
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/httptransport"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+const (
+	testName    = "stun_reachability"
+	testVersion = "0.1.0"
+
+	// defaultPort is the default STUN port.
+	defaultPort = "3478"
+
+	// maxAttempts is the number of times we send the request.
+	maxAttempts = 3
+
+	// readTimeout is the time we wait for a response before
+	// sending again the request, since we're using UDP.
+	readTimeout = 3 * time.Second
+)
+
+const (
+	magicCookie          = 0x2112A442
+	headerSize           = 20
+	bindingRequest       = 0x0001
+	bindingSuccess       = 0x0101
+	attrXORMappedAddress = 0x0020
+	familyIPv4           = 0x01
+	familyIPv6           = 0x02
+)
+
+// Config contains the experiment config.
+type Config struct{}
+
+// TestKeys contains the experiment test keys.
+type TestKeys struct {
+	Endpoint         string                   `json:"endpoint"`
+	Failure          *string                  `json:"failure"`
+	NetworkEvents    []archival.NetworkEvent  `json:"network_events"`
+	Queries          []archival.DNSQueryEntry `json:"queries"`
+	XORMappedAddress string                   `json:"xor_mapped_address,omitempty"`
+}
+
+var (
+	// ErrNoInput indicates that no input was provided.
+	ErrNoInput = errors.New("no input provided")
+
+	// ErrInvalidInput indicates that the input is not a stun:// URL.
+	ErrInvalidInput = errors.New("invalid input")
+
+	// ErrUnexpectedResponse indicates that the response is not a
+	// successful response to our Binding Request.
+	ErrUnexpectedResponse = errors.New("stun_unexpected_response")
+
+	// ErrNoXORMappedAddress indicates that the response does
+	// not contain a valid XOR-MAPPED-ADDRESS attribute.
+	ErrNoXORMappedAddress = errors.New("stun_no_xor_mapped_address")
+)
+
+// NewBindingRequest returns a new Binding Request with
+// the given twelve bytes long transaction ID.
+func NewBindingRequest(txid []byte) []byte {
+	out := make([]byte, headerSize)
+	binary.BigEndian.PutUint16(out[0:2], bindingRequest)
+	binary.BigEndian.PutUint16(out[2:4], 0) // no attributes
+	binary.BigEndian.PutUint32(out[4:8], magicCookie)
+	copy(out[8:headerSize], txid)
+	return out
+}
+
+// ParseBindingResponse parses the response to the Binding Request
+// with the given transaction ID and returns the endpoint contained
+// inside of the XOR-MAPPED-ADDRESS attribute.
+func ParseBindingResponse(data, txid []byte) (string, error) {
+	if len(data) < headerSize {
+		return "", ErrUnexpectedResponse
+	}
+	if binary.BigEndian.Uint16(data[0:2]) != bindingSuccess {
+		return "", ErrUnexpectedResponse
+	}
+	if binary.BigEndian.Uint32(data[4:8]) != magicCookie {
+		return "", ErrUnexpectedResponse
+	}
+	if !bytes.Equal(data[8:headerSize], txid) {
+		return "", ErrUnexpectedResponse
+	}
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	if len(data) < headerSize+length {
+		return "", ErrUnexpectedResponse
+	}
+	attrs := data[headerSize : headerSize+length]
+	for len(attrs) >= 4 {
+		atype := binary.BigEndian.Uint16(attrs[0:2])
+		alen := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if len(attrs) < 4+alen {
+			break
+		}
+		if atype == attrXORMappedAddress {
+			return parseXORMappedAddress(attrs[4:4+alen], data[4:headerSize])
+		}
+		// attributes are padded to a multiple of four bytes
+		padded := 4 + (alen+3)&^3
+		if padded > len(attrs) {
+			break
+		}
+		attrs = attrs[padded:]
+	}
+	return "", ErrNoXORMappedAddress
+}
+
+// parseXORMappedAddress parses the XOR-MAPPED-ADDRESS value. The key is the
+// magic cookie followed by the transaction ID, which we use for XORing.
+func parseXORMappedAddress(value, key []byte) (string, error) {
+	if len(value) < 4 {
+		return "", ErrNoXORMappedAddress
+	}
+	var size int
+	switch value[1] {
+	case familyIPv4:
+		size = net.IPv4len
+	case familyIPv6:
+		size = net.IPv6len
+	default:
+		return "", ErrNoXORMappedAddress
+	}
+	if len(value) < 4+size {
+		return "", ErrNoXORMappedAddress
+	}
+	port := binary.BigEndian.Uint16(value[2:4]) ^ uint16(magicCookie>>16)
+	ip := make(net.IP, size)
+	for idx := 0; idx < size; idx++ {
+		ip[idx] = value[4+idx] ^ key[idx]
+	}
+	return net.JoinHostPort(ip.String(), fmt.Sprintf("%d", port)), nil
+}
+
+// Endpoint returns the UDP endpoint described by the stun:// URL.
+func Endpoint(input string) (string, error) {
+	URL, err := url.Parse(input)
+	if err != nil {
+		return "", err
+	}
+	if URL.Scheme != "stun" {
+		return "", ErrInvalidInput
+	}
+	// stun:host:port URLs, as in RFC 7064, are parsed as opaque URLs
+	host := URL.Host
+	if URL.Opaque != "" {
+		host = URL.Opaque
+	}
+	if host == "" {
+		return "", ErrInvalidInput
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, defaultPort)
+	}
+	return host, nil
+}
+
+type measurer struct {
+	config Config
+}
+
+func (m *measurer) ExperimentName() string {
+	return testName
+}
+
+func (m *measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+func registerExtensions(m *model.Measurement) {
+	archival.ExtDNS.AddTo(m)
+	archival.ExtNetevents.AddTo(m)
+}
+
+func (m *measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	if measurement.Input == "" {
+		return ErrNoInput
+	}
+	endpoint, err := Endpoint(string(measurement.Input))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	registerExtensions(measurement)
+	tk := &TestKeys{Endpoint: endpoint}
+	measurement.TestKeys = tk
+	saver := new(trace.Saver)
+	dialer := httptransport.NewDialer(httptransport.Config{
+		DialSaver:      saver,
+		Logger:         sess.Logger(),
+		ReadWriteSaver: saver,
+		ResolveSaver:   saver,
+	})
+	tk.XORMappedAddress, err = transact(ctx, dialer, endpoint)
+	tk.Failure = newFailure(err)
+	begin := measurement.MeasurementStartTimeSaved
+	if begin.IsZero() {
+		begin = time.Now()
+	}
+	events := saver.Read()
+	tk.Queries = archival.NewDNSQueriesList(begin, events)
+	tk.NetworkEvents = newNetworkEventsList(begin, events)
+	callbacks.OnProgress(1, fmt.Sprintf(
+		"stun_reachability: %s: %s", endpoint, errString(err)))
+	return nil
+}
+
+// transact sends the Binding Request and waits for the response,
+// retransmitting the request on timeout, and returns the public
+// endpoint that the server has seen.
+func transact(
+	ctx context.Context, dialer httptransport.Dialer, endpoint string,
+) (string, error) {
+	conn, err := dialer.DialContext(ctx, "udp", endpoint)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	txid := make([]byte, headerSize-8)
+	if _, err := rand.Read(txid); err != nil {
+		return "", err
+	}
+	request := NewBindingRequest(txid)
+	buffer := make([]byte, 1500)
+	for attempt := 1; ; attempt++ {
+		if _, err := conn.Write(request); err != nil {
+			return "", err
+		}
+		deadline := time.Now().Add(readTimeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+		count, err := conn.Read(buffer)
+		if err == nil {
+			return ParseBindingResponse(buffer[:count], txid)
+		}
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() ||
+			attempt >= maxAttempts || ctx.Err() != nil {
+			return "", err
+		}
+	}
+}
+
+// newFailure is like archival.NewFailure except that it maps the
+// errors occurring when parsing the response to their failure string.
+func newFailure(err error) *string {
+	if errors.Is(err, ErrUnexpectedResponse) || errors.Is(err, ErrNoXORMappedAddress) {
+		s := err.Error()
+		return &s
+	}
+	return archival.NewFailure(err)
+}
+
+// newNetworkEventsList is like archival.NewNetworkEventsList except
+// that we skip DNS events, which are already inside the queries, and
+// we also include the bytes that we have sent and received.
+func newNetworkEventsList(begin time.Time, events []trace.Event) []archival.NetworkEvent {
+	var netevents []trace.Event
+	for _, ev := range events {
+		switch ev.Name {
+		case "connect", "read", "write":
+			netevents = append(netevents, ev)
+		}
+	}
+	out := archival.NewNetworkEventsList(begin, netevents)
+	for idx, ev := range netevents {
+		if len(ev.Data) > 0 {
+			out[idx].Data = &archival.MaybeBinaryValue{Value: string(ev.Data)}
+		}
+	}
+	return out
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return &measurer{config: config}
+}
+
+func errString(err error) (s string) {
+	s = "success"
+	if err != nil {
+		s = err.Error()
+	}
+	return
+}
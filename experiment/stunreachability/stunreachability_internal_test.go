@@ -0,0 +1,63 @@
+package stunreachability
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestUnitParseBindingResponse(t *testing.T) {
+	txid := []byte("abcdefghijkl")
+	request := NewBindingRequest(txid)
+	if len(request) != 20 {
+		t.Fatal("unexpected request length")
+	}
+	response := func(attrs ...byte) []byte {
+		out := append([]byte{}, request...)
+		binary.BigEndian.PutUint16(out[0:2], bindingSuccess)
+		binary.BigEndian.PutUint16(out[2:4], uint16(len(attrs)))
+		return append(out, attrs...)
+	}
+	t.Run("IPv6 after unknown attribute", func(t *testing.T) {
+		data := response(
+			// SOFTWARE attribute with a padded three bytes value
+			0x80, 0x22, 0x00, 0x03, 'f', 'o', 'o', 0x00,
+			0x00, 0x20, 0x00, 0x14, 0x00, 0x02, 0x21^0x12, 0x12^0x34,
+			// ::1 XORed with the magic cookie and the transaction ID
+			0x21, 0x12, 0xA4, 0x42, 'a', 'b', 'c', 'd',
+			'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l'^0x01,
+		)
+		endpoint, err := ParseBindingResponse(data, txid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if endpoint != "[::1]:4660" {
+			t.Fatalf("unexpected endpoint: %s", endpoint)
+		}
+	})
+	t.Run("without XOR-MAPPED-ADDRESS", func(t *testing.T) {
+		data := response(0x80, 0x22, 0x00, 0x00)
+		if _, err := ParseBindingResponse(data, txid); !errors.Is(err, ErrNoXORMappedAddress) {
+			t.Fatal("not the error we expected")
+		}
+	})
+	t.Run("with invalid family", func(t *testing.T) {
+		data := response(0x00, 0x20, 0x00, 0x08, 0x00, 0x07, 0x00, 0x00, 0, 0, 0, 0)
+		if _, err := ParseBindingResponse(data, txid); !errors.Is(err, ErrNoXORMappedAddress) {
+			t.Fatal("not the error we expected")
+		}
+	})
+	t.Run("with different transaction ID", func(t *testing.T) {
+		data := response()
+		if _, err := ParseBindingResponse(data, []byte("antaniantani")); !errors.Is(err, ErrUnexpectedResponse) {
+			t.Fatal("not the error we expected")
+		}
+	})
+	t.Run("with truncated attributes", func(t *testing.T) {
+		data := response(0x00, 0x20, 0x00, 0x08)
+		data = data[:len(data)-2]
+		if _, err := ParseBindingResponse(data, txid); !errors.Is(err, ErrUnexpectedResponse) {
+			t.Fatal("not the error we expected")
+		}
+	})
+}
@@ -0,0 +1,197 @@
+package stunreachability_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/handler"
+	"github.com/ooni/probe-engine/experiment/stunreachability"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestUnitNewExperimentMeasurer(t *testing.T) {
+	measurer := stunreachability.NewExperimentMeasurer(stunreachability.Config{})
+	if measurer.ExperimentName() != "stun_reachability" {
+		t.Fatal("unexpected name")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected version")
+	}
+}
+
+func TestUnitEndpoint(t *testing.T) {
+	var tests = []struct {
+		input    string
+		endpoint string
+		err      error
+	}{
+		{input: "stun://stun.example.com:19302", endpoint: "stun.example.com:19302"},
+		{input: "stun://stun.example.com", endpoint: "stun.example.com:3478"},
+		{input: "stun:stun.example.com:19302", endpoint: "stun.example.com:19302"},
+		{input: "stun://[::1]:3478", endpoint: "[::1]:3478"},
+		{input: "https://stun.example.com", err: stunreachability.ErrInvalidInput},
+		{input: "stun://", err: stunreachability.ErrInvalidInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			endpoint, err := stunreachability.Endpoint(tt.input)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("not the error we expected: %+v", err)
+			}
+			if endpoint != tt.endpoint {
+				t.Fatalf("expected %s but got %s", tt.endpoint, endpoint)
+			}
+		})
+	}
+}
+
+// startServer starts a local UDP STUN stand-in server that uses the
+// reply function to generate a response to each incoming request.
+func startServer(t *testing.T, reply func(req []byte, addr *net.UDPAddr) []byte) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buffer := make([]byte, 1500)
+		for {
+			count, addr, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			if data := reply(buffer[:count], addr); data != nil {
+				conn.WriteToUDP(data, addr)
+			}
+		}
+	}()
+	return conn
+}
+
+// bindingSuccess builds a successful Binding Response to req.
+func bindingSuccess(req []byte, addr *net.UDPAddr) []byte {
+	out := make([]byte, 32)
+	binary.BigEndian.PutUint16(out[0:2], 0x0101)
+	binary.BigEndian.PutUint16(out[2:4], 12)
+	copy(out[4:20], req[4:20])
+	binary.BigEndian.PutUint16(out[20:22], 0x0020)
+	binary.BigEndian.PutUint16(out[22:24], 8)
+	out[25] = 0x01
+	binary.BigEndian.PutUint16(out[26:28], uint16(addr.Port)^0x2112)
+	ip := addr.IP.To4()
+	for idx := 0; idx < 4; idx++ {
+		out[28+idx] = ip[idx] ^ req[4+idx]
+	}
+	return out
+}
+
+func run(
+	ctx context.Context, t *testing.T, input string,
+) *stunreachability.TestKeys {
+	measurer := stunreachability.NewExperimentMeasurer(stunreachability.Config{})
+	measurement := &model.Measurement{Input: model.MeasurementTarget(input)}
+	err := measurer.Run(
+		ctx,
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return measurement.TestKeys.(*stunreachability.TestKeys)
+}
+
+func TestUnitMeasureSuccess(t *testing.T) {
+	var client string
+	server := startServer(t, func(req []byte, addr *net.UDPAddr) []byte {
+		client = addr.String()
+		return bindingSuccess(req, addr)
+	})
+	defer server.Close()
+	tk := run(context.Background(), t, "stun://"+server.LocalAddr().String())
+	if tk.Failure != nil {
+		t.Fatal(*tk.Failure)
+	}
+	if tk.XORMappedAddress != client {
+		t.Fatalf("expected %s but got %s", client, tk.XORMappedAddress)
+	}
+	var connect, read, write bool
+	for _, ev := range tk.NetworkEvents {
+		switch ev.Operation {
+		case "connect":
+			connect = ev.Proto == "udp" && ev.Failure == nil
+		case "read":
+			read = ev.Data != nil && ev.NumBytes == 32
+		case "write":
+			write = ev.Data != nil && ev.NumBytes == 20
+		}
+	}
+	if !connect || !read || !write {
+		t.Fatalf("unexpected network events: %+v", tk.NetworkEvents)
+	}
+}
+
+func TestUnitMeasureUnexpectedResponse(t *testing.T) {
+	server := startServer(t, func(req []byte, addr *net.UDPAddr) []byte {
+		return []byte("antani")
+	})
+	defer server.Close()
+	tk := run(context.Background(), t, "stun://"+server.LocalAddr().String())
+	if tk.Failure == nil || *tk.Failure != "stun_unexpected_response" {
+		t.Fatal("not the failure we expected")
+	}
+}
+
+func TestUnitMeasureTimeout(t *testing.T) {
+	server := startServer(t, func(req []byte, addr *net.UDPAddr) []byte {
+		return nil
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	tk := run(ctx, t, "stun://"+server.LocalAddr().String())
+	if tk.Failure == nil || *tk.Failure != "generic_timeout_error" {
+		t.Fatal("not the failure we expected")
+	}
+}
+
+func TestUnitMeasureWithInvalidInput(t *testing.T) {
+	measurer := stunreachability.NewExperimentMeasurer(stunreachability.Config{})
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		new(model.Measurement),
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if !errors.Is(err, stunreachability.ErrNoInput) {
+		t.Fatal("not the error we expected")
+	}
+	err = measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		&model.Measurement{Input: "https://www.google.com"},
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if !errors.Is(err, stunreachability.ErrInvalidInput) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	tk := run(context.Background(), t, "stun://stun.l.google.com:19302")
+	if tk.Failure != nil {
+		t.Fatal(*tk.Failure)
+	}
+	if tk.XORMappedAddress == "" {
+		t.Fatal("expected a mapped address")
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/apex/log"
@@ -12,6 +14,7 @@ import (
 	"github.com/ooni/probe-engine/internal/oonitemplates"
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx/modelx"
+	"github.com/ooni/probe-engine/netx/netsim"
 )
 
 func TestUnitNewExperimentMeasurer(t *testing.T) {
@@ -59,6 +62,62 @@ func TestIntegrationMeasure(t *testing.T) {
 	}
 }
 
+func TestUnitMeasureWithSimulatedNetwork(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`<title>Telegram Web</title>`))
+		}))
+	defer server.Close()
+	accessPoints := []string{
+		"149.154.175.50", "149.154.167.51", "149.154.175.100",
+		"149.154.167.91", "149.154.171.5",
+	}
+	network := new(netsim.Network)
+	for _, ip := range accessPoints {
+		network.AddEndpoint(ip+":80", server.Listener.Addr().String())
+		network.AddEndpoint(ip+":443", server.Listener.Addr().String())
+	}
+	network.AddHost("web.telegram.org", "149.154.167.99")
+	network.AddEndpoint("149.154.167.99:80", server.Listener.Addr().String())
+	// The censor resets connections to the access points and injects
+	// a blockpage when accessing web.telegram.org using HTTP. Because
+	// there is no HTTPS server, accessing it using HTTPS fails.
+	for _, ip := range accessPoints {
+		network.AddPolicy(netsim.Policy{Action: netsim.ActionReset, Host: ip})
+	}
+	network.AddPolicy(netsim.Policy{
+		Action: netsim.ActionBlockpage,
+		Blockpage: []byte("HTTP/1.1 200 OK\r\nContent-Length: 7\r\n" +
+			"Connection: close\r\n\r\nblocked"),
+		Host: "web.telegram.org",
+	})
+	measurement := new(model.Measurement)
+	err := new(measurer).Run(
+		netsim.WithNetwork(context.Background(), network),
+		&mockable.ExperimentSession{
+			MockableLogger: log.Log,
+		},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(**TestKeys)
+	if !(*tk).TelegramTCPBlocking {
+		t.Fatal("unexpected TelegramTCPBlocking")
+	}
+	if !(*tk).TelegramHTTPBlocking {
+		t.Fatal("unexpected TelegramHTTPBlocking")
+	}
+	if (*tk).TelegramWebStatus != "blocked" {
+		t.Fatal("unexpected TelegramWebStatus")
+	}
+	if (*tk).TelegramWebFailure == nil {
+		t.Fatal("unexpected TelegramWebFailure")
+	}
+}
+
 func TestUnitProcessoneNil(t *testing.T) {
 	tk := newTestKeys()
 	err := tk.processone(nil)
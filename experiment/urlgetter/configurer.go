@@ -2,32 +2,44 @@ package urlgetter
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"math"
 	"net"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/dialer"
 	"github.com/ooni/probe-engine/netx/httptransport"
+	"github.com/ooni/probe-engine/netx/quicdialer"
 	"github.com/ooni/probe-engine/netx/resolver"
 	"github.com/ooni/probe-engine/netx/trace"
 )
 
 // The Configurer job is to construct a Configuration that can
-// later be used by the measurer to perform measurements.
+// later be used by the measurer to perform measurements. When the
+// CertPool is not nil, we use it instead of the system roots. When the
+// BaseDialer and the BaseResolver are not nil, we use them instead of the
+// system dialer and resolver (see httptransport.Config).
 type Configurer struct {
-	Config   Config
-	Logger   model.Logger
-	ProxyURL *url.URL
-	Saver    *trace.Saver
+	BaseDialer   httptransport.Dialer
+	BaseResolver httptransport.Resolver
+	CertPool     *x509.CertPool
+	Config       Config
+	Logger       model.Logger
+	ProxyURL     *url.URL
+	Saver        *trace.Saver
 }
 
 // The Configuration is the configuration for running a measurement.
 type Configuration struct {
 	HTTPConfig        httptransport.Config
 	DNSOverHTTPClient *http.Client
+	dnsOverUDP        *resolver.DNSOverUDP
 }
 
 // CloseIdleConnections will close idle connections, if needed.
@@ -37,22 +49,37 @@ func (c Configuration) CloseIdleConnections() {
 	}
 }
 
+// WaitLateReplies waits until we have stopped collecting the late
+// DNS over UDP replies, if needed, such that we can archive them.
+func (c Configuration) WaitLateReplies() {
+	if c.dnsOverUDP != nil {
+		c.dnsOverUDP.WaitLateReplies()
+	}
+}
+
 // NewConfiguration builds a new measurement configuration.
 func (c Configurer) NewConfiguration() (Configuration, error) {
 	// set up defaults
 	configuration := Configuration{
 		HTTPConfig: httptransport.Config{
+			BaseDialer:          c.BaseDialer,
+			BaseResolver:        c.BaseResolver,
 			BogonIsError:        c.Config.RejectDNSBogons,
 			CacheResolutions:    true,
 			ContextByteCounting: true,
 			DialSaver:           c.Saver,
 			HTTPSaver:           c.Saver,
 			Logger:              c.Logger,
+			NoReadWritePayloads: c.Config.PcapNGDir == "", // only pcap-ng needs them
 			ReadWriteSaver:      c.Saver,
 			ResolveSaver:        c.Saver,
 			TLSSaver:            c.Saver,
 		},
 	}
+	// QUIC would bypass the proxy (see quicdialer.ProxyDialer)
+	if c.ProxyURL != nil && c.Config.HTTP3Enabled {
+		return configuration, quicdialer.ErrProxyNotSupported
+	}
 	// fill DNS cache
 	if c.Config.DNSCache != "" {
 		entry := strings.Split(c.Config.DNSCache, " ")
@@ -103,10 +130,32 @@ func (c Configurer) NewConfiguration() (Configuration, error) {
 		)
 	case "udp":
 		dialer := httptransport.NewDialer(configuration.HTTPConfig)
+		txp := resolver.NewDNSOverUDPWithWindow(
+			dialer, endpoint(resolverURL, "53"),
+			time.Duration(c.Config.DNSUDPWindow)*time.Millisecond,
+		)
+		configuration.dnsOverUDP = &txp
+		configuration.HTTPConfig.BaseResolver = resolver.NewSerialResolver(
+			resolver.SaverDNSTransport{RoundTripper: txp, Saver: c.Saver},
+		)
+	case "tcp":
+		dialer := httptransport.NewDialer(configuration.HTTPConfig)
+		configuration.HTTPConfig.BaseResolver = resolver.NewSerialResolver(
+			resolver.SaverDNSTransport{
+				RoundTripper: resolver.NewDNSOverTCP(
+					dialer.DialContext, endpoint(resolverURL, "53"),
+				),
+				Saver: c.Saver,
+			},
+		)
+	case "dot":
+		config := configuration.HTTPConfig
+		config.TLSConfig = &tls.Config{NextProtos: []string{"dot"}}
+		tlsDialer := httptransport.NewTLSDialer(config)
 		configuration.HTTPConfig.BaseResolver = resolver.NewSerialResolver(
 			resolver.SaverDNSTransport{
-				RoundTripper: resolver.NewDNSOverUDP(
-					dialer, resolverURL.Host,
+				RoundTripper: resolver.NewDNSOverTLS(
+					tlsDialer.DialTLSContext, endpoint(resolverURL, "853"),
 				),
 				Saver: c.Saver,
 			},
@@ -115,14 +164,58 @@ func (c Configurer) NewConfiguration() (Configuration, error) {
 		return configuration, errors.New("unsupported resolver scheme")
 	}
 	// configure TLS
-	if c.Config.TLSServerName != "" {
+	clientHelloSplit, err := dialer.ParseSplitStrategy(c.Config.ClientHelloSplit)
+	if err != nil {
+		return configuration, err
+	}
+	configuration.HTTPConfig.ClientHelloSplit = clientHelloSplit
+	nextProtos := []string{"h2", "http/1.1"}
+	if c.Config.TLSClientHelloID != "" {
+		clientHelloID, err := dialer.NewClientHelloID(c.Config.TLSClientHelloID)
+		if err != nil {
+			return configuration, err
+		}
+		configuration.HTTPConfig.TLSClientHelloID = clientHelloID
+		// net/http only speaks HTTP/2 over *tls.Conn
+		nextProtos = []string{"http/1.1"}
+	}
+	if c.Config.TLSServerName != "" || c.CertPool != nil {
 		configuration.HTTPConfig.TLSConfig = &tls.Config{
-			NextProtos: []string{"h2", "http/1.1"},
+			NextProtos: nextProtos,
+			RootCAs:    c.CertPool,
 			ServerName: c.Config.TLSServerName,
 		}
 	}
 	configuration.HTTPConfig.NoTLSVerify = c.Config.NoTLSVerify
+	// configure EDNS0
+	if c.Config.EDNS0BufferSize != 0 || c.Config.EDNS0ClientSubnet != "" ||
+		c.Config.EDNS0DNSSECOK {
+		if c.Config.EDNS0BufferSize < 0 || c.Config.EDNS0BufferSize > math.MaxUint16 {
+			return configuration, errors.New("invalid EDNS0BufferSize")
+		}
+		if c.Config.EDNS0ClientSubnet != "" {
+			if _, _, err := net.ParseCIDR(c.Config.EDNS0ClientSubnet); err != nil {
+				return configuration, errors.New("invalid EDNS0ClientSubnet")
+			}
+		}
+		configuration.HTTPConfig.EDNS0 = &resolver.EDNS0Config{
+			BufferSize:   uint16(c.Config.EDNS0BufferSize),
+			ClientSubnet: c.Config.EDNS0ClientSubnet,
+			DNSSECOK:     c.Config.EDNS0DNSSECOK,
+		}
+	}
+	// configure parallel A and AAAA queries
+	configuration.HTTPConfig.ParallelResolver = c.Config.ParallelResolver
 	// configure proxy
 	configuration.HTTPConfig.ProxyURL = c.ProxyURL
 	return configuration, nil
 }
+
+// endpoint returns the resolver endpoint, using defaultPort
+// when the resolver URL does not specify any port.
+func endpoint(URL *url.URL, defaultPort string) string {
+	if URL.Port() != "" {
+		return URL.Host
+	}
+	return net.JoinHostPort(URL.Hostname(), defaultPort)
+}
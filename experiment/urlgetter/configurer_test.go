@@ -1,14 +1,22 @@
 package urlgetter_test
 
 import (
+	"crypto/x509"
+	"errors"
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/ooni/probe-engine/experiment/urlgetter"
+	"github.com/ooni/probe-engine/netx/dialer"
+	"github.com/ooni/probe-engine/netx/httptransport"
+	"github.com/ooni/probe-engine/netx/netsim"
+	"github.com/ooni/probe-engine/netx/quicdialer"
 	"github.com/ooni/probe-engine/netx/resolver"
 	"github.com/ooni/probe-engine/netx/trace"
+	utls "github.com/refraction-networking/utls"
 )
 
 func TestConfigurerNewConfigurationVanilla(t *testing.T) {
@@ -43,6 +51,9 @@ func TestConfigurerNewConfigurationVanilla(t *testing.T) {
 	if configuration.HTTPConfig.Logger != log.Log {
 		t.Fatal("not the Logger we expected")
 	}
+	if configuration.HTTPConfig.NoReadWritePayloads != true {
+		t.Fatal("not the NoReadWritePayloads we expected")
+	}
 	if configuration.HTTPConfig.ReadWriteSaver != saver {
 		t.Fatal("not the ReadWriteSaver we expected")
 	}
@@ -274,6 +285,9 @@ func TestConfigurerNewConfigurationResolverUDP(t *testing.T) {
 	if udptxp.Address() != "8.8.8.8:53" {
 		t.Fatal("not the DoH URL we expected")
 	}
+	if udptxp.Window() != 0 {
+		t.Fatal("not the Window we expected")
+	}
 	if configuration.HTTPConfig.TLSConfig != nil {
 		t.Fatal("not the TLSConfig we expected")
 	}
@@ -285,6 +299,284 @@ func TestConfigurerNewConfigurationResolverUDP(t *testing.T) {
 	}
 }
 
+func TestConfigurerNewConfigurationDNSUDPWindow(t *testing.T) {
+	configurer := urlgetter.Configurer{
+		Config: urlgetter.Config{
+			DNSUDPWindow: 500,
+			ResolverURL:  "udp://8.8.8.8:53",
+		},
+		Logger: log.Log,
+		Saver:  new(trace.Saver),
+	}
+	configuration, err := configurer.NewConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer configuration.CloseIdleConnections()
+	sr, ok := configuration.HTTPConfig.BaseResolver.(resolver.SerialResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	stxp, ok := sr.Txp.(resolver.SaverDNSTransport)
+	if !ok {
+		t.Fatal("not the DNS transport we expected")
+	}
+	udptxp, ok := stxp.RoundTripper.(resolver.DNSOverUDP)
+	if !ok {
+		t.Fatal("not the DNS transport we expected")
+	}
+	if udptxp.Window() != 500*time.Millisecond {
+		t.Fatal("not the Window we expected")
+	}
+}
+
+func TestConfigurerNewConfigurationTLSClientHelloID(t *testing.T) {
+	configurer := urlgetter.Configurer{
+		Config: urlgetter.Config{
+			TLSClientHelloID: "firefox",
+			TLSServerName:    "www.x.org",
+		},
+		Logger: log.Log,
+		Saver:  new(trace.Saver),
+	}
+	configuration, err := configurer.NewConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer configuration.CloseIdleConnections()
+	if configuration.HTTPConfig.TLSClientHelloID != &utls.HelloFirefox_Auto {
+		t.Fatal("not the TLSClientHelloID we expected")
+	}
+	nextProtos := configuration.HTTPConfig.TLSConfig.NextProtos
+	if len(nextProtos) != 1 || nextProtos[0] != "http/1.1" {
+		t.Fatal("not the NextProtos we expected")
+	}
+}
+
+func TestConfigurerNewConfigurationInvalidTLSClientHelloID(t *testing.T) {
+	configurer := urlgetter.Configurer{
+		Config: urlgetter.Config{
+			TLSClientHelloID: "antani",
+		},
+		Logger: log.Log,
+		Saver:  new(trace.Saver),
+	}
+	_, err := configurer.NewConfiguration()
+	if !errors.Is(err, dialer.ErrUnknownClientHelloID) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestConfigurerNewConfigurationClientHelloSplit(t *testing.T) {
+	configurer := urlgetter.Configurer{
+		Config: urlgetter.Config{
+			ClientHelloSplit: "1,20/10ms",
+		},
+		Logger: log.Log,
+		Saver:  new(trace.Saver),
+	}
+	configuration, err := configurer.NewConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer configuration.CloseIdleConnections()
+	split := configuration.HTTPConfig.ClientHelloSplit
+	if split == nil || split.String() != "1,20/10ms" {
+		t.Fatal("not the ClientHelloSplit we expected")
+	}
+}
+
+func TestConfigurerNewConfigurationInvalidClientHelloSplit(t *testing.T) {
+	configurer := urlgetter.Configurer{
+		Config: urlgetter.Config{
+			ClientHelloSplit: "antani",
+		},
+		Logger: log.Log,
+		Saver:  new(trace.Saver),
+	}
+	_, err := configurer.NewConfiguration()
+	if !errors.Is(err, dialer.ErrInvalidSplitStrategy) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestConfigurerNewConfigurationParallelResolver(t *testing.T) {
+	configurer := urlgetter.Configurer{
+		Config: urlgetter.Config{
+			ParallelResolver: true,
+			ResolverURL:      "udp://8.8.8.8:53",
+		},
+		Logger: log.Log,
+		Saver:  new(trace.Saver),
+	}
+	configuration, err := configurer.NewConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer configuration.CloseIdleConnections()
+	if configuration.HTTPConfig.ParallelResolver != true {
+		t.Fatal("not the ParallelResolver we expected")
+	}
+	r := httptransport.NewResolver(configuration.HTTPConfig)
+	ar, ok := r.(resolver.AddressResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	sr, ok := ar.Resolver.(resolver.SaverResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	lr, ok := sr.Resolver.(resolver.LoggingResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	ewr, ok := lr.Resolver.(resolver.ErrorWrapperResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	cr, ok := ewr.Resolver.(*resolver.CacheResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	if _, ok := cr.Resolver.(resolver.ParallelResolver); !ok {
+		t.Fatal("not the resolver we expected")
+	}
+}
+
+func TestConfigurerNewConfigurationEDNS0(t *testing.T) {
+	configurer := urlgetter.Configurer{
+		Config: urlgetter.Config{
+			EDNS0BufferSize:   1232,
+			EDNS0ClientSubnet: "192.0.2.0/24",
+			EDNS0DNSSECOK:     true,
+			ResolverURL:       "udp://8.8.8.8:53",
+		},
+		Logger: log.Log,
+		Saver:  new(trace.Saver),
+	}
+	configuration, err := configurer.NewConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer configuration.CloseIdleConnections()
+	edns0 := configuration.HTTPConfig.EDNS0
+	if edns0 == nil {
+		t.Fatal("expected an EDNS0 config here")
+	}
+	if edns0.BufferSize != 1232 || edns0.ClientSubnet != "192.0.2.0/24" || !edns0.DNSSECOK {
+		t.Fatal("not the EDNS0 config we expected")
+	}
+}
+
+func TestConfigurerNewConfigurationInvalidEDNS0(t *testing.T) {
+	for _, config := range []urlgetter.Config{
+		{EDNS0BufferSize: -1},
+		{EDNS0BufferSize: 65536},
+		{EDNS0ClientSubnet: "antani"},
+	} {
+		configurer := urlgetter.Configurer{
+			Config: config,
+			Logger: log.Log,
+			Saver:  new(trace.Saver),
+		}
+		if _, err := configurer.NewConfiguration(); err == nil {
+			t.Fatal("expected an error here")
+		}
+	}
+}
+
+func TestConfigurerNewConfigurationResolverTCP(t *testing.T) {
+	saver := new(trace.Saver)
+	configurer := urlgetter.Configurer{
+		Config: urlgetter.Config{
+			ResolverURL: "tcp://8.8.8.8:53",
+		},
+		Logger: log.Log,
+		Saver:  saver,
+	}
+	configuration, err := configurer.NewConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer configuration.CloseIdleConnections()
+	if configuration.DNSOverHTTPClient != nil {
+		t.Fatal("not the DNSOverHTTPClient we expected")
+	}
+	if configuration.HTTPConfig.ResolveSaver != saver {
+		t.Fatal("not the ResolveSaver we expected")
+	}
+	sr, ok := configuration.HTTPConfig.BaseResolver.(resolver.SerialResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	stxp, ok := sr.Txp.(resolver.SaverDNSTransport)
+	if !ok {
+		t.Fatal("not the DNS transport we expected")
+	}
+	if stxp.Saver != saver {
+		t.Fatal("not the DNS transport saver we expected")
+	}
+	txp, ok := stxp.RoundTripper.(resolver.DNSOverTCP)
+	if !ok {
+		t.Fatal("not the DNS transport we expected")
+	}
+	if txp.Network() != "tcp" {
+		t.Fatal("not the network we expected")
+	}
+	if txp.Address() != "8.8.8.8:53" {
+		t.Fatal("not the address we expected")
+	}
+	if configuration.HTTPConfig.TLSConfig != nil {
+		t.Fatal("not the TLSConfig we expected")
+	}
+}
+
+func TestConfigurerNewConfigurationResolverDoT(t *testing.T) {
+	saver := new(trace.Saver)
+	configurer := urlgetter.Configurer{
+		Config: urlgetter.Config{
+			ResolverURL: "dot://dns.google",
+		},
+		Logger: log.Log,
+		Saver:  saver,
+	}
+	configuration, err := configurer.NewConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer configuration.CloseIdleConnections()
+	if configuration.DNSOverHTTPClient != nil {
+		t.Fatal("not the DNSOverHTTPClient we expected")
+	}
+	if configuration.HTTPConfig.ResolveSaver != saver {
+		t.Fatal("not the ResolveSaver we expected")
+	}
+	sr, ok := configuration.HTTPConfig.BaseResolver.(resolver.SerialResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	stxp, ok := sr.Txp.(resolver.SaverDNSTransport)
+	if !ok {
+		t.Fatal("not the DNS transport we expected")
+	}
+	if stxp.Saver != saver {
+		t.Fatal("not the DNS transport saver we expected")
+	}
+	txp, ok := stxp.RoundTripper.(resolver.DNSOverTCP)
+	if !ok {
+		t.Fatal("not the DNS transport we expected")
+	}
+	if txp.Network() != "dot" {
+		t.Fatal("not the network we expected")
+	}
+	if txp.Address() != "dns.google:853" {
+		t.Fatal("not the address we expected")
+	}
+	if configuration.HTTPConfig.TLSConfig != nil {
+		t.Fatal("not the TLSConfig we expected")
+	}
+}
+
 func TestConfigurerNewConfigurationDNSCacheInvalidString(t *testing.T) {
 	saver := new(trace.Saver)
 	configurer := urlgetter.Configurer{
@@ -414,6 +706,29 @@ func TestConfigurerNewConfigurationTLSServerName(t *testing.T) {
 	}
 }
 
+func TestConfigurerNewConfigurationCertPool(t *testing.T) {
+	saver := new(trace.Saver)
+	pool := x509.NewCertPool()
+	configurer := urlgetter.Configurer{
+		CertPool: pool,
+		Logger:   log.Log,
+		Saver:    saver,
+	}
+	configuration, err := configurer.NewConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configuration.HTTPConfig.TLSConfig.RootCAs != pool {
+		t.Fatal("invalid RootCAs")
+	}
+	if configuration.HTTPConfig.TLSConfig.ServerName != "" {
+		t.Fatal("invalid ServerName")
+	}
+	if len(configuration.HTTPConfig.TLSConfig.NextProtos) != 2 {
+		t.Fatal("invalid len(NextProtos)")
+	}
+}
+
 func TestConfigurerNewConfigurationNoTLSVerify(t *testing.T) {
 	saver := new(trace.Saver)
 	configurer := urlgetter.Configurer{
@@ -448,3 +763,55 @@ func TestConfigurerNewConfigurationProxyURL(t *testing.T) {
 		t.Fatal("invalid ProxyURL")
 	}
 }
+
+func TestConfigurerNewConfigurationProxyURLWithHTTP3(t *testing.T) {
+	URL, _ := url.Parse("socks5://127.0.0.1:9050")
+	configurer := urlgetter.Configurer{
+		Config:   urlgetter.Config{HTTP3Enabled: true},
+		Logger:   log.Log,
+		ProxyURL: URL,
+		Saver:    new(trace.Saver),
+	}
+	if _, err := configurer.NewConfiguration(); !errors.Is(err, quicdialer.ErrProxyNotSupported) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestConfigurerNewConfigurationPcapNGDir(t *testing.T) {
+	configurer := urlgetter.Configurer{
+		Config: urlgetter.Config{
+			PcapNGDir: "/tmp",
+		},
+		Logger: log.Log,
+		Saver:  new(trace.Saver),
+	}
+	configuration, err := configurer.NewConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer configuration.CloseIdleConnections()
+	if configuration.HTTPConfig.NoReadWritePayloads != false {
+		t.Fatal("not the NoReadWritePayloads we expected")
+	}
+}
+
+func TestConfigurerNewConfigurationBaseDialerAndResolver(t *testing.T) {
+	network := new(netsim.Network)
+	configurer := urlgetter.Configurer{
+		BaseDialer:   network.Dialer(),
+		BaseResolver: network.Resolver(),
+		Logger:       log.Log,
+		Saver:        new(trace.Saver),
+	}
+	configuration, err := configurer.NewConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer configuration.CloseIdleConnections()
+	if _, ok := configuration.HTTPConfig.BaseDialer.(netsim.Dialer); !ok {
+		t.Fatal("not the BaseDialer we expected")
+	}
+	if _, ok := configuration.HTTPConfig.BaseResolver.(netsim.Resolver); !ok {
+		t.Fatal("not the BaseResolver we expected")
+	}
+}
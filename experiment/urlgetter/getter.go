@@ -2,10 +2,18 @@ package urlgetter
 
 import (
 	"context"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/httptransport"
+	"github.com/ooni/probe-engine/netx/pcapng"
+	"github.com/ooni/probe-engine/netx/quicdialer"
 	"github.com/ooni/probe-engine/netx/trace"
 )
 
@@ -14,37 +22,99 @@ import (
 //
 // Other OONI experiment should use the Getter to factor code when
 // the Getter implements the operations they wanna perform.
+//
+// When Begin is zero, the Getter uses the moment in which Get is
+// called as the zero time for the events it archives. Experiments
+// that run several Getters should set Begin to the measurement start
+// time, such that all the archived events share the same zero time.
+//
+// When CertPool is not nil, the Getter uses it rather than the system
+// roots to verify certificates. Experiments that pin a CA use this field,
+// since a certificate pool cannot be a user-settable option.
+//
+// When BaseDialer and BaseResolver are not nil, the Getter uses them rather
+// than the system dialer and resolver. Tests use these fields to measure a
+// simulated network (see netx/netsim). Note that QUIC always uses the
+// system UDP stack, regardless of the BaseDialer.
 type Getter struct {
-	Config  Config
-	Session model.ExperimentSession
-	Target  string
+	BaseDialer   httptransport.Dialer
+	BaseResolver httptransport.Resolver
+	Begin        time.Time
+	CertPool     *x509.CertPool
+	Config       Config
+	Session      model.ExperimentSession
+	Target       string
 }
 
 // Get performs the action described by g using the given context
 // and returning the test keys and eventually an error
 func (g Getter) Get(ctx context.Context) (TestKeys, error) {
-	begin := time.Now()
+	if g.Begin.IsZero() {
+		g.Begin = time.Now()
+	}
 	saver := new(trace.Saver)
 	tk, err := g.get(ctx, saver)
 	if err != nil {
 		tk.Failure = archival.NewFailure(err)
 	}
 	events := saver.Read()
+	if g.Config.PcapNGDir != "" {
+		g.savePcapNG(events)
+	}
 	tk.Queries = append(
-		tk.Queries, archival.NewDNSQueriesList(begin, events)...,
+		tk.Queries, archival.NewDNSQueriesList(g.Begin, events)...,
 	)
 	tk.NetworkEvents = append(
-		tk.NetworkEvents, archival.NewNetworkEventsList(begin, events)...,
+		tk.NetworkEvents, archival.NewNetworkEventsList(g.Begin, events)...,
 	)
 	tk.Requests = append(
-		tk.Requests, archival.NewRequestList(begin, events)...,
+		tk.Requests, archival.NewRequestList(g.Begin, events)...,
+	)
+	tk.TCPConnect = append(
+		tk.TCPConnect, archival.NewTCPConnectList(g.Begin, events)...,
 	)
 	tk.TLSHandshakes = append(
-		tk.TLSHandshakes, archival.NewTLSHandshakesList(begin, events)...,
+		tk.TLSHandshakes, archival.NewTLSHandshakesList(g.Begin, events)...,
+	)
+	tk.QUICHandshakes = append(
+		tk.QUICHandshakes, archival.NewQUICHandshakesList(g.Begin, events)...,
 	)
 	return tk, err
 }
 
+// savePcapNG writes a pcap-ng synthesized from events into a new file
+// inside the PcapNGDir. Because the pcap-ng is not part of the measurement
+// we only emit a warning if we cannot write it.
+//
+// Experiments may run several Getters per measurement, hence the file
+// name identifies the Getter using the measurement start time (Begin)
+// and the Target, followed by a random suffix making it unique, e.g.,
+// urlgetter-20201016T120000Z-https___www.example.com_-123456.pcapng.
+func (g Getter) savePcapNG(events []trace.Event) {
+	logger := g.Session.Logger()
+	target := pcapngUnsafeChars.ReplaceAllString(g.Target, "_")
+	if len(target) > 64 {
+		target = target[:64] // avoid exceeding the maximum file name length
+	}
+	pattern := fmt.Sprintf("urlgetter-%s-%s-*.pcapng",
+		g.Begin.UTC().Format("20060102T150405Z"), target)
+	filep, err := ioutil.TempFile(g.Config.PcapNGDir, pattern)
+	if err != nil {
+		logger.Warnf("urlgetter: cannot create pcap-ng file: %s", err.Error())
+		return
+	}
+	defer filep.Close()
+	if err := pcapng.Write(filep, events); err != nil {
+		logger.Warnf("urlgetter: cannot write pcap-ng file: %s", err.Error())
+		return
+	}
+	logger.Infof("urlgetter: written pcap-ng to %s", filep.Name())
+}
+
+// pcapngUnsafeChars matches the characters we don't want to
+// use in the name of pcap-ng files (see savePcapNG).
+var pcapngUnsafeChars = regexp.MustCompile(`[^A-Za-z0-9.-]`)
+
 func (g Getter) get(ctx context.Context, saver *trace.Saver) (TestKeys, error) {
 	tk := TestKeys{Agent: "redirect", Tunnel: g.Config.Tunnel}
 	if g.Config.NoFollowRedirects {
@@ -58,23 +128,34 @@ func (g Getter) get(ctx context.Context, saver *trace.Saver) (TestKeys, error) {
 	if url := g.Session.ProxyURL(); url != nil {
 		tk.SOCKSProxy = url.Host
 	}
+	// QUIC would bypass the proxy (see quicdialer.ProxyDialer)
+	if g.Session.ProxyURL() != nil && strings.HasPrefix(g.Target, "quichandshake://") {
+		return tk, quicdialer.ErrProxyNotSupported
+	}
 	// create configuration
 	configurer := Configurer{
-		Config:   g.Config,
-		Logger:   g.Session.Logger(),
-		ProxyURL: g.Session.ProxyURL(),
-		Saver:    saver,
+		BaseDialer:   g.BaseDialer,
+		BaseResolver: g.BaseResolver,
+		CertPool:     g.CertPool,
+		Config:       g.Config,
+		Logger:       g.Session.Logger(),
+		ProxyURL:     g.Session.ProxyURL(),
+		Saver:        saver,
 	}
 	configuration, err := configurer.NewConfiguration()
 	if err != nil {
 		return tk, err
 	}
 	defer configuration.CloseIdleConnections()
+	tk.ClientHelloSplit = configuration.HTTPConfig.ClientHelloSplit.String()
 	// run the measurement
 	runner := Runner{
 		Config:     g.Config,
 		HTTPConfig: configuration.HTTPConfig,
 		Target:     g.Target,
 	}
-	return tk, runner.Run(ctx)
+	err = runner.Run(ctx)
+	// the late DNS replies are collected in the background
+	configuration.WaitLateReplies()
+	return tk, err
 }
@@ -4,13 +4,21 @@ import (
 	"context"
 	"errors"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/apex/log"
 	"github.com/ooni/probe-engine/experiment/urlgetter"
 	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/netx/netsim"
+	"github.com/ooni/probe-engine/netx/quicdialer"
 )
 
 func TestGetterWithCancelledContextVanilla(t *testing.T) {
@@ -272,3 +280,99 @@ func TestGetterWithCancelledContextUnknownResolverURL(t *testing.T) {
 		t.Fatal("not the Tunnel we expected")
 	}
 }
+
+func TestGetterWithCancelledContextPcapNGDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "urlgetter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	g := urlgetter.Getter{
+		Begin: time.Date(2020, 10, 16, 12, 0, 0, 0, time.UTC),
+		Config: urlgetter.Config{
+			PcapNGDir: dir,
+		},
+		Session: &mockable.ExperimentSession{MockableLogger: log.Log},
+		Target:  "https://www.google.com",
+	}
+	if _, err := g.Get(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatal("not the error we expected")
+	}
+	matches, err := filepath.Glob(filepath.Join(
+		dir, "urlgetter-20201016T120000Z-https___www.google.com-*.pcapng"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Fatal("not the number of pcap-ng files we expected")
+	}
+}
+
+func TestGetterWithSimulatedNetwork(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello, world"))
+		}))
+	defer server.Close()
+	var tests = []struct {
+		name    string
+		policy  netsim.Policy
+		failure string
+	}{{
+		name: "with no censorship",
+	}, {
+		name:    "with RST on SNI",
+		policy:  netsim.Policy{Action: netsim.ActionReset, Host: "example.com"},
+		failure: "connection_reset",
+	}, {
+		name:    "with NXDOMAIN",
+		policy:  netsim.Policy{Action: netsim.ActionNXDOMAIN, Host: "example.com"},
+		failure: "dns_nxdomain_error",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network := new(netsim.Network)
+			network.AddHost("example.com", "93.184.216.34")
+			network.AddEndpoint(
+				"93.184.216.34:443", server.Listener.Addr().String())
+			network.AddPolicy(tt.policy)
+			g := urlgetter.Getter{
+				BaseDialer:   network.Dialer(),
+				BaseResolver: network.Resolver(),
+				CertPool:     server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs,
+				Session:      &mockable.ExperimentSession{},
+				Target:       "https://example.com/",
+			}
+			tk, _ := g.Get(context.Background())
+			if tt.failure == "" {
+				if tk.Failure != nil {
+					t.Fatal(*tk.Failure)
+				}
+				if len(tk.Requests) != 1 || tk.Requests[0].Response.Code != 200 {
+					t.Fatal("not the Requests we expected")
+				}
+				return
+			}
+			if tk.Failure == nil || *tk.Failure != tt.failure {
+				t.Fatal("not the Failure we expected")
+			}
+		})
+	}
+}
+
+func TestGetterWithProxyAndQUIC(t *testing.T) {
+	proxyURL, _ := url.Parse("socks5://127.0.0.1:9050")
+	g := urlgetter.Getter{
+		Session: &mockable.ExperimentSession{MockableProxyURL: proxyURL},
+		Target:  "quichandshake://93.184.216.34:443",
+	}
+	tk, err := g.Get(context.Background())
+	if !errors.Is(err, quicdialer.ErrProxyNotSupported) {
+		t.Fatal("not the error we expected")
+	}
+	if len(tk.QUICHandshakes) != 0 {
+		t.Fatal("not the QUICHandshakes we expected")
+	}
+}
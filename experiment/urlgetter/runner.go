@@ -2,6 +2,7 @@ package urlgetter
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -11,7 +12,10 @@ import (
 
 	"github.com/ooni/probe-engine/experiment/httpheader"
 	"github.com/ooni/probe-engine/internal/runtimex"
+	"github.com/ooni/probe-engine/netx/http3transport"
 	"github.com/ooni/probe-engine/netx/httptransport"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 )
 
 // The Runner job is to run a single measurement
@@ -36,6 +40,8 @@ func (r Runner) Run(ctx context.Context) error {
 		return r.tlsHandshake(ctx, targetURL.Host)
 	case "tcpconnect":
 		return r.tcpConnect(ctx, targetURL.Host)
+	case "quichandshake":
+		return r.quicHandshake(ctx, targetURL.Host)
 	default:
 		return errors.New("unknown targetURL scheme")
 	}
@@ -51,7 +57,11 @@ func (r Runner) httpGet(ctx context.Context, url string) error {
 	if r.Config.HTTPHost != "" {
 		req.Host = r.Config.HTTPHost
 	}
-	httpClient := &http.Client{Transport: httptransport.New(r.HTTPConfig)}
+	txp := httptransport.New
+	if r.Config.HTTP3Enabled {
+		txp = http3transport.New
+	}
+	httpClient := &http.Client{Transport: txp(r.HTTPConfig)}
 	if r.Config.NoFollowRedirects {
 		httpClient.CheckRedirect = func(*http.Request, []*http.Request) error {
 			return http.ErrUseLastResponse
@@ -90,3 +100,20 @@ func (r Runner) tcpConnect(ctx context.Context, address string) error {
 	}
 	return err
 }
+
+func (r Runner) quicHandshake(ctx context.Context, address string) error {
+	// We use the same ALPN used by the HTTP/3 transport, since most
+	// QUIC servers will refuse the handshake without a valid ALPN.
+	tlsConfig := &tls.Config{NextProtos: []string{http3.NextProtoH3}}
+	if r.HTTPConfig.TLSConfig != nil {
+		tlsConfig.RootCAs = r.HTTPConfig.TLSConfig.RootCAs
+		tlsConfig.ServerName = r.HTTPConfig.TLSConfig.ServerName
+	}
+	tlsConfig.InsecureSkipVerify = r.HTTPConfig.NoTLSVerify
+	dialer := http3transport.NewQUICDialer(r.HTTPConfig)
+	sess, err := dialer.DialContext(ctx, "udp", address, tlsConfig, &quic.Config{})
+	if sess != nil {
+		sess.CloseWithError(0, "")
+	}
+	return err
+}
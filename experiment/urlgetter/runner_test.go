@@ -59,6 +59,16 @@ func TestRunnerTCPConnectWithContextCanceled(t *testing.T) {
 	}
 }
 
+func TestRunnerQUICHandshakeWithContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := urlgetter.Runner{Target: "quichandshake://www.google.com:443"}
+	err := r.Run(ctx)
+	if err == nil || !strings.HasSuffix(err.Error(), "operation was canceled") {
+		t.Fatal("not the error we expected")
+	}
+}
+
 func TestRunnerWithInvalidURL(t *testing.T) {
 	r := urlgetter.Runner{Target: "\t"}
 	err := r.Run(context.Background())
@@ -97,6 +107,17 @@ func TestIntegrationRunnerTCPConnectSuccess(t *testing.T) {
 	}
 }
 
+func TestIntegrationRunnerQUICHandshakeSuccess(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	r := urlgetter.Runner{Target: "quichandshake://www.google.com:443"}
+	err := r.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestIntegrationRunnerDNSLookupSuccess(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping test in short mode")
@@ -119,6 +140,20 @@ func TestIntegrationRunnerHTTPSSuccess(t *testing.T) {
 	}
 }
 
+func TestIntegrationRunnerHTTP3Success(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	r := urlgetter.Runner{
+		Config: urlgetter.Config{HTTP3Enabled: true},
+		Target: "https://www.google.com",
+	}
+	err := r.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestRunnerHTTPSetHostHeader(t *testing.T) {
 	var host string
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -12,39 +12,52 @@ import (
 
 const (
 	testName    = "urlgetter"
-	testVersion = "0.0.3"
+	testVersion = "0.0.10"
 )
 
 // Config contains the experiment's configuration.
 type Config struct {
+	ClientHelloSplit  string `ooni:"Split the ClientHello in TCP segments, e.g. '1,20/100ms'"`
 	DNSCache          string `ooni:"Add 'DOMAIN IP...' to cache"`
+	DNSUDPWindow      int64  `ooni:"Milliseconds to wait for late DNS over UDP replies"`
+	EDNS0BufferSize   int64  `ooni:"EDNS0 UDP payload size to advertise (default: 4096)"`
+	EDNS0ClientSubnet string `ooni:"EDNS0 client subnet to send, e.g. '192.0.2.0/24'"`
+	EDNS0DNSSECOK     bool   `ooni:"Set the EDNS0 DNSSEC OK bit"`
+	HTTP3Enabled      bool   `ooni:"Use HTTP/3 instead of HTTP/1.1 or HTTP/2"`
 	HTTPHost          string `ooni:"Force using specific HTTP Host header"`
 	NoFollowRedirects bool   `ooni:"Disable following redirects"`
 	NoTLSVerify       bool   `ooni:"Disable TLS verification"`
+	ParallelResolver  bool   `ooni:"Send A and AAAA queries in parallel"`
+	PcapNGDir         string `ooni:"Write a synthesized pcap-ng of the traffic of each target into this directory"`
 	RejectDNSBogons   bool   `ooni:"Fail DNS lookup if response contains bogons"`
 	ResolverURL       string `ooni:"URL describing the resolver to use"`
+	TLSClientHelloID  string `ooni:"Parrot the ClientHello of chrome, firefox, ios or randomized"`
 	TLSServerName     string `ooni:"Force TLS to using a specific SNI in Client Hello"`
 	Tunnel            string `ooni:"Run experiment over a tunnel, e.g. psiphon"`
 }
 
 // TestKeys contains the experiment's result.
 type TestKeys struct {
-	Agent         string                   `json:"agent"`
-	BootstrapTime float64                  `json:"bootstrap_time,omitempty"`
-	DNSCache      []string                 `json:"dns_cache,omitempty"`
-	Failure       *string                  `json:"failure"`
-	NetworkEvents []archival.NetworkEvent  `json:"network_events"`
-	Queries       []archival.DNSQueryEntry `json:"queries"`
-	Requests      []archival.RequestEntry  `json:"requests"`
-	SOCKSProxy    string                   `json:"socksproxy,omitempty"`
-	TLSHandshakes []archival.TLSHandshake  `json:"tls_handshakes"`
-	Tunnel        string                   `json:"tunnel,omitempty"`
+	Agent            string                     `json:"agent"`
+	BootstrapTime    float64                    `json:"bootstrap_time,omitempty"`
+	ClientHelloSplit string                     `json:"client_hello_split,omitempty"`
+	DNSCache         []string                   `json:"dns_cache,omitempty"`
+	Failure          *string                    `json:"failure"`
+	NetworkEvents    []archival.NetworkEvent    `json:"network_events"`
+	QUICHandshakes   []archival.TLSHandshake    `json:"quic_handshakes"`
+	Queries          []archival.DNSQueryEntry   `json:"queries"`
+	Requests         []archival.RequestEntry    `json:"requests"`
+	SOCKSProxy       string                     `json:"socksproxy,omitempty"`
+	TCPConnect       []archival.TCPConnectEntry `json:"tcp_connect"`
+	TLSHandshakes    []archival.TLSHandshake    `json:"tls_handshakes"`
+	Tunnel           string                     `json:"tunnel,omitempty"`
 }
 
 func registerExtensions(m *model.Measurement) {
 	archival.ExtHTTP.AddTo(m)
 	archival.ExtDNS.AddTo(m)
 	archival.ExtNetevents.AddTo(m)
+	archival.ExtTCPConnect.AddTo(m)
 	archival.ExtTLSHandshake.AddTo(m)
 }
 
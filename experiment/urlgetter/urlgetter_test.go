@@ -19,7 +19,7 @@ func TestMeasurer(t *testing.T) {
 	if m.ExperimentName() != "urlgetter" {
 		t.Fatal("invalid experiment name")
 	}
-	if m.ExperimentVersion() != "0.0.3" {
+	if m.ExperimentVersion() != "0.0.10" {
 		t.Fatal("invalid experiment version")
 	}
 	measurement := new(model.Measurement)
@@ -31,7 +31,7 @@ func TestMeasurer(t *testing.T) {
 	if !errors.Is(err, context.Canceled) {
 		t.Fatal("not the error we expected")
 	}
-	if len(measurement.Extensions) != 4 {
+	if len(measurement.Extensions) != 5 {
 		t.Fatal("not the expected number of extensions")
 	}
 }
@@ -45,7 +45,7 @@ func TestMeasurerDNSCache(t *testing.T) {
 	if m.ExperimentName() != "urlgetter" {
 		t.Fatal("invalid experiment name")
 	}
-	if m.ExperimentVersion() != "0.0.3" {
+	if m.ExperimentVersion() != "0.0.10" {
 		t.Fatal("invalid experiment version")
 	}
 	measurement := new(model.Measurement)
@@ -57,7 +57,7 @@ func TestMeasurerDNSCache(t *testing.T) {
 	if !errors.Is(err, context.Canceled) {
 		t.Fatal("not the error we expected")
 	}
-	if len(measurement.Extensions) != 4 {
+	if len(measurement.Extensions) != 5 {
 		t.Fatal("not the expected number of extensions")
 	}
 	tk := measurement.TestKeys.(urlgetter.TestKeys)
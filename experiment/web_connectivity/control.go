@@ -0,0 +1,64 @@
+package web_connectivity
+
+import (
+	"context"
+
+	"github.com/ooni/probe-engine/internal/jsonapi"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/archival"
+)
+
+// ControlRequest is the request that we send to the control
+type ControlRequest struct {
+	HTTPRequest        string              `json:"http_request"`
+	HTTPRequestHeaders map[string][]string `json:"http_request_headers"`
+	TCPConnect         []string            `json:"tcp_connect"`
+}
+
+// ControlTCPConnectResult is the result of the TCP connect
+// attempt performed by the control vantage point.
+type ControlTCPConnectResult struct {
+	Status  bool    `json:"status"`
+	Failure *string `json:"failure"`
+}
+
+// ControlHTTPRequestResult is the result of the HTTP request
+// performed by the control vantage point.
+type ControlHTTPRequestResult struct {
+	BodyLength int64             `json:"body_length"`
+	Failure    *string           `json:"failure"`
+	Title      string            `json:"title"`
+	Headers    map[string]string `json:"headers"`
+	StatusCode int64             `json:"status_code"`
+}
+
+// ControlDNSResult is the result of the DNS lookup
+// performed by the control vantage point.
+type ControlDNSResult struct {
+	Failure *string  `json:"failure"`
+	Addrs   []string `json:"addrs"`
+}
+
+// ControlResponse is the response from the control service.
+type ControlResponse struct {
+	TCPConnect  map[string]ControlTCPConnectResult `json:"tcp_connect"`
+	HTTPRequest ControlHTTPRequestResult           `json:"http_request"`
+	DNS         ControlDNSResult                   `json:"dns"`
+}
+
+// Control performs the control request and returns the response.
+func Control(
+	ctx context.Context, sess model.ExperimentSession,
+	thAddr string, creq ControlRequest) (out ControlResponse, err error) {
+	clnt := &jsonapi.Client{
+		BaseURL:    thAddr,
+		HTTPClient: sess.DefaultHTTPClient(),
+		Logger:     sess.Logger(),
+		UserAgent:  sess.UserAgent(),
+	}
+	sess.Logger().Infof("web_connectivity: control for %s...", creq.HTTPRequest)
+	err = clnt.Create(ctx, "/", creq, &out)
+	sess.Logger().Infof("web_connectivity: control for %s... %s",
+		creq.HTTPRequest, asString(archival.NewFailure(err)))
+	return
+}
@@ -0,0 +1,85 @@
+package web_connectivity
+
+import (
+	"net"
+
+	"github.com/ooni/probe-engine/geoiplookup/mmdblookup"
+	"github.com/ooni/probe-engine/netx/modelx"
+	"github.com/ooni/probe-engine/netx/resolver"
+)
+
+const (
+	// DNSConsistent indicates that the measurement and the
+	// control have consistent DNS results.
+	DNSConsistent = "consistent"
+
+	// DNSInconsistent indicates that the measurement and the
+	// control have inconsistent DNS results.
+	DNSInconsistent = "inconsistent"
+
+	// controlDNSNameError is the failure returned by the
+	// control when the domain does not exist.
+	controlDNSNameError = "dns_name_error"
+)
+
+// dnsAnalysis compares the addresses we resolved with the ones that the
+// control resolved and tells us whether they are consistent. The algorithm
+// is the same used by Measurement Kit's Web Connectivity.
+func dnsAnalysis(
+	hostname string, failure *string, addrs []string,
+	control ControlDNSResult, lookupASN mmdblookup.ASNLookupFunc,
+) string {
+	// 1. when the input is an IP address there is no DNS lookup
+	// and the control will fail with dns_name_error
+	if net.ParseIP(hostname) != nil {
+		return DNSConsistent
+	}
+	// 2. the results are consistent if both failed because
+	// the domain does not exist, inconsistent otherwise
+	if failure != nil || control.Failure != nil {
+		if failure != nil && *failure == modelx.FailureDNSNXDOMAINError &&
+			control.Failure != nil && *control.Failure == controlDNSNameError {
+			return DNSConsistent
+		}
+		return DNSInconsistent
+	}
+	// 3. we treat bogons in the measurement as a signal of
+	// tampering unless the control sees bogons as well
+	if anyBogon(addrs) && !anyBogon(control.Addrs) {
+		return DNSInconsistent
+	}
+	// 4. the results are consistent if there is at least
+	// an address returned both by the probe and the control
+	seen := make(map[string]bool)
+	for _, addr := range control.Addrs {
+		seen[addr] = true
+	}
+	for _, addr := range addrs {
+		if seen[addr] {
+			return DNSConsistent
+		}
+	}
+	// 5. the results are consistent if there is at least one
+	// address belonging to the same AS in both lists
+	asns := make(map[uint]bool)
+	for _, addr := range control.Addrs {
+		if asn, err := lookupASN(addr); err == nil && asn != 0 {
+			asns[asn] = true
+		}
+	}
+	for _, addr := range addrs {
+		if asn, err := lookupASN(addr); err == nil && asn != 0 && asns[asn] {
+			return DNSConsistent
+		}
+	}
+	return DNSInconsistent
+}
+
+func anyBogon(addrs []string) bool {
+	for _, addr := range addrs {
+		if resolver.IsBogon(addr) {
+			return true
+		}
+	}
+	return false
+}
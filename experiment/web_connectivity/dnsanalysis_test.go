@@ -0,0 +1,85 @@
+package web_connectivity
+
+import (
+	"testing"
+
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+func TestUnitDNSAnalysisWithIPAddress(t *testing.T) {
+	out := dnsAnalysis("8.8.8.8", nil, []string{"8.8.8.8"}, ControlDNSResult{
+		Failure: stringPointer(controlDNSNameError),
+	}, mockable.ASNDatabase{}.LookupASN)
+	if out != DNSConsistent {
+		t.Fatal("unexpected result")
+	}
+}
+
+func TestUnitDNSAnalysisBothNXDOMAIN(t *testing.T) {
+	out := dnsAnalysis("antani.local", stringPointer(modelx.FailureDNSNXDOMAINError),
+		nil, ControlDNSResult{
+			Failure: stringPointer(controlDNSNameError),
+		}, mockable.ASNDatabase{}.LookupASN)
+	if out != DNSConsistent {
+		t.Fatal("unexpected result")
+	}
+}
+
+func TestUnitDNSAnalysisOnlyMeasurementFailed(t *testing.T) {
+	out := dnsAnalysis("example.com", stringPointer(modelx.FailureDNSNXDOMAINError),
+		nil, ControlDNSResult{
+			Addrs: []string{"93.184.216.34"},
+		}, mockable.ASNDatabase{}.LookupASN)
+	if out != DNSInconsistent {
+		t.Fatal("unexpected result")
+	}
+}
+
+func TestUnitDNSAnalysisBogon(t *testing.T) {
+	out := dnsAnalysis("example.com", nil, []string{"10.0.0.1"}, ControlDNSResult{
+		Addrs: []string{"93.184.216.34"},
+	}, mockable.ASNDatabase{}.LookupASN)
+	if out != DNSInconsistent {
+		t.Fatal("unexpected result")
+	}
+}
+
+func TestUnitDNSAnalysisCommonAddress(t *testing.T) {
+	out := dnsAnalysis("example.com", nil, []string{
+		"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946",
+	}, ControlDNSResult{
+		Addrs: []string{"93.184.216.34"},
+	}, mockable.ASNDatabase{}.LookupASN)
+	if out != DNSConsistent {
+		t.Fatal("unexpected result")
+	}
+}
+
+func TestUnitDNSAnalysisCommonASN(t *testing.T) {
+	out := dnsAnalysis("example.com", nil, []string{"93.184.216.35"}, ControlDNSResult{
+		Addrs: []string{"93.184.216.34"},
+	}, mockable.ASNDatabase{
+		"93.184.216.34": 15133,
+		"93.184.216.35": 15133,
+	}.LookupASN)
+	if out != DNSConsistent {
+		t.Fatal("unexpected result")
+	}
+}
+
+func TestUnitDNSAnalysisNoCommonASN(t *testing.T) {
+	out := dnsAnalysis("example.com", nil, []string{"1.1.1.1"}, ControlDNSResult{
+		Addrs: []string{"93.184.216.34"},
+	}, mockable.ASNDatabase{
+		"1.1.1.1":       13335,
+		"93.184.216.34": 15133,
+	}.LookupASN)
+	if out != DNSInconsistent {
+		t.Fatal("unexpected result")
+	}
+}
+
+func stringPointer(s string) *string {
+	return &s
+}
@@ -0,0 +1,177 @@
+package web_connectivity
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/ooni/probe-engine/netx/archival"
+)
+
+// httpAnalysisResult contains the results of comparing the
+// HTTP response we received with the control response.
+type httpAnalysisResult struct {
+	BodyLengthMatch *bool
+	BodyProportion  *float64
+	HeadersMatch    *bool
+	StatusCodeMatch *bool
+	TitleMatch      *bool
+}
+
+// httpAnalysis compares the final response in the redirect chain
+// with the one fetched by the control. All the comparisons return
+// nil when either side lacks the information required.
+func httpAnalysis(
+	requests []archival.RequestEntry, control ControlHTTPRequestResult,
+) (out httpAnalysisResult) {
+	if len(requests) <= 0 || control.Failure != nil {
+		return
+	}
+	// Implementation note: archival.NewRequestList puts the last
+	// request of the redirect chain in the first position.
+	response := requests[0].Response
+	out.BodyLengthMatch, out.BodyProportion = httpBodyLengthMatch(response, control)
+	out.HeadersMatch = httpHeadersMatch(response, control)
+	out.StatusCodeMatch = httpStatusCodeMatch(response, control)
+	out.TitleMatch = httpTitleMatch(response, control)
+	return
+}
+
+// httpBodyProportionThreshold is the threshold above which we
+// consider the body lengths to match. It's the same used by MK.
+const httpBodyProportionThreshold = 0.7
+
+func httpBodyLengthMatch(
+	response archival.HTTPResponse, control ControlHTTPRequestResult,
+) (*bool, *float64) {
+	measurement := int64(len(response.Body.Value))
+	if measurement <= 0 || control.BodyLength <= 0 {
+		return nil, nil
+	}
+	// If the body has been truncated, we cannot compare lengths
+	// unless the control body is even smaller than the snapshot.
+	if response.BodyIsTruncated && measurement <= control.BodyLength {
+		return nil, nil
+	}
+	var proportion float64
+	if measurement >= control.BodyLength {
+		proportion = float64(control.BodyLength) / float64(measurement)
+	} else {
+		proportion = float64(measurement) / float64(control.BodyLength)
+	}
+	match := proportion > httpBodyProportionThreshold
+	return &match, &proportion
+}
+
+func httpStatusCodeMatch(
+	response archival.HTTPResponse, control ControlHTTPRequestResult,
+) *bool {
+	if response.Code <= 0 || control.StatusCode <= 0 {
+		return nil
+	}
+	match := response.Code == control.StatusCode
+	if !match && control.StatusCode/100 == 5 {
+		// If the control fails with a server error, it's not
+		// helpful to say that the status code does not match.
+		return nil
+	}
+	return &match
+}
+
+// httpCommonHeaders contains headers that are so common that
+// comparing them does not tell us anything useful.
+var httpCommonHeaders = map[string]bool{
+	"date":                      true,
+	"content-type":              true,
+	"server":                    true,
+	"cache-control":             true,
+	"vary":                      true,
+	"set-cookie":                true,
+	"location":                  true,
+	"expires":                   true,
+	"x-powered-by":              true,
+	"content-encoding":          true,
+	"last-modified":             true,
+	"accept-ranges":             true,
+	"pragma":                    true,
+	"x-frame-options":           true,
+	"etag":                      true,
+	"x-content-type-options":    true,
+	"age":                       true,
+	"via":                       true,
+	"p3p":                       true,
+	"x-xss-protection":          true,
+	"content-language":          true,
+	"cf-ray":                    true,
+	"strict-transport-security": true,
+	"link":                      true,
+	"x-varnish":                 true,
+}
+
+func httpHeadersMatch(
+	response archival.HTTPResponse, control ControlHTTPRequestResult,
+) *bool {
+	if len(response.Headers) <= 0 || len(control.Headers) <= 0 {
+		return nil
+	}
+	const (
+		inMeasurement = 1 << 0
+		inControl     = 1 << 1
+		inBoth        = inMeasurement | inControl
+	)
+	uncommon := make(map[string]int)
+	for key := range response.Headers {
+		if key = strings.ToLower(key); !httpCommonHeaders[key] {
+			uncommon[key] |= inMeasurement
+		}
+	}
+	for key := range control.Headers {
+		if key = strings.ToLower(key); !httpCommonHeaders[key] {
+			uncommon[key] |= inControl
+		}
+	}
+	match := true
+	for _, value := range uncommon {
+		if value != inBoth {
+			match = false
+			break
+		}
+	}
+	return &match
+}
+
+var httpTitleRegexp = regexp.MustCompile(`(?i)<title>([^<]{1,256})</title>`)
+
+// httpTitleMinWordLength is the minimum length of a title word
+// that we consider when comparing titles, like MK does.
+const httpTitleMinWordLength = 5
+
+func httpTitleMatch(
+	response archival.HTTPResponse, control ControlHTTPRequestResult,
+) *bool {
+	if response.Code <= 0 || control.StatusCode <= 0 {
+		return nil
+	}
+	if response.Code != control.StatusCode {
+		return nil
+	}
+	var measurement string
+	if v := httpTitleRegexp.FindStringSubmatch(response.Body.Value); len(v) > 1 {
+		measurement = v[1]
+	}
+	if measurement == "" || control.Title == "" {
+		return nil
+	}
+	words := make(map[string]bool)
+	for _, word := range strings.Fields(strings.ToLower(control.Title)) {
+		words[word] = true
+	}
+	// Compare the first long enough word of our title
+	for _, word := range strings.Fields(strings.ToLower(measurement)) {
+		if len(word) < httpTitleMinWordLength {
+			continue
+		}
+		match := words[word]
+		return &match
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package web_connectivity
+
+import (
+	"testing"
+
+	"github.com/ooni/probe-engine/netx/archival"
+)
+
+func TestUnitHTTPAnalysisNoRequests(t *testing.T) {
+	out := httpAnalysis(nil, ControlHTTPRequestResult{})
+	if out.BodyLengthMatch != nil || out.HeadersMatch != nil ||
+		out.StatusCodeMatch != nil || out.TitleMatch != nil {
+		t.Fatal("expected all nil results")
+	}
+}
+
+func TestUnitHTTPAnalysisControlFailure(t *testing.T) {
+	out := httpAnalysis([]archival.RequestEntry{{}}, ControlHTTPRequestResult{
+		Failure: stringPointer("connection_refused"),
+	})
+	if out.BodyLengthMatch != nil || out.HeadersMatch != nil ||
+		out.StatusCodeMatch != nil || out.TitleMatch != nil {
+		t.Fatal("expected all nil results")
+	}
+}
+
+func TestUnitHTTPBodyLengthMatch(t *testing.T) {
+	response := archival.HTTPResponse{
+		Body: archival.HTTPBody{Value: "0123456789"},
+	}
+	match, proportion := httpBodyLengthMatch(response, ControlHTTPRequestResult{
+		BodyLength: 8,
+	})
+	if match == nil || *match != true {
+		t.Fatal("expected a match")
+	}
+	if proportion == nil || *proportion != 0.8 {
+		t.Fatal("unexpected proportion")
+	}
+	match, _ = httpBodyLengthMatch(response, ControlHTTPRequestResult{
+		BodyLength: 100,
+	})
+	if match == nil || *match != false {
+		t.Fatal("expected no match")
+	}
+}
+
+func TestUnitHTTPBodyLengthMatchTruncated(t *testing.T) {
+	response := archival.HTTPResponse{
+		Body:            archival.HTTPBody{Value: "0123456789"},
+		BodyIsTruncated: true,
+	}
+	match, _ := httpBodyLengthMatch(response, ControlHTTPRequestResult{
+		BodyLength: 100,
+	})
+	if match != nil {
+		t.Fatal("expected nil")
+	}
+}
+
+func TestUnitHTTPStatusCodeMatch(t *testing.T) {
+	match := httpStatusCodeMatch(archival.HTTPResponse{Code: 200},
+		ControlHTTPRequestResult{StatusCode: 200})
+	if match == nil || *match != true {
+		t.Fatal("expected a match")
+	}
+	match = httpStatusCodeMatch(archival.HTTPResponse{Code: 403},
+		ControlHTTPRequestResult{StatusCode: 200})
+	if match == nil || *match != false {
+		t.Fatal("expected no match")
+	}
+	match = httpStatusCodeMatch(archival.HTTPResponse{Code: 200},
+		ControlHTTPRequestResult{StatusCode: 503})
+	if match != nil {
+		t.Fatal("expected nil")
+	}
+}
+
+func TestUnitHTTPHeadersMatch(t *testing.T) {
+	response := archival.HTTPResponse{
+		Headers: map[string]archival.MaybeBinaryValue{
+			"Date":     {Value: "Mon, 01 Jun 2020 10:00:00 GMT"},
+			"X-Antani": {Value: "mascetti"},
+		},
+	}
+	match := httpHeadersMatch(response, ControlHTTPRequestResult{
+		Headers: map[string]string{"x-antani": "melandri", "Server": "nginx"},
+	})
+	if match == nil || *match != true {
+		t.Fatal("expected a match")
+	}
+	match = httpHeadersMatch(response, ControlHTTPRequestResult{
+		Headers: map[string]string{"X-Sbiriguda": "melandri"},
+	})
+	if match == nil || *match != false {
+		t.Fatal("expected no match")
+	}
+}
+
+func TestUnitHTTPTitleMatch(t *testing.T) {
+	response := archival.HTTPResponse{
+		Body: archival.HTTPBody{Value: "<TITLE>The Antani Website</TITLE>"},
+		Code: 200,
+	}
+	match := httpTitleMatch(response, ControlHTTPRequestResult{
+		StatusCode: 200, Title: "antani website",
+	})
+	if match == nil || *match != true {
+		t.Fatal("expected a match")
+	}
+	match = httpTitleMatch(response, ControlHTTPRequestResult{
+		StatusCode: 200, Title: "Blocked by order of the court",
+	})
+	if match == nil || *match != false {
+		t.Fatal("expected no match")
+	}
+	match = httpTitleMatch(response, ControlHTTPRequestResult{
+		StatusCode: 404, Title: "antani website",
+	})
+	if match != nil {
+		t.Fatal("expected nil")
+	}
+}
@@ -0,0 +1,113 @@
+package web_connectivity
+
+import "github.com/ooni/probe-engine/netx/modelx"
+
+const (
+	// BlockingDNS indicates that the website is blocked by
+	// tampering with the DNS resolution.
+	BlockingDNS = "dns"
+
+	// BlockingTCPIP indicates that the website is blocked
+	// by preventing us from connecting to its endpoints.
+	BlockingTCPIP = "tcp_ip"
+
+	// BlockingHTTPFailure indicates that the website is blocked
+	// by causing the HTTP request to fail.
+	BlockingHTTPFailure = "http-failure"
+
+	// BlockingHTTPDiff indicates that the website is blocked by
+	// returning content different from the expected one.
+	BlockingHTTPDiff = "http-diff"
+)
+
+// summary contains the final verdict of the experiment.
+type summary struct {
+	Accessible *bool
+	Blocking   interface{}
+}
+
+// summarize determines whether the website is accessible and the kind
+// of blocking, if any, from the test keys. Blocking is nil when we
+// cannot determine the blocking status, false when there is no
+// blocking, or one of the BlockingXXX strings otherwise. The logic
+// follows closely the one used by MK.
+func summarize(tk *TestKeys) (out summary) {
+	var (
+		accessible   = true
+		inaccessible = false
+	)
+	// If the control failed, we cannot say anything
+	if tk.ControlFailure != nil {
+		return
+	}
+	// If the website is down for the control as well, it's not
+	// accessible but this is not a censorship case.
+	if tk.Control.HTTPRequest.Failure != nil {
+		if tk.DNSConsistency == DNSConsistent {
+			out.Accessible = &inaccessible
+			out.Blocking = false
+			return
+		}
+		out.Accessible = &inaccessible
+		out.Blocking = BlockingDNS
+		return
+	}
+	// If all TCP connects failed, while the control was able to
+	// connect, the blocking is either at DNS or at TCP/IP level.
+	if tk.TCPConnectAttempts > 0 && tk.TCPConnectSuccesses <= 0 &&
+		controlTCPConnectSucceeded(tk.Control) {
+		out.Accessible = &inaccessible
+		out.Blocking = BlockingTCPIP
+		if tk.DNSConsistency == DNSInconsistent {
+			out.Blocking = BlockingDNS
+		}
+		return
+	}
+	// If the HTTP request failed, while the control succeeded,
+	// let's see whether it's DNS or HTTP blocking.
+	if tk.HTTPExperimentFailure != nil {
+		out.Accessible = &inaccessible
+		switch {
+		case tk.DNSConsistency == DNSInconsistent:
+			out.Blocking = BlockingDNS
+		case *tk.HTTPExperimentFailure == modelx.FailureDNSNXDOMAINError:
+			out.Blocking = BlockingDNS
+		default:
+			out.Blocking = BlockingHTTPFailure
+		}
+		return
+	}
+	// The HTTP request succeeded. If we could not compare the status
+	// code with the control's, e.g. because the control returned a
+	// server error, we cannot say anything.
+	if tk.StatusCodeMatch == nil {
+		return
+	}
+	// The website is accessible if the content we've got looks
+	// like the control content.
+	if isTrue(tk.StatusCodeMatch) && (isTrue(tk.BodyLengthMatch) ||
+		isTrue(tk.HeadersMatch) || isTrue(tk.TitleMatch)) {
+		out.Accessible = &accessible
+		out.Blocking = false
+		return
+	}
+	out.Accessible = &inaccessible
+	out.Blocking = BlockingHTTPDiff
+	if tk.DNSConsistency == DNSInconsistent {
+		out.Blocking = BlockingDNS
+	}
+	return
+}
+
+func controlTCPConnectSucceeded(control ControlResponse) bool {
+	for _, entry := range control.TCPConnect {
+		if entry.Status {
+			return true
+		}
+	}
+	return false
+}
+
+func isTrue(v *bool) bool {
+	return v != nil && *v
+}
@@ -0,0 +1,121 @@
+package web_connectivity
+
+import (
+	"testing"
+
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+func boolPointer(v bool) *bool {
+	return &v
+}
+
+func TestUnitSummarizeControlFailure(t *testing.T) {
+	out := summarize(&TestKeys{
+		ControlFailure: stringPointer("connection_refused"),
+	})
+	if out.Accessible != nil || out.Blocking != nil {
+		t.Fatal("expected nil results")
+	}
+}
+
+func TestUnitSummarizeWebsiteDown(t *testing.T) {
+	out := summarize(&TestKeys{
+		Control: ControlResponse{
+			HTTPRequest: ControlHTTPRequestResult{
+				Failure: stringPointer("connection_refused"),
+			},
+		},
+		DNSConsistency: DNSConsistent,
+	})
+	if out.Accessible == nil || *out.Accessible != false {
+		t.Fatal("unexpected Accessible")
+	}
+	if out.Blocking != false {
+		t.Fatal("unexpected Blocking")
+	}
+}
+
+func TestUnitSummarizeTCPIPBlocking(t *testing.T) {
+	out := summarize(&TestKeys{
+		Control: ControlResponse{
+			TCPConnect: map[string]ControlTCPConnectResult{
+				"1.1.1.1:443": {Status: true},
+			},
+		},
+		DNSConsistency:        DNSConsistent,
+		HTTPExperimentFailure: stringPointer(modelx.FailureGenericTimeoutError),
+		TCPConnectAttempts:    1,
+	})
+	if out.Accessible == nil || *out.Accessible != false {
+		t.Fatal("unexpected Accessible")
+	}
+	if out.Blocking != BlockingTCPIP {
+		t.Fatal("unexpected Blocking")
+	}
+}
+
+func TestUnitSummarizeDNSBlocking(t *testing.T) {
+	out := summarize(&TestKeys{
+		DNSConsistency:        DNSInconsistent,
+		HTTPExperimentFailure: stringPointer(modelx.FailureConnectionReset),
+	})
+	if out.Blocking != BlockingDNS {
+		t.Fatal("unexpected Blocking")
+	}
+}
+
+func TestUnitSummarizeHTTPFailure(t *testing.T) {
+	out := summarize(&TestKeys{
+		DNSConsistency:        DNSConsistent,
+		HTTPExperimentFailure: stringPointer(modelx.FailureConnectionReset),
+		TCPConnectAttempts:    1,
+		TCPConnectSuccesses:   1,
+	})
+	if out.Blocking != BlockingHTTPFailure {
+		t.Fatal("unexpected Blocking")
+	}
+}
+
+func TestUnitSummarizeHTTPDiff(t *testing.T) {
+	out := summarize(&TestKeys{
+		BodyLengthMatch: boolPointer(false),
+		DNSConsistency:  DNSConsistent,
+		HeadersMatch:    boolPointer(false),
+		StatusCodeMatch: boolPointer(true),
+		TitleMatch:      boolPointer(false),
+	})
+	if out.Accessible == nil || *out.Accessible != false {
+		t.Fatal("unexpected Accessible")
+	}
+	if out.Blocking != BlockingHTTPDiff {
+		t.Fatal("unexpected Blocking")
+	}
+}
+
+func TestUnitSummarizeMissingStatusCodeMatch(t *testing.T) {
+	out := summarize(&TestKeys{
+		BodyLengthMatch: boolPointer(false),
+		DNSConsistency:  DNSConsistent,
+	})
+	if out.Accessible != nil {
+		t.Fatal("unexpected Accessible")
+	}
+	if out.Blocking != nil {
+		t.Fatal("unexpected Blocking")
+	}
+}
+
+func TestUnitSummarizeAccessible(t *testing.T) {
+	out := summarize(&TestKeys{
+		BodyLengthMatch: boolPointer(true),
+		DNSConsistency:  DNSConsistent,
+		StatusCodeMatch: boolPointer(true),
+	})
+	if out.Accessible == nil || *out.Accessible != true {
+		t.Fatal("unexpected Accessible")
+	}
+	if out.Blocking != false {
+		t.Fatal("unexpected Blocking")
+	}
+}
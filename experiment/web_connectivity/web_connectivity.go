@@ -1,25 +1,72 @@
-// Package web_connectivity contains the Web Connectivity network experiment.
+// Package web_connectivity contains the Web Connectivity network
+// experiment. This file in particular is a pure-Go implementation
+// of that experiment that does not depend on Measurement Kit.
+//
+// See https://github.com/ooni/spec/blob/master/nettests/ts-017-web-connectivity.md.
 package web_connectivity
 
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
 
-	"github.com/ooni/probe-engine/experiment/mkhelper"
-	"github.com/ooni/probe-engine/experiment/mkrunner"
-	"github.com/ooni/probe-engine/measurementkit"
+	"github.com/ooni/probe-engine/experiment/httpheader"
+	"github.com/ooni/probe-engine/experiment/urlgetter"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/archival"
 )
 
 const (
 	testName    = "web_connectivity"
-	testVersion = "0.0.1"
+	testVersion = "0.1.0"
 )
 
 // Config contains the experiment config.
-type Config struct {
-	// LogLevel is the MK log level. Empty implies "WARNING".
-	LogLevel string
+type Config struct{}
+
+// TestKeys contains webconnectivity test keys.
+type TestKeys struct {
+	Agent          string `json:"agent"`
+	ClientResolver string `json:"client_resolver"`
+
+	// DNS experiment
+	Queries              []archival.DNSQueryEntry `json:"queries"`
+	DNSExperimentFailure *string                  `json:"dns_experiment_failure"`
+	DNSConsistency       string                   `json:"dns_consistency"`
+
+	// Control experiment
+	ControlFailure *string         `json:"control_failure"`
+	ControlRequest ControlRequest  `json:"-"`
+	Control        ControlResponse `json:"control"`
+
+	// TCP connect experiment
+	TCPConnect          []archival.TCPConnectEntry `json:"tcp_connect"`
+	TCPConnectAttempts  int64                      `json:"-"`
+	TCPConnectSuccesses int64                      `json:"-"`
+
+	// HTTP experiment
+	Requests              []archival.RequestEntry `json:"requests"`
+	TLSHandshakes         []archival.TLSHandshake `json:"tls_handshakes"`
+	HTTPExperimentFailure *string                 `json:"http_experiment_failure"`
+	BodyLengthMatch       *bool                   `json:"body_length_match"`
+	BodyProportion        *float64                `json:"body_proportion"`
+	HeadersMatch          *bool                   `json:"headers_match"`
+	StatusCodeMatch       *bool                   `json:"status_code_match"`
+	TitleMatch            *bool                   `json:"title_match"`
+
+	// Top-level analysis
+	Accessible *bool       `json:"accessible"`
+	Blocking   interface{} `json:"blocking"`
+}
+
+func registerExtensions(m *model.Measurement) {
+	archival.ExtHTTP.AddTo(m)
+	archival.ExtDNS.AddTo(m)
+	archival.ExtTCPConnect.AddTo(m)
+	archival.ExtTLSHandshake.AddTo(m)
 }
 
 type measurer struct {
@@ -34,30 +81,212 @@ func (m *measurer) ExperimentVersion() string {
 	return testVersion
 }
 
+var (
+	// ErrNoAvailableTestHelpers is emitted when there are no available test helpers.
+	ErrNoAvailableTestHelpers = errors.New("no available helpers")
+
+	// ErrNoInput indicates that no input was provided
+	ErrNoInput = errors.New("no input provided")
+
+	// ErrInputIsNotAnURL indicates that the input is not an URL.
+	ErrInputIsNotAnURL = errors.New("input is not an URL")
+
+	// ErrUnsupportedInput indicates that the input URL scheme is unsupported.
+	ErrUnsupportedInput = errors.New("unsupported input scheme")
+)
+
 func (m *measurer) Run(
 	ctx context.Context, sess model.ExperimentSession,
 	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
 ) error {
-	settings := measurementkit.NewSettings(
-		"WebConnectivity", sess.SoftwareName(), sess.SoftwareVersion(),
-		sess.CABundlePath(), sess.ProbeASNString(), sess.ProbeCC(),
-		sess.ProbeIP(), sess.ProbeNetworkName(), m.config.LogLevel,
-	)
-	settings.Options.GeoIPASNPath = sess.ASNDatabasePath()
+	registerExtensions(measurement)
+	tk := &TestKeys{
+		Agent:          "redirect",
+		ClientResolver: measurement.ResolverIP,
+	}
+	measurement.TestKeys = tk
+	// 0. parse the input and select the test helper
 	if measurement.Input == "" {
-		return errors.New("web_connectivity: passed an empty input")
+		return ErrNoInput
+	}
+	URL, err := url.Parse(string(measurement.Input))
+	if err != nil {
+		return ErrInputIsNotAnURL
+	}
+	if URL.Scheme != "http" && URL.Scheme != "https" {
+		return ErrUnsupportedInput
 	}
-	settings.Inputs = []string{string(measurement.Input)}
-	err := mkhelper.Set(sess, "web-connectivity", "https", &settings)
+	thAddr, err := selectTestHelper(sess)
 	if err != nil {
 		return err
 	}
-	return mkrunner.Do(
-		settings, sess, measurement, callbacks, measurementkit.StartEx,
-	)
+	measurement.TestHelpers = map[string]interface{}{
+		"backend": thAddr,
+	}
+	// 1. perform the DNS lookup
+	addrs := m.dnsLookup(ctx, sess, measurement, tk, URL.Hostname())
+	callbacks.OnProgress(0.25, fmt.Sprintf(
+		"web_connectivity: DNS lookup: %s", asString(tk.DNSExperimentFailure)))
+	// 2. query the control vantage point
+	endpoints := makeEndpoints(URL, addrs)
+	tk.ControlRequest = ControlRequest{
+		HTTPRequest: URL.String(),
+		HTTPRequestHeaders: map[string][]string{
+			"Accept":          {httpheader.RandomAccept()},
+			"Accept-Language": {httpheader.RandomAcceptLanguage()},
+			"User-Agent":      {httpheader.RandomUserAgent()},
+		},
+		TCPConnect: endpoints,
+	}
+	tk.Control, err = Control(ctx, sess, thAddr, tk.ControlRequest)
+	tk.ControlFailure = archival.NewFailure(err)
+	tk.DNSConsistency = dnsAnalysis(URL.Hostname(),
+		tk.DNSExperimentFailure, addrs, tk.Control.DNS, sess.LookupASN)
+	callbacks.OnProgress(0.5, fmt.Sprintf(
+		"web_connectivity: control: %s", asString(tk.ControlFailure)))
+	// 3. connect to every endpoint
+	m.tcpConnect(ctx, sess, measurement, tk, endpoints)
+	callbacks.OnProgress(0.75, fmt.Sprintf(
+		"web_connectivity: TCP connect: %d/%d successes",
+		tk.TCPConnectSuccesses, tk.TCPConnectAttempts))
+	// 4. fetch the webpage using the resolved addresses
+	m.httpGet(ctx, sess, measurement, tk, URL, addrs)
+	callbacks.OnProgress(1, fmt.Sprintf(
+		"web_connectivity: HTTP GET: %s", asString(tk.HTTPExperimentFailure)))
+	// 5. compare with the control and reach a verdict
+	analysis := httpAnalysis(tk.Requests, tk.Control.HTTPRequest)
+	tk.BodyLengthMatch = analysis.BodyLengthMatch
+	tk.BodyProportion = analysis.BodyProportion
+	tk.HeadersMatch = analysis.HeadersMatch
+	tk.StatusCodeMatch = analysis.StatusCodeMatch
+	tk.TitleMatch = analysis.TitleMatch
+	s := summarize(tk)
+	tk.Accessible = s.Accessible
+	tk.Blocking = s.Blocking
+	sess.Logger().Infof("web_connectivity: blocking: %+v; accessible: %s",
+		tk.Blocking, boolString(tk.Accessible))
+	return nil
+}
+
+func selectTestHelper(sess model.ExperimentSession) (string, error) {
+	ths, ok := sess.GetTestHelpersByName("web-connectivity")
+	if !ok {
+		return "", ErrNoAvailableTestHelpers
+	}
+	for _, th := range ths {
+		if th.Type == "https" {
+			return th.Address, nil
+		}
+	}
+	return "", ErrNoAvailableTestHelpers
+}
+
+func (m *measurer) dnsLookup(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, tk *TestKeys, hostname string,
+) []string {
+	if net.ParseIP(hostname) != nil {
+		return []string{hostname}
+	}
+	result, _ := urlgetter.Getter{
+		Begin:   measurement.MeasurementStartTimeSaved,
+		Session: sess,
+		Target:  "dnslookup://" + hostname,
+	}.Get(ctx)
+	tk.Queries = append(tk.Queries, result.Queries...)
+	tk.DNSExperimentFailure = result.Failure
+	var addrs []string
+	for _, query := range result.Queries {
+		for _, answer := range query.Answers {
+			if answer.IPv4 != "" {
+				addrs = append(addrs, answer.IPv4)
+			}
+			if answer.IPv6 != "" {
+				addrs = append(addrs, answer.IPv6)
+			}
+		}
+	}
+	return addrs
+}
+
+func (m *measurer) tcpConnect(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, tk *TestKeys, endpoints []string,
+) {
+	for _, endpoint := range endpoints {
+		result, err := urlgetter.Getter{
+			Begin:   measurement.MeasurementStartTimeSaved,
+			Session: sess,
+			Target:  "tcpconnect://" + endpoint,
+		}.Get(ctx)
+		tk.TCPConnect = append(tk.TCPConnect, result.TCPConnect...)
+		tk.TCPConnectAttempts++
+		if err == nil {
+			tk.TCPConnectSuccesses++
+		}
+	}
+}
+
+func (m *measurer) httpGet(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, tk *TestKeys, URL *url.URL, addrs []string,
+) {
+	if tk.DNSExperimentFailure != nil {
+		// Without addresses there is no point in fetching the webpage and
+		// the failure of the HTTP experiment is the DNS failure.
+		tk.HTTPExperimentFailure = tk.DNSExperimentFailure
+		return
+	}
+	var config urlgetter.Config
+	if net.ParseIP(URL.Hostname()) == nil && len(addrs) > 0 {
+		// Make sure we use the addresses we've just resolved
+		config.DNSCache = strings.ToLower(URL.Hostname()) + " " + strings.Join(addrs, " ")
+	}
+	result, _ := urlgetter.Getter{
+		Begin:   measurement.MeasurementStartTimeSaved,
+		Config:  config,
+		Session: sess,
+		Target:  URL.String(),
+	}.Get(ctx)
+	tk.Requests = append(tk.Requests, result.Requests...)
+	tk.TLSHandshakes = append(tk.TLSHandshakes, result.TLSHandshakes...)
+	tk.HTTPExperimentFailure = result.Failure
+}
+
+// makeEndpoints returns the list of endpoints to connect to given
+// the input URL and the addresses we have resolved.
+func makeEndpoints(URL *url.URL, addrs []string) []string {
+	port := URL.Port()
+	if port == "" {
+		port = "80"
+		if URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	var out []string
+	for _, addr := range addrs {
+		out = append(out, net.JoinHostPort(addr, port))
+	}
+	return out
 }
 
 // NewExperimentMeasurer creates a new ExperimentMeasurer.
 func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
 	return &measurer{config: config}
 }
+
+func asString(failure *string) (result string) {
+	result = "success"
+	if failure != nil {
+		result = *failure
+	}
+	return
+}
+
+func boolString(v *bool) (result string) {
+	result = "null"
+	if v != nil {
+		result = fmt.Sprintf("%+v", *v)
+	}
+	return
+}
@@ -1,18 +1,199 @@
 package web_connectivity_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 
-	"github.com/ooni/probe-engine/experiment/mktesting"
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/handler"
 	"github.com/ooni/probe-engine/experiment/web_connectivity"
+	"github.com/ooni/probe-engine/internal/mockable"
 	"github.com/ooni/probe-engine/model"
 )
 
+func TestUnitNewExperimentMeasurer(t *testing.T) {
+	measurer := web_connectivity.NewExperimentMeasurer(web_connectivity.Config{})
+	if measurer.ExperimentName() != "web_connectivity" {
+		t.Fatal("unexpected name")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected version")
+	}
+}
+
+func TestUnitMeasureWithNoInput(t *testing.T) {
+	measurer := web_connectivity.NewExperimentMeasurer(web_connectivity.Config{})
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		new(model.Measurement),
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if !errors.Is(err, web_connectivity.ErrNoInput) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitMeasureWithUnsupportedInput(t *testing.T) {
+	measurer := web_connectivity.NewExperimentMeasurer(web_connectivity.Config{})
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		&model.Measurement{Input: "dnslookup://example.com"},
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if !errors.Is(err, web_connectivity.ErrUnsupportedInput) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitMeasureWithNoAvailableTestHelpers(t *testing.T) {
+	measurer := web_connectivity.NewExperimentMeasurer(web_connectivity.Config{})
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		&model.Measurement{Input: "http://www.example.com"},
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if !errors.Is(err, web_connectivity.ErrNoAvailableTestHelpers) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitMeasureWithLocalServers(t *testing.T) {
+	const body = "<html><head><title>Antani Sbiriguda Website</title></head></html>"
+	website := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Antani", "mascetti")
+			w.Write([]byte(body))
+		}))
+	defer website.Close()
+	websiteURL, err := url.Parse(website.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	helper := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			data, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(400)
+				return
+			}
+			var creq web_connectivity.ControlRequest
+			if err := json.Unmarshal(data, &creq); err != nil {
+				w.WriteHeader(400)
+				return
+			}
+			cresp := web_connectivity.ControlResponse{
+				TCPConnect: make(map[string]web_connectivity.ControlTCPConnectResult),
+				HTTPRequest: web_connectivity.ControlHTTPRequestResult{
+					BodyLength: int64(len(body)),
+					Headers: map[string]string{
+						"Content-Length": fmt.Sprintf("%d", len(body)),
+						"X-Antani":       "mascetti",
+					},
+					StatusCode: 200,
+					Title:      "Antani Sbiriguda Website",
+				},
+			}
+			for _, endpoint := range creq.TCPConnect {
+				cresp.TCPConnect[endpoint] = web_connectivity.ControlTCPConnectResult{
+					Status: true,
+				}
+			}
+			data, _ = json.Marshal(cresp)
+			w.Write(data)
+		}))
+	defer helper.Close()
+	measurer := web_connectivity.NewExperimentMeasurer(web_connectivity.Config{})
+	measurement := &model.Measurement{Input: model.MeasurementTarget(website.URL)}
+	err = measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{
+			MockableHTTPClient: http.DefaultClient,
+			MockableLogger:     log.Log,
+			MockableTestHelpers: map[string][]model.Service{
+				"web-connectivity": {{Address: helper.URL, Type: "https"}},
+			},
+		},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*web_connectivity.TestKeys)
+	if tk.ControlFailure != nil {
+		t.Fatal(*tk.ControlFailure)
+	}
+	if tk.DNSConsistency != web_connectivity.DNSConsistent {
+		t.Fatal("unexpected DNSConsistency")
+	}
+	if len(tk.TCPConnect) != 1 || tk.TCPConnect[0].IP != websiteURL.Hostname() {
+		t.Fatal("unexpected TCPConnect")
+	}
+	if tk.HTTPExperimentFailure != nil {
+		t.Fatal(*tk.HTTPExperimentFailure)
+	}
+	if len(tk.Requests) != 1 {
+		t.Fatal("unexpected number of requests")
+	}
+	if tk.BodyLengthMatch == nil || *tk.BodyLengthMatch != true {
+		t.Fatal("unexpected BodyLengthMatch")
+	}
+	if tk.HeadersMatch == nil || *tk.HeadersMatch != true {
+		t.Fatal("unexpected HeadersMatch")
+	}
+	if tk.StatusCodeMatch == nil || *tk.StatusCodeMatch != true {
+		t.Fatal("unexpected StatusCodeMatch")
+	}
+	if tk.TitleMatch == nil || *tk.TitleMatch != true {
+		t.Fatal("unexpected TitleMatch")
+	}
+	if tk.Accessible == nil || *tk.Accessible != true {
+		t.Fatal("unexpected Accessible")
+	}
+	if tk.Blocking != false {
+		t.Fatal("unexpected Blocking")
+	}
+}
+
 func TestIntegration(t *testing.T) {
-	err := mktesting.Run("http://www.example.com", func() model.ExperimentMeasurer {
-		return web_connectivity.NewExperimentMeasurer(web_connectivity.Config{})
-	})
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	measurer := web_connectivity.NewExperimentMeasurer(web_connectivity.Config{})
+	measurement := &model.Measurement{Input: "http://www.example.com"}
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{
+			MockableHTTPClient: http.DefaultClient,
+			MockableLogger:     log.Log,
+			MockableTestHelpers: map[string][]model.Service{
+				"web-connectivity": {{
+					Address: "https://wcth.ooni.io",
+					Type:    "https",
+				}},
+			},
+		},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
 	if err != nil {
 		t.Fatal(err)
 	}
+	tk := measurement.TestKeys.(*web_connectivity.TestKeys)
+	if tk.ControlFailure != nil {
+		t.Fatal(*tk.ControlFailure)
+	}
+	if tk.Accessible == nil || *tk.Accessible != true {
+		t.Fatal("unexpected Accessible")
+	}
 }
@@ -1,23 +1,127 @@
-// Package whatsapp contains the WhatsApp network experiment.
+// Package whatsapp contains the WhatsApp network experiment. This file
+// in particular is a pure-Go implementation of that.
+//
+// See https://github.com/ooni/spec/blob/master/nettests/ts-018-whatsapp.md.
 package whatsapp
 
 import (
 	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
 
-	"github.com/ooni/probe-engine/experiment/mkrunner"
-	"github.com/ooni/probe-engine/measurementkit"
+	"github.com/ooni/probe-engine/atomicx"
+	"github.com/ooni/probe-engine/experiment/urlgetter"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/archival"
 )
 
 const (
 	testName    = "whatsapp"
-	testVersion = "0.6.1"
+	testVersion = "0.7.0"
+
+	// RegistrationServiceURL is the URL of the registration service.
+	RegistrationServiceURL = "https://v.whatsapp.net/v2/register"
+
+	// WebHTTPURL is the plaintext URL of WhatsApp Web.
+	WebHTTPURL = "http://web.whatsapp.com/"
+
+	// WebHTTPSURL is the encrypted URL of WhatsApp Web.
+	WebHTTPSURL = "https://web.whatsapp.com/"
+
+	numEndpoints = 16
 )
 
+// endpointPorts contains the ports we try for every endpoint.
+var endpointPorts = []string{"443", "5222"}
+
 // Config contains the experiment config.
 type Config struct {
-	// LogLevel is the MK log level. Empty implies "WARNING".
-	LogLevel string
+	AllEndpoints bool `ooni:"Whether to test all WhatsApp endpoints"`
+}
+
+// TestKeys contains the experiment results.
+type TestKeys struct {
+	Agent                     string                     `json:"agent"`
+	Queries                   []archival.DNSQueryEntry   `json:"queries"`
+	RegistrationServerFailure *string                    `json:"registration_server_failure"`
+	RegistrationServerStatus  string                     `json:"registration_server_status"`
+	Requests                  []archival.RequestEntry    `json:"requests"`
+	TCPConnect                []archival.TCPConnectEntry `json:"tcp_connect"`
+	TLSHandshakes             []archival.TLSHandshake    `json:"tls_handshakes"`
+	WhatsappEndpointsBlocked  []string                   `json:"whatsapp_endpoints_blocked"`
+	WhatsappEndpointsStatus   string                     `json:"whatsapp_endpoints_status"`
+	WhatsappWebFailure        *string                    `json:"whatsapp_web_failure"`
+	WhatsappWebStatus         string                     `json:"whatsapp_web_status"`
+
+	endpointsReachable map[string]bool
+}
+
+// NewTestKeys returns a new instance of the test keys.
+func NewTestKeys() *TestKeys {
+	return &TestKeys{
+		Agent:                    "redirect",
+		RegistrationServerStatus: "ok",
+		WhatsappEndpointsBlocked: []string{},
+		WhatsappEndpointsStatus:  "ok",
+		WhatsappWebStatus:        "ok",
+		endpointsReachable:       make(map[string]bool),
+	}
+}
+
+// MeasurementResult is the result of measuring a single target.
+type MeasurementResult struct {
+	Target   string
+	TestKeys urlgetter.TestKeys
+	Err      error
+}
+
+// Update updates the TestKeys using the given MeasurementResult.
+func (tk *TestKeys) Update(v MeasurementResult) {
+	tk.Queries = append(tk.Queries, v.TestKeys.Queries...)
+	tk.Requests = append(tk.Requests, v.TestKeys.Requests...)
+	tk.TCPConnect = append(tk.TCPConnect, v.TestKeys.TCPConnect...)
+	tk.TLSHandshakes = append(tk.TLSHandshakes, v.TestKeys.TLSHandshakes...)
+	switch v.Target {
+	case RegistrationServiceURL:
+		if v.Err != nil {
+			tk.RegistrationServerFailure = v.TestKeys.Failure
+			tk.RegistrationServerStatus = "blocked"
+		}
+	case WebHTTPURL, WebHTTPSURL:
+		if v.Err != nil && tk.WhatsappWebFailure == nil {
+			tk.WhatsappWebFailure = v.TestKeys.Failure
+			tk.WhatsappWebStatus = "blocked"
+		}
+	default:
+		URL, err := url.Parse(v.Target)
+		if err != nil {
+			return
+		}
+		hostname := URL.Hostname()
+		tk.endpointsReachable[hostname] = tk.endpointsReachable[hostname] || v.Err == nil
+	}
+}
+
+// ComputeEndpointsStatus computes the status of the endpoints once
+// we have processed all the measurement results.
+func (tk *TestKeys) ComputeEndpointsStatus() {
+	var reachable int
+	for hostname, ok := range tk.endpointsReachable {
+		if !ok {
+			tk.WhatsappEndpointsBlocked = append(tk.WhatsappEndpointsBlocked, hostname)
+			continue
+		}
+		reachable++
+	}
+	sort.Strings(tk.WhatsappEndpointsBlocked)
+	if reachable <= 0 {
+		tk.WhatsappEndpointsStatus = "blocked"
+	}
 }
 
 type measurer struct {
@@ -32,22 +136,87 @@ func (m *measurer) ExperimentVersion() string {
 	return testVersion
 }
 
+func registerExtensions(m *model.Measurement) {
+	archival.ExtHTTP.AddTo(m)
+	archival.ExtDNS.AddTo(m)
+	archival.ExtTCPConnect.AddTo(m)
+	archival.ExtTLSHandshake.AddTo(m)
+}
+
+// EndpointHostnames returns the hostnames of the endpoints to test. When
+// all is false, we only return a randomly selected endpoint.
+func EndpointHostnames(all bool, gen *rand.Rand) []string {
+	var out []string
+	for idx := 1; idx <= numEndpoints; idx++ {
+		out = append(out, fmt.Sprintf("e%d.whatsapp.net", idx))
+	}
+	if !all {
+		out = []string{out[gen.Intn(len(out))]}
+	}
+	return out
+}
+
+// Targets returns the list of targets to measure.
+func Targets(all bool, gen *rand.Rand) []string {
+	out := []string{RegistrationServiceURL, WebHTTPSURL, WebHTTPURL}
+	for _, hostname := range EndpointHostnames(all, gen) {
+		for _, port := range endpointPorts {
+			out = append(out, "tcpconnect://"+net.JoinHostPort(hostname, port))
+		}
+	}
+	return out
+}
+
 func (m *measurer) Run(
 	ctx context.Context, sess model.ExperimentSession,
 	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
 ) error {
-	settings := measurementkit.NewSettings(
-		"Whatsapp", sess.SoftwareName(), sess.SoftwareVersion(),
-		sess.CABundlePath(), sess.ProbeASNString(), sess.ProbeCC(),
-		sess.ProbeIP(), sess.ProbeNetworkName(), m.config.LogLevel,
-	)
-	settings.Options.GeoIPASNPath = sess.ASNDatabasePath()
-	return mkrunner.Do(
-		settings, sess, measurement, callbacks, measurementkit.StartEx,
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	registerExtensions(measurement)
+	gen := rand.New(rand.NewSource(time.Now().UnixNano()))
+	targets := Targets(m.config.AllEndpoints, gen)
+	results := make([]MeasurementResult, len(targets))
+	var (
+		completed = atomicx.NewInt64()
+		waitgroup sync.WaitGroup
 	)
+	waitgroup.Add(len(targets))
+	for idx, target := range targets {
+		go func(idx int, target string) {
+			defer waitgroup.Done()
+			tk, err := urlgetter.Getter{
+				Begin:   measurement.MeasurementStartTimeSaved,
+				Session: sess,
+				Target:  target,
+			}.Get(ctx)
+			results[idx] = MeasurementResult{Target: target, TestKeys: tk, Err: err}
+			sofar := completed.Add(1)
+			percentage := float64(sofar) / float64(len(targets))
+			callbacks.OnProgress(percentage, fmt.Sprintf(
+				"whatsapp: access %s: %s", target, errString(err),
+			))
+		}(idx, target)
+	}
+	waitgroup.Wait()
+	testkeys := NewTestKeys()
+	for _, result := range results {
+		testkeys.Update(result)
+	}
+	testkeys.ComputeEndpointsStatus()
+	measurement.TestKeys = testkeys
+	return nil
 }
 
 // NewExperimentMeasurer creates a new ExperimentMeasurer.
 func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
 	return &measurer{config: config}
 }
+
+func errString(err error) (s string) {
+	s = "success"
+	if err != nil {
+		s = err.Error()
+	}
+	return
+}
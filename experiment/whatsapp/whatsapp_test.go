@@ -1,18 +1,149 @@
 package whatsapp_test
 
 import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
 	"testing"
 
-	"github.com/ooni/probe-engine/experiment/mktesting"
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/handler"
+	"github.com/ooni/probe-engine/experiment/urlgetter"
 	"github.com/ooni/probe-engine/experiment/whatsapp"
+	"github.com/ooni/probe-engine/internal/mockable"
 	"github.com/ooni/probe-engine/model"
 )
 
-func TestIntegration(t *testing.T) {
-	err := mktesting.Run("", func() model.ExperimentMeasurer {
-		return whatsapp.NewExperimentMeasurer(whatsapp.Config{})
+func TestUnitNewExperimentMeasurer(t *testing.T) {
+	measurer := whatsapp.NewExperimentMeasurer(whatsapp.Config{})
+	if measurer.ExperimentName() != "whatsapp" {
+		t.Fatal("unexpected name")
+	}
+	if measurer.ExperimentVersion() != "0.7.0" {
+		t.Fatal("unexpected version")
+	}
+}
+
+func TestUnitEndpointHostnames(t *testing.T) {
+	gen := rand.New(rand.NewSource(0))
+	if out := whatsapp.EndpointHostnames(true, gen); len(out) != 16 {
+		t.Fatal("unexpected number of endpoints")
+	}
+	if out := whatsapp.EndpointHostnames(false, gen); len(out) != 1 {
+		t.Fatal("unexpected number of endpoints")
+	}
+}
+
+func TestUnitTargets(t *testing.T) {
+	gen := rand.New(rand.NewSource(0))
+	if out := whatsapp.Targets(true, gen); len(out) != 3+2*16 {
+		t.Fatal("unexpected number of targets")
+	}
+	if out := whatsapp.Targets(false, gen); len(out) != 3+2 {
+		t.Fatal("unexpected number of targets")
+	}
+}
+
+func TestUnitTestKeysAllSuccessful(t *testing.T) {
+	tk := whatsapp.NewTestKeys()
+	for _, target := range whatsapp.Targets(true, rand.New(rand.NewSource(0))) {
+		tk.Update(whatsapp.MeasurementResult{Target: target})
+	}
+	tk.ComputeEndpointsStatus()
+	if tk.RegistrationServerStatus != "ok" || tk.RegistrationServerFailure != nil {
+		t.Fatal("unexpected registration server result")
+	}
+	if tk.WhatsappWebStatus != "ok" || tk.WhatsappWebFailure != nil {
+		t.Fatal("unexpected web result")
+	}
+	if tk.WhatsappEndpointsStatus != "ok" || len(tk.WhatsappEndpointsBlocked) != 0 {
+		t.Fatal("unexpected endpoints result")
+	}
+}
+
+func TestUnitTestKeysSomeEndpointsBlocked(t *testing.T) {
+	tk := whatsapp.NewTestKeys()
+	failure := "connection_refused"
+	tk.Update(whatsapp.MeasurementResult{
+		Target:   "tcpconnect://e1.whatsapp.net:443",
+		TestKeys: urlgetter.TestKeys{Failure: &failure},
+		Err:      errors.New(failure),
+	})
+	tk.Update(whatsapp.MeasurementResult{
+		Target:   "tcpconnect://e1.whatsapp.net:5222",
+		TestKeys: urlgetter.TestKeys{Failure: &failure},
+		Err:      errors.New(failure),
 	})
+	tk.Update(whatsapp.MeasurementResult{
+		Target:   "tcpconnect://e2.whatsapp.net:443",
+		TestKeys: urlgetter.TestKeys{Failure: &failure},
+		Err:      errors.New(failure),
+	})
+	tk.Update(whatsapp.MeasurementResult{
+		Target: "tcpconnect://e2.whatsapp.net:5222",
+	})
+	tk.ComputeEndpointsStatus()
+	if tk.WhatsappEndpointsStatus != "ok" {
+		t.Fatal("unexpected endpoints status")
+	}
+	if len(tk.WhatsappEndpointsBlocked) != 1 || tk.WhatsappEndpointsBlocked[0] != "e1.whatsapp.net" {
+		t.Fatal("unexpected blocked endpoints")
+	}
+}
+
+func TestUnitMeasureWithCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // fail immediately
+	measurer := whatsapp.NewExperimentMeasurer(whatsapp.Config{AllEndpoints: true})
+	measurement := new(model.Measurement)
+	err := measurer.Run(
+		ctx,
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
 	if err != nil {
 		t.Fatal(err)
 	}
+	tk := measurement.TestKeys.(*whatsapp.TestKeys)
+	if tk.RegistrationServerStatus != "blocked" || tk.RegistrationServerFailure == nil {
+		t.Fatal("unexpected registration server result")
+	}
+	if tk.WhatsappWebStatus != "blocked" || tk.WhatsappWebFailure == nil {
+		t.Fatal("unexpected web result")
+	}
+	if tk.WhatsappEndpointsStatus != "blocked" || len(tk.WhatsappEndpointsBlocked) != 16 {
+		t.Fatal("unexpected endpoints result")
+	}
+}
+
+func TestIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	measurer := whatsapp.NewExperimentMeasurer(whatsapp.Config{})
+	measurement := new(model.Measurement)
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{
+			MockableHTTPClient: http.DefaultClient,
+			MockableLogger:     log.Log,
+		},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*whatsapp.TestKeys)
+	if tk.RegistrationServerStatus != "ok" {
+		t.Fatal("unexpected registration server status")
+	}
+	if tk.WhatsappWebStatus != "ok" {
+		t.Fatal("unexpected web status")
+	}
+	if tk.WhatsappEndpointsStatus != "ok" {
+		t.Fatal("unexpected endpoints status")
+	}
 }
@@ -3,6 +3,7 @@ package mmdblookup
 
 import (
 	"net"
+	"sync"
 
 	"github.com/ooni/probe-engine/model"
 	"github.com/oschwald/geoip2-golang"
@@ -56,3 +57,58 @@ func LookupCC(
 	}
 	return
 }
+
+// ASNLookupFunc maps an IP address to its ASN. Experiments use it
+// to check whether two IP addresses belong to the same AS.
+type ASNLookupFunc func(ip string) (asn uint, err error)
+
+// ASNDatabase maps IP addresses to ASNs using the MMDB database located
+// at Path. Experiments may perform many lookups, hence we open the database
+// the first time we need it and keep it open until Close is called.
+type ASNDatabase struct {
+	Logger model.Logger
+	Path   string
+	mu     sync.Mutex
+	reader *geoip2.Reader
+}
+
+// LookupASN implements ASNLookupFunc.
+func (d *ASNDatabase) LookupASN(ip string) (uint, error) {
+	reader, err := d.open()
+	if err != nil {
+		return model.DefaultProbeASN, err
+	}
+	record, err := reader.ASN(net.ParseIP(ip))
+	if err != nil {
+		return model.DefaultProbeASN, err
+	}
+	d.Logger.Debugf("mmdblookup: ASN: %+v", record)
+	return record.AutonomousSystemNumber, nil
+}
+
+func (d *ASNDatabase) open() (*geoip2.Reader, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.reader == nil {
+		reader, err := geoip2.Open(d.Path)
+		if err != nil {
+			return nil, err
+		}
+		d.reader = reader
+	}
+	return d.reader, nil
+}
+
+// Close closes the database, if it is open.
+func (d *ASNDatabase) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.reader == nil {
+		return nil
+	}
+	err := d.reader.Close()
+	d.reader = nil
+	return err
+}
+
+var _ ASNLookupFunc = (&ASNDatabase{}).LookupASN
@@ -97,3 +97,35 @@ func TestLookupProbeCCInvalidIP(t *testing.T) {
 		t.Fatal("expected an empty cc")
 	}
 }
+
+func TestASNDatabase(t *testing.T) {
+	maybeFetchResources(t)
+	db := &mmdblookup.ASNDatabase{Logger: log.Log, Path: asnDBPath}
+	for i := 0; i < 2; i++ { // the second time the database is already open
+		asn, err := db.LookupASN(ipAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if asn == model.DefaultProbeASN {
+			t.Fatal("expected a nonzero ASN")
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal("closing twice should not fail")
+	}
+}
+
+func TestASNDatabaseInvalidFile(t *testing.T) {
+	db := &mmdblookup.ASNDatabase{Logger: log.Log, Path: "/nonexistent"}
+	defer db.Close()
+	asn, err := db.LookupASN(ipAddr)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if asn != model.DefaultProbeASN {
+		t.Fatal("expected a zero ASN")
+	}
+}
@@ -3,11 +3,13 @@ package mockable
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/url"
 	"time"
 
 	"github.com/apex/log"
+	"github.com/ooni/probe-engine/geoiplookup/mmdblookup"
 	"github.com/ooni/probe-engine/internal/kvstore"
 	"github.com/ooni/probe-engine/internal/orchestra"
 	"github.com/ooni/probe-engine/internal/orchestra/statefile"
@@ -17,6 +19,7 @@ import (
 
 // ExperimentSession is a mockable ExperimentSession.
 type ExperimentSession struct {
+	MockableASNDatabase          ASNDatabase
 	MockableASNDatabasePath      string
 	MockableCABundlePath         string
 	MockableTestHelpers          map[string][]model.Service
@@ -63,6 +66,11 @@ func (sess *ExperimentSession) Logger() model.Logger {
 	return sess.MockableLogger
 }
 
+// LookupASN implements ExperimentSession.LookupASN
+func (sess *ExperimentSession) LookupASN(ip string) (uint, error) {
+	return sess.MockableASNDatabase.LookupASN(ip)
+}
+
 // MaybeStartTunnel implements ExperimentSession.MaybeStartTunnel
 func (sess *ExperimentSession) MaybeStartTunnel(ctx context.Context, name string) error {
 	return sess.MockableMaybeStartTunnelErr
@@ -168,3 +176,18 @@ func (c ExperimentOrchestraClient) FetchTorTargets(
 }
 
 var _ model.ExperimentOrchestraClient = ExperimentOrchestraClient{}
+
+// ASNDatabase is a mockable ASN database mapping IP addresses to ASNs.
+type ASNDatabase map[string]uint
+
+// LookupASN implements mmdblookup.ASNLookupFunc. It fails for the IP
+// addresses that are not in the database.
+func (db ASNDatabase) LookupASN(ip string) (uint, error) {
+	asn, found := db[ip]
+	if !found {
+		return model.DefaultProbeASN, errors.New("mockable: IP address not found")
+	}
+	return asn, nil
+}
+
+var _ mmdblookup.ASNLookupFunc = ASNDatabase{}.LookupASN
@@ -23,6 +23,7 @@ import (
 	"github.com/ooni/probe-engine/atomicx"
 	"github.com/ooni/probe-engine/internal/runtimex"
 	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/dialer"
 	"github.com/ooni/probe-engine/netx/handlers"
 	"github.com/ooni/probe-engine/netx/modelx"
 	"gitlab.com/yawning/obfs4.git/transports"
@@ -378,6 +379,7 @@ func HTTPDo(
 type TLSConnectConfig struct {
 	Address            string
 	Beginning          time.Time
+	ClientHelloSplit   *dialer.SplitStrategy
 	DNSServerAddress   string
 	DNSServerNetwork   string
 	Handler            modelx.Handler
@@ -425,6 +427,7 @@ func TLSConnect(
 	}
 	// TODO(bassosimone): can this call really fail?
 	dialer.ForceSpecificSNI(config.SNI)
+	dialer.ForceClientHelloSplit(config.ClientHelloSplit)
 	results.TestKeys.collect(channel, config.Handler, func() {
 		conn, err := dialer.DialTLSContext(ctx, "tcp", config.Address)
 		if conn != nil {
@@ -4,6 +4,7 @@
 package libminiooni
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -19,6 +20,8 @@ import (
 	"github.com/apex/log"
 	engine "github.com/ooni/probe-engine"
 	"github.com/ooni/probe-engine/internal/humanizex"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/har"
 	"github.com/ooni/probe-engine/version"
 	"github.com/pborman/getopt/v2"
 )
@@ -28,6 +31,7 @@ type Options struct {
 	Annotations  []string
 	BouncerURL   string
 	CollectorURL string
+	HARDir       string
 	Inputs       []string
 	ExtraOptions []string
 	NoBouncer    bool
@@ -60,6 +64,10 @@ func init() {
 		&globalOptions.CollectorURL, "collector", 'c',
 		"Set collector base URL", "URL",
 	)
+	getopt.FlagLong(
+		&globalOptions.HARDir, "har-dir", 0,
+		"Write a HAR file for each measurement into DIR", "DIR",
+	)
 	getopt.FlagLong(
 		&globalOptions.Inputs, "input", 'i',
 		"Add test-dependent input to the test input", "INPUT",
@@ -215,6 +223,11 @@ func MainWithConfiguration(experimentName string, currentOptions Options) {
 	fatalOnError(err, "cannot get a temporary directory")
 	log.Debugf("miniooni temporary directory: %s", tempDir)
 
+	if currentOptions.HARDir != "" {
+		err := os.MkdirAll(currentOptions.HARDir, 0700)
+		fatalOnError(err, "cannot create HAR directory")
+	}
+
 	var proxyURL *url.URL
 	if currentOptions.Proxy != "" {
 		proxyURL = mustParseURL(currentOptions.Proxy)
@@ -338,5 +351,40 @@ func MainWithConfiguration(experimentName string, currentOptions Options) {
 			err := experiment.SaveMeasurement(measurement, currentOptions.ReportFile)
 			warnOnError(err, "saving measurement failed")
 		}
+		if currentOptions.HARDir != "" {
+			// The start time makes the name unique across runs, while the
+			// counter distinguishes measurements within the same run.
+			filename := filepath.Join(currentOptions.HARDir, fmt.Sprintf(
+				"%s-%s-%d.har", experimentName,
+				measurement.MeasurementStartTimeSaved.UTC().Format("20060102T150405Z"),
+				inputCounter,
+			))
+			log.Infof("saving HAR to %s", filename)
+			err := saveHAR(measurement, filename)
+			warnOnError(err, "saving HAR failed")
+		}
+	}
+}
+
+// saveHAR writes the HTTP requests contained in measurement into filename
+// using the HAR format, so that they can be inspected using a browser. We
+// fail rather than overwriting an existing file.
+func saveHAR(measurement *model.Measurement, filename string) error {
+	archive, err := har.NewFromMeasurement(measurement)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return err
+	}
+	filep, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := filep.Write(data); err != nil {
+		filep.Close()
+		return err
 	}
+	return filep.Close()
 }
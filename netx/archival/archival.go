@@ -4,6 +4,7 @@
 package archival
 
 import (
+	"bytes"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
@@ -11,14 +12,17 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 	"unicode/utf8"
 
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx/internal/errwrapper"
 	"github.com/ooni/probe-engine/netx/modelx"
+	"github.com/ooni/probe-engine/netx/resolver"
 	"github.com/ooni/probe-engine/netx/trace"
 )
 
@@ -331,38 +335,83 @@ func newRequestList(begin time.Time, events []trace.Event) []RequestEntry {
 
 // DNSAnswerEntry is the answer to a DNS query
 type DNSAnswerEntry struct {
-	AnswerType string  `json:"answer_type"`
-	Hostname   string  `json:"hostname,omitempty"`
-	IPv4       string  `json:"ipv4,omitempty"`
-	IPv6       string  `json:"ipv6,omitempty"`
-	TTL        *uint32 `json:"ttl"`
+	ALPN       []string `json:"alpn,omitempty"`
+	AnswerType string   `json:"answer_type"`
+	Hostname   string   `json:"hostname,omitempty"`
+	IPv4       string   `json:"ipv4,omitempty"`
+	IPv4Hint   []string `json:"ipv4_hint,omitempty"`
+	IPv6       string   `json:"ipv6,omitempty"`
+	IPv6Hint   []string `json:"ipv6_hint,omitempty"`
+	Preference uint16   `json:"preference,omitempty"`
+	Priority   uint16   `json:"priority,omitempty"`
+	TTL        *uint32  `json:"ttl"`
+	TXT        []string `json:"txt,omitempty"`
 }
 
 // DNSQueryEntry is a DNS query with possibly an answer
 type DNSQueryEntry struct {
-	Answers          []DNSAnswerEntry `json:"answers"`
-	DialID           int64            `json:"dial_id,omitempty"`
-	Engine           string           `json:"engine"`
-	Failure          *string          `json:"failure"`
-	Hostname         string           `json:"hostname"`
-	QueryType        string           `json:"query_type"`
-	ResolverHostname *string          `json:"resolver_hostname"`
-	ResolverPort     *string          `json:"resolver_port"`
-	ResolverAddress  string           `json:"resolver_address"`
-	T                float64          `json:"t"`
-	TransactionID    int64            `json:"transaction_id,omitempty"`
+	Answers            []DNSAnswerEntry `json:"answers"`
+	ConflictingReplies bool             `json:"conflicting_replies,omitempty"`
+	DialID             int64            `json:"dial_id,omitempty"`
+	Engine             string           `json:"engine"`
+	Failure            *string          `json:"failure"`
+	Hostname           string           `json:"hostname"`
+	LateReplies        []DNSLateReply   `json:"late_replies,omitempty"`
+	QueryType          string           `json:"query_type"`
+	RawQuery           []byte           `json:"raw_query,omitempty"`
+	RawReply           []byte           `json:"raw_reply,omitempty"`
+	Rcode              string           `json:"rcode,omitempty"`
+	ResolverHostname   *string          `json:"resolver_hostname"`
+	ResolverPort       *string          `json:"resolver_port"`
+	ResolverAddress    string           `json:"resolver_address"`
+	T                  float64          `json:"t"`
+	TransactionID      int64            `json:"transaction_id,omitempty"`
+}
+
+// DNSLateReply is a reply to a DNS query received after the first one,
+// which may happen, e.g., when an on-path injector races with the real
+// resolver. See resolver.NewDNSOverUDPWithWindow.
+type DNSLateReply struct {
+	Answers  []DNSAnswerEntry `json:"answers"`
+	Failure  *string          `json:"failure"`
+	RawReply []byte           `json:"raw_reply"`
+	Rcode    string           `json:"rcode,omitempty"`
+	T        float64          `json:"t"`
 }
 
 type dnsQueryType string
 
-// NewDNSQueriesList returns a list of DNS queries.
+// NewDNSQueriesList returns a list of DNS queries. When the events contain
+// DNS round trips, we emit an entry for each round trip, including the
+// failed and the empty ones, with the rcode and the raw query and reply.
+// We also attach the late replies to their query, and we mark the query
+// as having conflicting replies if any late reply differs from the first.
+// Otherwise (e.g. with the system resolver), we reconstruct A and AAAA
+// queries from the resolve_done events.
 func NewDNSQueriesList(begin time.Time, events []trace.Event) []DNSQueryEntry {
-	// TODO(bassosimone): add support for CNAME lookups.
 	var out []DNSQueryEntry
+	roundtrips := make(map[string]bool)
 	for _, ev := range events {
+		if ev.Name == "dns_round_trip_done" {
+			entry, ok := newDNSRoundTripEntry(begin, ev)
+			if !ok {
+				continue
+			}
+			roundtrips[entry.Hostname] = true
+			out = append(out, entry)
+			continue
+		}
+		if ev.Name == "dns_late_reply" {
+			addDNSLateReply(begin, out, ev)
+			continue
+		}
 		if ev.Name != "resolve_done" {
 			continue
 		}
+		if hostname := normalizeDNSName(ev.Hostname); roundtrips[hostname] {
+			delete(roundtrips, hostname) // already archived the round trips
+			continue
+		}
 		for _, qtype := range []dnsQueryType{"A", "AAAA"} {
 			entry := qtype.makequeryentry(begin, ev)
 			for _, addr := range ev.Addresses {
@@ -370,7 +419,11 @@ func NewDNSQueriesList(begin time.Time, events []trace.Event) []DNSQueryEntry {
 					entry.Answers = append(entry.Answers, qtype.makeanswerentry(addr))
 				}
 			}
-			if len(entry.Answers) <= 0 {
+			if len(entry.Answers) <= 0 && ev.Err == nil {
+				// This allows us to skip, e.g., AAAA when the server only
+				// has IPv4 addresses. When the lookup failed, instead, we
+				// want to record the failure, but we don't know which
+				// qtype caused it, hence we record it for both.
 				continue
 			}
 			out = append(out, entry)
@@ -379,6 +432,114 @@ func NewDNSQueriesList(begin time.Time, events []trace.Event) []DNSQueryEntry {
 	return out
 }
 
+func normalizeDNSName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// newDNSRoundTripEntry creates a new entry from a dns_round_trip_done
+// event. It returns false if we cannot parse the query.
+func newDNSRoundTripEntry(begin time.Time, ev trace.Event) (DNSQueryEntry, bool) {
+	query := new(dns.Msg)
+	if err := query.Unpack(ev.DNSQuery); err != nil || len(query.Question) != 1 {
+		return DNSQueryEntry{}, false
+	}
+	qtype := dnsQueryType(dns.TypeToString[query.Question[0].Qtype])
+	if query.Question[0].Qtype == resolver.TypeHTTPS {
+		qtype = "HTTPS"
+	}
+	entry := qtype.makequeryentry(begin, ev)
+	entry.Hostname = normalizeDNSName(query.Question[0].Name)
+	entry.RawQuery = ev.DNSQuery
+	entry.RawReply = ev.DNSReply
+	if ev.Err != nil {
+		entry.Failure = newDNSFailure(ev.Err)
+		return entry, true
+	}
+	entry.Answers, entry.Rcode, entry.Failure = decodeDNSReply(ev.DNSReply)
+	return entry, true
+}
+
+// decodeDNSReply returns the answers, the rcode and the failure.
+func decodeDNSReply(data []byte) ([]DNSAnswerEntry, string, *string) {
+	reply, err := resolver.MiekgDecoder{}.DecodeReply(data)
+	if err != nil {
+		return nil, "", newDNSFailure(err)
+	}
+	return newDNSAnswerEntries(reply.Answers), reply.RcodeString(), newDNSFailure(reply.Err())
+}
+
+// addDNSLateReply attaches the late reply to the most recent entry
+// with the same query, transport and resolver address, if any.
+func addDNSLateReply(begin time.Time, entries []DNSQueryEntry, ev trace.Event) {
+	for idx := len(entries) - 1; idx >= 0; idx-- {
+		entry := &entries[idx]
+		if !bytes.Equal(entry.RawQuery, ev.DNSQuery) || entry.Engine != ev.Proto ||
+			entry.ResolverAddress != ev.Address {
+			continue
+		}
+		late := DNSLateReply{RawReply: ev.DNSReply, T: ev.Time.Sub(begin).Seconds()}
+		late.Answers, late.Rcode, late.Failure = decodeDNSReply(ev.DNSReply)
+		entry.LateReplies = append(entry.LateReplies, late)
+		if !sameDNSReply(entry.Answers, entry.Rcode, entry.Failure,
+			late.Answers, late.Rcode, late.Failure) {
+			entry.ConflictingReplies = true
+		}
+		return
+	}
+}
+
+// sameDNSReply returns whether two replies contain the same answers
+// regardless of their order and TTLs, and have the same outcome.
+func sameDNSReply(
+	answers1 []DNSAnswerEntry, rcode1 string, failure1 *string,
+	answers2 []DNSAnswerEntry, rcode2 string, failure2 *string,
+) bool {
+	if rcode1 != rcode2 || (failure1 == nil) != (failure2 == nil) ||
+		(failure1 != nil && *failure1 != *failure2) {
+		return false
+	}
+	key := func(answers []DNSAnswerEntry) string {
+		var out []string
+		for _, answer := range answers {
+			out = append(out, fmt.Sprintf("%s %s %s %s %v", answer.AnswerType,
+				answer.Hostname, answer.IPv4, answer.IPv6, answer.TXT))
+		}
+		sort.Strings(out)
+		return strings.Join(out, "\n")
+	}
+	return key(answers1) == key(answers2)
+}
+
+// newDNSFailure is like NewFailure but classifies errors that have
+// not been wrapped yet, as it happens with DNS round trips.
+func newDNSFailure(err error) *string {
+	return NewFailure(errwrapper.SafeErrWrapperBuilder{
+		Error:     err,
+		Operation: "resolve",
+	}.MaybeBuild())
+}
+
+func newDNSAnswerEntries(answers []resolver.DNSAnswer) (out []DNSAnswerEntry) {
+	for _, answer := range answers {
+		ttl := answer.TTL
+		entry := DNSAnswerEntry{
+			ALPN:       answer.ALPN,
+			AnswerType: answer.Type,
+			Hostname:   answer.Hostname,
+			IPv4:       answer.IPv4,
+			IPv4Hint:   answer.IPv4Hint,
+			IPv6:       answer.IPv6,
+			IPv6Hint:   answer.IPv6Hint,
+			Preference: answer.Preference,
+			Priority:   answer.Priority,
+			TTL:        &ttl,
+			TXT:        answer.TXT,
+		}
+		out = append(out, entry)
+	}
+	return
+}
+
 func (qtype dnsQueryType) ipoftype(addr string) bool {
 	switch qtype {
 	case "A":
@@ -413,15 +574,16 @@ func (qtype dnsQueryType) makequeryentry(begin time.Time, ev trace.Event) DNSQue
 
 // NetworkEvent is a network event.
 type NetworkEvent struct {
-	Address       string  `json:"address,omitempty"`
-	ConnID        int64   `json:"conn_id,omitempty"`
-	DialID        int64   `json:"dial_id,omitempty"`
-	Failure       *string `json:"failure"`
-	NumBytes      int64   `json:"num_bytes,omitempty"`
-	Operation     string  `json:"operation"`
-	Proto         string  `json:"proto,omitempty"`
-	T             float64 `json:"t"`
-	TransactionID int64   `json:"transaction_id,omitempty"`
+	Address       string            `json:"address,omitempty"`
+	ConnID        int64             `json:"conn_id,omitempty"`
+	Data          *MaybeBinaryValue `json:"data,omitempty"`
+	DialID        int64             `json:"dial_id,omitempty"`
+	Failure       *string           `json:"failure"`
+	NumBytes      int64             `json:"num_bytes,omitempty"`
+	Operation     string            `json:"operation"`
+	Proto         string            `json:"proto,omitempty"`
+	T             float64           `json:"t"`
+	TransactionID int64             `json:"transaction_id,omitempty"`
 }
 
 // NewNetworkEventsList returns a list of DNS queries.
@@ -438,6 +600,16 @@ func NewNetworkEventsList(begin time.Time, events []trace.Event) []NetworkEvent
 			})
 			continue
 		}
+		if ev.Name == "proxy_handshake_done" {
+			out = append(out, NetworkEvent{
+				Address:   ev.Address,
+				Failure:   NewFailure(ev.Err),
+				Operation: ev.Name,
+				Proto:     ev.Proto,
+				T:         ev.Time.Sub(begin).Seconds(),
+			})
+			continue
+		}
 		if ev.Name == "read" {
 			out = append(out, NetworkEvent{
 				Failure:   NewFailure(ev.Err),
@@ -468,6 +640,7 @@ func NewNetworkEventsList(begin time.Time, events []trace.Event) []NetworkEvent
 // TLSHandshake contains TLS handshake data
 type TLSHandshake struct {
 	CipherSuite        string             `json:"cipher_suite"`
+	ClientHelloID      string             `json:"client_hello_id,omitempty"`
 	ConnID             int64              `json:"conn_id,omitempty"`
 	Failure            *string            `json:"failure"`
 	NegotiatedProtocol string             `json:"negotiated_protocol"`
@@ -481,13 +654,24 @@ type TLSHandshake struct {
 
 // NewTLSHandshakesList creates a new TLSHandshakesList
 func NewTLSHandshakesList(begin time.Time, events []trace.Event) []TLSHandshake {
+	return newHandshakesList(begin, events, "tls_handshake_done")
+}
+
+// NewQUICHandshakesList creates a new list of QUIC handshakes. Since
+// QUIC uses TLS 1.3, we archive the same fields of a TLS handshake.
+func NewQUICHandshakesList(begin time.Time, events []trace.Event) []TLSHandshake {
+	return newHandshakesList(begin, events, "quic_handshake_done")
+}
+
+func newHandshakesList(begin time.Time, events []trace.Event, name string) []TLSHandshake {
 	var out []TLSHandshake
 	for _, ev := range events {
-		if ev.Name != "tls_handshake_done" {
+		if ev.Name != name {
 			continue
 		}
 		out = append(out, TLSHandshake{
 			CipherSuite:        ev.TLSCipherSuite,
+			ClientHelloID:      ev.TLSClientHelloID,
 			Failure:            NewFailure(ev.Err),
 			NegotiatedProtocol: ev.TLSNegotiatedProto,
 			NoTLSVerify:        ev.NoTLSVerify,
@@ -5,6 +5,7 @@ import (
 	"crypto/x509"
 	"errors"
 	"io"
+	"net"
 	"net/http"
 	"reflect"
 	"testing"
@@ -12,6 +13,7 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/gorilla/websocket"
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx/archival"
 	"github.com/ooni/probe-engine/netx/modelx"
@@ -209,6 +211,48 @@ func TestNewRequestList(t *testing.T) {
 
 func TestNewDNSQueriesList(t *testing.T) {
 	begin := time.Now()
+	queryA := dnsPack(t, dnsQuery("www.example.com", dns.TypeA))
+	replyA := dnsPack(t, dnsReply("www.example.com", dns.TypeA, &dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   "www.example.com.",
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+			Ttl:    300,
+		},
+		Target: "blockpage.example.org.",
+	}, &dns.A{
+		Hdr: dns.RR_Header{
+			Name:   "blockpage.example.org.",
+			Rrtype: dns.TypeA,
+			Class:  dns.ClassINET,
+			Ttl:    60,
+		},
+		A: net.IPv4(10, 0, 0, 1),
+	}))
+	queryAAAA := dnsPack(t, dnsQuery("www.example.com", dns.TypeAAAA))
+	replyAAAA := dnsPack(t, dnsReply("www.example.com", dns.TypeAAAA))
+	queryTXT := dnsPack(t, dnsQuery("example.com", dns.TypeTXT))
+	replyTXT := dnsPack(t, dnsReply("example.com", dns.TypeTXT, &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   "example.com.",
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+			Ttl:    3600,
+		},
+		Txt: []string{"v=spf1 -all"},
+	}))
+	nxdomain := dnsReply("www.example.com", dns.TypeA)
+	nxdomain.Rcode = dns.RcodeNameError
+	replyNXDOMAIN := dnsPack(t, nxdomain)
+	replyLegitA := dnsPack(t, dnsReply("www.example.com", dns.TypeA, &dns.A{
+		Hdr: dns.RR_Header{
+			Name:   "www.example.com.",
+			Rrtype: dns.TypeA,
+			Class:  dns.ClassINET,
+			Ttl:    3600,
+		},
+		A: net.IPv4(93, 184, 216, 34),
+	}))
 	type args struct {
 		begin  time.Time
 		events []trace.Event
@@ -284,6 +328,238 @@ func TestNewDNSQueriesList(t *testing.T) {
 			QueryType: "AAAA",
 			T:         0.2,
 		}},
+	}, {
+		name: "run with DNS round trips",
+		args: args{
+			begin: begin,
+			events: []trace.Event{{
+				Address:  "8.8.8.8:53",
+				DNSQuery: queryA,
+				DNSReply: replyA,
+				Name:     "dns_round_trip_done",
+				Proto:    "udp",
+				Time:     begin.Add(50 * time.Millisecond),
+			}, {
+				Address:  "8.8.8.8:53",
+				DNSQuery: queryAAAA,
+				DNSReply: replyAAAA,
+				Name:     "dns_round_trip_done",
+				Proto:    "udp",
+				Time:     begin.Add(60 * time.Millisecond),
+			}, {
+				Address:   "8.8.8.8:53",
+				Addresses: []string{"10.0.0.1"},
+				Hostname:  "www.example.com",
+				Name:      "resolve_done",
+				Proto:     "udp",
+				Time:      begin.Add(70 * time.Millisecond),
+			}, {
+				Address:  "8.8.8.8:53",
+				DNSQuery: queryTXT,
+				DNSReply: replyTXT,
+				Name:     "dns_round_trip_done",
+				Proto:    "udp",
+				Time:     begin.Add(80 * time.Millisecond),
+			}},
+		},
+		want: []archival.DNSQueryEntry{{
+			Answers: []archival.DNSAnswerEntry{{
+				AnswerType: "CNAME",
+				Hostname:   "blockpage.example.org.",
+				TTL:        uint32ptr(300),
+			}, {
+				AnswerType: "A",
+				IPv4:       "10.0.0.1",
+				TTL:        uint32ptr(60),
+			}},
+			Engine:          "udp",
+			Hostname:        "www.example.com",
+			QueryType:       "A",
+			RawQuery:        queryA,
+			RawReply:        replyA,
+			Rcode:           "NOERROR",
+			ResolverAddress: "8.8.8.8:53",
+			T:               0.05,
+		}, {
+			Engine:          "udp",
+			Hostname:        "www.example.com",
+			QueryType:       "AAAA",
+			RawQuery:        queryAAAA,
+			RawReply:        replyAAAA,
+			Rcode:           "NOERROR",
+			ResolverAddress: "8.8.8.8:53",
+			T:               0.06,
+		}, {
+			Answers: []archival.DNSAnswerEntry{{
+				AnswerType: "TXT",
+				TTL:        uint32ptr(3600),
+				TXT:        []string{"v=spf1 -all"},
+			}},
+			Engine:          "udp",
+			Hostname:        "example.com",
+			QueryType:       "TXT",
+			RawQuery:        queryTXT,
+			RawReply:        replyTXT,
+			Rcode:           "NOERROR",
+			ResolverAddress: "8.8.8.8:53",
+			T:               0.08,
+		}},
+	}, {
+		name: "run with failed DNS round trips",
+		args: args{
+			begin: begin,
+			events: []trace.Event{{
+				Address:  "8.8.8.8:53",
+				DNSQuery: queryA,
+				Err:      errors.New("read udp 8.8.8.8:53: i/o timeout"),
+				Name:     "dns_round_trip_done",
+				Proto:    "udp",
+				Time:     begin.Add(100 * time.Millisecond),
+			}, {
+				Address:  "8.8.8.8:53",
+				DNSQuery: queryA,
+				DNSReply: replyNXDOMAIN,
+				Name:     "dns_round_trip_done",
+				Proto:    "udp",
+				Time:     begin.Add(200 * time.Millisecond),
+			}, {
+				Address:  "8.8.8.8:53",
+				Err:      errors.New("mocked error"),
+				Hostname: "www.example.com",
+				Name:     "resolve_done",
+				Proto:    "udp",
+				Time:     begin.Add(300 * time.Millisecond),
+			}},
+		},
+		want: []archival.DNSQueryEntry{{
+			Engine:          "udp",
+			Failure:         strptr(modelx.FailureGenericTimeoutError),
+			Hostname:        "www.example.com",
+			QueryType:       "A",
+			RawQuery:        queryA,
+			ResolverAddress: "8.8.8.8:53",
+			T:               0.1,
+		}, {
+			Engine:          "udp",
+			Failure:         strptr(modelx.FailureDNSNXDOMAINError),
+			Hostname:        "www.example.com",
+			QueryType:       "A",
+			RawQuery:        queryA,
+			RawReply:        replyNXDOMAIN,
+			Rcode:           "NXDOMAIN",
+			ResolverAddress: "8.8.8.8:53",
+			T:               0.2,
+		}},
+	}, {
+		name: "run with late DNS replies",
+		args: args{
+			begin: begin,
+			events: []trace.Event{{
+				Address:  "8.8.8.8:53",
+				DNSQuery: queryA,
+				DNSReply: replyA,
+				Name:     "dns_round_trip_done",
+				Proto:    "udp",
+				Time:     begin.Add(50 * time.Millisecond),
+			}, {
+				Address:  "8.8.8.8:53",
+				DNSQuery: queryAAAA,
+				DNSReply: replyAAAA,
+				Name:     "dns_round_trip_done",
+				Proto:    "udp",
+				Time:     begin.Add(60 * time.Millisecond),
+			}, {
+				Address:  "8.8.8.8:53",
+				DNSQuery: queryA,
+				DNSReply: replyLegitA,
+				Name:     "dns_late_reply",
+				Proto:    "udp",
+				Time:     begin.Add(70 * time.Millisecond),
+			}, {
+				Address:  "8.8.8.8:53",
+				DNSQuery: queryAAAA,
+				DNSReply: replyAAAA,
+				Name:     "dns_late_reply",
+				Proto:    "udp",
+				Time:     begin.Add(80 * time.Millisecond),
+			}, {
+				Address:  "1.1.1.1:53",
+				DNSQuery: queryTXT,
+				DNSReply: replyTXT,
+				Name:     "dns_late_reply",
+				Proto:    "udp",
+				Time:     begin.Add(90 * time.Millisecond),
+			}},
+		},
+		want: []archival.DNSQueryEntry{{
+			Answers: []archival.DNSAnswerEntry{{
+				AnswerType: "CNAME",
+				Hostname:   "blockpage.example.org.",
+				TTL:        uint32ptr(300),
+			}, {
+				AnswerType: "A",
+				IPv4:       "10.0.0.1",
+				TTL:        uint32ptr(60),
+			}},
+			ConflictingReplies: true,
+			Engine:             "udp",
+			Hostname:           "www.example.com",
+			LateReplies: []archival.DNSLateReply{{
+				Answers: []archival.DNSAnswerEntry{{
+					AnswerType: "A",
+					IPv4:       "93.184.216.34",
+					TTL:        uint32ptr(3600),
+				}},
+				RawReply: replyLegitA,
+				Rcode:    "NOERROR",
+				T:        0.07,
+			}},
+			QueryType:       "A",
+			RawQuery:        queryA,
+			RawReply:        replyA,
+			Rcode:           "NOERROR",
+			ResolverAddress: "8.8.8.8:53",
+			T:               0.05,
+		}, {
+			Engine:   "udp",
+			Hostname: "www.example.com",
+			LateReplies: []archival.DNSLateReply{{
+				RawReply: replyAAAA,
+				Rcode:    "NOERROR",
+				T:        0.08,
+			}},
+			QueryType:       "AAAA",
+			RawQuery:        queryAAAA,
+			RawReply:        replyAAAA,
+			Rcode:           "NOERROR",
+			ResolverAddress: "8.8.8.8:53",
+			T:               0.06,
+		}},
+	}, {
+		name: "run with failed system resolver",
+		args: args{
+			begin: begin,
+			events: []trace.Event{{
+				Err:      &modelx.ErrWrapper{Failure: modelx.FailureDNSNXDOMAINError},
+				Hostname: "www.example.com",
+				Name:     "resolve_done",
+				Proto:    "system",
+				Time:     begin.Add(300 * time.Millisecond),
+			}},
+		},
+		want: []archival.DNSQueryEntry{{
+			Engine:    "system",
+			Failure:   strptr(modelx.FailureDNSNXDOMAINError),
+			Hostname:  "www.example.com",
+			QueryType: "A",
+			T:         0.3,
+		}, {
+			Engine:    "system",
+			Failure:   strptr(modelx.FailureDNSNXDOMAINError),
+			Hostname:  "www.example.com",
+			QueryType: "AAAA",
+			T:         0.3,
+		}},
 	}}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -294,6 +570,35 @@ func TestNewDNSQueriesList(t *testing.T) {
 	}
 }
 
+func dnsQuery(domain string, qtype uint16) *dns.Msg {
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(domain), qtype)
+	return query
+}
+
+func dnsReply(domain string, qtype uint16, answers ...dns.RR) *dns.Msg {
+	reply := new(dns.Msg)
+	reply.SetReply(dnsQuery(domain, qtype))
+	reply.Answer = answers
+	return reply
+}
+
+func dnsPack(t *testing.T, msg *dns.Msg) []byte {
+	data, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func uint32ptr(v uint32) *uint32 {
+	return &v
+}
+
+func strptr(s string) *string {
+	return &s
+}
+
 func TestNewNetworkEventsList(t *testing.T) {
 	begin := time.Now()
 	type args struct {
@@ -321,6 +626,13 @@ func TestNewNetworkEventsList(t *testing.T) {
 				Err:     io.EOF,
 				Proto:   "tcp",
 				Time:    begin.Add(7 * time.Millisecond),
+			}, {
+				Name:     "proxy_handshake_done",
+				Address:  "www.google.com:443",
+				Err:      io.ErrUnexpectedEOF,
+				Proto:    "tcp",
+				ProxyURL: "socks5://127.0.0.1:9050",
+				Time:     begin.Add(9 * time.Millisecond),
 			}, {
 				Name:     "read",
 				Err:      context.Canceled,
@@ -343,6 +655,12 @@ func TestNewNetworkEventsList(t *testing.T) {
 			Operation: "connect",
 			Proto:     "tcp",
 			T:         0.007,
+		}, {
+			Address:   "www.google.com:443",
+			Failure:   archival.NewFailure(io.ErrUnexpectedEOF),
+			Operation: "proxy_handshake_done",
+			Proto:     "tcp",
+			T:         0.009,
 		}, {
 			Failure:   archival.NewFailure(context.Canceled),
 			NumBytes:  7117,
@@ -423,6 +741,28 @@ func TestNewTLSHandshakesList(t *testing.T) {
 			T:          0.055,
 			TLSVersion: "TLSv1.3",
 		}},
+	}, {
+		name: "run with parroted ClientHello",
+		args: args{
+			begin: begin,
+			events: []trace.Event{{
+				Name:               "tls_handshake_done",
+				TLSCipherSuite:     "TLS_AES_128_GCM_SHA256",
+				TLSClientHelloID:   "Chrome-72",
+				TLSNegotiatedProto: "http/1.1",
+				TLSServerName:      "x.org",
+				TLSVersion:         "TLSv1.3",
+				Time:               begin.Add(55 * time.Millisecond),
+			}},
+		},
+		want: []archival.TLSHandshake{{
+			CipherSuite:        "TLS_AES_128_GCM_SHA256",
+			ClientHelloID:      "Chrome-72",
+			NegotiatedProtocol: "http/1.1",
+			ServerName:         "x.org",
+			T:                  0.055,
+			TLSVersion:         "TLSv1.3",
+		}},
 	}}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -433,6 +773,32 @@ func TestNewTLSHandshakesList(t *testing.T) {
 	}
 }
 
+func TestNewQUICHandshakesList(t *testing.T) {
+	begin := time.Now()
+	events := []trace.Event{{
+		Name:          "tls_handshake_done",
+		TLSServerName: "x.org",
+		Time:          begin.Add(17 * time.Millisecond),
+	}, {
+		Name:               "quic_handshake_done",
+		TLSCipherSuite:     "TLS_AES_128_GCM_SHA256",
+		TLSNegotiatedProto: "h3-29",
+		TLSServerName:      "y.org",
+		TLSVersion:         "TLSv1.3",
+		Time:               begin.Add(55 * time.Millisecond),
+	}}
+	want := []archival.TLSHandshake{{
+		CipherSuite:        "TLS_AES_128_GCM_SHA256",
+		NegotiatedProtocol: "h3-29",
+		ServerName:         "y.org",
+		T:                  0.055,
+		TLSVersion:         "TLSv1.3",
+	}}
+	if got := archival.NewQUICHandshakesList(begin, events); !reflect.DeepEqual(got, want) {
+		t.Error(cmp.Diff(got, want))
+	}
+}
+
 func TestExtSpec_AddTo(t *testing.T) {
 	m := new(model.Measurement)
 	archival.ExtDNS.AddTo(m)
@@ -13,14 +13,16 @@ import (
 	"github.com/ooni/probe-engine/netx/dialer"
 	"github.com/ooni/probe-engine/netx/handlers"
 	"github.com/ooni/probe-engine/netx/modelx"
+	"github.com/ooni/probe-engine/netx/netsim"
 )
 
 // Dialer performs measurements while dialing.
 type Dialer struct {
-	Beginning time.Time
-	Handler   modelx.Handler
-	Resolver  modelx.DNSResolver
-	TLSConfig *tls.Config
+	Beginning        time.Time
+	ClientHelloSplit *dialer.SplitStrategy
+	Handler          modelx.Handler
+	Resolver         modelx.DNSResolver
+	TLSConfig        *tls.Config
 }
 
 func newDialer(beginning time.Time, handler modelx.Handler) *Dialer {
@@ -60,18 +62,22 @@ func maybeWithMeasurementRoot(
 // - EmitterDialer
 // - ErrorWrapperDialer
 // - TimeoutDialer
+// - SplitterDialer (only if split is not nil)
 // - ByteCountingDialer
-// - net.Dialer
+// - base (typically net.Dialer)
 //
 // If you have others needs, manually build the chain you need.
-func newDNSDialer(resolver dialer.Resolver) dialer.DNSDialer {
+func newDNSDialer(resolver dialer.Resolver, base dialer.Dialer,
+	split *dialer.SplitStrategy) dialer.DNSDialer {
+	var d dialer.Dialer = dialer.ByteCounterDialer{Dialer: base}
+	if split != nil {
+		d = dialer.SplitterDialer{Dialer: d, Strategy: *split}
+	}
 	return dialer.DNSDialer{
 		Dialer: dialer.EmitterDialer{
 			Dialer: dialer.ErrorWrapperDialer{
 				Dialer: dialer.TimeoutDialer{
-					Dialer: dialer.ByteCounterDialer{
-						Dialer: new(net.Dialer),
-					},
+					Dialer: d,
 				},
 			},
 		},
@@ -85,7 +91,19 @@ func (d *Dialer) DialContext(
 	ctx context.Context, network, address string,
 ) (conn net.Conn, err error) {
 	ctx = maybeWithMeasurementRoot(ctx, d.Beginning, d.Handler)
-	return newDNSDialer(d.Resolver).DialContext(ctx, network, address)
+	return d.newDNSDialer(ctx).DialContext(ctx, network, address)
+}
+
+// newDNSDialer creates a new DNS dialer. When ctx contains a simulated
+// network, we use its dialer and its resolver, ignoring the configured
+// resolver, because the system resolver would otherwise hit the real
+// network, while the other resolvers would only know about real servers.
+func (d *Dialer) newDNSDialer(ctx context.Context) dialer.DNSDialer {
+	if simnet := netsim.ContextNetwork(ctx); simnet != nil {
+		return newDNSDialer(resolverWrapResolver(simnet.Resolver()),
+			simnet.Dialer(), d.ClientHelloSplit)
+	}
+	return newDNSDialer(d.Resolver, new(net.Dialer), d.ClientHelloSplit)
 }
 
 // DialTLS is like Dial, but creates TLS connections.
@@ -121,7 +139,7 @@ func (d *Dialer) DialTLSContext(
 ) (net.Conn, error) {
 	ctx = maybeWithMeasurementRoot(ctx, d.Beginning, d.Handler)
 	return newTLSDialer(
-		newDNSDialer(d.Resolver),
+		d.newDNSDialer(ctx),
 		d.TLSConfig,
 	).DialTLSContext(ctx, network, address)
 }
@@ -148,6 +166,13 @@ func (d *Dialer) ForceSpecificSNI(sni string) error {
 	return nil
 }
 
+// ForceClientHelloSplit forces splitting the first write of TCP
+// connections, i.e., the ClientHello, according to split.
+func (d *Dialer) ForceClientHelloSplit(split *dialer.SplitStrategy) error {
+	d.ClientHelloSplit = split
+	return nil
+}
+
 // ForceSkipVerify forces to skip certificate verification
 func (d *Dialer) ForceSkipVerify() error {
 	d.TLSConfig.InsecureSkipVerify = true
@@ -5,16 +5,30 @@ import (
 	"errors"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/ooni/probe-engine/netx/internal/dialid"
 	"github.com/ooni/probe-engine/netx/modelx"
 )
 
+// DefaultConnectionAttemptDelay is the default delay between staggered
+// connection attempts, as recommended by RFC 8305 Sect. 8.
+const DefaultConnectionAttemptDelay = 250 * time.Millisecond
+
 // DNSDialer is a dialer that uses the configured Resolver to resolver a
 // domain name to IP addresses, and the configured Dialer to connect.
+//
+// When there are many addresses, we implement RFC 8305 (happy eyeballs):
+// we interleave IPv4 and IPv6 addresses, we start a new connection attempt
+// every ConnectionAttemptDelay (or as soon as the previous attempt fails)
+// and we cancel the pending attempts as soon as one of them succeeds.
 type DNSDialer struct {
 	Dialer
 	Resolver Resolver
+
+	// ConnectionAttemptDelay is the delay between staggered connection
+	// attempts. If zero, we use DefaultConnectionAttemptDelay.
+	ConnectionAttemptDelay time.Duration
 }
 
 // DialContext implements Dialer.DialContext.
@@ -29,18 +43,104 @@ func (d DNSDialer) DialContext(ctx context.Context, network, address string) (ne
 	if err != nil {
 		return nil, err
 	}
-	var errorslist []error
-	for _, addr := range addrs {
-		target := net.JoinHostPort(addr, onlyport)
-		conn, err := d.Dialer.DialContext(ctx, network, target)
-		if err == nil {
-			return conn, nil
+	if len(addrs) <= 0 {
+		return nil, errors.New("dialer: no addresses to connect to")
+	}
+	return d.dialParallel(ctx, network, onlyport, interleaveAddresses(addrs))
+}
+
+type dialResult struct {
+	conn  net.Conn
+	err   error
+	index int
+}
+
+func (d DNSDialer) dialParallel(
+	ctx context.Context, network, port string, addrs []string) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	delay := d.ConnectionAttemptDelay
+	if delay <= 0 {
+		delay = DefaultConnectionAttemptDelay
+	}
+	// The channel is buffered so that the losers never block
+	results := make(chan dialResult, len(addrs))
+	errorslist := make([]error, len(addrs))
+	var next, pending int
+	var timer <-chan time.Time
+	startNext := func() {
+		target := net.JoinHostPort(addrs[next], port)
+		go func(index int) {
+			conn, err := d.Dialer.DialContext(ctx, network, target)
+			results <- dialResult{conn: conn, err: err, index: index}
+		}(next)
+		next, pending = next+1, pending+1
+		timer = nil
+		if next < len(addrs) {
+			timer = time.After(delay)
+		}
+	}
+	startNext()
+	for pending > 0 {
+		select {
+		case <-timer:
+			startNext()
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				go closeLosers(results, pending)
+				return r.conn, nil
+			}
+			errorslist[r.index] = r.err
+			if next < len(addrs) {
+				startNext() // don't wait for the timer after a failure
+			}
 		}
-		errorslist = append(errorslist, err)
 	}
+	// Here all the attempts have failed, so errorslist has no holes
+	// and is sorted in the same order in which we tried.
 	return nil, reduceErrors(errorslist)
 }
 
+// closeLosers closes the connections established by the attempts
+// that complete after the winner. Our caller cancels the context
+// on return, so the pending attempts should terminate quickly.
+func closeLosers(results <-chan dialResult, pending int) {
+	for ; pending > 0; pending-- {
+		if r := <-results; r.conn != nil {
+			r.conn.Close()
+		}
+	}
+}
+
+// interleaveAddresses reorders addrs such that address families are
+// alternated, starting with the family of the first address, as
+// described by RFC 8305 Sect. 4.
+func interleaveAddresses(addrs []string) []string {
+	var first, second []string
+	for _, addr := range addrs {
+		if isIPv6(addr) == isIPv6(addrs[0]) {
+			first = append(first, addr)
+			continue
+		}
+		second = append(second, addr)
+	}
+	out := make([]string, 0, len(addrs))
+	for len(first) > 0 || len(second) > 0 {
+		if len(first) > 0 {
+			out, first = append(out, first[0]), first[1:]
+		}
+		if len(second) > 0 {
+			out, second = append(out, second[0]), second[1:]
+		}
+	}
+	return out
+}
+
+func isIPv6(addr string) bool {
+	return strings.Contains(addr, ":")
+}
+
 func reduceErrors(errorslist []error) error {
 	if len(errorslist) == 0 {
 		return nil
@@ -48,7 +148,8 @@ func reduceErrors(errorslist []error) error {
 	// If we have a known error, let's consider this the real error
 	// since it's probably most relevant. Otherwise let's return the
 	// first considering that (1) local resolvers likely will give
-	// us IPv4 first and (2) also our resolver does that. So, in case
+	// us IPv4 first, (2) also our resolver does that and (3) we keep
+	// the family of the first address when interleaving. So, in case
 	// the user has no IPv6 connectivity, an IPv6 error is going to
 	// appear later in the list of errors.
 	for _, err := range errorslist {
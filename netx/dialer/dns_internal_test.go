@@ -4,3 +4,8 @@ package dialer
 func ReduceErrors(errorslist []error) error {
 	return reduceErrors(errorslist)
 }
+
+// InterleaveAddresses exposes the internal function interleaveAddresses
+func InterleaveAddresses(addrs []string) []string {
+	return interleaveAddresses(addrs)
+}
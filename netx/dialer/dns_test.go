@@ -5,12 +5,16 @@ import (
 	"errors"
 	"io"
 	"net"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/ooni/probe-engine/netx/dialer"
 	"github.com/ooni/probe-engine/netx/handlers"
 	"github.com/ooni/probe-engine/netx/modelx"
+	"github.com/ooni/probe-engine/netx/trace"
 )
 
 func TestUnitDNSDialerNoPort(t *testing.T) {
@@ -168,3 +172,115 @@ func TestUnitReduceErrors(t *testing.T) {
 		}
 	})
 }
+
+func TestUnitInterleaveAddresses(t *testing.T) {
+	t.Run("with IPv6 first", func(t *testing.T) {
+		out := dialer.InterleaveAddresses([]string{
+			"2001:db8::1", "2001:db8::2", "2001:db8::3", "1.1.1.1", "8.8.8.8",
+		})
+		expected := []string{
+			"2001:db8::1", "1.1.1.1", "2001:db8::2", "8.8.8.8", "2001:db8::3",
+		}
+		if diff := cmp.Diff(expected, out); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+	t.Run("with IPv4 first", func(t *testing.T) {
+		out := dialer.InterleaveAddresses([]string{"1.1.1.1", "8.8.8.8", "::1"})
+		expected := []string{"1.1.1.1", "::1", "8.8.8.8"}
+		if diff := cmp.Diff(expected, out); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+}
+
+// SlowIPv6Dialer simulates broken IPv6 connectivity: IPv6 attempts
+// block until the context is done, IPv4 attempts succeed.
+type SlowIPv6Dialer struct {
+	mu       sync.Mutex
+	attempts []string
+	canceled int
+}
+
+func (d *SlowIPv6Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.mu.Lock()
+	d.attempts = append(d.attempts, address)
+	d.mu.Unlock()
+	if strings.HasPrefix(address, "[") {
+		<-ctx.Done()
+		d.mu.Lock()
+		d.canceled++
+		d.mu.Unlock()
+		return nil, ctx.Err()
+	}
+	return dialer.EOFConn{}, nil
+}
+
+func TestUnitDNSDialerHappyEyeballs(t *testing.T) {
+	slow := &SlowIPv6Dialer{}
+	d := dialer.DNSDialer{
+		ConnectionAttemptDelay: 10 * time.Millisecond,
+		Dialer:                 slow,
+		Resolver: MockableResolver{
+			Addresses: []string{"2001:db8::1", "2001:db8::2", "1.1.1.1"},
+		},
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", "dot.dns:853")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn == nil {
+		t.Fatal("expected non-nil conn")
+	}
+	conn.Close()
+	for {
+		slow.mu.Lock()
+		canceled := slow.canceled
+		slow.mu.Unlock()
+		if canceled == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	expected := []string{"[2001:db8::1]:853", "1.1.1.1:853"}
+	if diff := cmp.Diff(expected, slow.attempts); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestUnitDNSDialerSavesAllAttempts(t *testing.T) {
+	saver := new(trace.Saver)
+	d := dialer.DNSDialer{
+		Dialer: dialer.SaverDialer{Dialer: dialer.EOFDialer{}, Saver: saver},
+		Resolver: MockableResolver{
+			Addresses: []string{"1.1.1.1", "8.8.8.8", "::1"},
+		},
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", "dot.dns:853")
+	if !errors.Is(err, io.EOF) {
+		t.Fatal("not the error we expected")
+	}
+	if conn != nil {
+		t.Fatal("expected nil conn")
+	}
+	events := saver.Read()
+	if len(events) != 3 {
+		t.Fatal("unexpected number of events")
+	}
+	for _, ev := range events {
+		if ev.Name != "connect" || !errors.Is(ev.Err, io.EOF) {
+			t.Fatal("unexpected event")
+		}
+	}
+}
+
+func TestUnitDNSDialerNoAddresses(t *testing.T) {
+	d := dialer.DNSDialer{Dialer: dialer.EOFConnDialer{}, Resolver: MockableResolver{}}
+	conn, err := d.DialContext(context.Background(), "tcp", "dot.dns:853")
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if conn != nil {
+		t.Fatal("expected nil conn")
+	}
+}
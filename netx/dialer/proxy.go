@@ -1,20 +1,41 @@
 package dialer
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"net"
+	"net/http"
 	"net/url"
+	"time"
 
+	"github.com/ooni/probe-engine/netx/internal/errwrapper"
+	"github.com/ooni/probe-engine/netx/trace"
 	"golang.org/x/net/proxy"
 )
 
+// ErrProxyUnsupportedScheme indicates that the ProxyURL scheme is
+// not one of socks5, http, and https.
+var ErrProxyUnsupportedScheme = errors.New("dialer: unsupported proxy scheme")
+
 // ProxyDialer is a dialer that uses a proxy. If the ProxyURL is not configured, this
 // dialer is a passthrough for the next Dialer in chain. Otherwise, it will internally
-// create a SOCKS5 dialer that will connect to the proxy using the underlying Dialer.
+// create a SOCKS5 or HTTP CONNECT dialer that will connect to the proxy using the
+// underlying Dialer. The credentials in ProxyURL.User, if any, are used to
+// authenticate with the proxy.
+//
+// We wrap the errors occurring while handshaking with the proxy using the
+// proxy_handshake operation, so that it is possible to distinguish them from
+// errors occurring later when using the connection with the target. When the
+// Saver is not nil, we also save the proxy_handshake_start and the
+// proxy_handshake_done events.
 type ProxyDialer struct {
 	Dialer
 	ProxyURL *url.URL
+	Saver    *trace.Saver
 }
 
 // DialContext implements Dialer.DialContext
@@ -22,13 +43,58 @@ func (d ProxyDialer) DialContext(ctx context.Context, network, address string) (
 	if d.ProxyURL == nil {
 		return d.Dialer.DialContext(ctx, network, address)
 	}
-	if d.ProxyURL.Scheme != "socks5" {
-		return nil, errors.New("Scheme is not socks5")
+	var child proxy.Dialer
+	switch d.ProxyURL.Scheme {
+	case "socks5":
+		// the code at proxy/socks5.go never fails; see https://git.io/JfJ4g
+		child, _ = proxy.SOCKS5(
+			network, d.ProxyURL.Host, d.socks5Auth(),
+			proxyDialerWrapper{Dialer: d.Dialer})
+	case "http", "https":
+		child = httpConnectDialer{Dialer: d.Dialer, ProxyURL: d.ProxyURL}
+	default:
+		return nil, ErrProxyUnsupportedScheme
+	}
+	start := time.Now()
+	d.saveEvent(trace.Event{
+		Address: address,
+		Name:    "proxy_handshake_start",
+		Proto:   network,
+		Time:    start,
+	})
+	conn, err := d.dial(ctx, child, network, address)
+	err = errwrapper.SafeErrWrapperBuilder{
+		Error:     err,
+		Operation: "proxy_handshake",
+	}.MaybeBuild()
+	stop := time.Now()
+	d.saveEvent(trace.Event{
+		Address:  address,
+		Duration: stop.Sub(start),
+		Err:      err,
+		Name:     "proxy_handshake_done",
+		Proto:    network,
+		Time:     stop,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (d ProxyDialer) saveEvent(ev trace.Event) {
+	if d.Saver != nil {
+		ev.ProxyURL = (&url.URL{Scheme: d.ProxyURL.Scheme, Host: d.ProxyURL.Host}).String()
+		d.Saver.Write(ev)
+	}
+}
+
+func (d ProxyDialer) socks5Auth() *proxy.Auth {
+	if d.ProxyURL.User == nil {
+		return nil
 	}
-	// the code at proxy/socks5.go never fails; see https://git.io/JfJ4g
-	child, _ := proxy.SOCKS5(
-		network, d.ProxyURL.Host, nil, proxyDialerWrapper{Dialer: d.Dialer})
-	return d.dial(ctx, child, network, address)
+	password, _ := d.ProxyURL.User.Password()
+	return &proxy.Auth{User: d.ProxyURL.User.Username(), Password: password}
 }
 
 func (d ProxyDialer) dial(
@@ -69,3 +135,95 @@ type proxyDialerWrapper struct {
 func (d proxyDialerWrapper) Dial(network, address string) (net.Conn, error) {
 	return d.DialContext(context.Background(), network, address)
 }
+
+// httpConnectDialer is a proxy.Dialer that uses HTTP CONNECT to ask
+// an HTTP or HTTPS proxy to connect to the target address.
+type httpConnectDialer struct {
+	Dialer
+	ProxyURL *url.URL
+}
+
+// httpConnectTimeout is the maximum time we allow for establishing the
+// connection with the proxy and receiving the CONNECT response.
+const httpConnectTimeout = 15 * time.Second
+
+func (d httpConnectDialer) Dial(network, address string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), httpConnectTimeout)
+	defer cancel()
+	conn, err := d.DialContext(ctx, "tcp", d.proxyAddress())
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(httpConnectTimeout))
+	if d.ProxyURL.Scheme == "https" {
+		tlsconn := tls.Client(conn, &tls.Config{
+			NextProtos: []string{"http/1.1"},
+			ServerName: d.ProxyURL.Hostname(),
+		})
+		if err := tlsconn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsconn
+	}
+	req := &http.Request{
+		Header: make(http.Header),
+		Host:   address,
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: address},
+	}
+	if d.ProxyURL.User != nil {
+		password, _ := d.ProxyURL.User.Password()
+		credentials := d.ProxyURL.User.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization",
+			"Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		conn.Close()
+		return nil, fmt.Errorf("dialer: proxy CONNECT failed: %s", resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	if reader.Buffered() > 0 {
+		// The proxy may have sent us data from the target along
+		// with its response, which we must not lose.
+		return bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+func (d httpConnectDialer) proxyAddress() string {
+	if d.ProxyURL.Port() != "" {
+		return d.ProxyURL.Host
+	}
+	port := "80"
+	if d.ProxyURL.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(d.ProxyURL.Hostname(), port)
+}
+
+// bufferedConn is a net.Conn that first reads the data that
+// has already been buffered by the reader.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+var _ Dialer = ProxyDialer{}
+var _ proxy.Dialer = httpConnectDialer{}
+var _ net.Conn = bufferedConn{}
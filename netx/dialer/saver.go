@@ -10,6 +10,7 @@ import (
 
 	"github.com/ooni/probe-engine/internal/tlsx"
 	"github.com/ooni/probe-engine/netx/trace"
+	utls "github.com/refraction-networking/utls"
 )
 
 // SaverDialer saves events occurring during the dial
@@ -34,10 +35,13 @@ func (d SaverDialer) DialContext(ctx context.Context, network, address string) (
 	return conn, err
 }
 
-// SaverTLSHandshaker saves events occurring during the handshake
+// SaverTLSHandshaker saves events occurring during the handshake. When the
+// TLSHandshaker is parroting a ClientHello, set ClientHelloID accordingly
+// so that we save the parroted profile along with the handshake.
 type SaverTLSHandshaker struct {
 	TLSHandshaker
-	Saver *trace.Saver
+	ClientHelloID *utls.ClientHelloID
+	Saver         *trace.Saver
 }
 
 // Handshake implements TLSHandshaker.Handshake
@@ -46,11 +50,12 @@ func (h SaverTLSHandshaker) Handshake(
 ) (net.Conn, tls.ConnectionState, error) {
 	start := time.Now()
 	h.Saver.Write(trace.Event{
-		Name:          "tls_handshake_start",
-		NoTLSVerify:   config.InsecureSkipVerify,
-		TLSNextProtos: config.NextProtos,
-		TLSServerName: config.ServerName,
-		Time:          start,
+		Name:             "tls_handshake_start",
+		NoTLSVerify:      config.InsecureSkipVerify,
+		TLSClientHelloID: ClientHelloIDString(h.ClientHelloID),
+		TLSNextProtos:    config.NextProtos,
+		TLSServerName:    config.ServerName,
+		Time:             start,
 	})
 	tlsconn, state, err := h.TLSHandshaker.Handshake(ctx, conn, config)
 	stop := time.Now()
@@ -60,6 +65,7 @@ func (h SaverTLSHandshaker) Handshake(
 		Name:               "tls_handshake_done",
 		NoTLSVerify:        config.InsecureSkipVerify,
 		TLSCipherSuite:     tlsx.CipherSuiteString(state.CipherSuite),
+		TLSClientHelloID:   ClientHelloIDString(h.ClientHelloID),
 		TLSNegotiatedProto: state.NegotiatedProtocol,
 		TLSNextProtos:      config.NextProtos,
 		TLSPeerCerts:       peerCerts(state, err),
@@ -72,9 +78,15 @@ func (h SaverTLSHandshaker) Handshake(
 
 // SaverConnDialer wraps the returned connection such that we
 // collect all the read/write events that occur.
+//
+// By default we save a copy of the bytes read or written in the Data
+// field of the events, which allows, e.g., to synthesize a pcap-ng. When
+// DropPayloads is true, we only save the number of bytes, which is
+// useful to save memory when nobody is going to use the payloads.
 type SaverConnDialer struct {
 	Dialer
-	Saver *trace.Saver
+	DropPayloads bool
+	Saver        *trace.Saver
 }
 
 // DialContext implements Dialer.DialContext
@@ -83,26 +95,46 @@ func (d SaverConnDialer) DialContext(ctx context.Context, network, address strin
 	if err != nil {
 		return nil, err
 	}
-	return saverConn{saver: d.Saver, Conn: conn}, nil
+	return saverConn{saver: d.Saver, Conn: conn, dropPayloads: d.DropPayloads}, nil
 }
 
 type saverConn struct {
 	net.Conn
-	saver *trace.Saver
+	dropPayloads bool
+	saver        *trace.Saver
+}
+
+// complete adds the addresses of the connection to ev, so that
+// the Saver can tell apart the events of different connections, and
+// copies or drops the payload depending on dropPayloads.
+func (c saverConn) complete(ev trace.Event) trace.Event {
+	if c.dropPayloads {
+		ev.Data = nil
+	} else {
+		// We must copy because the caller owns the buffer
+		ev.Data = append([]byte(nil), ev.Data...)
+	}
+	if addr := c.RemoteAddr(); addr != nil {
+		ev.Address, ev.Proto = addr.String(), addr.Network()
+	}
+	if addr := c.LocalAddr(); addr != nil {
+		ev.LocalAddress = addr.String()
+	}
+	return ev
 }
 
 func (c saverConn) Read(p []byte) (int, error) {
 	start := time.Now()
 	count, err := c.Conn.Read(p)
 	stop := time.Now()
-	c.saver.Write(trace.Event{
+	c.saver.Write(c.complete(trace.Event{
 		Data:     p[:count],
 		Duration: stop.Sub(start),
 		Err:      err,
 		NumBytes: count,
 		Name:     "read",
 		Time:     stop,
-	})
+	}))
 	return count, err
 }
 
@@ -110,14 +142,14 @@ func (c saverConn) Write(p []byte) (int, error) {
 	start := time.Now()
 	count, err := c.Conn.Write(p)
 	stop := time.Now()
-	c.saver.Write(trace.Event{
+	c.saver.Write(c.complete(trace.Event{
 		Data:     p[:count],
 		Duration: stop.Sub(start),
 		Err:      err,
 		NumBytes: count,
 		Name:     "write",
 		Time:     stop,
-	})
+	}))
 	return count, err
 }
 
@@ -71,6 +71,48 @@ func TestUnitSaverConnDialerFailure(t *testing.T) {
 	}
 }
 
+func TestUnitSaverConnDialerPayloads(t *testing.T) {
+	for _, drop := range []bool{false, true} {
+		saver := &trace.Saver{}
+		dlr := dialer.SaverConnDialer{
+			Dialer: dialer.FakeDialer{
+				Conn: &dialer.FakeConn{ReadData: []byte("abc")},
+			},
+			DropPayloads: drop,
+			Saver:        saver,
+		}
+		conn, err := dlr.DialContext(context.Background(), "tcp", "www.google.com:443")
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 8)
+		count, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		copy(buf, "xyz") // the caller reuses the buffer
+		if _, err := conn.Write(buf[:count]); err != nil {
+			t.Fatal(err)
+		}
+		ev := saver.Read()
+		if len(ev) != 2 {
+			t.Fatal("expected two events here")
+		}
+		if ev[0].NumBytes != 3 || ev[1].NumBytes != 3 {
+			t.Fatal("unexpected NumBytes")
+		}
+		if drop {
+			if ev[0].Data != nil || ev[1].Data != nil {
+				t.Fatal("we should not have kept the payloads")
+			}
+			continue
+		}
+		if string(ev[0].Data) != "abc" || string(ev[1].Data) != "xyz" {
+			t.Fatal("unexpected payloads")
+		}
+	}
+}
+
 func TestIntegrationSaverTLSHandshakerSuccessWithReadWrite(t *testing.T) {
 	// This is the most common use case for collecting reads, writes
 	if testing.Short() {
@@ -132,6 +174,12 @@ func TestIntegrationSaverTLSHandshakerSuccessWithReadWrite(t *testing.T) {
 		default:
 			t.Fatal("unexpected Name")
 		}
+		if ev[idx].Address == "" || ev[idx].LocalAddress == "" {
+			t.Fatal("unexpected Address or LocalAddress")
+		}
+		if ev[idx].Proto != "tcp" {
+			t.Fatal("unexpected Proto")
+		}
 		if ev[idx].Time.Before(ev[idx-1].Time) {
 			t.Fatal("unexpected Time")
 		}
@@ -0,0 +1,143 @@
+package dialer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidSplitStrategy indicates that we cannot parse a SplitStrategy.
+var ErrInvalidSplitStrategy = errors.New("dialer: invalid split strategy")
+
+// SplitStrategy describes how SplitterDialer splits the first write
+// of a TCP connection, which is the ClientHello for TLS connections.
+type SplitStrategy struct {
+	// Chunks contains the size of each chunk. We write the data
+	// beyond the sum of the sizes as the last chunk.
+	Chunks []int
+
+	// Delay is the delay between consecutive chunks.
+	Delay time.Duration
+}
+
+// ParseSplitStrategy parses a SplitStrategy. The format is a comma
+// separated list of chunk sizes optionally followed by a slash and by
+// the delay between chunks, e.g., "1,20" or "1,20/100ms". An empty
+// string means that we should not split and yields a nil strategy.
+func ParseSplitStrategy(s string) (*SplitStrategy, error) {
+	if s == "" {
+		return nil, nil
+	}
+	strategy, sizes := new(SplitStrategy), s
+	if idx := strings.Index(s, "/"); idx >= 0 {
+		delay, err := time.ParseDuration(s[idx+1:])
+		if err != nil || delay < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSplitStrategy, s)
+		}
+		strategy.Delay, sizes = delay, s[:idx]
+	}
+	for _, field := range strings.Split(sizes, ",") {
+		size, err := strconv.Atoi(field)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSplitStrategy, s)
+		}
+		strategy.Chunks = append(strategy.Chunks, size)
+	}
+	return strategy, nil
+}
+
+// String returns the representation of the strategy accepted
+// by ParseSplitStrategy, or an empty string if s is nil.
+func (s *SplitStrategy) String() string {
+	if s == nil {
+		return ""
+	}
+	var fields []string
+	for _, size := range s.Chunks {
+		fields = append(fields, strconv.Itoa(size))
+	}
+	out := strings.Join(fields, ",")
+	if s.Delay > 0 {
+		out += "/" + s.Delay.String()
+	}
+	return out
+}
+
+// split splits data into chunks according to the strategy.
+func (s SplitStrategy) split(data []byte) (out [][]byte) {
+	for _, size := range s.Chunks {
+		if len(data) <= 0 {
+			break
+		}
+		if size > len(data) {
+			size = len(data)
+		}
+		out = append(out, data[:size])
+		data = data[size:]
+	}
+	if len(data) > 0 {
+		out = append(out, data)
+	}
+	return
+}
+
+// SplitterDialer is a dialer that splits the first write of TCP
+// connections into chunks according to the Strategy. Because we
+// disable Nagle's algorithm, each chunk is sent in its own segment,
+// which allows us to see whether a DPI box that matches the SNI
+// reassembles the TCP stream before inspecting the ClientHello.
+type SplitterDialer struct {
+	Dialer
+	Strategy SplitStrategy
+}
+
+// DialContext implements Dialer.DialContext
+func (d SplitterDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.Dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(network, "tcp") {
+		return conn, nil // splitting datagrams makes no sense
+	}
+	if tcpconn, ok := conn.(*net.TCPConn); ok {
+		tcpconn.SetNoDelay(true) // the default, but let's be explicit
+	}
+	return &splitterConn{Conn: conn, strategy: d.Strategy}, nil
+}
+
+type splitterConn struct {
+	net.Conn
+	once     sync.Once
+	strategy SplitStrategy
+}
+
+func (c *splitterConn) Write(b []byte) (int, error) {
+	var first bool
+	c.once.Do(func() {
+		first = true
+	})
+	if !first {
+		return c.Conn.Write(b)
+	}
+	var total int
+	for idx, chunk := range c.strategy.split(b) {
+		if idx > 0 && c.strategy.Delay > 0 {
+			time.Sleep(c.strategy.Delay)
+		}
+		count, err := c.Conn.Write(chunk)
+		total += count
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+var _ Dialer = SplitterDialer{}
+var _ net.Conn = &splitterConn{}
@@ -0,0 +1,139 @@
+package dialer_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/netx/dialer"
+)
+
+func TestUnitParseSplitStrategy(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected *dialer.SplitStrategy
+		err      error
+	}{{
+		input: "",
+	}, {
+		input:    "1,20",
+		expected: &dialer.SplitStrategy{Chunks: []int{1, 20}},
+	}, {
+		input: "1,20/100ms",
+		expected: &dialer.SplitStrategy{
+			Chunks: []int{1, 20}, Delay: 100 * time.Millisecond,
+		},
+	}, {
+		input: "1,antani",
+		err:   dialer.ErrInvalidSplitStrategy,
+	}, {
+		input: "0",
+		err:   dialer.ErrInvalidSplitStrategy,
+	}, {
+		input: "1/antani",
+		err:   dialer.ErrInvalidSplitStrategy,
+	}, {
+		input: "1/-1s",
+		err:   dialer.ErrInvalidSplitStrategy,
+	}}
+	for _, tt := range tests {
+		strategy, err := dialer.ParseSplitStrategy(tt.input)
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("%s: not the error we expected: %+v", tt.input, err)
+		}
+		if !reflect.DeepEqual(strategy, tt.expected) {
+			t.Fatalf("%s: not the strategy we expected", tt.input)
+		}
+		if err == nil && strategy.String() != tt.input {
+			t.Fatalf("%s: not the string we expected", tt.input)
+		}
+	}
+}
+
+func TestUnitSplitterDialerSplitsFirstWrite(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	d := dialer.SplitterDialer{
+		Dialer: dialer.FakeDialer{Conn: client},
+		Strategy: dialer.SplitStrategy{
+			Chunks: []int{1, 3},
+			Delay:  10 * time.Millisecond,
+		},
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", "8.8.8.8:853")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reads := make(chan []byte)
+	go func() {
+		defer close(reads)
+		for {
+			buffer := make([]byte, 1024)
+			count, err := server.Read(buffer)
+			if err != nil {
+				return
+			}
+			reads <- buffer[:count]
+		}
+	}()
+	first, second := []byte("clienthello"), []byte("second")
+	start := time.Now()
+	go func() {
+		conn.Write(first)
+		conn.Write(second)
+		conn.Close()
+	}()
+	var chunks [][]byte
+	for data := range reads {
+		chunks = append(chunks, data)
+	}
+	if time.Now().Sub(start) < 20*time.Millisecond {
+		t.Fatal("we did not wait between chunks")
+	}
+	expected := [][]byte{
+		[]byte("c"), []byte("lie"), []byte("nthello"), second,
+	}
+	if len(chunks) != len(expected) {
+		t.Fatal("unexpected number of chunks")
+	}
+	for idx := range chunks {
+		if !bytes.Equal(chunks[idx], expected[idx]) {
+			t.Fatal("unexpected chunk")
+		}
+	}
+}
+
+func TestUnitSplitterDialerIgnoresUDP(t *testing.T) {
+	expected := &dialer.FakeConn{}
+	d := dialer.SplitterDialer{
+		Dialer:   dialer.FakeDialer{Conn: expected},
+		Strategy: dialer.SplitStrategy{Chunks: []int{1}},
+	}
+	conn, err := d.DialContext(context.Background(), "udp", "8.8.8.8:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn != expected {
+		t.Fatal("we should not wrap UDP connections")
+	}
+}
+
+func TestUnitSplitterDialerFailure(t *testing.T) {
+	expected := errors.New("mocked error")
+	d := dialer.SplitterDialer{
+		Dialer:   dialer.FakeDialer{Err: expected},
+		Strategy: dialer.SplitStrategy{Chunks: []int{1}},
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", "8.8.8.8:853")
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+	if conn != nil {
+		t.Fatal("expected nil conn here")
+	}
+}
@@ -0,0 +1,144 @@
+package dialer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// ClientHelloIDs maps the names of the ClientHello fingerprints that
+// we know how to parrot to the corresponding utls IDs.
+var ClientHelloIDs = map[string]*utls.ClientHelloID{
+	"chrome":     &utls.HelloChrome_Auto,
+	"firefox":    &utls.HelloFirefox_Auto,
+	"ios":        &utls.HelloIOS_Auto,
+	"randomized": &utls.HelloRandomized,
+}
+
+// ErrUnknownClientHelloID indicates that we don't know the name
+// of the ClientHello fingerprint you asked us to parrot.
+var ErrUnknownClientHelloID = errors.New("dialer: unknown ClientHello ID")
+
+// NewClientHelloID returns the utls ID corresponding to name, which
+// must be one of the keys of ClientHelloIDs.
+func NewClientHelloID(name string) (*utls.ClientHelloID, error) {
+	id, found := ClientHelloIDs[name]
+	if !found {
+		return nil, ErrUnknownClientHelloID
+	}
+	return id, nil
+}
+
+// ClientHelloIDString returns a string representation of the ClientHello
+// ID (e.g. "Chrome-72"), or an empty string if id is nil.
+func ClientHelloIDString(id *utls.ClientHelloID) string {
+	if id == nil {
+		return ""
+	}
+	return id.Client + "-" + id.Version
+}
+
+// UTLSHandshaker is a TLSHandshaker using refraction-networking/utls to
+// parrot the ClientHello of a browser, so that our ClientHello is not
+// as easy to fingerprint as the one of the Go standard library.
+//
+// We replace the ALPN protocols of the parroted ClientHello with the
+// NextProtos of the config, if any. This is important because net/http
+// is only able to speak HTTP/2 over a *tls.Conn, so you should not
+// advertise h2 when using this handshaker for HTTP. Like the standard
+// library, we also omit the SNI extension when the ServerName is empty,
+// because an empty host_name makes the ClientHello malformed. Finally, we
+// make sure the two GREASE extensions of Chrome have distinct values, since
+// utls sometimes gives them the same value and servers reject duplicate
+// extensions as a malformed ClientHello.
+type UTLSHandshaker struct {
+	ClientHelloID *utls.ClientHelloID
+}
+
+// Handshake implements Handshaker.Handshake
+func (h UTLSHandshaker) Handshake(
+	ctx context.Context, conn net.Conn, config *tls.Config,
+) (net.Conn, tls.ConnectionState, error) {
+	tlsconn := utls.UClient(conn, &utls.Config{
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		NextProtos:         config.NextProtos,
+		RootCAs:            config.RootCAs,
+		ServerName:         config.ServerName,
+	}, *h.ClientHelloID)
+	errch := make(chan error, 1)
+	go func() {
+		errch <- h.handshake(tlsconn, config)
+	}()
+	select {
+	case err := <-errch:
+		if err != nil {
+			return nil, tls.ConnectionState{}, err
+		}
+		return tlsconn, newConnectionState(tlsconn.ConnectionState()), nil
+	case <-ctx.Done():
+		return nil, tls.ConnectionState{}, ctx.Err()
+	}
+}
+
+func (h UTLSHandshaker) handshake(tlsconn *utls.UConn, config *tls.Config) error {
+	if err := h.customizeClientHello(tlsconn, config); err != nil {
+		return err
+	}
+	return tlsconn.Handshake()
+}
+
+func (h UTLSHandshaker) customizeClientHello(
+	tlsconn *utls.UConn, config *tls.Config) error {
+	if err := tlsconn.BuildHandshakeState(); err != nil {
+		return err
+	}
+	// Build a new list of extensions rather than modifying the parroted
+	// ones in place, so we don't mess up with the parroted spec.
+	var (
+		extensions []utls.TLSExtension
+		grease     *utls.UtlsGREASEExtension
+	)
+	for _, ext := range tlsconn.Extensions {
+		switch e := ext.(type) {
+		case *utls.SNIExtension:
+			if config.ServerName == "" {
+				continue
+			}
+		case *utls.ALPNExtension:
+			if len(config.NextProtos) > 0 {
+				ext = &utls.ALPNExtension{AlpnProtocols: config.NextProtos}
+			}
+		case *utls.UtlsGREASEExtension:
+			if grease != nil && grease.Value == e.Value {
+				ext = &utls.UtlsGREASEExtension{Value: e.Value ^ 0x1010, Body: e.Body}
+			}
+			grease = e
+		}
+		extensions = append(extensions, ext)
+	}
+	tlsconn.Extensions = extensions
+	if err := tlsconn.ApplyConfig(); err != nil {
+		return err
+	}
+	return tlsconn.MarshalClientHello()
+}
+
+// newConnectionState converts the utls connection state to the
+// connection state used by the standard library.
+func newConnectionState(state utls.ConnectionState) tls.ConnectionState {
+	return tls.ConnectionState{
+		CipherSuite:        state.CipherSuite,
+		DidResume:          state.DidResume,
+		HandshakeComplete:  state.HandshakeComplete,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		PeerCertificates:   state.PeerCertificates,
+		ServerName:         state.ServerName,
+		VerifiedChains:     state.VerifiedChains,
+		Version:            state.Version,
+	}
+}
+
+var _ TLSHandshaker = UTLSHandshaker{}
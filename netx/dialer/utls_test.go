@@ -0,0 +1,164 @@
+package dialer_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ooni/probe-engine/netx/dialer"
+	"github.com/ooni/probe-engine/netx/trace"
+	utls "github.com/refraction-networking/utls"
+)
+
+func TestUnitNewClientHelloID(t *testing.T) {
+	id, err := dialer.NewClientHelloID("chrome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != &utls.HelloChrome_Auto {
+		t.Fatal("not the ClientHelloID we expected")
+	}
+	id, err = dialer.NewClientHelloID("antani")
+	if !errors.Is(err, dialer.ErrUnknownClientHelloID) {
+		t.Fatal("not the error we expected")
+	}
+	if id != nil {
+		t.Fatal("expected nil ClientHelloID here")
+	}
+}
+
+func TestUnitClientHelloIDString(t *testing.T) {
+	if dialer.ClientHelloIDString(nil) != "" {
+		t.Fatal("unexpected string for nil ClientHelloID")
+	}
+	id := &utls.ClientHelloID{Client: "Firefox", Version: "65"}
+	if dialer.ClientHelloIDString(id) != "Firefox-65" {
+		t.Fatal("unexpected string for ClientHelloID")
+	}
+}
+
+func TestUnitUTLSHandshakerContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // immeditely cancel
+	h := dialer.UTLSHandshaker{ClientHelloID: &utls.HelloFirefox_Auto}
+	conn, _, err := h.Handshake(ctx, dialer.EOFConn{}, new(tls.Config))
+	if err != context.Canceled {
+		t.Fatal("not the error that we expected")
+	}
+	if conn != nil {
+		t.Fatal("expected nil con here")
+	}
+}
+
+func TestUnitUTLSHandshakerEOFError(t *testing.T) {
+	h := dialer.UTLSHandshaker{ClientHelloID: &utls.HelloFirefox_Auto}
+	conn, _, err := h.Handshake(context.Background(), dialer.EOFConn{}, &tls.Config{
+		ServerName: "x.org",
+	})
+	if err != io.EOF {
+		t.Fatal("not the error that we expected")
+	}
+	if conn != nil {
+		t.Fatal("expected nil con here")
+	}
+}
+
+func TestUnitUTLSHandshakerNextProtos(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	// the server prefers h2, so it would pick h2 if we offered it
+	server.TLS = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	server.StartTLS()
+	defer server.Close()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "https://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	h := dialer.UTLSHandshaker{ClientHelloID: &utls.HelloChrome_Auto}
+	tlsconn, state, err := h.Handshake(context.Background(), conn, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"http/1.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tlsconn.Close()
+	if state.NegotiatedProtocol != "http/1.1" {
+		t.Fatal("unexpected NegotiatedProtocol")
+	}
+	if !state.HandshakeComplete || len(state.PeerCertificates) <= 0 {
+		t.Fatal("unexpected connection state")
+	}
+}
+
+func TestUnitUTLSHandshakerChromeGREASE(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	// Without deduplication, the two GREASE extensions have the same value
+	// once every sixteen handshakes, so do enough handshakes to notice.
+	for i := 0; i < 64; i++ {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "https://"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := dialer.UTLSHandshaker{ClientHelloID: &utls.HelloChrome_Auto}
+		tlsconn, _, err := h.Handshake(context.Background(), conn, &tls.Config{
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tlsconn.Close()
+	}
+}
+
+func TestUnitSaverTLSHandshakerClientHelloID(t *testing.T) {
+	saver := new(trace.Saver)
+	h := dialer.SaverTLSHandshaker{
+		ClientHelloID: &utls.HelloIOS_Auto,
+		Saver:         saver,
+		TLSHandshaker: dialer.UTLSHandshaker{ClientHelloID: &utls.HelloIOS_Auto},
+	}
+	_, _, err := h.Handshake(context.Background(), dialer.EOFConn{}, &tls.Config{
+		ServerName: "x.org",
+	})
+	if err != io.EOF {
+		t.Fatal("not the error that we expected")
+	}
+	events := saver.Read()
+	if len(events) != 2 {
+		t.Fatal("unexpected number of events")
+	}
+	expected := dialer.ClientHelloIDString(&utls.HelloIOS_Auto)
+	for _, ev := range events {
+		if ev.TLSClientHelloID != expected {
+			t.Fatal("unexpected TLSClientHelloID")
+		}
+	}
+}
+
+func TestIntegrationUTLSHandshakerChrome(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	tlsdlr := dialer.TLSDialer{
+		Config: &tls.Config{NextProtos: []string{"h2", "http/1.1"}},
+		Dialer: new(net.Dialer),
+		TLSHandshaker: dialer.UTLSHandshaker{
+			ClientHelloID: &utls.HelloChrome_Auto,
+		},
+	}
+	conn, err := tlsdlr.DialTLSContext(context.Background(), "tcp", "www.google.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
@@ -1,11 +1,16 @@
 package netx_test
 
 import (
+	"context"
 	"crypto/x509"
 	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/modelx"
+	"github.com/ooni/probe-engine/netx/netsim"
 )
 
 func TestIntegrationDialerDial(t *testing.T) {
@@ -113,3 +118,33 @@ func TestIntegrationDialerForceSpecificSNI(t *testing.T) {
 		t.Fatal("expected a nil connection here")
 	}
 }
+
+func TestUnitDialerWithSimulatedNetwork(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	network := new(netsim.Network)
+	network.AddHost("example.com", "93.184.216.34")
+	network.AddEndpoint("93.184.216.34:443", server.Listener.Addr().String())
+	network.AddPolicy(netsim.Policy{
+		Action: netsim.ActionReset,
+		Host:   "example.com",
+	})
+	ctx := netsim.WithNetwork(context.Background(), network)
+	dialer := netx.NewDialer()
+	conn, err := dialer.DialContext(ctx, "tcp", "example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.RemoteAddr().String() != "93.184.216.34:443" {
+		t.Fatal("not the RemoteAddr we expected")
+	}
+	conn.Close()
+	conn, err = dialer.DialTLSContext(ctx, "tcp", "example.com:443")
+	var wrapper *modelx.ErrWrapper
+	if !errors.As(err, &wrapper) || wrapper.Failure != modelx.FailureConnectionReset {
+		t.Fatal("not the error we expected", err)
+	}
+	if conn != nil {
+		t.Fatal("expected nil conn here")
+	}
+}
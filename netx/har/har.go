@@ -0,0 +1,585 @@
+// Package har converts HTTP traces to the HTTP Archive (HAR) 1.2 format,
+// so that they can be inspected using the browser developer tools.
+//
+// See http://www.softwareishard.com/blog/har-12-spec/.
+//
+// We use the "_failure" field of an entry to store the OONI failure
+// of a transaction, if any, and the "_tls" field to store information
+// about the TLS handshake performed for the transaction, if any. We also
+// set the "_bodyIsTruncated" field of the content when we have only
+// saved the beginning of the response body.
+package har
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/trace"
+	"github.com/ooni/probe-engine/version"
+)
+
+// HAR is the root object of a HAR file.
+type HAR struct {
+	Log Log `json:"log"`
+}
+
+// Log contains the HAR entries.
+type Log struct {
+	Creator Creator `json:"creator"`
+	Entries []Entry `json:"entries"`
+	Version string  `json:"version"`
+}
+
+// Creator describes the application that created the HAR.
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Entry is an HTTP transaction.
+type Entry struct {
+	Cache           Cache    `json:"cache"`
+	Failure         *string  `json:"_failure,omitempty"`
+	Request         Request  `json:"request"`
+	Response        Response `json:"response"`
+	ServerIPAddress string   `json:"serverIPAddress,omitempty"`
+	StartedDateTime string   `json:"startedDateTime"`
+	TLS             *TLS     `json:"_tls,omitempty"`
+	Time            float64  `json:"time"`
+	Timings         Timings  `json:"timings"`
+}
+
+// Cache contains information about the cache. We don't use a cache
+// hence it's always empty, but HAR requires it.
+type Cache struct{}
+
+// Request is an HTTP request.
+type Request struct {
+	BodySize    int64       `json:"bodySize"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	HeadersSize int64       `json:"headersSize"`
+	HTTPVersion string      `json:"httpVersion"`
+	Method      string      `json:"method"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	QueryString []NameValue `json:"queryString"`
+	URL         string      `json:"url"`
+}
+
+// Response is an HTTP response.
+type Response struct {
+	BodySize    int64       `json:"bodySize"`
+	Content     Content     `json:"content"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	HeadersSize int64       `json:"headersSize"`
+	HTTPVersion string      `json:"httpVersion"`
+	RedirectURL string      `json:"redirectURL"`
+	Status      int64       `json:"status"`
+	StatusText  string      `json:"statusText"`
+}
+
+// Cookie is a cookie.
+type Cookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// NameValue is a name-value pair, e.g., a header.
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// PostData is the body of a request. We use the "_encoding" field,
+// which HAR 1.2 does not define, for binary bodies.
+type PostData struct {
+	Encoding string `json:"_encoding,omitempty"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// Content is the body of a response.
+type Content struct {
+	BodyIsTruncated bool   `json:"_bodyIsTruncated,omitempty"`
+	Encoding        string `json:"encoding,omitempty"`
+	MimeType        string `json:"mimeType"`
+	Size            int64  `json:"size"`
+	Text            string `json:"text,omitempty"`
+}
+
+// Timings contains the timings of a transaction in milliseconds, where
+// -1 means that a timing does not apply to the transaction. As mandated
+// by HAR, the SSL time is also included in the Connect time.
+type Timings struct {
+	Blocked float64 `json:"blocked"`
+	Connect float64 `json:"connect"`
+	DNS     float64 `json:"dns"`
+	Receive float64 `json:"receive"`
+	Send    float64 `json:"send"`
+	SSL     float64 `json:"ssl"`
+	Wait    float64 `json:"wait"`
+}
+
+// TLS contains information about the TLS handshake.
+type TLS struct {
+	CipherSuite        string   `json:"cipherSuite"`
+	ClientHelloID      string   `json:"clientHelloID,omitempty"`
+	NegotiatedProtocol string   `json:"negotiatedProtocol"`
+	PeerCertificates   []string `json:"peerCertificates,omitempty"`
+	ServerName         string   `json:"serverName"`
+	Version            string   `json:"version"`
+}
+
+// New creates a new HAR with no entries.
+func New() *HAR {
+	return &HAR{Log: Log{
+		Creator: Creator{Name: "ooniprobe-engine", Version: version.Version},
+		Entries: []Entry{},
+		Version: "1.2",
+	}}
+}
+
+// NewFromEvents creates a HAR from the events saved by the HTTP
+// savers of the httptransport package, which must have been configured
+// to save metadata, bodies, performance and transaction events. Like
+// archival.NewRequestList, we assume that transactions do not overlap,
+// which is the case, e.g., for a urlgetter measurement. We use the
+// resolve, connect and TLS handshake events occurring during a
+// transaction to compute its timings and to fill its TLS info.
+func NewFromEvents(events []trace.Event) *HAR {
+	har := New()
+	var tx *transaction
+	for _, ev := range events {
+		switch ev.Name {
+		case "http_transaction_start":
+			tx = &transaction{start: ev}
+		case "http_transaction_done":
+			if tx != nil {
+				tx.done = ev
+				har.Log.Entries = append(har.Log.Entries, tx.entry())
+				tx = nil
+			}
+		default:
+			if tx != nil {
+				tx.add(ev)
+			}
+		}
+	}
+	return har
+}
+
+// transaction collects the events of an HTTP transaction.
+type transaction struct {
+	connect          *trace.Event
+	done             trace.Event
+	firstByte        *trace.Event
+	handshake        *trace.Event
+	requestBody      *trace.Event
+	requestMetadata  *trace.Event
+	resolveDone      *trace.Event
+	resolveStart     *trace.Event
+	responseBody     *trace.Event
+	responseMetadata *trace.Event
+	start            trace.Event
+	wroteRequest     *trace.Event
+}
+
+func (tx *transaction) add(ev trace.Event) {
+	switch ev.Name {
+	case "resolve_start":
+		if tx.resolveStart == nil {
+			tx.resolveStart = &ev
+		}
+	case "resolve_done":
+		if tx.resolveDone == nil {
+			tx.resolveDone = &ev
+		}
+	case "connect":
+		tx.connect = &ev // the last one is the one we've used
+	case "tls_handshake_done", "quic_handshake_done":
+		tx.handshake = &ev
+	case "http_request_body_snapshot":
+		tx.requestBody = &ev
+	case "http_request_metadata":
+		tx.requestMetadata = &ev
+	case "http_wrote_request":
+		tx.wroteRequest = &ev
+	case "http_first_response_byte":
+		tx.firstByte = &ev
+	case "http_response_metadata":
+		tx.responseMetadata = &ev
+	case "http_response_body_snapshot":
+		tx.responseBody = &ev
+	}
+}
+
+func (tx *transaction) entry() Entry {
+	entry := Entry{
+		Failure:         archival.NewFailure(tx.done.Err),
+		StartedDateTime: formatTime(tx.start.Time),
+	}
+	httpVersion := tx.httpVersion()
+	if tx.requestMetadata != nil {
+		var body []byte
+		var truncated bool
+		if tx.requestBody != nil {
+			body, truncated = tx.requestBody.Data, tx.requestBody.DataIsTruncated
+		}
+		entry.Request = newRequest(
+			tx.requestMetadata.HTTPMethod, tx.requestMetadata.HTTPURL,
+			newHeaders(tx.requestMetadata.HTTPHeaders), string(body), truncated)
+	} else {
+		entry.Request = newRequest("", "", nil, "", false)
+	}
+	entry.Request.HTTPVersion = httpVersion
+	if tx.responseMetadata != nil {
+		var body []byte
+		var truncated bool
+		if tx.responseBody != nil {
+			body, truncated = tx.responseBody.Data, tx.responseBody.DataIsTruncated
+		}
+		entry.Response = newResponse(
+			int64(tx.responseMetadata.HTTPStatusCode),
+			newHeaders(tx.responseMetadata.HTTPHeaders), string(body), truncated)
+	} else {
+		entry.Response = newResponse(0, nil, "", false)
+	}
+	entry.Response.HTTPVersion = httpVersion
+	if tx.connect != nil {
+		entry.ServerIPAddress, _, _ = net.SplitHostPort(tx.connect.Address)
+	}
+	if tx.handshake != nil {
+		entry.TLS = &TLS{
+			CipherSuite:        tx.handshake.TLSCipherSuite,
+			ClientHelloID:      tx.handshake.TLSClientHelloID,
+			NegotiatedProtocol: tx.handshake.TLSNegotiatedProto,
+			ServerName:         tx.handshake.TLSServerName,
+			Version:            tx.handshake.TLSVersion,
+		}
+		for _, cert := range tx.handshake.TLSPeerCerts {
+			entry.TLS.PeerCertificates = append(entry.TLS.PeerCertificates,
+				base64.StdEncoding.EncodeToString(cert.Raw))
+		}
+	}
+	entry.Timings = tx.timings()
+	entry.Time = totalTime(entry.Timings)
+	return entry
+}
+
+// httpVersion returns the HTTP version, if we know it. When we reuse
+// a connection we don't see its handshake and we don't know the version.
+func (tx *transaction) httpVersion() string {
+	if tx.handshake != nil {
+		return httpVersionFromALPN(tx.handshake.TLSNegotiatedProto)
+	}
+	if tx.connect != nil {
+		return "HTTP/1.1" // cleartext HTTP
+	}
+	return ""
+}
+
+func (tx *transaction) timings() Timings {
+	timings := Timings{Blocked: -1, Connect: -1, DNS: -1, SSL: -1}
+	ready := tx.start.Time
+	if tx.requestMetadata != nil {
+		ready = tx.requestMetadata.Time
+	}
+	if tx.resolveStart != nil && tx.resolveDone != nil {
+		timings.DNS = milliseconds(tx.resolveDone.Time.Sub(tx.resolveStart.Time))
+		ready = latest(ready, tx.resolveDone.Time)
+	}
+	if tx.connect != nil {
+		timings.Connect = milliseconds(tx.connect.Duration)
+		ready = latest(ready, tx.connect.Time)
+	}
+	if tx.handshake != nil {
+		timings.SSL = milliseconds(tx.handshake.Duration)
+		if timings.Connect < 0 {
+			timings.Connect = 0 // e.g. QUIC does not have a connect event
+		}
+		timings.Connect += timings.SSL
+		ready = latest(ready, tx.handshake.Time)
+	}
+	if tx.wroteRequest != nil {
+		timings.Send = milliseconds(tx.wroteRequest.Time.Sub(ready))
+		if tx.firstByte != nil {
+			timings.Wait = milliseconds(tx.firstByte.Time.Sub(tx.wroteRequest.Time))
+		}
+	}
+	if tx.firstByte != nil {
+		end := tx.done.Time
+		if tx.responseBody != nil {
+			end = tx.responseBody.Time
+		}
+		timings.Receive = milliseconds(end.Sub(tx.firstByte.Time))
+	}
+	return timings
+}
+
+// ErrNoTestKeys indicates that a measurement does not contain test keys.
+var ErrNoTestKeys = errors.New("har: measurement without test keys")
+
+// NewFromMeasurement creates a HAR from the "requests" and the
+// "tls_handshakes" test keys of a measurement. See NewFromRequestList
+// for more information. A measurement that does not contain HTTP
+// requests yields a HAR without entries.
+func NewFromMeasurement(measurement *model.Measurement) (*HAR, error) {
+	if measurement.TestKeys == nil {
+		return nil, ErrNoTestKeys
+	}
+	data, err := json.Marshal(measurement.TestKeys)
+	if err != nil {
+		return nil, err
+	}
+	var tk struct {
+		Requests      []archival.RequestEntry `json:"requests"`
+		TLSHandshakes []archival.TLSHandshake `json:"tls_handshakes"`
+	}
+	if err := json.Unmarshal(data, &tk); err != nil {
+		return nil, err
+	}
+	begin := measurement.MeasurementStartTimeSaved
+	if begin.IsZero() {
+		begin, err = time.Parse(
+			"2006-01-02 15:04:05", measurement.MeasurementStartTime)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewFromRequestList(begin, tk.Requests, tk.TLSHandshakes), nil
+}
+
+// NewFromRequestList creates a HAR from archived requests, where begin
+// is the time relative to which the entries times are computed. Since
+// the archival format does not contain detailed timings, all the timings
+// of the entries are zero or -1. We attach to each entry the latest TLS
+// handshake preceding it whose server name matches the request URL host.
+func NewFromRequestList(
+	begin time.Time, requests []archival.RequestEntry,
+	handshakes []archival.TLSHandshake) *HAR {
+	har := New()
+	// The archival format wants the last request to appear first.
+	requests = append([]archival.RequestEntry(nil), requests...)
+	sort.SliceStable(requests, func(i, j int) bool {
+		return requests[i].T < requests[j].T
+	})
+	for _, req := range requests {
+		entry := Entry{
+			Failure: req.Failure,
+			StartedDateTime: formatTime(begin.Add(
+				time.Duration(req.T * float64(time.Second)))),
+			Timings: Timings{Blocked: -1, Connect: -1, DNS: -1, SSL: -1},
+		}
+		entry.Request = newRequest(
+			req.Request.Method, req.Request.URL,
+			newArchivalHeaders(req.Request.HeadersList, req.Request.Headers),
+			req.Request.Body.Value, req.Request.BodyIsTruncated)
+		entry.Response = newResponse(
+			req.Response.Code,
+			newArchivalHeaders(req.Response.HeadersList, req.Response.Headers),
+			req.Response.Body.Value, req.Response.BodyIsTruncated)
+		if handshake := findHandshake(handshakes, req); handshake != nil {
+			entry.TLS = &TLS{
+				CipherSuite:        handshake.CipherSuite,
+				ClientHelloID:      handshake.ClientHelloID,
+				NegotiatedProtocol: handshake.NegotiatedProtocol,
+				ServerName:         handshake.ServerName,
+				Version:            handshake.TLSVersion,
+			}
+			for _, cert := range handshake.PeerCertificates {
+				entry.TLS.PeerCertificates = append(entry.TLS.PeerCertificates,
+					base64.StdEncoding.EncodeToString([]byte(cert.Value)))
+			}
+			entry.Request.HTTPVersion = httpVersionFromALPN(handshake.NegotiatedProtocol)
+			entry.Response.HTTPVersion = entry.Request.HTTPVersion
+		}
+		har.Log.Entries = append(har.Log.Entries, entry)
+	}
+	return har
+}
+
+func findHandshake(
+	handshakes []archival.TLSHandshake, req archival.RequestEntry) *archival.TLSHandshake {
+	URL, err := url.Parse(req.Request.URL)
+	if err != nil || URL.Scheme != "https" {
+		return nil
+	}
+	var found *archival.TLSHandshake
+	for idx := range handshakes {
+		if handshakes[idx].ServerName == URL.Hostname() && handshakes[idx].T <= req.T {
+			if found == nil || handshakes[idx].T >= found.T {
+				found = &handshakes[idx]
+			}
+		}
+	}
+	return found
+}
+
+func newRequest(
+	method, URL string, headers []NameValue, body string, truncated bool) Request {
+	req := Request{
+		BodySize:    newBodySize(body, truncated),
+		Cookies:     []Cookie{},
+		Headers:     headers,
+		HeadersSize: -1,
+		Method:      method,
+		QueryString: []NameValue{},
+		URL:         URL,
+	}
+	if req.Headers == nil {
+		req.Headers = []NameValue{}
+	}
+	for _, cookie := range (&http.Request{Header: toHTTPHeader(headers)}).Cookies() {
+		req.Cookies = append(req.Cookies, Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	if parsed, err := url.Parse(URL); err == nil {
+		req.QueryString = sortedKeys(parsed.Query())
+	}
+	if body != "" {
+		req.PostData = &PostData{
+			MimeType: toHTTPHeader(headers).Get("Content-Type"),
+			Text:     body,
+		}
+		if !utf8.ValidString(body) {
+			req.PostData.Encoding = "base64"
+			req.PostData.Text = base64.StdEncoding.EncodeToString([]byte(body))
+		}
+	}
+	return req
+}
+
+func newResponse(code int64, headers []NameValue, body string, truncated bool) Response {
+	resp := Response{
+		BodySize: newBodySize(body, truncated),
+		Content: Content{
+			BodyIsTruncated: truncated,
+			MimeType:        toHTTPHeader(headers).Get("Content-Type"),
+			Size:            int64(len(body)),
+			Text:            body,
+		},
+		Cookies:     []Cookie{},
+		Headers:     headers,
+		HeadersSize: -1,
+		RedirectURL: toHTTPHeader(headers).Get("Location"),
+		Status:      code,
+		StatusText:  http.StatusText(int(code)),
+	}
+	if resp.Headers == nil {
+		resp.Headers = []NameValue{}
+	}
+	for _, cookie := range (&http.Response{Header: toHTTPHeader(headers)}).Cookies() {
+		resp.Cookies = append(resp.Cookies, Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	if !utf8.ValidString(body) {
+		resp.Content.Encoding = "base64"
+		resp.Content.Text = base64.StdEncoding.EncodeToString([]byte(body))
+	}
+	return resp
+}
+
+// newBodySize returns the body size, or -1 if we don't know it.
+func newBodySize(body string, truncated bool) int64 {
+	if truncated {
+		return -1
+	}
+	return int64(len(body))
+}
+
+func newHeaders(header http.Header) []NameValue {
+	return sortedKeys(map[string][]string(header))
+}
+
+func newArchivalHeaders(
+	list []archival.HTTPHeader, headers map[string]archival.MaybeBinaryValue) []NameValue {
+	out := []NameValue{}
+	if len(list) > 0 {
+		for _, entry := range list {
+			out = append(out, NameValue{Name: entry.Key, Value: entry.Value.Value})
+		}
+		return out
+	}
+	// Older measurements only contain the headers map
+	for key, value := range headers {
+		out = append(out, NameValue{Name: key, Value: value.Value})
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// sortedKeys flattens a multi-valued map sorting by key, so that
+// the output does not depend on the map iteration order.
+func sortedKeys(m map[string][]string) []NameValue {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	out := []NameValue{}
+	for _, key := range keys {
+		for _, value := range m[key] {
+			out = append(out, NameValue{Name: key, Value: value})
+		}
+	}
+	return out
+}
+
+func toHTTPHeader(headers []NameValue) http.Header {
+	header := make(http.Header)
+	for _, entry := range headers {
+		header.Add(entry.Name, entry.Value)
+	}
+	return header
+}
+
+func httpVersionFromALPN(alpn string) string {
+	switch alpn {
+	case "h2":
+		return "HTTP/2.0"
+	case "h3", "h3-29":
+		return "HTTP/3"
+	default:
+		return "HTTP/1.1"
+	}
+}
+
+func totalTime(timings Timings) float64 {
+	var total float64
+	// The SSL time is already included in the Connect time.
+	for _, value := range []float64{timings.Blocked, timings.DNS,
+		timings.Connect, timings.Send, timings.Wait, timings.Receive} {
+		if value > 0 {
+			total += value
+		}
+	}
+	return total
+}
+
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+func milliseconds(d time.Duration) float64 {
+	if d < 0 {
+		return 0
+	}
+	return float64(d) / float64(time.Millisecond)
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,370 @@
+package har_test
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/archival"
+	"github.com/ooni/probe-engine/netx/har"
+	"github.com/ooni/probe-engine/netx/httptransport"
+	"github.com/ooni/probe-engine/netx/modelx"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+func TestUnitNewFromEvents(t *testing.T) {
+	begin := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return begin.Add(time.Duration(ms) * time.Millisecond)
+	}
+	events := []trace.Event{{
+		Name: "http_transaction_start",
+		Time: at(0),
+	}, {
+		Data: []byte("a=b"),
+		Name: "http_request_body_snapshot",
+		Time: at(0),
+	}, {
+		HTTPHeaders: http.Header{
+			"Content-Type": {"application/x-www-form-urlencoded"},
+			"Cookie":       {"session=deadbeef"},
+		},
+		HTTPMethod: "POST",
+		HTTPURL:    "https://www.example.com/submit?x=1&y=2",
+		Name:       "http_request_metadata",
+		Time:       at(1),
+	}, {
+		Name: "resolve_start",
+		Time: at(1),
+	}, {
+		Name: "resolve_done",
+		Time: at(11),
+	}, {
+		Address:  "93.184.216.34:443",
+		Duration: 20 * time.Millisecond,
+		Name:     "connect",
+		Time:     at(31),
+	}, {
+		Name: "read", // not relevant for HAR
+		Time: at(40),
+	}, {
+		Duration:           30 * time.Millisecond,
+		Name:               "tls_handshake_done",
+		TLSCipherSuite:     "TLS_AES_128_GCM_SHA256",
+		TLSNegotiatedProto: "h2",
+		TLSPeerCerts:       []*x509.Certificate{{Raw: []byte("deadbeef")}},
+		TLSServerName:      "www.example.com",
+		TLSVersion:         "TLSv1.3",
+		Time:               at(61),
+	}, {
+		Name: "http_wrote_headers",
+		Time: at(62),
+	}, {
+		Name: "http_wrote_request",
+		Time: at(63),
+	}, {
+		Name: "http_first_response_byte",
+		Time: at(163),
+	}, {
+		HTTPHeaders: http.Header{
+			"Content-Type": {"text/html"},
+			"Location":     {"https://www.example.com/thanks"},
+			"Set-Cookie":   {"session=cafebabe"},
+		},
+		HTTPStatusCode: 302,
+		Name:           "http_response_metadata",
+		Time:           at(164),
+	}, {
+		Data:            []byte("\xff\xfe"),
+		DataIsTruncated: true,
+		Name:            "http_response_body_snapshot",
+		Time:            at(173),
+	}, {
+		Name: "http_transaction_done",
+		Time: at(174),
+	}, {
+		Name: "http_transaction_start",
+		Time: at(200),
+	}, {
+		HTTPMethod: "GET",
+		HTTPURL:    "https://www.example.com/thanks",
+		Name:       "http_request_metadata",
+		Time:       at(200),
+	}, {
+		Err:  &modelx.ErrWrapper{Failure: modelx.FailureConnectionReset},
+		Name: "http_transaction_done",
+		Time: at(300),
+	}}
+	archive := har.NewFromEvents(events)
+	if archive.Log.Version != "1.2" || len(archive.Log.Entries) != 2 {
+		t.Fatal("unexpected log")
+	}
+	entry := archive.Log.Entries[0]
+	if entry.StartedDateTime != "2020-05-01T12:00:00Z" {
+		t.Fatal("unexpected startedDateTime")
+	}
+	if entry.Failure != nil {
+		t.Fatal("unexpected failure")
+	}
+	if entry.ServerIPAddress != "93.184.216.34" {
+		t.Fatal("unexpected serverIPAddress")
+	}
+	expectedTimings := har.Timings{
+		Blocked: -1, DNS: 10, Connect: 50, SSL: 30, Send: 2, Wait: 100, Receive: 10}
+	if entry.Timings != expectedTimings {
+		t.Fatalf("unexpected timings: %+v", entry.Timings)
+	}
+	if entry.Time != 172 {
+		t.Fatal("unexpected time", entry.Time)
+	}
+	if entry.Request.Method != "POST" || entry.Request.HTTPVersion != "HTTP/2.0" {
+		t.Fatal("unexpected request")
+	}
+	if len(entry.Request.Headers) != 2 || entry.Request.Headers[0].Name != "Content-Type" {
+		t.Fatal("unexpected request headers")
+	}
+	if len(entry.Request.Cookies) != 1 || entry.Request.Cookies[0].Value != "deadbeef" {
+		t.Fatal("unexpected request cookies")
+	}
+	if len(entry.Request.QueryString) != 2 || entry.Request.QueryString[1].Name != "y" {
+		t.Fatal("unexpected query string")
+	}
+	if entry.Request.PostData == nil || entry.Request.PostData.Text != "a=b" {
+		t.Fatal("unexpected post data")
+	}
+	if entry.Request.PostData.MimeType != "application/x-www-form-urlencoded" {
+		t.Fatal("unexpected post data mime type")
+	}
+	if entry.Response.Status != 302 || entry.Response.StatusText != "Found" {
+		t.Fatal("unexpected response status")
+	}
+	if entry.Response.RedirectURL != "https://www.example.com/thanks" {
+		t.Fatal("unexpected redirectURL")
+	}
+	if len(entry.Response.Cookies) != 1 || entry.Response.Cookies[0].Value != "cafebabe" {
+		t.Fatal("unexpected response cookies")
+	}
+	content := entry.Response.Content
+	if content.Encoding != "base64" || content.Text != "//4=" || !content.BodyIsTruncated {
+		t.Fatal("unexpected response content")
+	}
+	if entry.Response.BodySize != -1 {
+		t.Fatal("the body size of a truncated body should be unknown")
+	}
+	if entry.TLS == nil || entry.TLS.Version != "TLSv1.3" || entry.TLS.ServerName != "www.example.com" {
+		t.Fatal("unexpected TLS")
+	}
+	if len(entry.TLS.PeerCertificates) != 1 || entry.TLS.PeerCertificates[0] != "ZGVhZGJlZWY=" {
+		t.Fatal("unexpected peer certificates")
+	}
+	entry = archive.Log.Entries[1]
+	if entry.Failure == nil || *entry.Failure != modelx.FailureConnectionReset {
+		t.Fatal("unexpected failure")
+	}
+	if entry.Timings.DNS != -1 || entry.Timings.Connect != -1 || entry.TLS != nil {
+		t.Fatal("a reused connection should not have DNS, connect and TLS")
+	}
+	if entry.Response.Status != 0 || entry.Response.HTTPVersion != "" {
+		t.Fatal("unexpected response")
+	}
+	// Make sure the arrays that HAR requires are not null
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "null") {
+		t.Fatal("unexpected null value in", string(data))
+	}
+}
+
+func TestIntegrationNewFromEventsWithHTTPTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("hello, world"))
+		}))
+	defer server.Close()
+	saver := new(trace.Saver)
+	txp := httptransport.New(httptransport.Config{
+		DialSaver: saver, HTTPSaver: saver, ResolveSaver: saver})
+	client := &http.Client{Transport: txp}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	archive := har.NewFromEvents(saver.Read())
+	if len(archive.Log.Entries) != 1 {
+		t.Fatal("unexpected number of entries")
+	}
+	entry := archive.Log.Entries[0]
+	if entry.Response.Content.Text != "hello, world" {
+		t.Fatal("unexpected body")
+	}
+	if entry.Response.Content.MimeType != "text/plain" {
+		t.Fatal("unexpected mime type")
+	}
+	if entry.Timings.Connect < 0 || entry.Timings.SSL != -1 {
+		t.Fatal("unexpected timings")
+	}
+	if entry.Request.HTTPVersion != "HTTP/1.1" {
+		t.Fatal("unexpected HTTP version")
+	}
+}
+
+func TestUnitNewFromRequestList(t *testing.T) {
+	begin := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	failure := modelx.FailureConnectionReset
+	requests := []archival.RequestEntry{{
+		// The archival format wants the last request to appear first
+		Failure: &failure,
+		Request: archival.HTTPRequest{
+			Method: "GET",
+			URL:    "https://www.example.com/second",
+		},
+		T: 2.5,
+	}, {
+		Request: archival.HTTPRequest{
+			Headers: map[string]archival.MaybeBinaryValue{
+				"User-Agent": {Value: "miniooni/0.1.0"},
+				"Accept":     {Value: "*/*"},
+			},
+			Method: "GET",
+			URL:    "https://www.example.com/",
+		},
+		Response: archival.HTTPResponse{
+			Body: archival.HTTPBody{Value: "<html></html>"},
+			Code: 200,
+			HeadersList: []archival.HTTPHeader{{
+				Key:   "Content-Type",
+				Value: archival.MaybeBinaryValue{Value: "text/html"},
+			}},
+		},
+		T: 1.25,
+	}}
+	handshakes := []archival.TLSHandshake{{
+		NegotiatedProtocol: "h2",
+		ServerName:         "www.example.com",
+		T:                  1,
+		TLSVersion:         "TLSv1.2",
+	}, {
+		NegotiatedProtocol: "http/1.1",
+		ServerName:         "www.example.org",
+		T:                  1.1,
+		TLSVersion:         "TLSv1.3",
+	}}
+	archive := har.NewFromRequestList(begin, requests, handshakes)
+	if len(archive.Log.Entries) != 2 {
+		t.Fatal("unexpected number of entries")
+	}
+	entry := archive.Log.Entries[0]
+	if entry.StartedDateTime != "2020-05-01T12:00:01.25Z" {
+		t.Fatal("unexpected startedDateTime", entry.StartedDateTime)
+	}
+	if entry.Request.Headers[0].Name != "Accept" || entry.Request.Headers[1].Name != "User-Agent" {
+		t.Fatal("unexpected request headers")
+	}
+	if entry.Response.Status != 200 || entry.Response.Content.Text != "<html></html>" {
+		t.Fatal("unexpected response")
+	}
+	if entry.Response.Content.MimeType != "text/html" {
+		t.Fatal("unexpected mime type")
+	}
+	if entry.TLS == nil || entry.TLS.Version != "TLSv1.2" {
+		t.Fatal("unexpected TLS")
+	}
+	if entry.Response.HTTPVersion != "HTTP/2.0" {
+		t.Fatal("unexpected HTTP version")
+	}
+	if entry.Timings.Connect != -1 || entry.Timings.Send != 0 {
+		t.Fatal("unexpected timings")
+	}
+	entry = archive.Log.Entries[1]
+	if entry.Failure == nil || *entry.Failure != failure {
+		t.Fatal("unexpected failure")
+	}
+	if entry.Request.URL != "https://www.example.com/second" {
+		t.Fatal("unexpected URL")
+	}
+}
+
+func TestUnitNewFromMeasurement(t *testing.T) {
+	var tk struct {
+		Requests []archival.RequestEntry `json:"requests"`
+	}
+	tk.Requests = append(tk.Requests, archival.RequestEntry{
+		Request: archival.HTTPRequest{
+			Method: "GET",
+			URL:    (&url.URL{Scheme: "http", Host: "example.com", Path: "/"}).String(),
+		},
+		T: 1,
+	})
+	measurement := &model.Measurement{
+		MeasurementStartTime: "2020-05-01 12:00:00",
+		TestKeys:             tk,
+	}
+	archive, err := har.NewFromMeasurement(measurement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.Log.Entries) != 1 {
+		t.Fatal("unexpected number of entries")
+	}
+	if archive.Log.Entries[0].StartedDateTime != "2020-05-01T12:00:01Z" {
+		t.Fatal("unexpected startedDateTime")
+	}
+	if archive.Log.Entries[0].Request.URL != "http://example.com/" {
+		t.Fatal("unexpected URL")
+	}
+}
+
+func TestUnitNewFromMeasurementWithoutRequests(t *testing.T) {
+	measurement := &model.Measurement{
+		MeasurementStartTime: "2020-05-01 12:00:00",
+		TestKeys:             map[string]interface{}{"failure": nil},
+	}
+	archive, err := har.NewFromMeasurement(measurement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archive.Log.Entries == nil || len(archive.Log.Entries) != 0 {
+		t.Fatal("expected an empty list of entries")
+	}
+}
+
+func TestUnitNewFromMeasurementErrors(t *testing.T) {
+	t.Run("without test keys", func(t *testing.T) {
+		_, err := har.NewFromMeasurement(&model.Measurement{})
+		if !errors.Is(err, har.ErrNoTestKeys) {
+			t.Fatal("not the error we expected")
+		}
+	})
+	t.Run("with invalid test keys", func(t *testing.T) {
+		_, err := har.NewFromMeasurement(&model.Measurement{
+			TestKeys: map[string]interface{}{"requests": 17},
+		})
+		if err == nil {
+			t.Fatal("expected an error here")
+		}
+	})
+	t.Run("with invalid measurement start time", func(t *testing.T) {
+		_, err := har.NewFromMeasurement(&model.Measurement{
+			MeasurementStartTime: "antani",
+			TestKeys:             map[string]interface{}{},
+		})
+		if err == nil {
+			t.Fatal("expected an error here")
+		}
+	})
+}
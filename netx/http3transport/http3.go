@@ -0,0 +1,30 @@
+package http3transport
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/ooni/probe-engine/netx/httptransport"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// HTTP3Transport is an HTTP/3 transport using the QUIC dialer.
+type HTTP3Transport struct {
+	http3.Transport
+}
+
+// NewHTTP3Transport creates a new HTTP/3 transport using the given QUIC
+// dialer. The http3 package overrides the NextProtos of the TLS config.
+func NewHTTP3Transport(dialer QUICDialer, tlsConfig *tls.Config) *HTTP3Transport {
+	return &HTTP3Transport{http3.Transport{
+		Dial: func(ctx context.Context, address string, tlsConfig *tls.Config,
+			quicConfig *quic.Config) (*quic.Conn, error) {
+			return dialer.DialContext(ctx, "udp", address, tlsConfig, quicConfig)
+		},
+		QUICConfig:      &quic.Config{},
+		TLSClientConfig: tlsConfig,
+	}}
+}
+
+var _ httptransport.RoundTripper = &HTTP3Transport{}
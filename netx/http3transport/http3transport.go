@@ -0,0 +1,49 @@
+// Package http3transport contains the HTTP/3 transport. We keep it
+// separate from netx/httptransport such that only the code actually
+// speaking QUIC links the QUIC library.
+package http3transport
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/ooni/probe-engine/netx/httptransport"
+	"github.com/ooni/probe-engine/netx/quicdialer"
+	"github.com/quic-go/quic-go"
+)
+
+// QUICDialer is the definition of a QUIC dialer assumed by this package.
+type QUICDialer interface {
+	DialContext(ctx context.Context, network, address string,
+		tlsConfig *tls.Config, quicConfig *quic.Config) (*quic.Conn, error)
+}
+
+// NewQUICDialer creates a new QUICDialer from the specified config. The
+// handshake events are saved using the TLSSaver, if configured. Since we
+// cannot use QUIC with a proxy, the returned dialer always fails with
+// quicdialer.ErrProxyNotSupported when the ProxyURL is configured.
+func NewQUICDialer(config httptransport.Config) QUICDialer {
+	if config.FullResolver == nil {
+		config.FullResolver = httptransport.NewResolver(config)
+	}
+	var d quicdialer.ContextDialer = quicdialer.SystemDialer{}
+	d = quicdialer.ErrorWrapperDialer{Dialer: d}
+	if config.TLSSaver != nil {
+		d = quicdialer.HandshakeSaver{Dialer: d, Saver: config.TLSSaver}
+	}
+	d = quicdialer.DNSDialer{Dialer: d, Resolver: config.FullResolver}
+	d = quicdialer.ProxyDialer{Dialer: d, ProxyURL: config.ProxyURL}
+	return d
+}
+
+// New creates a new HTTP/3 RoundTripper from the specified config. The
+// returned RoundTripper is wrapped like the one returned by httptransport.New.
+func New(config httptransport.Config) httptransport.RoundTripper {
+	tlsConfig := new(tls.Config)
+	if config.TLSConfig != nil {
+		tlsConfig = config.TLSConfig.Clone()
+	}
+	tlsConfig.InsecureSkipVerify = config.NoTLSVerify
+	txp := NewHTTP3Transport(NewQUICDialer(config), tlsConfig)
+	return httptransport.Wrap(config, txp)
+}
@@ -0,0 +1,140 @@
+package http3transport_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/netx/http3transport"
+	"github.com/ooni/probe-engine/netx/httptransport"
+	"github.com/ooni/probe-engine/netx/quicdialer"
+	"github.com/ooni/probe-engine/netx/resolver"
+	"github.com/ooni/probe-engine/netx/trace"
+	"github.com/quic-go/quic-go"
+)
+
+func TestNewQUICDialerVanilla(t *testing.T) {
+	d := http3transport.NewQUICDialer(httptransport.Config{})
+	pd, ok := d.(quicdialer.ProxyDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if pd.ProxyURL != nil {
+		t.Fatal("not the ProxyURL we expected")
+	}
+	dnsd, ok := pd.Dialer.(quicdialer.DNSDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if _, ok := dnsd.Resolver.(resolver.AddressResolver); !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	ewd, ok := dnsd.Dialer.(quicdialer.ErrorWrapperDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if _, ok := ewd.Dialer.(quicdialer.SystemDialer); !ok {
+		t.Fatal("not the dialer we expected")
+	}
+}
+
+func TestNewQUICDialerWithSaver(t *testing.T) {
+	saver := new(trace.Saver)
+	d := http3transport.NewQUICDialer(httptransport.Config{TLSSaver: saver})
+	pd, ok := d.(quicdialer.ProxyDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	dnsd, ok := pd.Dialer.(quicdialer.DNSDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	hsd, ok := dnsd.Dialer.(quicdialer.HandshakeSaver)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if hsd.Saver != saver {
+		t.Fatal("not the saver we expected")
+	}
+	if _, ok := hsd.Dialer.(quicdialer.ErrorWrapperDialer); !ok {
+		t.Fatal("not the dialer we expected")
+	}
+}
+
+func TestNewQUICDialerWithProxyURL(t *testing.T) {
+	URL, _ := url.Parse("socks5://127.0.0.1:9050")
+	d := http3transport.NewQUICDialer(httptransport.Config{ProxyURL: URL})
+	sess, err := d.DialContext(context.Background(), "udp", "dns.google:443",
+		&tls.Config{}, &quic.Config{})
+	if !errors.Is(err, quicdialer.ErrProxyNotSupported) {
+		t.Fatal("not the error we expected")
+	}
+	if sess != nil {
+		t.Fatal("expected nil session here")
+	}
+}
+
+func TestNewWithProxyURL(t *testing.T) {
+	URL, _ := url.Parse("socks5://127.0.0.1:9050")
+	txp := http3transport.New(httptransport.Config{ProxyURL: URL})
+	client := &http.Client{Transport: txp}
+	resp, err := client.Get("https://dns.google/")
+	if !errors.Is(err, quicdialer.ErrProxyNotSupported) {
+		t.Fatal("not the error we expected")
+	}
+	if resp != nil {
+		t.Fatal("not the response we expected")
+	}
+}
+
+func TestNewVanilla(t *testing.T) {
+	txp := http3transport.New(httptransport.Config{})
+	uatxp, ok := txp.(httptransport.UserAgentTransport)
+	if !ok {
+		t.Fatal("not the transport we expected")
+	}
+	h3txp, ok := uatxp.RoundTripper.(*http3transport.HTTP3Transport)
+	if !ok {
+		t.Fatal("not the transport we expected")
+	}
+	if h3txp.TLSClientConfig == nil || h3txp.TLSClientConfig.InsecureSkipVerify {
+		t.Fatal("not the TLS config we expected")
+	}
+}
+
+func TestNewWithConfig(t *testing.T) {
+	tlsConfig := &tls.Config{ServerName: "dns.google"}
+	txp := http3transport.New(httptransport.Config{
+		Logger:      log.Log,
+		NoTLSVerify: true,
+		TLSConfig:   tlsConfig,
+	})
+	uatxp, ok := txp.(httptransport.UserAgentTransport)
+	if !ok {
+		t.Fatal("not the transport we expected")
+	}
+	ltxp, ok := uatxp.RoundTripper.(httptransport.LoggingTransport)
+	if !ok {
+		t.Fatal("not the transport we expected")
+	}
+	h3txp, ok := ltxp.RoundTripper.(*http3transport.HTTP3Transport)
+	if !ok {
+		t.Fatal("not the transport we expected")
+	}
+	if h3txp.TLSClientConfig == tlsConfig {
+		t.Fatal("we did not clone the TLS config")
+	}
+	if h3txp.TLSClientConfig.ServerName != "dns.google" {
+		t.Fatal("not the ServerName we expected")
+	}
+	if !h3txp.TLSClientConfig.InsecureSkipVerify {
+		t.Fatal("not the InsecureSkipVerify we expected")
+	}
+	if tlsConfig.InsecureSkipVerify {
+		t.Fatal("we modified the original config")
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/ooni/probe-engine/netx/dialer"
 	"github.com/ooni/probe-engine/netx/resolver"
 	"github.com/ooni/probe-engine/netx/trace"
+	utls "github.com/refraction-networking/utls"
 )
 
 // Dialer is the definition of dialer assumed by this package.
@@ -40,25 +41,35 @@ type Resolver interface {
 
 // Config contains configuration for creating a new transport. When any
 // field of Config is nil/empty, we will use a suitable default.
+//
+// EDNS0 and ParallelResolver only apply when BaseResolver is a SerialResolver.
+// We leave any other BaseResolver, including the default system resolver,
+// unchanged, because we cannot control how it sends the queries.
 type Config struct {
-	BaseResolver        Resolver             // default: system resolver
-	BogonIsError        bool                 // default: bogon is not error
-	ByteCounter         *bytecounter.Counter // default: no explicit byte counting
-	CacheResolutions    bool                 // default: no caching
-	ContextByteCounting bool                 // default: no implicit byte counting
-	DNSCache            map[string][]string  // default: cache is empty
-	DialSaver           *trace.Saver         // default: not saving dials
-	Dialer              Dialer               // default: dialer.DNSDialer
-	FullResolver        Resolver             // default: base resolver + goodies
-	HTTPSaver           *trace.Saver         // default: not saving HTTP
-	Logger              Logger               // default: no logging
-	NoTLSVerify         bool                 // default: perform TLS verify
-	ProxyURL            *url.URL             // default: no proxy
-	ReadWriteSaver      *trace.Saver         // default: not saving read/write
-	ResolveSaver        *trace.Saver         // default: not saving resolves
-	TLSConfig           *tls.Config          // default: attempt using h2
-	TLSDialer           TLSDialer            // default: dialer.TLSDialer
-	TLSSaver            *trace.Saver         // defaukt: not saving TLS
+	BaseDialer          Dialer                // default: net.Dialer
+	BaseResolver        Resolver              // default: system resolver
+	BogonIsError        bool                  // default: bogon is not error
+	ByteCounter         *bytecounter.Counter  // default: no explicit byte counting
+	CacheResolutions    bool                  // default: no caching
+	ClientHelloSplit    *dialer.SplitStrategy // default: do not split
+	ContextByteCounting bool                  // default: no implicit byte counting
+	DNSCache            map[string][]string   // default: cache is empty
+	DialSaver           *trace.Saver          // default: not saving dials
+	Dialer              Dialer                // default: dialer.DNSDialer
+	EDNS0               *resolver.EDNS0Config // default: EDNS0 only for padding
+	FullResolver        Resolver              // default: base resolver + goodies
+	HTTPSaver           *trace.Saver          // default: not saving HTTP
+	Logger              Logger                // default: no logging
+	NoReadWritePayloads bool                  // default: saving payloads
+	NoTLSVerify         bool                  // default: perform TLS verify
+	ParallelResolver    bool                  // default: A then AAAA queries
+	ProxyURL            *url.URL              // default: no proxy
+	ReadWriteSaver      *trace.Saver          // default: not saving read/write
+	ResolveSaver        *trace.Saver          // default: not saving resolves
+	TLSClientHelloID    *utls.ClientHelloID   // default: Go's ClientHello
+	TLSConfig           *tls.Config           // default: attempt using h2
+	TLSDialer           TLSDialer             // default: dialer.TLSDialer
+	TLSSaver            *trace.Saver          // defaukt: not saving TLS
 }
 
 type tlsHandshaker interface {
@@ -71,6 +82,12 @@ func NewResolver(config Config) Resolver {
 	if config.BaseResolver == nil {
 		config.BaseResolver = resolver.SystemResolver{}
 	}
+	if config.EDNS0 != nil {
+		config.BaseResolver = newEDNS0Resolver(config.BaseResolver, config.EDNS0)
+	}
+	if config.ParallelResolver {
+		config.BaseResolver = newParallelResolver(config.BaseResolver)
+	}
 	var r Resolver = config.BaseResolver
 	if config.CacheResolutions {
 		r = &resolver.CacheResolver{Resolver: r}
@@ -96,13 +113,47 @@ func NewResolver(config Config) Resolver {
 	return r
 }
 
+// newEDNS0Resolver returns a copy of the base SerialResolver whose
+// encoder uses the given EDNS0 configuration. Any other resolver is
+// returned unchanged (see newParallelResolver).
+func newEDNS0Resolver(base Resolver, edns0 *resolver.EDNS0Config) Resolver {
+	if sr, ok := base.(resolver.SerialResolver); ok {
+		sr.Encoder = resolver.MiekgEncoder{EDNS0: edns0}
+		return sr
+	}
+	return base
+}
+
+// newParallelResolver converts a SerialResolver into a ParallelResolver
+// preserving its encoder, decoder, fallback transport and timeouts
+// counter. Any other resolver (e.g. the system resolver, which does
+// not allow us to choose how to send queries) is returned unchanged.
+func newParallelResolver(base Resolver) Resolver {
+	if sr, ok := base.(resolver.SerialResolver); ok {
+		return resolver.ParallelResolver{
+			Encoder:     sr.Encoder,
+			Decoder:     sr.Decoder,
+			FallbackTxp: sr.FallbackTxp,
+			NumTimeouts: sr.NumTimeouts,
+			Txp:         sr.Txp,
+		}
+	}
+	return base
+}
+
 // NewDialer creates a new Dialer from the specified config
 func NewDialer(config Config) Dialer {
 	if config.FullResolver == nil {
 		config.FullResolver = NewResolver(config)
 	}
-	var d Dialer = new(net.Dialer)
+	if config.BaseDialer == nil {
+		config.BaseDialer = new(net.Dialer)
+	}
+	var d Dialer = config.BaseDialer
 	d = dialer.TimeoutDialer{Dialer: d}
+	if config.ClientHelloSplit != nil {
+		d = dialer.SplitterDialer{Dialer: d, Strategy: *config.ClientHelloSplit}
+	}
 	d = dialer.ErrorWrapperDialer{Dialer: d}
 	if config.Logger != nil {
 		d = dialer.LoggingDialer{Dialer: d, Logger: config.Logger}
@@ -111,10 +162,15 @@ func NewDialer(config Config) Dialer {
 		d = dialer.SaverDialer{Dialer: d, Saver: config.DialSaver}
 	}
 	if config.ReadWriteSaver != nil {
-		d = dialer.SaverConnDialer{Dialer: d, Saver: config.ReadWriteSaver}
+		d = dialer.SaverConnDialer{
+			Dialer:       d,
+			DropPayloads: config.NoReadWritePayloads,
+			Saver:        config.ReadWriteSaver,
+		}
 	}
 	d = dialer.DNSDialer{Resolver: config.FullResolver, Dialer: d}
-	d = dialer.ProxyDialer{ProxyURL: config.ProxyURL, Dialer: d}
+	d = dialer.ProxyDialer{
+		Dialer: d, ProxyURL: config.ProxyURL, Saver: config.DialSaver}
 	if config.ContextByteCounting {
 		d = dialer.ByteCounterDialer{Dialer: d}
 	}
@@ -127,16 +183,27 @@ func NewTLSDialer(config Config) TLSDialer {
 		config.Dialer = NewDialer(config)
 	}
 	var h tlsHandshaker = dialer.SystemTLSHandshaker{}
+	if config.TLSClientHelloID != nil {
+		h = dialer.UTLSHandshaker{ClientHelloID: config.TLSClientHelloID}
+	}
 	h = dialer.TimeoutTLSHandshaker{TLSHandshaker: h}
 	h = dialer.ErrorWrapperTLSHandshaker{TLSHandshaker: h}
 	if config.Logger != nil {
 		h = dialer.LoggingTLSHandshaker{Logger: config.Logger, TLSHandshaker: h}
 	}
 	if config.TLSSaver != nil {
-		h = dialer.SaverTLSHandshaker{TLSHandshaker: h, Saver: config.TLSSaver}
+		h = dialer.SaverTLSHandshaker{
+			ClientHelloID: config.TLSClientHelloID,
+			Saver:         config.TLSSaver,
+			TLSHandshaker: h,
+		}
 	}
 	if config.TLSConfig == nil {
 		config.TLSConfig = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+		if config.TLSClientHelloID != nil {
+			// net/http only speaks HTTP/2 over *tls.Conn
+			config.TLSConfig.NextProtos = []string{"http/1.1"}
+		}
 	}
 	config.TLSConfig.InsecureSkipVerify = config.NoTLSVerify
 	return dialer.TLSDialer{
@@ -155,8 +222,14 @@ func New(config Config) RoundTripper {
 	if config.TLSDialer == nil {
 		config.TLSDialer = NewTLSDialer(config)
 	}
-	var txp RoundTripper
-	txp = NewSystemTransport(config.Dialer, config.TLSDialer)
+	return Wrap(config, NewSystemTransport(config.Dialer, config.TLSDialer))
+}
+
+// Wrap wraps the txp RoundTripper, which actually performs the round
+// trips, with the RoundTrippers that count bytes, log and save events
+// as specified by the config. We use Wrap for transports other than the
+// system transport (e.g. netx/http3transport).
+func Wrap(config Config, txp RoundTripper) RoundTripper {
 	if config.ByteCounter != nil {
 		txp = ByteCountingTransport{Counter: config.ByteCounter, RoundTripper: txp}
 	}
@@ -5,14 +5,17 @@ import (
 	"errors"
 	"net"
 	"net/http"
+	"reflect"
 	"testing"
 
 	"github.com/apex/log"
 	"github.com/ooni/probe-engine/netx/bytecounter"
 	"github.com/ooni/probe-engine/netx/dialer"
 	"github.com/ooni/probe-engine/netx/httptransport"
+	"github.com/ooni/probe-engine/netx/netsim"
 	"github.com/ooni/probe-engine/netx/resolver"
 	"github.com/ooni/probe-engine/netx/trace"
+	utls "github.com/refraction-networking/utls"
 )
 
 func TestNewResolverVanilla(t *testing.T) {
@@ -51,6 +54,87 @@ func TestNewResolverSpecificResolver(t *testing.T) {
 	}
 }
 
+func TestNewResolverWithParallelResolver(t *testing.T) {
+	txp := resolver.NewDNSOverUDP(new(net.Dialer), "8.8.8.8:53")
+	sr := resolver.NewSerialResolver(txp)
+	sr.Encoder = resolver.MiekgEncoder{EDNS0: &resolver.EDNS0Config{DNSSECOK: true}}
+	r := httptransport.NewResolver(httptransport.Config{
+		BaseResolver:     sr,
+		ParallelResolver: true,
+	})
+	ar, ok := r.(resolver.AddressResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	ewr, ok := ar.Resolver.(resolver.ErrorWrapperResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	pr, ok := ewr.Resolver.(resolver.ParallelResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	if pr.Transport() != txp {
+		t.Fatal("not the transport we expected")
+	}
+	if !reflect.DeepEqual(pr.Encoder, sr.Encoder) {
+		t.Fatal("not the encoder we expected")
+	}
+	if pr.FallbackTxp == nil || pr.FallbackTxp.Network() != "tcp" {
+		t.Fatal("not the fallback transport we expected")
+	}
+	if pr.NumTimeouts != sr.NumTimeouts {
+		t.Fatal("not the timeouts counter we expected")
+	}
+}
+
+func TestNewResolverWithEDNS0(t *testing.T) {
+	txp := resolver.NewDNSOverUDP(new(net.Dialer), "8.8.8.8:53")
+	edns0 := &resolver.EDNS0Config{BufferSize: 1232, DNSSECOK: true}
+	r := httptransport.NewResolver(httptransport.Config{
+		BaseResolver:     resolver.NewSerialResolver(txp),
+		EDNS0:            edns0,
+		ParallelResolver: true,
+	})
+	ar, ok := r.(resolver.AddressResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	ewr, ok := ar.Resolver.(resolver.ErrorWrapperResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	pr, ok := ewr.Resolver.(resolver.ParallelResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	encoder, ok := pr.Encoder.(resolver.MiekgEncoder)
+	if !ok {
+		t.Fatal("not the encoder we expected")
+	}
+	if encoder.EDNS0 != edns0 {
+		t.Fatal("not the EDNS0 config we expected")
+	}
+}
+
+func TestNewResolverWithParallelResolverAndSystemResolver(t *testing.T) {
+	r := httptransport.NewResolver(httptransport.Config{
+		ParallelResolver: true,
+	})
+	ar, ok := r.(resolver.AddressResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	ewr, ok := ar.Resolver.(resolver.ErrorWrapperResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+	_, ok = ewr.Resolver.(resolver.SystemResolver)
+	if !ok {
+		t.Fatal("not the resolver we expected")
+	}
+}
+
 func TestNewResolverWithBogonFilter(t *testing.T) {
 	r := httptransport.NewResolver(httptransport.Config{
 		BogonIsError: true,
@@ -211,6 +295,65 @@ func TestNewDialerVanilla(t *testing.T) {
 	}
 }
 
+func TestNewDialerWithBaseDialer(t *testing.T) {
+	network := new(netsim.Network)
+	d := httptransport.NewDialer(httptransport.Config{
+		BaseDialer: network.Dialer(),
+	})
+	pd, ok := d.(dialer.ProxyDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	dnsd, ok := pd.Dialer.(dialer.DNSDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	ewd, ok := dnsd.Dialer.(dialer.ErrorWrapperDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	td, ok := ewd.Dialer.(dialer.TimeoutDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if _, ok := td.Dialer.(netsim.Dialer); !ok {
+		t.Fatal("not the dialer we expected")
+	}
+}
+
+func TestNewDialerWithClientHelloSplit(t *testing.T) {
+	strategy := &dialer.SplitStrategy{Chunks: []int{1, 20}}
+	d := httptransport.NewDialer(httptransport.Config{
+		ClientHelloSplit: strategy,
+	})
+	pd, ok := d.(dialer.ProxyDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	dnsd, ok := pd.Dialer.(dialer.DNSDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	ewd, ok := dnsd.Dialer.(dialer.ErrorWrapperDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	sd, ok := ewd.Dialer.(dialer.SplitterDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if !reflect.DeepEqual(sd.Strategy, *strategy) {
+		t.Fatal("not the strategy we expected")
+	}
+	td, ok := sd.Dialer.(dialer.TimeoutDialer)
+	if !ok {
+		t.Fatal("not the dialer we expected")
+	}
+	if _, ok := td.Dialer.(*net.Dialer); !ok {
+		t.Fatal("not the dialer we expected")
+	}
+}
+
 func TestNewDialerWithResolver(t *testing.T) {
 	d := httptransport.NewDialer(httptransport.Config{
 		FullResolver: resolver.BogonResolver{
@@ -300,6 +443,9 @@ func TestNewDialerWithDialSaver(t *testing.T) {
 	if pd.ProxyURL != nil {
 		t.Fatal("not the proxy URL we expected")
 	}
+	if pd.Saver != saver {
+		t.Fatal("not the saver we expected")
+	}
 	dnsd, ok := pd.Dialer.(dialer.DNSDialer)
 	if !ok {
 		t.Fatal("not the dialer we expected")
@@ -333,7 +479,8 @@ func TestNewDialerWithDialSaver(t *testing.T) {
 func TestNewDialerWithReadWriteSaver(t *testing.T) {
 	saver := new(trace.Saver)
 	d := httptransport.NewDialer(httptransport.Config{
-		ReadWriteSaver: saver,
+		NoReadWritePayloads: true,
+		ReadWriteSaver:      saver,
 	})
 	pd, ok := d.(dialer.ProxyDialer)
 	if !ok {
@@ -359,6 +506,9 @@ func TestNewDialerWithReadWriteSaver(t *testing.T) {
 	if scd.Saver != saver {
 		t.Fatal("not the logger we expected")
 	}
+	if scd.DropPayloads != true {
+		t.Fatal("not the DropPayloads we expected")
+	}
 	ewd, ok := scd.Dialer.(dialer.ErrorWrapperDialer)
 	if !ok {
 		t.Fatal("not the dialer we expected")
@@ -477,6 +627,34 @@ func TestNewTLSDialerWithConfig(t *testing.T) {
 	}
 }
 
+func TestNewTLSDialerWithClientHelloID(t *testing.T) {
+	td := httptransport.NewTLSDialer(httptransport.Config{
+		TLSClientHelloID: &utls.HelloFirefox_Auto,
+	})
+	rtd, ok := td.(dialer.TLSDialer)
+	if !ok {
+		t.Fatal("not the TLSDialer we expected")
+	}
+	if len(rtd.Config.NextProtos) != 1 || rtd.Config.NextProtos[0] != "http/1.1" {
+		t.Fatal("invalid Config.NextProtos")
+	}
+	ewth, ok := rtd.TLSHandshaker.(dialer.ErrorWrapperTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	tth, ok := ewth.TLSHandshaker.(dialer.TimeoutTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	uth, ok := tth.TLSHandshaker.(dialer.UTLSHandshaker)
+	if !ok {
+		t.Fatal("not the TLSHandshaker we expected")
+	}
+	if uth.ClientHelloID != &utls.HelloFirefox_Auto {
+		t.Fatal("not the ClientHelloID we expected")
+	}
+}
+
 func TestNewTLSDialerWithLogging(t *testing.T) {
 	td := httptransport.NewTLSDialer(httptransport.Config{
 		Logger: log.Log,
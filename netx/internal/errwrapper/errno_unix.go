@@ -0,0 +1,27 @@
+// +build !windows
+
+package errwrapper
+
+import (
+	"syscall"
+
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+// The system errors we map to OONI failures. See errno_windows.go
+// for the corresponding values on Windows.
+const (
+	errnoConnectionAborted  = syscall.ECONNABORTED
+	errnoConnectionRefused  = syscall.ECONNREFUSED
+	errnoConnectionReset    = syscall.ECONNRESET
+	errnoHostUnreachable    = syscall.EHOSTUNREACH
+	errnoNetworkDown        = syscall.ENETDOWN
+	errnoNetworkUnreachable = syscall.ENETUNREACH
+	errnoTimedOut           = syscall.ETIMEDOUT
+)
+
+// platformSyscallFailures contains the system errors that only make
+// sense on Unix. On Windows, there is no socket error like EPIPE.
+var platformSyscallFailures = []syscallFailure{
+	{syscall.EPIPE, modelx.FailureBrokenPipe},
+}
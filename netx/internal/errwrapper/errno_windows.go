@@ -0,0 +1,21 @@
+package errwrapper
+
+import "syscall"
+
+// The system errors we map to OONI failures. On Windows, sockets
+// return the WSA error codes rather than the values that the syscall
+// package defines for compatibility with Unix. See:
+// https://docs.microsoft.com/en-us/windows/win32/winsock/windows-sockets-error-codes-2
+const (
+	errnoConnectionAborted  = syscall.Errno(10053) // WSAECONNABORTED
+	errnoConnectionRefused  = syscall.Errno(10061) // WSAECONNREFUSED
+	errnoConnectionReset    = syscall.Errno(10054) // WSAECONNRESET
+	errnoHostUnreachable    = syscall.Errno(10065) // WSAEHOSTUNREACH
+	errnoNetworkDown        = syscall.Errno(10050) // WSAENETDOWN
+	errnoNetworkUnreachable = syscall.Errno(10051) // WSAENETUNREACH
+	errnoTimedOut           = syscall.Errno(10060) // WSAETIMEDOUT
+)
+
+// There is no Windows sockets error corresponding to EPIPE: writing into
+// a socket closed by the peer fails with WSAECONNRESET or WSAECONNABORTED.
+var platformSyscallFailures []syscallFailure
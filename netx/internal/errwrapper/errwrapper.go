@@ -2,12 +2,18 @@
 package errwrapper
 
 import (
+	"context"
+	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"strings"
+	"syscall"
 
 	"github.com/ooni/probe-engine/netx/modelx"
+	utls "github.com/refraction-networking/utls"
 )
 
 // SafeErrWrapperBuilder contains a builder for modelx.ErrWrapper that
@@ -75,6 +81,28 @@ func toFailureString(err error) string {
 		return modelx.FailureSSLInvalidCertificate
 	}
 
+	if failure := toTLSFailureString(err); failure != "" {
+		return failure
+	}
+	for _, entry := range syscallFailures {
+		if errors.Is(err, entry.errno) {
+			return entry.failure
+		}
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return modelx.FailureEOFError
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return modelx.FailureGenericTimeoutError
+	}
+	var netError net.Error
+	if errors.As(err, &netError) && netError.Timeout() {
+		return modelx.FailureGenericTimeoutError
+	}
+
+	// We also match the error string, so that we can classify errors that
+	// do not wrap the original error (e.g. errors crossing an API boundary
+	// as strings). Note that these checks only work for English messages.
 	s := err.Error()
 	if strings.HasSuffix(s, "EOF") {
 		return modelx.FailureEOFError
@@ -105,6 +133,46 @@ func toFailureString(err error) string {
 	return Scrub(formatted) // scrub IP addresses in the error
 }
 
+type syscallFailure struct {
+	errno   syscall.Errno
+	failure string
+}
+
+// syscallFailures maps system errors to OONI failures. Using the
+// system errors rather than the error strings allows us to correctly
+// classify errors on systems where the strings are localized.
+var syscallFailures = append([]syscallFailure{
+	{errnoConnectionAborted, modelx.FailureConnectionAborted},
+	{errnoConnectionRefused, modelx.FailureConnectionRefused},
+	{errnoConnectionReset, modelx.FailureConnectionReset},
+	{errnoHostUnreachable, modelx.FailureHostUnreachable},
+	{errnoNetworkDown, modelx.FailureNetworkDown},
+	{errnoNetworkUnreachable, modelx.FailureNetworkUnreachable},
+	{errnoTimedOut, modelx.FailureGenericTimeoutError},
+}, platformSyscallFailures...)
+
+// toTLSFailureString returns the failure corresponding to TLS errors
+// that are not related to certificates, or an empty string.
+func toTLSFailureString(err error) string {
+	var recordHeaderError tls.RecordHeaderError
+	if errors.As(err, &recordHeaderError) {
+		// Test case: connecting with TLS to a plaintext HTTP server.
+		return modelx.FailureSSLInvalidRecord
+	}
+	var utlsRecordHeaderError utls.RecordHeaderError
+	if errors.As(err, &utlsRecordHeaderError) {
+		return modelx.FailureSSLInvalidRecord
+	}
+	// The alerts we receive are of the unexported tls.alert type, but
+	// both crypto/tls and utls wrap them using a net.OpError whose Op
+	// is "remote error", so that's what we check for.
+	var opError *net.OpError
+	if errors.As(err, &opError) && opError.Op == "remote error" {
+		return modelx.FailureSSLRemoteAlert
+	}
+	return ""
+}
+
 func toOperationString(err error, operation string) string {
 	var errwrapper *modelx.ErrWrapper
 	if errors.As(err, &errwrapper) {
@@ -122,6 +190,12 @@ func toOperationString(err error, operation string) string {
 		if errwrapper.Operation == "tls_handshake" {
 			return errwrapper.Operation
 		}
+		if errwrapper.Operation == "quic_handshake" {
+			return errwrapper.Operation
+		}
+		if errwrapper.Operation == "proxy_handshake" {
+			return errwrapper.Operation
+		}
 		// FALLTHROUGH
 	}
 	return operation
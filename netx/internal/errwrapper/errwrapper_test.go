@@ -2,10 +2,15 @@ package errwrapper
 
 import (
 	"context"
+	"crypto/tls"
 	"crypto/x509"
 	"errors"
+	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"syscall"
 	"testing"
 
@@ -86,6 +91,60 @@ func TestToFailureString(t *testing.T) {
 			t.Fatal("unexpected results")
 		}
 	})
+	t.Run("for wrapped system errors", func(t *testing.T) {
+		var table = append([]syscallFailure{
+			{errnoConnectionAborted, modelx.FailureConnectionAborted},
+			{errnoConnectionRefused, modelx.FailureConnectionRefused},
+			{errnoConnectionReset, modelx.FailureConnectionReset},
+			{errnoHostUnreachable, modelx.FailureHostUnreachable},
+			{errnoNetworkDown, modelx.FailureNetworkDown},
+			{errnoNetworkUnreachable, modelx.FailureNetworkUnreachable},
+			{errnoTimedOut, modelx.FailureGenericTimeoutError},
+		}, platformSyscallFailures...)
+		for _, entry := range table {
+			// This is how the net package wraps system errors
+			err := &net.OpError{
+				Op:  "dial",
+				Net: "tcp",
+				Err: os.NewSyscallError("connect", entry.errno),
+			}
+			if out := toFailureString(err); out != entry.failure {
+				t.Fatal(cmp.Diff(entry.failure, out))
+			}
+		}
+	})
+	t.Run("for unexpected EOF", func(t *testing.T) {
+		err := fmt.Errorf("read failed: %w", io.ErrUnexpectedEOF)
+		if toFailureString(err) != modelx.FailureEOFError {
+			t.Fatal("unexpected results")
+		}
+	})
+	t.Run("for tls.RecordHeaderError", func(t *testing.T) {
+		var err tls.RecordHeaderError
+		if toFailureString(err) != modelx.FailureSSLInvalidRecord {
+			t.Fatal("unexpected results")
+		}
+	})
+	t.Run("for TLS alert", func(t *testing.T) {
+		// The server requires a client certificate and sends us
+		// an alert because we don't have one.
+		server := httptest.NewUnstartedServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {}))
+		server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+		server.StartTLS()
+		defer server.Close()
+		conn, err := tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{
+			InsecureSkipVerify: true,
+			MaxVersion:         tls.VersionTLS12,
+		})
+		if err == nil {
+			conn.Close()
+			t.Fatal("expected an error here")
+		}
+		if toFailureString(err) != modelx.FailureSSLRemoteAlert {
+			t.Fatal("unexpected results")
+		}
+	})
 	t.Run("for context deadline expired", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 1)
 		defer cancel()
@@ -172,6 +231,22 @@ func TestUnitToOperationString(t *testing.T) {
 			t.Fatal("unexpected result")
 		}
 	})
+	t.Run("for quic_handshake", func(t *testing.T) {
+		// You're doing HTTP/3 and the QUIC handshake fails. You want
+		// to know about a QUIC handshake error.
+		err := &modelx.ErrWrapper{Operation: "quic_handshake"}
+		if toOperationString(err, "http_round_trip") != "quic_handshake" {
+			t.Fatal("unexpected result")
+		}
+	})
+	t.Run("for proxy_handshake", func(t *testing.T) {
+		// You're using a proxy and the proxy refuses to connect you
+		// to the target. You want to know about a proxy error.
+		err := &modelx.ErrWrapper{Operation: "proxy_handshake"}
+		if toOperationString(err, "http_round_trip") != "proxy_handshake" {
+			t.Fatal("unexpected result")
+		}
+	})
 	t.Run("for minor operation", func(t *testing.T) {
 		// You just noticed that TLS handshake failed and you
 		// have a child error telling you that read failed. Here
@@ -39,7 +39,7 @@ import (
 // SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 // ================================================================================
 
-//Test the log scrubber on known problematic log messages
+// Test the log scrubber on known problematic log messages
 func TestLogScrubberMessages(t *testing.T) {
 	for _, test := range []struct {
 		input, expected string
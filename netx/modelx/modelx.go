@@ -86,6 +86,12 @@ type Measurement struct {
 }
 
 const (
+	// FailureBrokenPipe means EPIPE.
+	FailureBrokenPipe = "broken_pipe"
+
+	// FailureConnectionAborted means ECONNABORTED.
+	FailureConnectionAborted = "connection_aborted"
+
 	// FailureConnectionRefused means ECONNREFUSED.
 	FailureConnectionRefused = "connection_refused"
 
@@ -104,6 +110,22 @@ const (
 	// FailureGenericTimeoutError means we got some timer has expired.
 	FailureGenericTimeoutError = "generic_timeout_error"
 
+	// FailureHostUnreachable means EHOSTUNREACH.
+	FailureHostUnreachable = "host_unreachable"
+
+	// FailureNetworkDown means ENETDOWN.
+	FailureNetworkDown = "network_down"
+
+	// FailureNetworkUnreachable means ENETUNREACH.
+	FailureNetworkUnreachable = "network_unreachable"
+
+	// FailureSSLInvalidRecord means the peer sent us something that
+	// is not a TLS record, e.g., because it does not speak TLS.
+	FailureSSLInvalidRecord = "ssl_invalid_record"
+
+	// FailureSSLRemoteAlert means the peer sent us a TLS alert.
+	FailureSSLRemoteAlert = "ssl_remote_alert"
+
 	// FailureSSLInvalidHostname means we got certificate is not valid for SNI.
 	FailureSSLInvalidHostname = "ssl_invalid_hostname"
 
@@ -139,6 +161,8 @@ type ErrWrapper struct {
 	// - `resolve`: resolving a domain name failed
 	// - `connect`: connecting to an IP failed
 	// - `tls_handshake`: TLS handshaking failed
+	// - `quic_handshake`: QUIC handshaking failed
+	// - `proxy_handshake`: handshaking with the proxy failed
 	// - `http_round_trip`: other errors during round trip
 	//
 	// Because a network connection doesn't necessarily know
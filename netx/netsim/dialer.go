@@ -0,0 +1,182 @@
+package netsim
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Dialer connects to endpoints of the simulated Network. It is meant
+// to replace the net.Dialer at the bottom of a chain of dialers.
+type Dialer struct {
+	network *Network
+}
+
+// DialContext implements Dialer.DialContext
+func (d Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := resolveAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	policy := d.network.policy(host, ActionBlackhole, ActionReset, ActionThrottle)
+	if policy != nil && policy.Action == ActionBlackhole {
+		<-ctx.Done()
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: remote, Err: ctx.Err()}
+	}
+	target, found := d.network.endpoint(address)
+	if !found || (policy != nil && policy.Action == ActionReset) {
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: remote,
+			Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	}
+	conn, err := new(net.Dialer).DialContext(ctx, network, target)
+	if err != nil {
+		return nil, err
+	}
+	c := &censoredConn{
+		Conn:      conn,
+		inspected: !strings.HasPrefix(network, "tcp"),
+		network:   d.network,
+		remote:    remote,
+	}
+	if policy != nil {
+		c.policy = *policy
+	}
+	return c, nil
+}
+
+func resolveAddr(network, address string) (net.Addr, error) {
+	if strings.HasPrefix(network, "udp") {
+		return net.ResolveUDPAddr(network, address)
+	}
+	return net.ResolveTCPAddr(network, address)
+}
+
+// censoredConn is a connection towards a simulated endpoint. Before
+// we inspect the first segment, the policy is the one of the remote IP
+// address, if any. Afterwards, the policy of the SNI or of the HTTP Host
+// takes precedence over the one of the remote IP address.
+type censoredConn struct {
+	net.Conn
+	blockpage *bytes.Reader
+	inspected bool
+	mu        sync.Mutex
+	network   *Network
+	policy    Policy
+	remote    net.Addr
+}
+
+// inspect inspects the first segment sent by the client and returns the
+// policy to apply to the connection.
+func (c *censoredConn) inspect(b []byte) Policy {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inspected {
+		return c.policy
+	}
+	c.inspected = true
+	actions := []Action{ActionBlackhole, ActionReset, ActionThrottle}
+	host := parseClientHelloSNI(b)
+	if host == "" {
+		// We only inject blockpages in response to HTTP requests
+		actions = append(actions, ActionBlockpage)
+		host = parseHTTPHost(b)
+	}
+	if host == "" {
+		return c.policy
+	}
+	policy := c.network.policy(host, actions...)
+	if policy == nil {
+		return c.policy
+	}
+	c.policy = *policy
+	switch c.policy.Action {
+	case ActionBlockpage:
+		// The blockpage replaces the response of the server, so we
+		// close the real connection, which also unblocks pending reads.
+		c.blockpage = bytes.NewReader(c.policy.Blockpage)
+		c.Conn.Close()
+	case ActionReset:
+		c.Conn.Close()
+	}
+	return c.policy
+}
+
+func (c *censoredConn) currentPolicy() Policy {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.policy
+}
+
+// Read implements net.Conn.Read
+func (c *censoredConn) Read(b []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		policy, blockpage := c.policy, c.blockpage
+		c.mu.Unlock()
+		switch policy.Action {
+		case ActionBlockpage:
+			return blockpage.Read(b)
+		case ActionReset:
+			return 0, c.resetError("read")
+		case ActionThrottle:
+			if policy.Bandwidth > 0 && len(b) > policy.Bandwidth {
+				b = b[:policy.Bandwidth]
+			}
+			count, err := c.Conn.Read(b)
+			c.throttle(count, policy.Bandwidth)
+			return count, err
+		}
+		// Note that, with ActionBlackhole, the server did not receive
+		// anything, so we will block until the read deadline.
+		count, err := c.Conn.Read(b)
+		if err != nil && c.currentPolicy().Action != policy.Action {
+			// We have been censored while blocked reading, which is
+			// what happens with net/http, which reads before writing.
+			continue
+		}
+		return count, err
+	}
+}
+
+// Write implements net.Conn.Write
+func (c *censoredConn) Write(b []byte) (int, error) {
+	if c.currentPolicy().Action == ActionReset {
+		return 0, c.resetError("write")
+	}
+	// The write that triggers the censor succeeds, since the censor
+	// injects the RST or the blockpage after seeing the segment.
+	switch policy := c.inspect(b); policy.Action {
+	case ActionBlackhole, ActionBlockpage, ActionReset:
+		return len(b), nil
+	case ActionThrottle:
+		count, err := c.Conn.Write(b)
+		c.throttle(count, policy.Bandwidth)
+		return count, err
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *censoredConn) throttle(count, bandwidth int) {
+	if bandwidth > 0 {
+		time.Sleep(time.Duration(count) * time.Second / time.Duration(bandwidth))
+	}
+}
+
+func (c *censoredConn) resetError(op string) error {
+	return &net.OpError{Op: op, Net: c.remote.Network(), Source: c.LocalAddr(),
+		Addr: c.remote, Err: os.NewSyscallError(op, syscall.ECONNRESET)}
+}
+
+// RemoteAddr implements net.Conn.RemoteAddr
+func (c *censoredConn) RemoteAddr() net.Addr {
+	return c.remote
+}
@@ -0,0 +1,109 @@
+package netsim
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/http"
+)
+
+// parseClientHelloSNI returns the SNI of the ClientHello contained in
+// data, or an empty string if data does not contain a whole TLS record
+// containing a ClientHello with the server_name extension.
+func parseClientHelloSNI(data []byte) string {
+	const (
+		recordTypeHandshake      = 22
+		handshakeTypeClientHello = 1
+		extensionServerName      = 0
+		serverNameTypeHostName   = 0
+	)
+	// record header: type (1), version (2), length (2)
+	if len(data) < 5 || data[0] != recordTypeHandshake {
+		return ""
+	}
+	length := int(binary.BigEndian.Uint16(data[3:5]))
+	if len(data) < 5+length {
+		return ""
+	}
+	data = data[5 : 5+length]
+	// handshake header: type (1), length (3)
+	if len(data) < 4 || data[0] != handshakeTypeClientHello {
+		return ""
+	}
+	length = int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	if len(data) < 4+length {
+		return ""
+	}
+	data = data[4 : 4+length]
+	// version (2), random (32), then variable length fields
+	if len(data) < 34 {
+		return ""
+	}
+	data = data[34:]
+	var ok bool
+	if _, data, ok = readVector(data, 1); !ok { // session_id
+		return ""
+	}
+	if _, data, ok = readVector(data, 2); !ok { // cipher_suites
+		return ""
+	}
+	if _, data, ok = readVector(data, 1); !ok { // compression_methods
+		return ""
+	}
+	extensions, _, ok := readVector(data, 2)
+	if !ok {
+		return ""
+	}
+	for len(extensions) >= 4 {
+		kind := binary.BigEndian.Uint16(extensions[0:2])
+		var body []byte
+		if body, extensions, ok = readVector(extensions[2:], 2); !ok {
+			return ""
+		}
+		if kind != extensionServerName {
+			continue
+		}
+		names, _, ok := readVector(body, 2)
+		for ok && len(names) >= 1 {
+			nameType := names[0]
+			var name []byte
+			if name, names, ok = readVector(names[1:], 2); ok && nameType == serverNameTypeHostName {
+				return string(name)
+			}
+		}
+		return ""
+	}
+	return ""
+}
+
+// readVector reads a vector whose length is encoded using size bytes and
+// returns the vector, the remainder of data, and whether it succeeded.
+func readVector(data []byte, size int) ([]byte, []byte, bool) {
+	if len(data) < size {
+		return nil, nil, false
+	}
+	var length int
+	for _, b := range data[:size] {
+		length = length<<8 | int(b)
+	}
+	data = data[size:]
+	if len(data) < length {
+		return nil, nil, false
+	}
+	return data[:length], data[length:], true
+}
+
+// parseHTTPHost returns the host of the HTTP request contained in
+// data, or an empty string if data does not contain the whole headers
+// of a HTTP request.
+func parseHTTPHost(data []byte) string {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(req.Host); err == nil {
+		return host
+	}
+	return req.Host
+}
@@ -0,0 +1,65 @@
+package netsim
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+// clientHello returns the first segment sent by a TLS client.
+func clientHello(t *testing.T, config *tls.Config) []byte {
+	client, server := net.Pipe()
+	defer server.Close()
+	go tls.Client(client, config).Handshake()
+	data := make([]byte, 1<<16)
+	count, err := server.Read(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	return data[:count]
+}
+
+func TestUnitParseClientHelloSNI(t *testing.T) {
+	data := clientHello(t, &tls.Config{ServerName: "example.com"})
+	if sni := parseClientHelloSNI(data); sni != "example.com" {
+		t.Fatal("not the SNI we expected", sni)
+	}
+	for idx := 0; idx < len(data); idx++ {
+		if parseClientHelloSNI(data[:idx]) != "" {
+			t.Fatal("we should not parse a truncated ClientHello")
+		}
+	}
+	// TLS clients do not send IP addresses as SNI
+	data = clientHello(t, &tls.Config{ServerName: "93.184.216.34"})
+	if sni := parseClientHelloSNI(data); sni != "" {
+		t.Fatal("not the SNI we expected", sni)
+	}
+	if sni := parseClientHelloSNI([]byte("GET / HTTP/1.1\r\n\r\n")); sni != "" {
+		t.Fatal("not the SNI we expected", sni)
+	}
+}
+
+func TestUnitParseHTTPHost(t *testing.T) {
+	var tests = []struct {
+		data string
+		host string
+	}{{
+		data: "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		host: "example.com",
+	}, {
+		data: "GET / HTTP/1.1\r\nHost: example.com:8080\r\n\r\n",
+		host: "example.com",
+	}, {
+		data: "GET / HTTP/1.1\r\nHost: example.com\r\n",
+		host: "",
+	}, {
+		data: "\x16\x03\x01",
+		host: "",
+	}}
+	for _, tt := range tests {
+		if host := parseHTTPHost([]byte(tt.data)); host != tt.host {
+			t.Fatal("not the host we expected", host)
+		}
+	}
+}
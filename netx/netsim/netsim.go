@@ -0,0 +1,180 @@
+// Package netsim contains an in-process simulated network that allows
+// to test experiments offline against known censorship scenarios.
+//
+// A Network maps domain names to addresses, like the DNS would do, and
+// routes the connections towards the simulated endpoints to local servers
+// (e.g. created using net/http/httptest). Connections towards endpoints
+// we do not know about are refused, so nothing reaches the Internet.
+//
+// The censor applies the configured policies at three stages:
+//
+// 1. when resolving a domain name, where it may return NXDOMAIN or
+// hijack the response (ActionNXDOMAIN, ActionHijackDNS);
+//
+// 2. when connecting to an IP address, where it may drop the SYN, reset
+// the connection or throttle it (ActionBlackhole, ActionReset,
+// ActionThrottle);
+//
+// 3. when the client sends the first segment of a TCP connection, where,
+// if such segment contains a TLS ClientHello with a SNI or a HTTP request
+// with a Host header, the censor may drop all the traffic, reset the
+// connection, throttle it, or inject a blockpage (ActionBlackhole,
+// ActionReset, ActionThrottle, ActionBlockpage).
+//
+// Like many real world censors, at the third stage we only inspect the
+// first segment, so splitting the ClientHello evades censorship. We do
+// that in the connection rather than in a TLSHandshaker, such that we
+// also censor the TLS handshakes performed by net/http.
+//
+// You plug the simulated network into the netx stack using the Dialer
+// and the Resolver, e.g., as the BaseDialer and BaseResolver of the
+// httptransport.Config, or of the urlgetter.Getter. To test legacy
+// experiments, save the Network into the context using WithNetwork; the
+// netx.Dialer they use will then use the simulated network.
+//
+// We do not simulate QUIC, hence tests should not use QUIC (i.e. HTTP/3
+// and quichandshake), which always uses the system UDP stack.
+package netsim
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Action is the action taken by the censor.
+type Action string
+
+const (
+	// ActionBlackhole drops the traffic, causing a timeout.
+	ActionBlackhole = Action("blackhole")
+
+	// ActionBlockpage injects the Blockpage in response to a HTTP request.
+	ActionBlockpage = Action("blockpage")
+
+	// ActionHijackDNS returns Addresses in response to DNS queries.
+	ActionHijackDNS = Action("hijack_dns")
+
+	// ActionNXDOMAIN returns NXDOMAIN in response to DNS queries.
+	ActionNXDOMAIN = Action("nxdomain")
+
+	// ActionReset resets the connection.
+	ActionReset = Action("reset")
+
+	// ActionThrottle limits the bandwidth of the connection.
+	ActionThrottle = Action("throttle")
+)
+
+// Policy describes how the censor treats a host.
+type Policy struct {
+	// Action is the action taken by the censor.
+	Action Action
+
+	// Addresses contains the addresses returned by ActionHijackDNS.
+	Addresses []string
+
+	// Bandwidth is the bandwidth in bytes per second of ActionThrottle.
+	Bandwidth int
+
+	// Blockpage is the raw HTTP response injected by ActionBlockpage.
+	Blockpage []byte
+
+	// Host is the domain name or the IP address to censor.
+	Host string
+}
+
+// Network is a simulated network. The zero value is an empty network
+// where you can add hosts, endpoints and policies. Do not change the
+// network after you have started using it.
+type Network struct {
+	endpoints map[string]string
+	hosts     map[string][]string
+	mu        sync.Mutex
+	policies  []Policy
+}
+
+// AddHost adds to the simulated DNS the addresses of domain.
+func (n *Network) AddHost(domain string, addrs ...string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.hosts == nil {
+		n.hosts = make(map[string][]string)
+	}
+	n.hosts[strings.ToLower(domain)] = addrs
+}
+
+// AddEndpoint routes the connections towards the simulated address,
+// e.g. "93.184.216.34:443", to the target address, which typically
+// is the address of a local server, e.g. "127.0.0.1:54321".
+func (n *Network) AddEndpoint(address, target string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.endpoints == nil {
+		n.endpoints = make(map[string]string)
+	}
+	n.endpoints[address] = target
+}
+
+// AddPolicy adds a censorship policy. When there are several policies
+// for the same host, we apply the first one that is relevant for the
+// stage at which we are (see the package documentation).
+func (n *Network) AddPolicy(policy Policy) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.policies = append(n.policies, policy)
+}
+
+// Dialer returns a Dialer that connects using the simulated network.
+func (n *Network) Dialer() Dialer {
+	return Dialer{network: n}
+}
+
+// Resolver returns a Resolver that uses the simulated DNS.
+func (n *Network) Resolver() Resolver {
+	return Resolver{network: n}
+}
+
+func (n *Network) endpoint(address string) (string, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	target, found := n.endpoints[address]
+	return target, found
+}
+
+func (n *Network) lookup(domain string) ([]string, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	addrs, found := n.hosts[strings.ToLower(domain)]
+	return addrs, found
+}
+
+// policy returns the first policy for host whose action is one of
+// actions, if any. Otherwise, it returns nil.
+func (n *Network) policy(host string, actions ...Action) *Policy {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, policy := range n.policies {
+		if !strings.EqualFold(policy.Host, host) {
+			continue
+		}
+		for _, action := range actions {
+			if policy.Action == action {
+				return &policy
+			}
+		}
+	}
+	return nil
+}
+
+type networkKey struct{}
+
+// WithNetwork returns a copy of ctx that uses network.
+func WithNetwork(ctx context.Context, network *Network) context.Context {
+	return context.WithValue(ctx, networkKey{}, network)
+}
+
+// ContextNetwork returns the Network saved in ctx, or nil.
+func ContextNetwork(ctx context.Context) *Network {
+	network, _ := ctx.Value(networkKey{}).(*Network)
+	return network
+}
@@ -0,0 +1,347 @@
+package netsim_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/netx/dialer"
+	"github.com/ooni/probe-engine/netx/httptransport"
+	"github.com/ooni/probe-engine/netx/modelx"
+	"github.com/ooni/probe-engine/netx/netsim"
+)
+
+func TestUnitResolver(t *testing.T) {
+	network := new(netsim.Network)
+	network.AddHost("example.com", "93.184.216.34")
+	network.AddHost("example.org", "93.184.216.34")
+	network.AddPolicy(netsim.Policy{
+		Action: netsim.ActionNXDOMAIN,
+		Host:   "example.org",
+	})
+	network.AddPolicy(netsim.Policy{
+		Action:    netsim.ActionHijackDNS,
+		Addresses: []string{"10.10.34.35"},
+		Host:      "example.net",
+	})
+	reso := network.Resolver()
+	ctx := context.Background()
+	addrs, err := reso.LookupHost(ctx, "EXAMPLE.com")
+	if err != nil || len(addrs) != 1 || addrs[0] != "93.184.216.34" {
+		t.Fatal("not the result we expected")
+	}
+	addrs, err = reso.LookupHost(ctx, "example.net")
+	if err != nil || len(addrs) != 1 || addrs[0] != "10.10.34.35" {
+		t.Fatal("not the result we expected")
+	}
+	addrs, err = reso.LookupHost(ctx, "1.1.1.1")
+	if err != nil || len(addrs) != 1 || addrs[0] != "1.1.1.1" {
+		t.Fatal("not the result we expected")
+	}
+	for _, domain := range []string{"example.org", "antani.ooni.io"} {
+		addrs, err := reso.LookupHost(ctx, domain)
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			t.Fatal("not the error we expected")
+		}
+		if addrs != nil {
+			t.Fatal("expected nil addrs here")
+		}
+	}
+	if reso.Network() != "netsim" || reso.Address() != "" {
+		t.Fatal("not the network or address we expected")
+	}
+}
+
+func TestUnitDialerRefused(t *testing.T) {
+	network := new(netsim.Network)
+	network.AddEndpoint("93.184.216.34:80", "127.0.0.1:1")
+	network.AddPolicy(netsim.Policy{
+		Action: netsim.ActionReset,
+		Host:   "93.184.216.34",
+	})
+	for _, address := range []string{"93.184.216.34:80", "1.1.1.1:80"} {
+		conn, err := network.Dialer().DialContext(
+			context.Background(), "tcp", address)
+		if err == nil || !strings.HasSuffix(err.Error(), "connection refused") {
+			t.Fatal("not the error we expected", err)
+		}
+		if conn != nil {
+			t.Fatal("expected nil conn here")
+		}
+	}
+}
+
+func TestUnitDialerBlackhole(t *testing.T) {
+	network := new(netsim.Network)
+	network.AddPolicy(netsim.Policy{
+		Action: netsim.ActionBlackhole,
+		Host:   "93.184.216.34",
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	conn, err := network.Dialer().DialContext(ctx, "tcp", "93.184.216.34:80")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("not the error we expected")
+	}
+	if conn != nil {
+		t.Fatal("expected nil conn here")
+	}
+}
+
+func TestUnitDialerRemoteAddr(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	network := new(netsim.Network)
+	network.AddEndpoint("93.184.216.34:80", server.Listener.Addr().String())
+	conn, err := network.Dialer().DialContext(
+		context.Background(), "tcp", "93.184.216.34:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != "93.184.216.34:80" {
+		t.Fatal("not the RemoteAddr we expected")
+	}
+}
+
+func TestUnitContextNetwork(t *testing.T) {
+	if netsim.ContextNetwork(context.Background()) != nil {
+		t.Fatal("expected nil network here")
+	}
+	network := new(netsim.Network)
+	ctx := netsim.WithNetwork(context.Background(), network)
+	if netsim.ContextNetwork(ctx) != network {
+		t.Fatal("not the network we expected")
+	}
+}
+
+// scenario is a simulated network where example.com points to
+// a local HTTP server and to a local HTTPS server.
+type scenario struct {
+	network *netsim.Network
+	servers []*httptest.Server
+	timeout time.Duration
+	txp     httptransport.RoundTripper
+}
+
+func newScenario(t *testing.T, config httptransport.Config) *scenario {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("0123456789", 100)))
+	})
+	cleartext := httptest.NewServer(handler)
+	encrypted := httptest.NewTLSServer(handler)
+	network := new(netsim.Network)
+	network.AddHost("example.com", "93.184.216.34")
+	network.AddEndpoint("93.184.216.34:80", cleartext.Listener.Addr().String())
+	network.AddEndpoint("93.184.216.34:443", encrypted.Listener.Addr().String())
+	config.BaseDialer = network.Dialer()
+	config.BaseResolver = network.Resolver()
+	config.TLSConfig = &tls.Config{
+		RootCAs: encrypted.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs,
+	}
+	return &scenario{
+		network: network,
+		servers: []*httptest.Server{cleartext, encrypted},
+		timeout: 2 * time.Second,
+		txp:     httptransport.New(config),
+	}
+}
+
+func (s *scenario) get(URL string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.txp.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (s *scenario) close() {
+	s.txp.CloseIdleConnections()
+	for _, server := range s.servers {
+		server.Close()
+	}
+}
+
+func failure(err error) string {
+	var wrapper *modelx.ErrWrapper
+	if !errors.As(err, &wrapper) {
+		return ""
+	}
+	return wrapper.Failure
+}
+
+func TestUnitScenarioNoCensorship(t *testing.T) {
+	s := newScenario(t, httptransport.Config{})
+	defer s.close()
+	for _, URL := range []string{"http://example.com/", "https://example.com/"} {
+		data, err := s.get(URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 1000 {
+			t.Fatal("not the body we expected")
+		}
+	}
+}
+
+func TestUnitScenarioNXDOMAIN(t *testing.T) {
+	s := newScenario(t, httptransport.Config{})
+	defer s.close()
+	s.network.AddPolicy(netsim.Policy{
+		Action: netsim.ActionNXDOMAIN,
+		Host:   "example.com",
+	})
+	_, err := s.get("https://example.com/")
+	if failure(err) != modelx.FailureDNSNXDOMAINError {
+		t.Fatal("not the error we expected", err)
+	}
+}
+
+func TestUnitScenarioHijackDNS(t *testing.T) {
+	s := newScenario(t, httptransport.Config{BogonIsError: true})
+	defer s.close()
+	s.network.AddPolicy(netsim.Policy{
+		Action:    netsim.ActionHijackDNS,
+		Addresses: []string{"10.10.34.35"},
+		Host:      "example.com",
+	})
+	_, err := s.get("https://example.com/")
+	if failure(err) != modelx.FailureDNSBogonError {
+		t.Fatal("not the error we expected", err)
+	}
+}
+
+func TestUnitScenarioResetOnSNI(t *testing.T) {
+	s := newScenario(t, httptransport.Config{})
+	defer s.close()
+	s.network.AddPolicy(netsim.Policy{
+		Action: netsim.ActionReset,
+		Host:   "example.com",
+	})
+	_, err := s.get("https://example.com/")
+	if failure(err) != modelx.FailureConnectionReset {
+		t.Fatal("not the error we expected", err)
+	}
+}
+
+func TestUnitScenarioResetOnSNIWithClientHelloSplit(t *testing.T) {
+	s := newScenario(t, httptransport.Config{
+		ClientHelloSplit: &dialer.SplitStrategy{Chunks: []int{1}},
+	})
+	defer s.close()
+	s.network.AddPolicy(netsim.Policy{
+		Action: netsim.ActionReset,
+		Host:   "example.com",
+	})
+	if _, err := s.get("https://example.com/"); err != nil {
+		t.Fatal("splitting the ClientHello should evade the censor", err)
+	}
+}
+
+func TestUnitScenarioBlackholeOnSNI(t *testing.T) {
+	s := newScenario(t, httptransport.Config{})
+	defer s.close()
+	s.timeout = 250 * time.Millisecond
+	s.network.AddPolicy(netsim.Policy{
+		Action: netsim.ActionBlackhole,
+		Host:   "example.com",
+	})
+	_, err := s.get("https://example.com/")
+	// Depending on timing, either we or net/http notice the timeout
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("not the error we expected", err)
+	}
+}
+
+func TestUnitScenarioBlackholeIP(t *testing.T) {
+	s := newScenario(t, httptransport.Config{})
+	defer s.close()
+	s.timeout = 250 * time.Millisecond
+	s.network.AddPolicy(netsim.Policy{
+		Action: netsim.ActionBlackhole,
+		Host:   "93.184.216.34",
+	})
+	_, err := s.get("http://example.com/")
+	// Depending on timing, either we or net/http notice the timeout
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("not the error we expected", err)
+	}
+}
+
+func TestUnitScenarioBlockpage(t *testing.T) {
+	s := newScenario(t, httptransport.Config{})
+	defer s.close()
+	s.network.AddPolicy(netsim.Policy{
+		Action: netsim.ActionBlockpage,
+		Blockpage: []byte("HTTP/1.1 200 OK\r\nContent-Length: 7\r\n" +
+			"Connection: close\r\n\r\nblocked"),
+		Host: "example.com",
+	})
+	data, err := s.get("http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "blocked" {
+		t.Fatal("not the body we expected")
+	}
+	// The censor does not see the Host header of HTTPS requests
+	data, err = s.get("https://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1000 {
+		t.Fatal("not the body we expected")
+	}
+}
+
+func TestUnitScenarioThrottle(t *testing.T) {
+	s := newScenario(t, httptransport.Config{})
+	defer s.close()
+	s.network.AddPolicy(netsim.Policy{
+		Action:    netsim.ActionThrottle,
+		Bandwidth: 10000,
+		Host:      "93.184.216.34",
+	})
+	begin := time.Now()
+	data, err := s.get("http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1000 {
+		t.Fatal("not the body we expected")
+	}
+	// The request and the response are more than 1000 bytes, hence
+	// at 10000 bytes per second we need at least 100 ms
+	if time.Since(begin) < 100*time.Millisecond {
+		t.Fatal("the connection has not been throttled")
+	}
+}
+
+func TestUnitScenarioProxy(t *testing.T) {
+	// Make sure that we cannot escape from the simulated
+	// network by using a proxy located on the Internet
+	s := newScenario(t, httptransport.Config{
+		ProxyURL: &url.URL{Scheme: "socks5", Host: "8.8.8.8:9050"},
+	})
+	defer s.close()
+	_, err := s.get("https://example.com/")
+	if failure(err) != modelx.FailureConnectionRefused {
+		t.Fatal("not the error we expected", err)
+	}
+}
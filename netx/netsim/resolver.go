@@ -0,0 +1,42 @@
+package netsim
+
+import (
+	"context"
+	"net"
+)
+
+// Resolver resolves domain names using the simulated Network. When
+// the Network does not know about a domain, we return NXDOMAIN.
+type Resolver struct {
+	network *Network
+}
+
+// LookupHost implements Resolver.LookupHost
+func (r Resolver) LookupHost(ctx context.Context, hostname string) ([]string, error) {
+	if net.ParseIP(hostname) != nil {
+		return []string{hostname}, nil
+	}
+	policy := r.network.policy(hostname, ActionHijackDNS, ActionNXDOMAIN)
+	if policy != nil && policy.Action == ActionHijackDNS {
+		return policy.Addresses, nil
+	}
+	addrs, found := r.network.lookup(hostname)
+	if policy != nil || !found {
+		return nil, &net.DNSError{
+			Err:        "no such host",
+			IsNotFound: true,
+			Name:       hostname,
+		}
+	}
+	return addrs, nil
+}
+
+// Network implements Resolver.Network
+func (r Resolver) Network() string {
+	return "netsim"
+}
+
+// Address implements Resolver.Address
+func (r Resolver) Address() string {
+	return ""
+}
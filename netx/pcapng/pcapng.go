@@ -0,0 +1,457 @@
+// Package pcapng synthesizes a pcap-ng capture from the events saved
+// while tracing connections, so that researchers can inspect the traffic
+// generated by a measurement using Wireshark without needing root
+// privileges to perform a real capture.
+//
+// We synthesize raw IP packets with TCP or UDP framing using the local
+// and remote addresses of the read and write events saved by the
+// dialer.SaverConnDialer. For TCP connections, we also synthesize the
+// three way handshake and, when the server closes the connection, a FIN
+// segment. We do not synthesize acknowledgments. When the events do not
+// contain the full payload (e.g. because the dialer was not configured to
+// keep payloads or the trace.Saver truncated the Data), we record packets
+// whose captured length is smaller than their original length, as if the
+// capture was performed using a small snap length.
+//
+// We also synthesize DNS over UDP queries and replies from the DNS round
+// trip events, unless the read and write events of the same exchange are
+// also available. Because these events do not contain the local address,
+// we use the unspecified address and port zero as source.
+//
+// See https://tools.ietf.org/html/draft-tuexen-opsawg-pcapng-02.
+package pcapng
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ooni/probe-engine/netx/trace"
+	"github.com/ooni/probe-engine/version"
+)
+
+// maxSegmentSize is the maximum size of the payload of the TCP
+// segments that we synthesize.
+const maxSegmentSize = 1460
+
+// Write synthesizes a pcap-ng capture from events and writes it to w.
+func Write(w io.Writer, events []trace.Event) error {
+	packets := NewPackets(events)
+	bw := &blockWriter{w: w}
+	bw.writeSectionHeader()
+	bw.writeInterfaceDescription()
+	for _, packet := range packets {
+		bw.writeEnhancedPacket(packet)
+	}
+	return bw.err
+}
+
+// Packet is a synthesized raw IP packet.
+type Packet struct {
+	// Data contains the captured bytes of the packet.
+	Data []byte
+
+	// Length is the original length of the packet, which is larger
+	// than len(Data) if we did not see the whole payload.
+	Length int
+
+	// Time is the time when we have seen the packet.
+	Time time.Time
+}
+
+// NewPackets synthesizes the packets corresponding to events, sorted
+// by time. Events that are not related to TCP or UDP traffic, or whose
+// addresses are not IP endpoints, do not produce any packet.
+func NewPackets(events []trace.Event) []Packet {
+	s := &synthesizer{flows: make(map[flowKey]*tcpFlow)}
+	udpRemotes := make(map[string]bool)
+	for _, ev := range events {
+		if (ev.Name == "read" || ev.Name == "write") && isUDP(ev.Proto) {
+			udpRemotes[ev.Address] = true
+		}
+	}
+	for _, ev := range events {
+		switch ev.Name {
+		case "connect":
+			if ev.Err == nil && isTCP(ev.Proto) {
+				s.connects = append(s.connects, ev)
+			}
+		case "read", "write":
+			if isTCP(ev.Proto) {
+				s.tcp(ev)
+			} else if isUDP(ev.Proto) {
+				s.udp(ev)
+			}
+		case "dns_round_trip_done", "dns_late_reply":
+			if ev.Proto == "udp" && !udpRemotes[ev.Address] {
+				s.dns(ev)
+			}
+		}
+	}
+	sort.SliceStable(s.packets, func(i, j int) bool {
+		return s.packets[i].Time.Before(s.packets[j].Time)
+	})
+	return s.packets
+}
+
+func isTCP(proto string) bool {
+	return strings.HasPrefix(proto, "tcp")
+}
+
+func isUDP(proto string) bool {
+	return strings.HasPrefix(proto, "udp")
+}
+
+type flowKey struct {
+	local  string
+	remote string
+}
+
+type tcpFlow struct {
+	clientSeq uint32
+	serverSeq uint32
+}
+
+type synthesizer struct {
+	connects []trace.Event
+	flows    map[flowKey]*tcpFlow
+	packets  []Packet
+}
+
+func (s *synthesizer) tcp(ev trace.Event) {
+	local, remote, ok := parseEndpoints(ev.LocalAddress, ev.Address)
+	if !ok {
+		return
+	}
+	key := flowKey{local: ev.LocalAddress, remote: ev.Address}
+	flow, found := s.flows[key]
+	if !found {
+		s.handshake(local, remote, ev)
+		flow = &tcpFlow{clientSeq: 1, serverSeq: 1} // SYNs consume one
+		s.flows[key] = flow
+	}
+	client, server := &flow.clientSeq, &flow.serverSeq
+	src, dst := local, remote
+	if ev.Name == "read" {
+		client, server = server, client
+		src, dst = dst, src
+	}
+	data := ev.Data
+	for offset := 0; offset < ev.NumBytes; offset += maxSegmentSize {
+		size := ev.NumBytes - offset
+		if size > maxSegmentSize {
+			size = maxSegmentSize
+		}
+		var payload []byte
+		if offset < len(data) {
+			payload = data[offset:minInt(len(data), offset+size)]
+		}
+		s.add(ev.Time, newTCPPacket(src, dst, *client, *server,
+			tcpFlagPSH|tcpFlagACK, payload, size))
+		*client += uint32(size)
+	}
+	if ev.Name == "read" && isEOF(ev.Err) {
+		s.add(ev.Time, newTCPPacket(src, dst, *client, *server,
+			tcpFlagFIN|tcpFlagACK, nil, 0))
+		*client++
+	}
+}
+
+// handshake synthesizes the three way handshake of the connection
+// to which ev belongs, using the corresponding connect event, if any,
+// to figure out when the handshake happened.
+func (s *synthesizer) handshake(local, remote endpoint, ev trace.Event) {
+	start, stop := ev.Time, ev.Time
+	for idx := len(s.connects) - 1; idx >= 0; idx-- {
+		connect := s.connects[idx]
+		if connect.Address == ev.Address && !connect.Time.After(ev.Time) {
+			start, stop = connect.Time.Add(-connect.Duration), connect.Time
+			s.connects = append(s.connects[:idx], s.connects[idx+1:]...)
+			break
+		}
+	}
+	// We use zero as the initial sequence number of both peers
+	s.add(start, newTCPPacket(local, remote, 0, 0, tcpFlagSYN, nil, 0))
+	s.add(stop, newTCPPacket(remote, local, 0, 1, tcpFlagSYN|tcpFlagACK, nil, 0))
+	s.add(stop, newTCPPacket(local, remote, 1, 1, tcpFlagACK, nil, 0))
+}
+
+func (s *synthesizer) udp(ev trace.Event) {
+	local, remote, ok := parseEndpoints(ev.LocalAddress, ev.Address)
+	if !ok {
+		return
+	}
+	payload := ev.Data
+	if len(payload) > ev.NumBytes {
+		payload = payload[:ev.NumBytes]
+	}
+	if ev.Name == "read" {
+		s.add(ev.Time, newUDPPacket(remote, local, payload, ev.NumBytes))
+		return
+	}
+	s.add(ev.Time, newUDPPacket(local, remote, payload, ev.NumBytes))
+}
+
+func (s *synthesizer) dns(ev trace.Event) {
+	remote, ok := parseEndpoint(ev.Address)
+	if !ok {
+		return
+	}
+	local := endpoint{ip: net.IPv4zero}
+	if remote.ip.To4() == nil {
+		local.ip = net.IPv6unspecified
+	}
+	if ev.Name == "dns_round_trip_done" {
+		// The late replies share the query of the first reply
+		s.add(ev.Time.Add(-ev.Duration),
+			newUDPPacket(local, remote, ev.DNSQuery, len(ev.DNSQuery)))
+	}
+	if ev.DNSReply != nil {
+		s.add(ev.Time, newUDPPacket(remote, local, ev.DNSReply, len(ev.DNSReply)))
+	}
+}
+
+func (s *synthesizer) add(t time.Time, packet Packet) {
+	packet.Time = t
+	s.packets = append(s.packets, packet)
+}
+
+func isEOF(err error) bool {
+	// The error may be wrapped such that errors.Is does not work,
+	// hence we also check the error string.
+	return err != nil && (errors.Is(err, io.EOF) ||
+		strings.HasSuffix(err.Error(), "EOF") || err.Error() == "eof_error")
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+type endpoint struct {
+	ip   net.IP
+	port uint16
+}
+
+func parseEndpoint(address string) (endpoint, bool) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return endpoint{}, false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return endpoint{}, false
+	}
+	num, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return endpoint{}, false
+	}
+	return endpoint{ip: ip, port: uint16(num)}, true
+}
+
+func parseEndpoints(localAddress, remoteAddress string) (endpoint, endpoint, bool) {
+	local, ok := parseEndpoint(localAddress)
+	if !ok {
+		return endpoint{}, endpoint{}, false
+	}
+	remote, ok := parseEndpoint(remoteAddress)
+	if !ok {
+		return endpoint{}, endpoint{}, false
+	}
+	if (local.ip.To4() == nil) != (remote.ip.To4() == nil) {
+		return endpoint{}, endpoint{}, false
+	}
+	return local, remote, true
+}
+
+// Flags of the TCP header
+const (
+	tcpFlagFIN = 1 << 0
+	tcpFlagSYN = 1 << 1
+	tcpFlagPSH = 1 << 3
+	tcpFlagACK = 1 << 4
+)
+
+// IP protocol numbers
+const (
+	protoTCP = 6
+	protoUDP = 17
+)
+
+// newTCPPacket creates a TCP packet. The payload may be shorter than
+// size, in which case we only capture the beginning of the packet.
+func newTCPPacket(
+	src, dst endpoint, seq, ack uint32, flags byte, payload []byte, size int) Packet {
+	header := make([]byte, 20)
+	binary.BigEndian.PutUint16(header[0:2], src.port)
+	binary.BigEndian.PutUint16(header[2:4], dst.port)
+	binary.BigEndian.PutUint32(header[4:8], seq)
+	binary.BigEndian.PutUint32(header[8:12], ack)
+	header[12] = 5 << 4 // data offset in 32 bit words
+	header[13] = flags
+	binary.BigEndian.PutUint16(header[14:16], 65535) // window
+	return newIPPacket(src, dst, protoTCP, header, 16, payload, size)
+}
+
+// newUDPPacket creates an UDP packet. See newTCPPacket.
+func newUDPPacket(src, dst endpoint, payload []byte, size int) Packet {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint16(header[0:2], src.port)
+	binary.BigEndian.PutUint16(header[2:4], dst.port)
+	binary.BigEndian.PutUint16(header[4:6], uint16(len(header)+size))
+	return newIPPacket(src, dst, protoUDP, header, 6, payload, size)
+}
+
+// newIPPacket creates an IPv4 or IPv6 packet containing the given
+// transport header and payload. The checksum offset is the offset of
+// the checksum within the transport header. When we know the whole
+// payload, we compute the transport checksum, otherwise we leave it
+// zero, which Wireshark does not validate by default.
+func newIPPacket(src, dst endpoint, proto byte, header []byte,
+	checksumOffset int, payload []byte, size int) Packet {
+	var ipheader, pseudo []byte
+	length := len(header) + size
+	if src4, dst4 := src.ip.To4(), dst.ip.To4(); src4 != nil && dst4 != nil {
+		ipheader = make([]byte, 20)
+		ipheader[0] = 4<<4 | 5 // version and header length
+		binary.BigEndian.PutUint16(ipheader[2:4], uint16(len(ipheader)+length))
+		ipheader[6] = 0x40 // don't fragment
+		ipheader[8] = 64   // TTL
+		ipheader[9] = proto
+		copy(ipheader[12:16], src4)
+		copy(ipheader[16:20], dst4)
+		binary.BigEndian.PutUint16(ipheader[10:12], checksum(ipheader))
+		pseudo = make([]byte, 12)
+		copy(pseudo[0:4], src4)
+		copy(pseudo[4:8], dst4)
+		pseudo[9] = proto
+		binary.BigEndian.PutUint16(pseudo[10:12], uint16(length))
+	} else {
+		ipheader = make([]byte, 40)
+		ipheader[0] = 6 << 4 // version
+		binary.BigEndian.PutUint16(ipheader[4:6], uint16(length))
+		ipheader[6] = proto
+		ipheader[7] = 64 // hop limit
+		copy(ipheader[8:24], src.ip.To16())
+		copy(ipheader[24:40], dst.ip.To16())
+		pseudo = make([]byte, 40)
+		copy(pseudo[0:32], ipheader[8:40])
+		binary.BigEndian.PutUint32(pseudo[32:36], uint32(length))
+		pseudo[39] = proto
+	}
+	if len(payload) == size {
+		data := append(append(pseudo, header...), payload...)
+		sum := checksum(data)
+		if sum == 0 && proto == protoUDP {
+			sum = 0xffff // zero means no checksum for UDP
+		}
+		binary.BigEndian.PutUint16(header[checksumOffset:], sum)
+	}
+	data := append(append(ipheader, header...), payload...)
+	return Packet{Data: data, Length: len(ipheader) + length}
+}
+
+// checksum computes the Internet checksum (RFC1071).
+func checksum(data []byte) uint16 {
+	var sum uint32
+	for ; len(data) >= 2; data = data[2:] {
+		sum += uint32(data[0])<<8 | uint32(data[1])
+	}
+	if len(data) > 0 {
+		sum += uint32(data[0]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+// Block types and other constants of the pcap-ng format
+const (
+	blockTypeSectionHeader        = 0x0A0D0D0A
+	blockTypeInterfaceDescription = 0x00000001
+	blockTypeEnhancedPacket       = 0x00000006
+	byteOrderMagic                = 0x1A2B3C4D
+	linkTypeRaw                   = 101
+	optionEndOfOptions            = 0
+	optionSHBUserApplication      = 4
+	optionIFTimestampResolution   = 9
+	snapLength                    = 262144
+)
+
+// blockWriter writes pcap-ng blocks using the little endian byte
+// order. It remembers the first error that occurred.
+type blockWriter struct {
+	err error
+	w   io.Writer
+}
+
+func (bw *blockWriter) writeSectionHeader() {
+	body := make([]byte, 16)
+	binary.LittleEndian.PutUint32(body[0:4], byteOrderMagic)
+	binary.LittleEndian.PutUint16(body[4:6], 1) // major version
+	binary.LittleEndian.PutUint16(body[6:8], 0) // minor version
+	binary.LittleEndian.PutUint64(body[8:16], 0xFFFFFFFFFFFFFFFF)
+	body = appendOption(body, optionSHBUserApplication,
+		[]byte("ooniprobe-engine "+version.Version))
+	body = appendOption(body, optionEndOfOptions, nil)
+	bw.writeBlock(blockTypeSectionHeader, body)
+}
+
+func (bw *blockWriter) writeInterfaceDescription() {
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint16(body[0:2], linkTypeRaw)
+	binary.LittleEndian.PutUint32(body[4:8], snapLength)
+	// Timestamps have nanosecond resolution (10^-9)
+	body = appendOption(body, optionIFTimestampResolution, []byte{9})
+	body = appendOption(body, optionEndOfOptions, nil)
+	bw.writeBlock(blockTypeInterfaceDescription, body)
+}
+
+func (bw *blockWriter) writeEnhancedPacket(packet Packet) {
+	body := make([]byte, 20)
+	ts := uint64(packet.Time.UnixNano())
+	binary.LittleEndian.PutUint32(body[0:4], 0) // interface ID
+	binary.LittleEndian.PutUint32(body[4:8], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(body[8:12], uint32(ts))
+	binary.LittleEndian.PutUint32(body[12:16], uint32(len(packet.Data)))
+	binary.LittleEndian.PutUint32(body[16:20], uint32(packet.Length))
+	body = append(body, pad(packet.Data)...)
+	bw.writeBlock(blockTypeEnhancedPacket, body)
+}
+
+func (bw *blockWriter) writeBlock(blockType uint32, body []byte) {
+	if bw.err != nil {
+		return
+	}
+	total := uint32(len(body) + 12)
+	block := make([]byte, 8, total)
+	binary.LittleEndian.PutUint32(block[0:4], blockType)
+	binary.LittleEndian.PutUint32(block[4:8], total)
+	block = append(block, body...)
+	block = append(block, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(block[len(block)-4:], total)
+	_, bw.err = bw.w.Write(block)
+}
+
+func appendOption(body []byte, code uint16, value []byte) []byte {
+	header := make([]byte, 4)
+	binary.LittleEndian.PutUint16(header[0:2], code)
+	binary.LittleEndian.PutUint16(header[2:4], uint16(len(value)))
+	return append(append(body, header...), pad(value)...)
+}
+
+// pad pads data to a multiple of 32 bits.
+func pad(data []byte) []byte {
+	if rem := len(data) % 4; rem != 0 {
+		data = append(append([]byte(nil), data...), make([]byte, 4-rem)...)
+	}
+	return data
+}
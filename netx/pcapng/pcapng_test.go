@@ -0,0 +1,319 @@
+package pcapng_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/netx/httptransport"
+	"github.com/ooni/probe-engine/netx/pcapng"
+	"github.com/ooni/probe-engine/netx/trace"
+)
+
+func TestUnitNewPacketsTCP(t *testing.T) {
+	begin := time.Now()
+	local, remote := "10.0.0.1:54321", "93.184.216.34:80"
+	request := []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	events := []trace.Event{{
+		Address:  remote,
+		Duration: 10 * time.Millisecond,
+		Name:     "connect",
+		Proto:    "tcp",
+		Time:     begin.Add(10 * time.Millisecond),
+	}, {
+		Address:      remote,
+		Data:         request,
+		LocalAddress: local,
+		Name:         "write",
+		NumBytes:     len(request),
+		Proto:        "tcp",
+		Time:         begin.Add(11 * time.Millisecond),
+	}, {
+		// The payload is missing, hence we cannot capture it
+		Address:      remote,
+		LocalAddress: local,
+		Name:         "read",
+		NumBytes:     2000,
+		Proto:        "tcp",
+		Time:         begin.Add(20 * time.Millisecond),
+	}, {
+		Address:      remote,
+		Err:          io.EOF,
+		LocalAddress: local,
+		Name:         "read",
+		Proto:        "tcp",
+		Time:         begin.Add(21 * time.Millisecond),
+	}}
+	packets := pcapng.NewPackets(events)
+	// SYN, SYN-ACK, ACK, request, two response segments, FIN
+	if len(packets) != 7 {
+		t.Fatal("unexpected number of packets", len(packets))
+	}
+	if !packets[0].Time.Equal(begin) {
+		t.Fatal("the SYN should be sent when we start connecting")
+	}
+	syn := parseTCP(t, packets[0])
+	if syn.flags != 0x02 || syn.src != "10.0.0.1:54321" || syn.dst != remote {
+		t.Fatal("unexpected SYN")
+	}
+	synack := parseTCP(t, packets[1])
+	if synack.flags != 0x12 || synack.src != remote || synack.ack != 1 {
+		t.Fatal("unexpected SYN-ACK")
+	}
+	req := parseTCP(t, packets[3])
+	if req.seq != 1 || !bytes.Equal(req.payload, request) {
+		t.Fatal("unexpected request")
+	}
+	if packets[3].Length != len(packets[3].Data) {
+		t.Fatal("the request should have been fully captured")
+	}
+	if !validChecksum(packets[3]) {
+		t.Fatal("invalid TCP checksum")
+	}
+	resp := parseTCP(t, packets[5])
+	if resp.seq != 1+1460 || resp.ack != uint32(1+len(request)) {
+		t.Fatal("unexpected response segment")
+	}
+	if packets[5].Length != 20+20+540 || len(packets[5].Data) != 20+20 {
+		t.Fatal("the response should not have been captured")
+	}
+	fin := parseTCP(t, packets[6])
+	if fin.flags != 0x11 || fin.seq != 1+2000 {
+		t.Fatal("unexpected FIN")
+	}
+}
+
+func TestUnitNewPacketsUDPAndDNS(t *testing.T) {
+	begin := time.Now()
+	query, reply := []byte("\x00\x01query"), []byte("\x00\x01reply")
+	events := []trace.Event{{
+		Address:      "[2001:4860:4860::8888]:53",
+		Data:         query,
+		LocalAddress: "[2001:db8::1]:5555",
+		Name:         "write",
+		NumBytes:     len(query),
+		Proto:        "udp",
+		Time:         begin,
+	}, {
+		// This DNS round trip is also available as read and write events
+		Address:  "[2001:4860:4860::8888]:53",
+		DNSQuery: query,
+		DNSReply: reply,
+		Name:     "dns_round_trip_done",
+		Proto:    "udp",
+		Time:     begin,
+	}, {
+		Address:  "8.8.4.4:53",
+		DNSQuery: query,
+		DNSReply: reply,
+		Duration: time.Millisecond,
+		Name:     "dns_round_trip_done",
+		Proto:    "udp",
+		Time:     begin.Add(2 * time.Millisecond),
+	}, {
+		Address:  "8.8.4.4:53",
+		DNSQuery: query,
+		DNSReply: reply,
+		Name:     "dns_late_reply",
+		Proto:    "udp",
+		Time:     begin.Add(3 * time.Millisecond),
+	}, {
+		Address:  "dns.google:853",
+		DNSQuery: query,
+		DNSReply: reply,
+		Name:     "dns_round_trip_done",
+		Proto:    "dot",
+		Time:     begin,
+	}}
+	packets := pcapng.NewPackets(events)
+	if len(packets) != 4 {
+		t.Fatal("unexpected number of packets", len(packets))
+	}
+	if packets[0].Data[0]>>4 != 6 || len(packets[0].Data) != 40+8+len(query) {
+		t.Fatal("unexpected IPv6 packet")
+	}
+	for _, packet := range packets[1:] {
+		if packet.Data[0]>>4 != 4 || !validChecksum(packet) {
+			t.Fatal("unexpected IPv4 packet")
+		}
+	}
+	if !bytes.Equal(packets[3].Data[28:], reply) {
+		t.Fatal("unexpected late reply")
+	}
+	if !validChecksum(packets[0]) {
+		t.Fatal("invalid UDP checksum")
+	}
+}
+
+func TestUnitWrite(t *testing.T) {
+	events := []trace.Event{{
+		Address:      "1.1.1.1:53",
+		Data:         []byte("abc"),
+		LocalAddress: "10.0.0.1:5555",
+		Name:         "write",
+		NumBytes:     3,
+		Proto:        "udp",
+		Time:         time.Unix(1588334400, 1),
+	}}
+	var buf bytes.Buffer
+	if err := pcapng.Write(&buf, events); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	var blocks []uint32
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatal("truncated block")
+		}
+		length := binary.LittleEndian.Uint32(data[4:8])
+		if length%4 != 0 || int(length) > len(data) {
+			t.Fatal("invalid block length")
+		}
+		if binary.LittleEndian.Uint32(data[length-4:length]) != length {
+			t.Fatal("trailing length does not match")
+		}
+		blocks = append(blocks, binary.LittleEndian.Uint32(data[0:4]))
+		if len(blocks) == 1 && binary.LittleEndian.Uint32(data[8:12]) != 0x1A2B3C4D {
+			t.Fatal("invalid byte order magic")
+		}
+		if len(blocks) == 2 && binary.LittleEndian.Uint16(data[8:10]) != 101 {
+			t.Fatal("invalid link type")
+		}
+		if len(blocks) == 3 {
+			ts := uint64(binary.LittleEndian.Uint32(data[12:16]))<<32 |
+				uint64(binary.LittleEndian.Uint32(data[16:20]))
+			if ts != 1588334400000000001 {
+				t.Fatal("invalid timestamp")
+			}
+			if binary.LittleEndian.Uint32(data[20:24]) != 20+8+3 {
+				t.Fatal("invalid captured length")
+			}
+		}
+		data = data[length:]
+	}
+	if len(blocks) != 3 || blocks[0] != 0x0A0D0D0A || blocks[1] != 1 || blocks[2] != 6 {
+		t.Fatal("unexpected blocks", blocks)
+	}
+}
+
+func TestUnitWriteFailure(t *testing.T) {
+	expected := errors.New("mocked error")
+	if err := pcapng.Write(failingWriter{err: expected}, nil); !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestIntegrationNewPacketsWithHTTPTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello, world"))
+		}))
+	defer server.Close()
+	saver := new(trace.Saver)
+	txp := httptransport.New(httptransport.Config{
+		DialSaver:      saver,
+		ReadWriteSaver: saver,
+	})
+	client := &http.Client{Transport: txp}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	client.CloseIdleConnections()
+	packets := pcapng.NewPackets(saver.Read())
+	if len(packets) < 5 {
+		t.Fatal("unexpected number of packets")
+	}
+	if parseTCP(t, packets[0]).flags != 0x02 {
+		t.Fatal("the first packet should be a SYN")
+	}
+	var found bool
+	for _, packet := range packets {
+		if bytes.Contains(packet.Data, []byte("hello, world")) {
+			found = true
+		}
+		if !validChecksum(packet) {
+			t.Fatal("invalid TCP checksum")
+		}
+	}
+	if !found {
+		t.Fatal("we did not capture the response body")
+	}
+}
+
+type failingWriter struct {
+	err error
+}
+
+func (w failingWriter) Write(b []byte) (int, error) {
+	return 0, w.err
+}
+
+type tcpSegment struct {
+	ack     uint32
+	dst     string
+	flags   byte
+	payload []byte
+	seq     uint32
+	src     string
+}
+
+func parseTCP(t *testing.T, packet pcapng.Packet) tcpSegment {
+	data := packet.Data
+	if len(data) < 40 || data[0] != 0x45 || data[9] != 6 {
+		t.Fatal("not an IPv4 TCP packet")
+	}
+	endpoint := func(ip, port []byte) string {
+		return net.JoinHostPort(net.IP(ip).String(),
+			strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	}
+	return tcpSegment{
+		ack:     binary.BigEndian.Uint32(data[28:32]),
+		dst:     endpoint(data[16:20], data[22:24]),
+		flags:   data[33],
+		payload: data[40:],
+		seq:     binary.BigEndian.Uint32(data[24:28]),
+		src:     endpoint(data[12:16], data[20:22]),
+	}
+}
+
+// validChecksum returns whether the transport checksum of a packet
+// whose payload we have fully captured is valid.
+func validChecksum(packet pcapng.Packet) bool {
+	data := packet.Data
+	var pseudo, segment []byte
+	if data[0]>>4 == 4 {
+		segment = data[20:]
+		pseudo = append(append([]byte(nil), data[12:20]...), 0, data[9], 0, 0)
+		binary.BigEndian.PutUint16(pseudo[10:12], uint16(len(segment)))
+	} else {
+		segment = data[40:]
+		pseudo = append([]byte(nil), data[8:40]...)
+		pseudo = append(pseudo, 0, 0, 0, 0, 0, 0, 0, data[6])
+		binary.BigEndian.PutUint32(pseudo[32:36], uint32(len(segment)))
+	}
+	var sum uint32
+	all := append(pseudo, segment...)
+	for ; len(all) >= 2; all = all[2:] {
+		sum += uint32(all[0])<<8 | uint32(all[1])
+	}
+	if len(all) > 0 {
+		sum += uint32(all[0]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return sum == 0xffff
+}
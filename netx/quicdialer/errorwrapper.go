@@ -0,0 +1,33 @@
+package quicdialer
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/ooni/probe-engine/netx/internal/dialid"
+	"github.com/ooni/probe-engine/netx/internal/errwrapper"
+	"github.com/quic-go/quic-go"
+)
+
+// ErrorWrapperDialer is a dialer that performs quic err wrapping
+type ErrorWrapperDialer struct {
+	Dialer ContextDialer
+}
+
+// DialContext implements ContextDialer.DialContext
+func (d ErrorWrapperDialer) DialContext(ctx context.Context, network, address string,
+	tlsConfig *tls.Config, quicConfig *quic.Config) (*quic.Conn, error) {
+	dialID := dialid.ContextDialID(ctx)
+	sess, err := d.Dialer.DialContext(ctx, network, address, tlsConfig, quicConfig)
+	err = errwrapper.SafeErrWrapperBuilder{
+		// ConnID does not make any sense if we've failed and the error
+		// does not make any sense (and is nil) if we succeded.
+		DialID:    dialID,
+		Error:     err,
+		Operation: "quic_handshake",
+	}.MaybeBuild()
+	if err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
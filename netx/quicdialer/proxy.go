@@ -0,0 +1,32 @@
+package quicdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/url"
+
+	"github.com/quic-go/quic-go"
+)
+
+// ErrProxyNotSupported indicates that we cannot use QUIC with a proxy,
+// because the proxies we support only relay TCP connections.
+var ErrProxyNotSupported = errors.New("quicdialer: cannot use QUIC with a proxy")
+
+// ProxyDialer is a dialer that refuses to dial when the ProxyURL is
+// configured. Otherwise, it is a passthrough for the next Dialer in chain.
+// Dialing without the proxy would bypass it and reveal our address to
+// the target, hence we prefer to fail.
+type ProxyDialer struct {
+	Dialer   ContextDialer
+	ProxyURL *url.URL
+}
+
+// DialContext implements ContextDialer.DialContext
+func (d ProxyDialer) DialContext(ctx context.Context, network, address string,
+	tlsConfig *tls.Config, quicConfig *quic.Config) (*quic.Conn, error) {
+	if d.ProxyURL != nil {
+		return nil, ErrProxyNotSupported
+	}
+	return d.Dialer.DialContext(ctx, network, address, tlsConfig, quicConfig)
+}
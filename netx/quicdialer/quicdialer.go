@@ -0,0 +1,71 @@
+// Package quicdialer contains QUIC dialer extensions. Here we define
+// dialers that perform the QUIC handshake and emit events, similarly to
+// what netx/dialer does for TCP connections and TLS handshakes.
+package quicdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+
+	"github.com/quic-go/quic-go"
+)
+
+// ContextDialer is the interface we expect from a QUIC dialer
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, address string,
+		tlsConfig *tls.Config, quicConfig *quic.Config) (*quic.Conn, error)
+}
+
+// Resolver is the interface we expect from a resolver
+type Resolver interface {
+	LookupHost(ctx context.Context, hostname string) (addrs []string, err error)
+}
+
+// SystemDialer is the basic QUIC dialer. It expects the address
+// to contain an IP address rather than a domain name.
+type SystemDialer struct{}
+
+// DialContext implements ContextDialer.DialContext
+func (d SystemDialer) DialContext(ctx context.Context, network, address string,
+	tlsConfig *tls.Config, quicConfig *quic.Config) (*quic.Conn, error) {
+	onlyhost, onlyport, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(onlyport)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(onlyhost)
+	if ip == nil {
+		return nil, &net.AddrError{Err: "not an IP address", Addr: onlyhost}
+	}
+	// Use the unspecified address such that the kernel binds the
+	// socket to the address family required to reach the target.
+	pconn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	raddr := &net.UDPAddr{IP: ip, Port: port}
+	sess, err := quic.DialEarly(ctx, pconn, raddr, tlsConfig, quicConfig)
+	if err != nil {
+		// when dialing with a net.PacketConn, quic-go does
+		// not close the connection if the handshake fails
+		pconn.Close()
+		return nil, err
+	}
+	// for the same reason, we close the connection
+	// when the session is closed
+	go func() {
+		<-sess.Context().Done()
+		pconn.Close()
+	}()
+	return sess, nil
+}
+
+// connectionState returns the TLS connection state of the session.
+func connectionState(sess *quic.Conn) tls.ConnectionState {
+	return sess.ConnectionState().TLS
+}
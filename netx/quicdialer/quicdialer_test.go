@@ -0,0 +1,220 @@
+package quicdialer_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/netx/internal/dialid"
+	"github.com/ooni/probe-engine/netx/modelx"
+	"github.com/ooni/probe-engine/netx/quicdialer"
+	"github.com/ooni/probe-engine/netx/trace"
+	"github.com/quic-go/quic-go"
+)
+
+type FakeDialer struct {
+	Err error
+
+	Address   string
+	TLSConfig *tls.Config
+}
+
+func (d *FakeDialer) DialContext(ctx context.Context, network, address string,
+	tlsConfig *tls.Config, quicConfig *quic.Config) (*quic.Conn, error) {
+	d.Address, d.TLSConfig = address, tlsConfig
+	return nil, d.Err
+}
+
+type FakeResolver struct {
+	Addrs []string
+	Err   error
+}
+
+func (r FakeResolver) LookupHost(ctx context.Context, hostname string) ([]string, error) {
+	return r.Addrs, r.Err
+}
+
+func TestUnitSystemDialerInvalidAddress(t *testing.T) {
+	for _, address := range []string{"www.google.com", "www.google.com:443", "8.8.8.8:antani"} {
+		sess, err := quicdialer.SystemDialer{}.DialContext(
+			context.Background(), "udp", address, &tls.Config{}, &quic.Config{})
+		if err == nil {
+			t.Fatalf("%s: expected an error here", address)
+		}
+		if sess != nil {
+			t.Fatalf("%s: expected nil session here", address)
+		}
+	}
+}
+
+func TestIntegrationSystemDialerCancelledContext(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip test in short mode")
+	}
+	// a UDP socket that never answers to the QUIC handshake
+	pconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pconn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	sess, err := quicdialer.SystemDialer{}.DialContext(
+		ctx, "udp", pconn.LocalAddr().String(), &tls.Config{}, &quic.Config{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("not the error we expected", err)
+	}
+	if sess != nil {
+		t.Fatal("expected nil session here")
+	}
+}
+
+func TestUnitDNSDialerLookupFailure(t *testing.T) {
+	expected := errors.New("mocked error")
+	dialer := &FakeDialer{}
+	d := quicdialer.DNSDialer{Dialer: dialer, Resolver: FakeResolver{Err: expected}}
+	sess, err := d.DialContext(
+		context.Background(), "udp", "www.google.com:443", nil, &quic.Config{})
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+	if sess != nil {
+		t.Fatal("expected nil session here")
+	}
+}
+
+func TestUnitDNSDialerSetsServerName(t *testing.T) {
+	dialer := &FakeDialer{Err: io.EOF}
+	d := quicdialer.DNSDialer{
+		Dialer:   dialer,
+		Resolver: FakeResolver{Addrs: []string{"8.8.8.8", "8.8.4.4"}},
+	}
+	tlsConfig := &tls.Config{NextProtos: []string{"h3"}}
+	_, err := d.DialContext(
+		context.Background(), "udp", "dns.google:443", tlsConfig, &quic.Config{})
+	if !errors.Is(err, io.EOF) {
+		t.Fatal("not the error we expected")
+	}
+	if dialer.Address != "8.8.4.4:443" {
+		t.Fatal("we did not try all the addresses")
+	}
+	if dialer.TLSConfig.ServerName != "dns.google" {
+		t.Fatal("we did not set the ServerName")
+	}
+	if tlsConfig.ServerName != "" {
+		t.Fatal("we modified the original config")
+	}
+}
+
+func TestUnitErrorWrapperFailure(t *testing.T) {
+	ctx := dialid.WithDialID(context.Background())
+	d := quicdialer.ErrorWrapperDialer{Dialer: &FakeDialer{Err: io.EOF}}
+	sess, err := d.DialContext(ctx, "udp", "8.8.8.8:443", &tls.Config{}, &quic.Config{})
+	if sess != nil {
+		t.Fatal("expected a nil session here")
+	}
+	var errWrapper *modelx.ErrWrapper
+	if !errors.As(err, &errWrapper) {
+		t.Fatal("cannot cast to ErrWrapper")
+	}
+	if errWrapper.DialID == 0 {
+		t.Fatal("unexpected DialID")
+	}
+	if errWrapper.Operation != "quic_handshake" {
+		t.Fatal("unexpected Operation")
+	}
+	if errWrapper.Failure != modelx.FailureEOFError {
+		t.Fatal("unexpected failure")
+	}
+}
+
+func TestUnitProxyDialerNoProxyURL(t *testing.T) {
+	dialer := &FakeDialer{Err: io.EOF}
+	d := quicdialer.ProxyDialer{Dialer: dialer}
+	sess, err := d.DialContext(
+		context.Background(), "udp", "8.8.8.8:443", &tls.Config{}, &quic.Config{})
+	if !errors.Is(err, io.EOF) {
+		t.Fatal("not the error we expected")
+	}
+	if sess != nil {
+		t.Fatal("expected nil session here")
+	}
+	if dialer.Address != "8.8.8.8:443" {
+		t.Fatal("we did not call the underlying dialer")
+	}
+}
+
+func TestUnitProxyDialerWithProxyURL(t *testing.T) {
+	dialer := &FakeDialer{Err: io.EOF}
+	d := quicdialer.ProxyDialer{
+		Dialer:   dialer,
+		ProxyURL: &url.URL{Scheme: "socks5", Host: "127.0.0.1:9050"},
+	}
+	sess, err := d.DialContext(
+		context.Background(), "udp", "8.8.8.8:443", &tls.Config{}, &quic.Config{})
+	if !errors.Is(err, quicdialer.ErrProxyNotSupported) {
+		t.Fatal("not the error we expected")
+	}
+	if sess != nil {
+		t.Fatal("expected nil session here")
+	}
+	if dialer.Address != "" {
+		t.Fatal("we called the underlying dialer")
+	}
+}
+
+func TestUnitHandshakeSaverFailure(t *testing.T) {
+	saver := new(trace.Saver)
+	d := quicdialer.HandshakeSaver{Dialer: &FakeDialer{Err: io.EOF}, Saver: saver}
+	_, err := d.DialContext(context.Background(), "udp", "8.8.8.8:443", &tls.Config{
+		NextProtos: []string{"h3"},
+		ServerName: "dns.google",
+	}, &quic.Config{})
+	if !errors.Is(err, io.EOF) {
+		t.Fatal("not the error we expected")
+	}
+	events := saver.Read()
+	if len(events) != 2 {
+		t.Fatal("unexpected number of events")
+	}
+	if events[0].Name != "quic_handshake_start" || events[1].Name != "quic_handshake_done" {
+		t.Fatal("unexpected event names")
+	}
+	if !errors.Is(events[1].Err, io.EOF) {
+		t.Fatal("unexpected Err")
+	}
+	if events[1].TLSServerName != "dns.google" || events[1].Address != "8.8.8.8:443" {
+		t.Fatal("unexpected event fields")
+	}
+}
+
+func TestIntegrationHandshakeSaverSuccess(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	saver := new(trace.Saver)
+	d := quicdialer.HandshakeSaver{Dialer: quicdialer.SystemDialer{}, Saver: saver}
+	sess, err := d.DialContext(context.Background(), "udp", "216.58.212.164:443", &tls.Config{
+		NextProtos: []string{"h3"},
+		ServerName: "www.google.com",
+	}, &quic.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.CloseWithError(0, "")
+	events := saver.Read()
+	if len(events) != 2 {
+		t.Fatal("unexpected number of events")
+	}
+	if events[1].TLSNegotiatedProto != "h3" {
+		t.Fatal("unexpected negotiated protocol")
+	}
+	if len(events[1].TLSPeerCerts) <= 0 {
+		t.Fatal("expected some peer certificates")
+	}
+}
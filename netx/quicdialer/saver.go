@@ -0,0 +1,67 @@
+package quicdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+
+	"github.com/ooni/probe-engine/internal/tlsx"
+	"github.com/ooni/probe-engine/netx/trace"
+	"github.com/quic-go/quic-go"
+)
+
+// HandshakeSaver saves events occurring during the QUIC handshake
+type HandshakeSaver struct {
+	Dialer ContextDialer
+	Saver  *trace.Saver
+}
+
+// DialContext implements ContextDialer.DialContext
+func (d HandshakeSaver) DialContext(ctx context.Context, network, address string,
+	tlsConfig *tls.Config, quicConfig *quic.Config) (*quic.Conn, error) {
+	if tlsConfig == nil {
+		tlsConfig = new(tls.Config)
+	}
+	start := time.Now()
+	d.Saver.Write(trace.Event{
+		Address:       address,
+		Name:          "quic_handshake_start",
+		NoTLSVerify:   tlsConfig.InsecureSkipVerify,
+		Proto:         network,
+		TLSNextProtos: tlsConfig.NextProtos,
+		TLSServerName: tlsConfig.ServerName,
+		Time:          start,
+	})
+	sess, err := d.Dialer.DialContext(ctx, network, address, tlsConfig, quicConfig)
+	stop := time.Now()
+	if err != nil {
+		d.Saver.Write(trace.Event{
+			Address:       address,
+			Duration:      stop.Sub(start),
+			Err:           err,
+			Name:          "quic_handshake_done",
+			NoTLSVerify:   tlsConfig.InsecureSkipVerify,
+			Proto:         network,
+			TLSNextProtos: tlsConfig.NextProtos,
+			TLSServerName: tlsConfig.ServerName,
+			Time:          stop,
+		})
+		return nil, err
+	}
+	state := connectionState(sess)
+	d.Saver.Write(trace.Event{
+		Address:            address,
+		Duration:           stop.Sub(start),
+		Name:               "quic_handshake_done",
+		NoTLSVerify:        tlsConfig.InsecureSkipVerify,
+		Proto:              network,
+		TLSCipherSuite:     tlsx.CipherSuiteString(state.CipherSuite),
+		TLSNegotiatedProto: state.NegotiatedProtocol,
+		TLSNextProtos:      tlsConfig.NextProtos,
+		TLSPeerCerts:       state.PeerCertificates,
+		TLSServerName:      tlsConfig.ServerName,
+		TLSVersion:         tlsx.VersionString(state.Version),
+		Time:               stop,
+	})
+	return sess, nil
+}
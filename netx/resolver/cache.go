@@ -1,47 +1,221 @@
 package resolver
 
 import (
+	"container/list"
 	"context"
+	"encoding/json"
+	"errors"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
 )
 
+// DefaultCacheTTL is the TTL we use when the underlying resolver is
+// not able to tell us the TTL of the addresses (e.g. SystemResolver).
+const DefaultCacheTTL = 5 * time.Minute
+
+// CacheKVStoreKey is the key we use to persist the cache.
+const CacheKVStoreKey = "dnscache.state"
+
+// errCachedNXDOMAIN is returned when we have cached a NXDOMAIN reply. The
+// error string is such that errwrapper classifies it as NXDOMAIN.
+var errCachedNXDOMAIN = errors.New("ooniresolver: no such host")
+
 // CacheResolver is a resolver that caches successful replies.
+//
+// We honour the TTL of the replies when the underlying resolver has
+// a LookupHostWithTTL method, otherwise we use DefaultCacheTTL. The
+// entries added using Set never expire.
+//
+// When NegativeTTL is positive, we cache NXDOMAIN replies for at most
+// NegativeTTL. When MaxEntries is positive, we evict the least recently
+// used entries to keep the cache size below MaxEntries. When KVStore is
+// not nil, we load the cache from it and Save persists the cache into
+// it, so that a CacheResolver using the same KVStore after a restart
+// starts with a warm cache.
 type CacheResolver struct {
-	ReadOnly bool
+	KVStore     model.KeyValueStore
+	MaxEntries  int
+	NegativeTTL time.Duration
+	ReadOnly    bool
 	Resolver
-	mu    sync.Mutex
-	cache map[string][]string
+	mu     sync.Mutex
+	cache  map[string]*list.Element
+	lru    *list.List
+	loaded bool
+	dirty  bool
+}
+
+// cacheEntry is an entry in the cache. A zero Expires means that
+// the entry does not expire. We also use this structure to persist
+// the cache into the key-value store.
+type cacheEntry struct {
+	Addresses []string  `json:"addresses,omitempty"`
+	Domain    string    `json:"domain"`
+	Expires   time.Time `json:"expires"`
+	NXDOMAIN  bool      `json:"nxdomain,omitempty"`
+}
+
+func (e *cacheEntry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && now.After(e.Expires)
 }
 
 // LookupHost implements Resolver.LookupHost
 func (r *CacheResolver) LookupHost(
 	ctx context.Context, hostname string) ([]string, error) {
-	if entry := r.Get(hostname); entry != nil {
-		return entry, nil
+	if entry := r.get(hostname); entry != nil {
+		if entry.NXDOMAIN {
+			return nil, errCachedNXDOMAIN
+		}
+		return entry.Addresses, nil
 	}
-	entry, err := r.Resolver.LookupHost(ctx, hostname)
+	addrs, ttl, err := r.lookupHostWithTTL(ctx, hostname)
 	if err != nil {
+		if r.ReadOnly == false && r.NegativeTTL > 0 && isNXDOMAIN(err) {
+			r.set(cacheEntry{
+				Domain:   hostname,
+				Expires:  time.Now().Add(r.NegativeTTL),
+				NXDOMAIN: true,
+			})
+		}
 		return nil, err
 	}
-	if r.ReadOnly == false {
-		r.Set(hostname, entry)
+	if r.ReadOnly == false && ttl > 0 {
+		r.set(cacheEntry{
+			Addresses: addrs,
+			Domain:    hostname,
+			Expires:   time.Now().Add(ttl),
+		})
+	}
+	return addrs, nil
+}
+
+func (r *CacheResolver) lookupHostWithTTL(
+	ctx context.Context, hostname string) ([]string, time.Duration, error) {
+	type ttlResolver interface {
+		LookupHostWithTTL(ctx context.Context, hostname string) ([]string, time.Duration, error)
+	}
+	if tr, ok := r.Resolver.(ttlResolver); ok {
+		return tr.LookupHostWithTTL(ctx, hostname)
 	}
-	return entry, nil
+	addrs, err := r.Resolver.LookupHost(ctx, hostname)
+	return addrs, DefaultCacheTTL, err
+}
+
+func isNXDOMAIN(err error) bool {
+	return strings.HasSuffix(err.Error(), "no such host")
 }
 
 // Get gets the currently configured entry for domain, or nil
 func (r *CacheResolver) Get(domain string) []string {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	return r.cache[domain]
+	if entry := r.get(domain); entry != nil {
+		return entry.Addresses
+	}
+	return nil
 }
 
 // Set allows to pre-populate the cache
 func (r *CacheResolver) Set(domain string, addresses []string) {
+	r.set(cacheEntry{Addresses: addresses, Domain: domain})
+}
+
+func (r *CacheResolver) get(domain string) *cacheEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.maybeLoad()
+	elem, found := r.cache[domain]
+	if !found {
+		return nil
+	}
+	entry := elem.Value.(*cacheEntry)
+	if entry.expired(time.Now()) {
+		r.lru.Remove(elem)
+		delete(r.cache, domain)
+		r.dirty = true
+		return nil
+	}
+	r.lru.MoveToFront(elem)
+	return entry
+}
+
+func (r *CacheResolver) set(entry cacheEntry) {
 	r.mu.Lock()
-	if r.cache == nil {
-		r.cache = make(map[string][]string)
+	defer r.mu.Unlock()
+	r.maybeLoad()
+	r.insert(&entry)
+	r.dirty = true
+}
+
+// insert inserts or replaces entry and evicts the least recently used
+// entries when needed. This method assumes we're holding the mutex.
+func (r *CacheResolver) insert(entry *cacheEntry) {
+	if elem, found := r.cache[entry.Domain]; found {
+		elem.Value = entry
+		r.lru.MoveToFront(elem)
+		return
+	}
+	r.cache[entry.Domain] = r.lru.PushFront(entry)
+	for r.MaxEntries > 0 && r.lru.Len() > r.MaxEntries {
+		elem := r.lru.Back()
+		r.lru.Remove(elem)
+		delete(r.cache, elem.Value.(*cacheEntry).Domain)
+	}
+}
+
+// maybeLoad initializes the cache and loads the persisted entries, if
+// any. This method assumes we're holding the mutex.
+func (r *CacheResolver) maybeLoad() {
+	if r.loaded {
+		return
+	}
+	r.loaded = true
+	r.cache = make(map[string]*list.Element)
+	r.lru = list.New()
+	if r.KVStore == nil {
+		return
+	}
+	data, err := r.KVStore.Get(CacheKVStoreKey)
+	if err != nil {
+		return // most likely there is no persisted cache yet
+	}
+	var entries []*cacheEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return // the cache is just an optimization
+	}
+	now := time.Now()
+	// The entries are persisted from the most to the least recently used,
+	// hence we insert them in reverse order to restore the LRU order.
+	for idx := len(entries) - 1; idx >= 0; idx-- {
+		if entries[idx] == nil || entries[idx].expired(now) {
+			continue
+		}
+		r.insert(entries[idx])
+	}
+}
+
+// Save persists the cache into the KVStore, if the cache has changed
+// since we last saved it. We do not persist the cache on every change,
+// because that would mean rewriting it on most lookups, hence you
+// should call Save when you are done using the resolver.
+func (r *CacheResolver) Save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.KVStore == nil || r.dirty == false {
+		return nil
+	}
+	var entries []*cacheEntry
+	for elem := r.lru.Front(); elem != nil; elem = elem.Next() {
+		entries = append(entries, elem.Value.(*cacheEntry))
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err := r.KVStore.Set(CacheKVStoreKey, data); err != nil {
+		return err
 	}
-	r.cache[domain] = addresses
-	r.mu.Unlock()
+	r.dirty = false
+	return nil
 }
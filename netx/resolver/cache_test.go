@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
+	"github.com/ooni/probe-engine/atomicx"
+	"github.com/ooni/probe-engine/internal/kvstore"
 	"github.com/ooni/probe-engine/netx/resolver"
 )
 
@@ -74,3 +77,181 @@ func TestUnitCacheReadonlySuccess(t *testing.T) {
 		t.Fatal("expected empty cache here")
 	}
 }
+
+type FakeTTLResolver struct {
+	resolver.FakeResolver
+	TTL time.Duration
+}
+
+func (r FakeTTLResolver) LookupHostWithTTL(
+	ctx context.Context, hostname string) ([]string, time.Duration, error) {
+	addrs, err := r.LookupHost(ctx, hostname)
+	return addrs, r.TTL, err
+}
+
+func TestUnitCacheHonoursTTL(t *testing.T) {
+	r := FakeTTLResolver{
+		FakeResolver: resolver.NewFakeResolverWithResult([]string{"8.8.8.8"}),
+		TTL:          10 * time.Millisecond,
+	}
+	cache := &resolver.CacheResolver{Resolver: r}
+	if _, err := cache.LookupHost(context.Background(), "dns.google.com"); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Get("dns.google.com") == nil {
+		t.Fatal("expected full cache here")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if cache.Get("dns.google.com") != nil {
+		t.Fatal("expected the entry to be expired")
+	}
+}
+
+func TestUnitCacheDoesNotCacheZeroTTL(t *testing.T) {
+	r := FakeTTLResolver{
+		FakeResolver: resolver.NewFakeResolverWithResult([]string{"8.8.8.8"}),
+	}
+	cache := &resolver.CacheResolver{Resolver: r}
+	if _, err := cache.LookupHost(context.Background(), "dns.google.com"); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Get("dns.google.com") != nil {
+		t.Fatal("expected empty cache here")
+	}
+}
+
+func TestUnitCacheNegativeCaching(t *testing.T) {
+	r := resolver.FakeResolver{
+		NumFailures: atomicx.NewInt64(),
+		Err:         errors.New("ooniresolver: no such host"),
+	}
+	cache := &resolver.CacheResolver{Resolver: r, NegativeTTL: time.Minute}
+	for i := 0; i < 2; i++ {
+		addrs, err := cache.LookupHost(context.Background(), "antani.ooni.io")
+		if err == nil || err.Error() != "ooniresolver: no such host" {
+			t.Fatal("not the error we expected")
+		}
+		if addrs != nil {
+			t.Fatal("expected nil addrs here")
+		}
+	}
+	if r.NumFailures.Load() != 1 {
+		t.Fatal("expected the NXDOMAIN reply to be cached")
+	}
+	if cache.Get("antani.ooni.io") != nil {
+		t.Fatal("expected no addresses for a negative entry")
+	}
+}
+
+func TestUnitCacheNoNegativeCachingByDefault(t *testing.T) {
+	r := resolver.FakeResolver{
+		NumFailures: atomicx.NewInt64(),
+		Err:         errors.New("ooniresolver: no such host"),
+	}
+	cache := &resolver.CacheResolver{Resolver: r}
+	for i := 0; i < 2; i++ {
+		if _, err := cache.LookupHost(context.Background(), "antani.ooni.io"); err == nil {
+			t.Fatal("expected an error here")
+		}
+	}
+	if r.NumFailures.Load() != 2 {
+		t.Fatal("expected the NXDOMAIN reply not to be cached")
+	}
+}
+
+func TestUnitCacheLRUEviction(t *testing.T) {
+	cache := &resolver.CacheResolver{MaxEntries: 2}
+	cache.Set("a.example.com", []string{"10.0.0.1"})
+	cache.Set("b.example.com", []string{"10.0.0.2"})
+	if cache.Get("a.example.com") == nil {
+		t.Fatal("expected a.example.com in cache")
+	}
+	cache.Set("c.example.com", []string{"10.0.0.3"})
+	if cache.Get("b.example.com") != nil {
+		t.Fatal("expected b.example.com to be evicted")
+	}
+	if cache.Get("a.example.com") == nil || cache.Get("c.example.com") == nil {
+		t.Fatal("expected a.example.com and c.example.com in cache")
+	}
+}
+
+func TestUnitCachePersistence(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	r := FakeTTLResolver{
+		FakeResolver: resolver.NewFakeResolverWithResult([]string{"8.8.8.8"}),
+		TTL:          time.Hour,
+	}
+	cache := &resolver.CacheResolver{KVStore: kvs, Resolver: r}
+	if _, err := cache.LookupHost(context.Background(), "dns.google.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kvs.Get(resolver.CacheKVStoreKey); err == nil {
+		t.Fatal("expected the cache to be persisted only by Save")
+	}
+	if err := cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+	restarted := &resolver.CacheResolver{
+		KVStore:  kvs,
+		Resolver: resolver.NewFakeResolverThatFails(),
+	}
+	addrs, err := restarted.LookupHost(context.Background(), "dns.google.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "8.8.8.8" {
+		t.Fatal("not the result we expected")
+	}
+}
+
+func TestUnitCachePersistenceSkipsExpired(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	r := FakeTTLResolver{
+		FakeResolver: resolver.NewFakeResolverWithResult([]string{"8.8.8.8"}),
+		TTL:          10 * time.Millisecond,
+	}
+	cache := &resolver.CacheResolver{KVStore: kvs, Resolver: r}
+	if _, err := cache.LookupHost(context.Background(), "dns.google.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	restarted := &resolver.CacheResolver{KVStore: kvs}
+	if restarted.Get("dns.google.com") != nil {
+		t.Fatal("expected empty cache here")
+	}
+}
+
+func TestUnitCacheInvalidPersistedState(t *testing.T) {
+	kvs := kvstore.NewMemoryKeyValueStore()
+	if err := kvs.Set(resolver.CacheKVStoreKey, []byte("{")); err != nil {
+		t.Fatal(err)
+	}
+	cache := &resolver.CacheResolver{KVStore: kvs}
+	if cache.Get("dns.google.com") != nil {
+		t.Fatal("expected empty cache here")
+	}
+}
+
+func TestUnitCacheSaveFailure(t *testing.T) {
+	expected := errors.New("mocked error")
+	cache := &resolver.CacheResolver{KVStore: failingKVStore{err: expected}}
+	cache.Set("dns.google.com", []string{"8.8.8.8"})
+	if err := cache.Save(); !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+type failingKVStore struct {
+	err error
+}
+
+func (kvs failingKVStore) Get(key string) ([]byte, error) {
+	return nil, kvs.err
+}
+
+func (kvs failingKVStore) Set(key string, value []byte) error {
+	return kvs.err
+}
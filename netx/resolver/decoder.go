@@ -13,42 +13,40 @@ type Decoder interface {
 	Decode(qtype uint16, data []byte) ([]string, error)
 }
 
+// The ReplyDecoder decodes all the supported answers of a DNS reply along
+// with the reply flags and rcode. It does not fail if the rcode is not
+// successful, since the caller may want to inspect it. When a Decoder is
+// also a ReplyDecoder, the SerialResolver uses DecodeReply to parse each
+// reply just once and to learn the TTL of the answers.
+type ReplyDecoder interface {
+	DecodeReply(data []byte) (DNSReply, error)
+}
+
 // MiekgDecoder uses github.com/miekg/dns to implement the Decoder.
 type MiekgDecoder struct{}
 
 // Decode implements Decoder.Decode.
 func (d MiekgDecoder) Decode(qtype uint16, data []byte) ([]string, error) {
-	reply := new(dns.Msg)
-	if err := reply.Unpack(data); err != nil {
+	reply, err := d.DecodeReply(data)
+	if err != nil {
 		return nil, err
 	}
-	// TODO(bassosimone): map more errors to net.DNSError names
-	switch reply.Rcode {
+	return reply.Addresses(qtype)
+}
+
+// rcodeToError maps an unsuccessful rcode to an error.
+func rcodeToError(rcode int) error {
+	switch rcode {
 	case dns.RcodeSuccess:
+		return nil
 	case dns.RcodeNameError:
-		return nil, errors.New("ooniresolver: no such host")
+		return errors.New("ooniresolver: no such host")
 	default:
-		return nil, errors.New("ooniresolver: query failed")
+		return errors.New("ooniresolver: query failed")
 	}
-	var addrs []string
-	for _, answer := range reply.Answer {
-		switch qtype {
-		case dns.TypeA:
-			if rra, ok := answer.(*dns.A); ok {
-				ip := rra.A
-				addrs = append(addrs, ip.String())
-			}
-		case dns.TypeAAAA:
-			if rra, ok := answer.(*dns.AAAA); ok {
-				ip := rra.AAAA
-				addrs = append(addrs, ip.String())
-			}
-		}
-	}
-	if len(addrs) <= 0 {
-		return nil, errors.New("ooniresolver: no response returned")
-	}
-	return addrs, nil
 }
 
-var _ Decoder = MiekgDecoder{}
+var (
+	_ Decoder      = MiekgDecoder{}
+	_ ReplyDecoder = MiekgDecoder{}
+)
@@ -1,6 +1,8 @@
 package resolver_test
 
 import (
+	"encoding/hex"
+	"net"
 	"strings"
 	"testing"
 
@@ -111,3 +113,142 @@ func TestUnitDecoderUnexpectedAAAAReply(t *testing.T) {
 		t.Fatal("expected nil data here")
 	}
 }
+
+func TestUnitDecoderDecodeReplyUnpackError(t *testing.T) {
+	d := resolver.MiekgDecoder{}
+	reply, err := d.DecodeReply(nil)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if reply.Answers != nil {
+		t.Fatal("expected nil answers here")
+	}
+}
+
+func TestUnitDecoderDecodeReplyNXDOMAIN(t *testing.T) {
+	d := resolver.MiekgDecoder{}
+	reply, err := d.DecodeReply(resolver.GenReplyError(t, dns.RcodeNameError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Rcode != dns.RcodeNameError || reply.RcodeString() != "NXDOMAIN" {
+		t.Fatal("unexpected rcode")
+	}
+	if !reply.Flags.RecursionAvailable {
+		t.Fatal("unexpected flags")
+	}
+}
+
+func TestUnitDecoderDecodeReplyCNAME(t *testing.T) {
+	d := resolver.MiekgDecoder{}
+	reply, err := d.DecodeReply(resolver.GenReplyWithRecords(t, dns.TypeA, &dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name: "x.org.", Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 300,
+		},
+		Target: "blockpage.example.",
+	}, &dns.A{
+		Hdr: dns.RR_Header{
+			Name: "blockpage.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60,
+		},
+		A: net.ParseIP("10.0.0.1"),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Answers) != 2 {
+		t.Fatal("unexpected number of answers")
+	}
+	if reply.Answers[0].Type != "CNAME" || reply.Answers[0].Hostname != "blockpage.example." {
+		t.Fatal("unexpected CNAME answer")
+	}
+	if reply.Answers[0].TTL != 300 || reply.Answers[0].Name != "x.org." {
+		t.Fatal("unexpected CNAME header")
+	}
+	if reply.Answers[1].Type != "A" || reply.Answers[1].IPv4 != "10.0.0.1" {
+		t.Fatal("unexpected A answer")
+	}
+}
+
+func TestUnitDecoderDecodeReplyMXNSTXT(t *testing.T) {
+	d := resolver.MiekgDecoder{}
+	reply, err := d.DecodeReply(resolver.GenReplyWithRecords(t, dns.TypeANY, &dns.MX{
+		Hdr:        dns.RR_Header{Name: "x.org.", Rrtype: dns.TypeMX, Class: dns.ClassINET},
+		Mx:         "mx.x.org.",
+		Preference: 10,
+	}, &dns.NS{
+		Hdr: dns.RR_Header{Name: "x.org.", Rrtype: dns.TypeNS, Class: dns.ClassINET},
+		Ns:  "ns1.x.org.",
+	}, &dns.TXT{
+		Hdr: dns.RR_Header{Name: "x.org.", Rrtype: dns.TypeTXT, Class: dns.ClassINET},
+		Txt: []string{"v=spf1 -all"},
+	}, &dns.SOA{
+		Hdr:  dns.RR_Header{Name: "x.org.", Rrtype: dns.TypeSOA, Class: dns.ClassINET},
+		Ns:   "ns1.x.org.",
+		Mbox: "root.x.org.",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Answers) != 3 {
+		t.Fatal("unexpected number of answers")
+	}
+	if reply.Answers[0].Hostname != "mx.x.org." || reply.Answers[0].Preference != 10 {
+		t.Fatal("unexpected MX answer")
+	}
+	if reply.Answers[1].Type != "NS" || reply.Answers[1].Hostname != "ns1.x.org." {
+		t.Fatal("unexpected NS answer")
+	}
+	if len(reply.Answers[2].TXT) != 1 || reply.Answers[2].TXT[0] != "v=spf1 -all" {
+		t.Fatal("unexpected TXT answer")
+	}
+}
+
+func TestUnitDecoderDecodeReplyHTTPS(t *testing.T) {
+	rdata := []byte{
+		0, 1, // priority
+		0,                       // target name (root)
+		0, 1, 0, 3, 2, 'h', '2', // alpn=h2
+		0, 4, 0, 4, 1, 1, 1, 1, // ipv4hint=1.1.1.1
+		0, 6, 0, 16, 0x26, 0x06, 0x47, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x11, 0x11,
+	}
+	d := resolver.MiekgDecoder{}
+	reply, err := d.DecodeReply(resolver.GenReplyWithRecords(t, resolver.TypeHTTPS, &dns.RFC3597{
+		Hdr: dns.RR_Header{
+			Name: "x.org.", Rrtype: resolver.TypeHTTPS, Class: dns.ClassINET, Ttl: 10,
+		},
+		Rdata: hex.EncodeToString(rdata),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Answers) != 1 {
+		t.Fatal("unexpected number of answers")
+	}
+	answer := reply.Answers[0]
+	if answer.Type != "HTTPS" || answer.Priority != 1 || answer.Hostname != "." {
+		t.Fatal("unexpected HTTPS answer")
+	}
+	if len(answer.ALPN) != 1 || answer.ALPN[0] != "h2" {
+		t.Fatal("unexpected ALPN")
+	}
+	if len(answer.IPv4Hint) != 1 || answer.IPv4Hint[0] != "1.1.1.1" {
+		t.Fatal("unexpected IPv4Hint")
+	}
+	if len(answer.IPv6Hint) != 1 || answer.IPv6Hint[0] != "2606:4700::1111" {
+		t.Fatal("unexpected IPv6Hint")
+	}
+}
+
+func TestUnitDecoderDecodeReplyInvalidHTTPS(t *testing.T) {
+	d := resolver.MiekgDecoder{}
+	reply, err := d.DecodeReply(resolver.GenReplyWithRecords(t, resolver.TypeHTTPS, &dns.RFC3597{
+		Hdr:   dns.RR_Header{Name: "x.org.", Rrtype: resolver.TypeHTTPS, Class: dns.ClassINET},
+		Rdata: "0001000001", // truncated parameter
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Answers) != 0 {
+		t.Fatal("expected no answers here")
+	}
+}
@@ -3,6 +3,7 @@ package resolver
 import (
 	"context"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -12,9 +13,18 @@ type Dialer interface {
 }
 
 // DNSOverUDP is a DNS over UDP RoundTripper.
+//
+// When the collection window is positive, after receiving the first
+// reply we keep the socket open for the duration of the window and we
+// collect all the other replies. On-path injectors race with the real
+// resolver, so the legitimate reply may be one of the late replies. Use
+// RoundTripCollect to obtain all the replies. RoundTrip, instead, only
+// returns the first reply, as it happens with a zero window.
 type DNSOverUDP struct {
 	dialer  Dialer
 	address string
+	pending *sync.WaitGroup
+	window  time.Duration
 }
 
 // NewDNSOverUDP creates a DNSOverUDP instance.
@@ -22,28 +32,112 @@ func NewDNSOverUDP(dialer Dialer, address string) DNSOverUDP {
 	return DNSOverUDP{dialer: dialer, address: address}
 }
 
+// NewDNSOverUDPWithWindow creates a DNSOverUDP instance that collects
+// all the replies received within window after the first reply.
+func NewDNSOverUDPWithWindow(
+	dialer Dialer, address string, window time.Duration) DNSOverUDP {
+	return DNSOverUDP{
+		dialer:  dialer,
+		address: address,
+		pending: new(sync.WaitGroup),
+		window:  window,
+	}
+}
+
+// UDPReply is a reply received by DNSOverUDP.
+type UDPReply struct {
+	Data []byte
+	Time time.Time
+}
+
 // RoundTrip implements RoundTripper.RoundTrip.
 func (t DNSOverUDP) RoundTrip(ctx context.Context, query []byte) ([]byte, error) {
-	conn, err := t.dialer.DialContext(ctx, "udp", t.address)
+	reply, err := t.RoundTripCollect(ctx, query, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
+	return reply.Data, nil
+}
+
+// RoundTripCollect is like RoundTrip but also collects the late replies. We
+// return the first reply as soon as we receive it. Then, if the window is
+// positive and onLateReply is not nil, we keep reading from the socket in
+// the background and we call onLateReply for each late reply, until the
+// window expires or the context is done. Use WaitLateReplies to wait for
+// the background collection of late replies to terminate.
+func (t DNSOverUDP) RoundTripCollect(
+	ctx context.Context, query []byte, onLateReply func(UDPReply)) (UDPReply, error) {
+	conn, err := t.dialer.DialContext(ctx, "udp", t.address)
+	if err != nil {
+		return UDPReply{}, err
+	}
+	reply, err := t.roundTrip(conn, query)
+	if err != nil || t.window <= 0 || onLateReply == nil {
+		conn.Close()
+		return reply, err
+	}
+	t.pending.Add(1)
+	go t.collectLateReplies(ctx, conn, onLateReply)
+	return reply, nil
+}
+
+func (t DNSOverUDP) roundTrip(conn net.Conn, query []byte) (UDPReply, error) {
 	// Use five seconds timeout like Bionic does. See
 	// https://labs.ripe.net/Members/baptiste_jonglez_1/persistent-dns-connections-for-reliability-and-performance
-	if err = conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
-		return nil, err
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return UDPReply{}, err
 	}
-	if _, err = conn.Write(query); err != nil {
-		return nil, err
+	if _, err := conn.Write(query); err != nil {
+		return UDPReply{}, err
 	}
 	reply := make([]byte, 1<<17)
-	var n int
-	n, err = conn.Read(reply)
+	n, err := conn.Read(reply)
 	if err != nil {
-		return nil, err
+		return UDPReply{}, err
+	}
+	return UDPReply{Data: reply[:n], Time: time.Now()}, nil
+}
+
+// collectLateReplies reads late replies from conn until the window
+// expires or ctx is done. It takes ownership of conn.
+func (t DNSOverUDP) collectLateReplies(
+	ctx context.Context, conn net.Conn, onLateReply func(UDPReply)) {
+	defer t.pending.Done()
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(t.window)); err != nil {
+		return
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now()) // interrupt the pending read
+		case <-done:
+		}
+	}()
+	for {
+		reply := make([]byte, 1<<17)
+		n, err := conn.Read(reply)
+		if err != nil {
+			return // most likely the window has expired
+		}
+		onLateReply(UDPReply{Data: reply[:n], Time: time.Now()})
 	}
-	return reply[:n], nil
+}
+
+// WaitLateReplies waits for the background goroutines that collect
+// late replies (see RoundTripCollect) to terminate.
+func (t DNSOverUDP) WaitLateReplies() {
+	if t.pending != nil {
+		t.pending.Wait()
+	}
+}
+
+// TCPFallback returns a DNSOverTCP transport towards the same server
+// that we should use when the reply is truncated.
+func (t DNSOverUDP) TCPFallback() RoundTripper {
+	return NewDNSOverTCP(t.dialer.DialContext, t.address)
 }
 
 // RequiresPadding returns false for UDP according to RFC8467
@@ -61,4 +155,11 @@ func (t DNSOverUDP) Address() string {
 	return t.address
 }
 
+// Window returns the window during which we collect late replies.
+func (t DNSOverUDP) Window() time.Duration {
+	return t.window
+}
+
 var _ RoundTripper = DNSOverUDP{}
+var _ TCPFallbacker = DNSOverUDP{}
+var _ UDPCollector = DNSOverUDP{}
@@ -1,10 +1,12 @@
 package resolver_test
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/ooni/probe-engine/netx/resolver"
 )
@@ -105,3 +107,113 @@ func TestUnitDNSOverUDPTransportOK(t *testing.T) {
 		t.Fatal("invalid Address")
 	}
 }
+
+func TestUnitDNSOverUDPTCPFallback(t *testing.T) {
+	const address = "9.9.9.9:53"
+	txp := resolver.NewDNSOverUDP(&net.Dialer{}, address)
+	fallback := txp.TCPFallback()
+	if fallback.Network() != "tcp" {
+		t.Fatal("invalid Network")
+	}
+	if fallback.Address() != address {
+		t.Fatal("invalid Address")
+	}
+}
+
+// startInjectingServer starts an UDP server that replies to the first
+// query it receives with all the given replies, like an on-path injector
+// racing with the legitimate resolver would do.
+func startInjectingServer(t *testing.T, replies ...[]byte) net.PacketConn {
+	pconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buffer := make([]byte, 1<<17)
+		_, addr, err := pconn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		for _, reply := range replies {
+			pconn.WriteTo(reply, addr)
+		}
+	}()
+	return pconn
+}
+
+func TestUnitDNSOverUDPRoundTripCollectWithWindow(t *testing.T) {
+	first, second := []byte("first reply"), []byte("second reply")
+	pconn := startInjectingServer(t, first, second)
+	defer pconn.Close()
+	txp := resolver.NewDNSOverUDPWithWindow(
+		new(net.Dialer), pconn.LocalAddr().String(), time.Second)
+	var late []resolver.UDPReply
+	reply, err := txp.RoundTripCollect(
+		context.Background(), []byte("query"), func(r resolver.UDPReply) {
+			late = append(late, r)
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	txp.WaitLateReplies()
+	if !bytes.Equal(reply.Data, first) {
+		t.Fatal("not the reply we expected")
+	}
+	if len(late) != 1 || !bytes.Equal(late[0].Data, second) {
+		t.Fatal("not the late replies we expected")
+	}
+	if late[0].Time.Before(reply.Time) {
+		t.Fatal("the replies times are wrong")
+	}
+}
+
+func TestUnitDNSOverUDPRoundTripCollectDoesNotWaitForWindow(t *testing.T) {
+	pconn := startInjectingServer(t, []byte("first reply"))
+	defer pconn.Close()
+	txp := resolver.NewDNSOverUDPWithWindow(
+		new(net.Dialer), pconn.LocalAddr().String(), time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	_, err := txp.RoundTripCollect(ctx, []byte("query"), func(r resolver.UDPReply) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("we should have returned right after the first reply")
+	}
+	cancel() // should interrupt the collection of late replies
+	txp.WaitLateReplies()
+}
+
+func TestUnitDNSOverUDPRoundTripCollectWithoutWindow(t *testing.T) {
+	first, second := []byte("first reply"), []byte("second reply")
+	pconn := startInjectingServer(t, first, second)
+	defer pconn.Close()
+	txp := resolver.NewDNSOverUDP(new(net.Dialer), pconn.LocalAddr().String())
+	reply, err := txp.RoundTripCollect(
+		context.Background(), []byte("query"), func(r resolver.UDPReply) {
+			t.Error("did not expect any late reply")
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	txp.WaitLateReplies()
+	if !bytes.Equal(reply.Data, first) {
+		t.Fatal("not the reply we expected")
+	}
+}
+
+func TestUnitDNSOverUDPRoundTripWithWindowReturnsFirstReply(t *testing.T) {
+	first, second := []byte("first reply"), []byte("second reply")
+	pconn := startInjectingServer(t, first, second)
+	defer pconn.Close()
+	txp := resolver.NewDNSOverUDPWithWindow(
+		new(net.Dialer), pconn.LocalAddr().String(), 100*time.Millisecond)
+	data, err := txp.RoundTrip(context.Background(), []byte("query"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, first) {
+		t.Fatal("not the reply we expected")
+	}
+}
@@ -38,6 +38,16 @@ func (txp EmitterTransport) RoundTrip(ctx context.Context, querydata []byte) ([]
 	return replydata, nil
 }
 
+// TCPFallback implements TCPFallbacker.TCPFallback. The returned
+// transport, if any, also emits events.
+func (txp EmitterTransport) TCPFallback() RoundTripper {
+	fallback := NewTCPFallback(txp.RoundTripper)
+	if fallback == nil {
+		return nil
+	}
+	return EmitterTransport{RoundTripper: fallback}
+}
+
 // EmitterResolver is a resolver that emits events
 type EmitterResolver struct {
 	Resolver
@@ -86,4 +96,5 @@ func (r EmitterResolver) LookupHost(ctx context.Context, hostname string) ([]str
 }
 
 var _ RoundTripper = EmitterTransport{}
+var _ TCPFallbacker = EmitterTransport{}
 var _ Resolver = EmitterResolver{}
@@ -1,6 +1,10 @@
 package resolver
 
-import "github.com/miekg/dns"
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
 
 // The Encoder encodes DNS queries to bytes
 type Encoder interface {
@@ -8,7 +12,25 @@ type Encoder interface {
 }
 
 // MiekgEncoder uses github.com/miekg/dns to implement the Encoder.
-type MiekgEncoder struct{}
+type MiekgEncoder struct {
+	// EDNS0 is the optional EDNS0 configuration. When it is nil, we
+	// only add an EDNS0 record when padding queries.
+	EDNS0 *EDNS0Config
+}
+
+// EDNS0Config contains the EDNS0 configuration.
+type EDNS0Config struct {
+	// BufferSize is the UDP payload size we advertise. When it
+	// is zero, we use EDNS0MaxResponseSize.
+	BufferSize uint16
+
+	// ClientSubnet is the optional client subnet (RFC7871) to send
+	// along with the query, e.g. "192.0.2.0/24".
+	ClientSubnet string
+
+	// DNSSECOK indicates whether to set the DO bit.
+	DNSSECOK bool
+}
 
 const (
 	// PaddingDesiredBlockSize is the size that the padded query should be multiple of
@@ -33,8 +55,15 @@ func (e MiekgEncoder) Encode(domain string, qtype uint16, padding bool) ([]byte,
 	query.RecursionDesired = true
 	query.Question = make([]dns.Question, 1)
 	query.Question[0] = question
+	if e.EDNS0 != nil {
+		if err := e.setEDNS0(query); err != nil {
+			return nil, err
+		}
+	}
 	if padding {
-		query.SetEdns0(EDNS0MaxResponseSize, DNSSECEnabled)
+		if query.IsEdns0() == nil {
+			query.SetEdns0(EDNS0MaxResponseSize, DNSSECEnabled)
+		}
 		// Clients SHOULD pad queries to the closest multiple of
 		// 128 octets RFC8467#section-4.1. We inflate the query
 		// length by the size of the option (i.e. 4 octets). The
@@ -49,4 +78,31 @@ func (e MiekgEncoder) Encode(domain string, qtype uint16, padding bool) ([]byte,
 	return query.Pack()
 }
 
+func (e MiekgEncoder) setEDNS0(query *dns.Msg) error {
+	size := e.EDNS0.BufferSize
+	if size == 0 {
+		size = EDNS0MaxResponseSize
+	}
+	query.SetEdns0(size, e.EDNS0.DNSSECOK)
+	if e.EDNS0.ClientSubnet == "" {
+		return nil
+	}
+	_, ipnet, err := net.ParseCIDR(e.EDNS0.ClientSubnet)
+	if err != nil {
+		return err
+	}
+	ones, _ := ipnet.Mask.Size()
+	opt := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        1,
+		SourceNetmask: uint8(ones),
+		Address:       ipnet.IP,
+	}
+	if ipnet.IP.To4() == nil {
+		opt.Family = 2
+	}
+	query.IsEdns0().Option = append(query.IsEdns0().Option, opt)
+	return nil
+}
+
 var _ Encoder = MiekgEncoder{}
@@ -97,3 +97,74 @@ func TestUnitEncoderPadding(t *testing.T) {
 		}
 	}
 }
+
+func TestUnitEncoderEDNS0(t *testing.T) {
+	e := resolver.MiekgEncoder{EDNS0: &resolver.EDNS0Config{
+		BufferSize:   1232,
+		ClientSubnet: "192.0.2.0/24",
+		DNSSECOK:     true,
+	}}
+	data, err := e.Encode("x.org", dns.TypeA, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := new(dns.Msg)
+	if err := query.Unpack(data); err != nil {
+		t.Fatal(err)
+	}
+	opt := query.IsEdns0()
+	if opt == nil {
+		t.Fatal("expected an EDNS0 record")
+	}
+	if opt.UDPSize() != 1232 || !opt.Do() {
+		t.Fatal("unexpected EDNS0 settings")
+	}
+	if len(opt.Option) != 1 {
+		t.Fatal("unexpected number of options")
+	}
+	subnet, ok := opt.Option[0].(*dns.EDNS0_SUBNET)
+	if !ok {
+		t.Fatal("expected client subnet option")
+	}
+	if subnet.Family != 1 || subnet.SourceNetmask != 24 || subnet.Address.String() != "192.0.2.0" {
+		t.Fatal("unexpected client subnet")
+	}
+}
+
+func TestUnitEncoderEDNS0DefaultsAndIPv6(t *testing.T) {
+	e := resolver.MiekgEncoder{EDNS0: &resolver.EDNS0Config{
+		ClientSubnet: "2001:db8::/56",
+	}}
+	data, err := e.Encode("x.org", dns.TypeAAAA, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(data) % resolver.PaddingDesiredBlockSize) != 0 {
+		t.Fatal("expected a padded query")
+	}
+	query := new(dns.Msg)
+	if err := query.Unpack(data); err != nil {
+		t.Fatal(err)
+	}
+	opt := query.IsEdns0()
+	if opt.UDPSize() != resolver.EDNS0MaxResponseSize || opt.Do() {
+		t.Fatal("unexpected EDNS0 settings")
+	}
+	subnet, ok := opt.Option[0].(*dns.EDNS0_SUBNET)
+	if !ok || subnet.Family != 2 || subnet.SourceNetmask != 56 {
+		t.Fatal("unexpected client subnet")
+	}
+}
+
+func TestUnitEncoderEDNS0InvalidClientSubnet(t *testing.T) {
+	e := resolver.MiekgEncoder{EDNS0: &resolver.EDNS0Config{
+		ClientSubnet: "antani",
+	}}
+	data, err := e.Encode("x.org", dns.TypeA, false)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if data != nil {
+		t.Fatal("expected nil data here")
+	}
+}
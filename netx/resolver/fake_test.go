@@ -102,6 +102,15 @@ func (fe FakeEncoder) Encode(domain string, qtype uint16, padding bool) ([]byte,
 	return fe.Data, fe.Err
 }
 
+type FakeDecoder struct {
+	Data []string
+	Err  error
+}
+
+func (fd FakeDecoder) Decode(qtype uint16, data []byte) ([]string, error) {
+	return fd.Data, fd.Err
+}
+
 type FakeResolver struct {
 	NumFailures *atomicx.Int64
 	Err         error
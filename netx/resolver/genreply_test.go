@@ -74,3 +74,24 @@ func GenReplySuccess(t *testing.T, qtype uint16, ips ...string) []byte {
 	}
 	return data
 }
+
+func GenReplyWithRecords(t *testing.T, qtype uint16, answers ...dns.RR) []byte {
+	query := new(dns.Msg)
+	query.Id = dns.Id()
+	query.RecursionDesired = true
+	query.Question = []dns.Question{{
+		Name:   dns.Fqdn("x.org"),
+		Qtype:  qtype,
+		Qclass: dns.ClassINET,
+	}}
+	reply := new(dns.Msg)
+	reply.Compress = true
+	reply.MsgHdr.RecursionAvailable = true
+	reply.SetReply(query)
+	reply.Answer = answers
+	data, err := reply.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,89 @@
+package resolver
+
+import (
+	"context"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/atomicx"
+)
+
+// ParallelResolver is a resolver that issues the A and the AAAA queries
+// for the requested domain in parallel using the same transport. We retry
+// each query like SerialResolver does, so the two resolvers only differ
+// in how long a lookup takes in case of timeouts. We also handle
+// truncated replies like SerialResolver does.
+type ParallelResolver struct {
+	Encoder     Encoder
+	Decoder     Decoder
+	FallbackTxp RoundTripper
+	NumTimeouts *atomicx.Int64
+	Txp         RoundTripper
+}
+
+// NewParallelResolver creates a new ParallelResolver instance.
+func NewParallelResolver(t RoundTripper) ParallelResolver {
+	return ParallelResolver{
+		Encoder:     MiekgEncoder{},
+		Decoder:     MiekgDecoder{},
+		FallbackTxp: NewTCPFallback(t),
+		NumTimeouts: atomicx.NewInt64(),
+		Txp:         t,
+	}
+}
+
+// Transport returns the transport being used.
+func (r ParallelResolver) Transport() RoundTripper {
+	return r.Txp
+}
+
+// Network implements Resolver.Network
+func (r ParallelResolver) Network() string {
+	return r.Txp.Network()
+}
+
+// Address implements Resolver.Address
+func (r ParallelResolver) Address() string {
+	return r.Txp.Address()
+}
+
+type parallelResult struct {
+	addrs []string
+	ttl   time.Duration
+	err   error
+}
+
+// LookupHost implements Resolver.LookupHost.
+func (r ParallelResolver) LookupHost(ctx context.Context, hostname string) ([]string, error) {
+	addrs, _, err := r.LookupHostWithTTL(ctx, hostname)
+	return addrs, err
+}
+
+// LookupHostWithTTL is like LookupHost but also returns the minimum
+// TTL of the answers to the A and AAAA queries.
+func (r ParallelResolver) LookupHostWithTTL(
+	ctx context.Context, hostname string) ([]string, time.Duration, error) {
+	serial := SerialResolver{
+		Encoder:     r.Encoder,
+		Decoder:     r.Decoder,
+		FallbackTxp: r.FallbackTxp,
+		NumTimeouts: r.NumTimeouts,
+		Txp:         r.Txp,
+	}
+	resA, resAAAA := make(chan parallelResult), make(chan parallelResult)
+	go func() {
+		addrs, ttl, err := serial.roundTripWithRetry(ctx, hostname, dns.TypeA)
+		resA <- parallelResult{addrs: addrs, ttl: ttl, err: err}
+	}()
+	go func() {
+		addrs, ttl, err := serial.roundTripWithRetry(ctx, hostname, dns.TypeAAAA)
+		resAAAA <- parallelResult{addrs: addrs, ttl: ttl, err: err}
+	}()
+	replyA, replyAAAA := <-resA, <-resAAAA
+	return mergeLookupResults(
+		replyA.addrs, replyA.ttl, replyA.err,
+		replyAAAA.addrs, replyAAAA.ttl, replyAAAA.err,
+	)
+}
+
+var _ Resolver = ParallelResolver{}
@@ -0,0 +1,115 @@
+package resolver_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/resolver"
+)
+
+func TestUnitParallelGettingTransport(t *testing.T) {
+	txp := resolver.NewDNSOverTLS(resolver.DialTLSContext, "8.8.8.8:853")
+	r := resolver.NewParallelResolver(txp)
+	rtx := r.Transport()
+	if rtx.Network() != "dot" || rtx.Address() != "8.8.8.8:853" {
+		t.Fatal("not the transport we expected")
+	}
+	if r.Network() != rtx.Network() {
+		t.Fatal("invalid network seen from the resolver")
+	}
+	if r.Address() != rtx.Address() {
+		t.Fatal("invalid address seen from the resolver")
+	}
+}
+
+func TestUnitParallelEncodeError(t *testing.T) {
+	mocked := errors.New("mocked error")
+	txp := resolver.NewDNSOverTLS(resolver.DialTLSContext, "8.8.8.8:853")
+	r := resolver.ParallelResolver{Encoder: resolver.FakeEncoder{Err: mocked}, Txp: txp}
+	addrs, err := r.LookupHost(context.Background(), "www.gogle.com")
+	if !errors.Is(err, mocked) {
+		t.Fatal("not the error we expected")
+	}
+	if addrs != nil {
+		t.Fatal("expected nil address here")
+	}
+}
+
+func TestUnitParallelWithAReply(t *testing.T) {
+	txp := resolver.FakeTransport{
+		Data: resolver.GenReplySuccess(t, dns.TypeA, "8.8.8.8"),
+	}
+	r := resolver.NewParallelResolver(txp)
+	addrs, err := r.LookupHost(context.Background(), "www.gogle.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "8.8.8.8" {
+		t.Fatal("not the result we expected")
+	}
+}
+
+func TestUnitParallelWithTimeout(t *testing.T) {
+	txp := resolver.FakeTransport{
+		Err: &net.OpError{Err: syscall.ETIMEDOUT, Op: "dial"},
+	}
+	r := resolver.NewParallelResolver(txp)
+	addrs, err := r.LookupHost(context.Background(), "www.gogle.com")
+	if !errors.Is(err, syscall.ETIMEDOUT) {
+		t.Fatal("not the error we expected")
+	}
+	if addrs != nil {
+		t.Fatal("expected nil address here")
+	}
+	if r.NumTimeouts.Load() != 6 {
+		t.Fatal("we didn't take the expected number of timeouts")
+	}
+}
+
+// BarrierTransport only replies once it has received both the A and
+// the AAAA queries, thus failing unless they are sent in parallel.
+type BarrierTransport struct {
+	resolver.FakeTransport
+	T       *testing.T
+	Queries chan uint16
+	Release chan struct{}
+}
+
+func (txp BarrierTransport) RoundTrip(ctx context.Context, query []byte) ([]byte, error) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(query); err != nil {
+		return nil, err
+	}
+	qtype := msg.Question[0].Qtype
+	txp.Queries <- qtype
+	<-txp.Release
+	if qtype == dns.TypeA {
+		return resolver.GenReplySuccess(txp.T, qtype, "8.8.8.8"), nil
+	}
+	return resolver.GenReplySuccess(txp.T, qtype, "2001:4860:4860::8888"), nil
+}
+
+func TestUnitParallelSendsQueriesInParallel(t *testing.T) {
+	txp := BarrierTransport{
+		T:       t,
+		Queries: make(chan uint16),
+		Release: make(chan struct{}),
+	}
+	go func() {
+		<-txp.Queries
+		<-txp.Queries
+		close(txp.Release)
+	}()
+	r := resolver.NewParallelResolver(txp)
+	addrs, err := r.LookupHost(context.Background(), "dns.google")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 2 || addrs[0] != "8.8.8.8" || addrs[1] != "2001:4860:4860::8888" {
+		t.Fatal("not the result we expected")
+	}
+}
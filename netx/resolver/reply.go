@@ -0,0 +1,240 @@
+package resolver
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// TypeHTTPS is the HTTPS resource record type (see
+// draft-ietf-dnsop-svcb-https). The version of miekg/dns we
+// use does not know about it, so we parse it ourselves.
+const TypeHTTPS uint16 = 65
+
+// DNSReply is a DNS reply decoded at the record level.
+type DNSReply struct {
+	Answers []DNSAnswer
+	Flags   DNSFlags
+	Rcode   int
+}
+
+// RcodeString returns the textual representation of the rcode.
+func (r DNSReply) RcodeString() string {
+	if s, ok := dns.RcodeToString[r.Rcode]; ok {
+		return s
+	}
+	return "UNKNOWN"
+}
+
+// Err returns the error corresponding to the rcode, or nil.
+func (r DNSReply) Err() error {
+	return rcodeToError(r.Rcode)
+}
+
+// Addresses returns the addresses contained in the answers of type qtype,
+// which is either dns.TypeA or dns.TypeAAAA. Like Decoder.Decode, it fails
+// if the rcode is not successful or if there are no such answers.
+func (r DNSReply) Addresses(qtype uint16) ([]string, error) {
+	// TODO(bassosimone): map more errors to net.DNSError names
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for _, answer := range r.Answers {
+		switch {
+		case qtype == dns.TypeA && answer.IPv4 != "":
+			addrs = append(addrs, answer.IPv4)
+		case qtype == dns.TypeAAAA && answer.IPv6 != "":
+			addrs = append(addrs, answer.IPv6)
+		}
+	}
+	if len(addrs) <= 0 {
+		return nil, errors.New("ooniresolver: no response returned")
+	}
+	return addrs, nil
+}
+
+// MinTTL returns the minimum TTL among the answers, or zero.
+func (r DNSReply) MinTTL() time.Duration {
+	var ttl time.Duration
+	for idx, answer := range r.Answers {
+		if value := time.Duration(answer.TTL) * time.Second; idx == 0 || value < ttl {
+			ttl = value
+		}
+	}
+	return ttl
+}
+
+// DNSFlags contains the flags of a DNS reply.
+type DNSFlags struct {
+	Authoritative      bool
+	AuthenticatedData  bool
+	CheckingDisabled   bool
+	RecursionAvailable bool
+	RecursionDesired   bool
+	Truncated          bool
+}
+
+// DNSAnswer is a typed answer contained in a DNS reply. Only the
+// fields that make sense for the answer Type are filled.
+type DNSAnswer struct {
+	// ALPN contains the ALPNs of an HTTPS answer.
+	ALPN []string
+
+	// Hostname is the CNAME target, the NS name, the MX exchange
+	// or the target name of an HTTPS answer.
+	Hostname string
+
+	// IPv4 is the address of an A answer.
+	IPv4 string
+
+	// IPv4Hint contains the IPv4 hints of an HTTPS answer.
+	IPv4Hint []string
+
+	// IPv6 is the address of an AAAA answer.
+	IPv6 string
+
+	// IPv6Hint contains the IPv6 hints of an HTTPS answer.
+	IPv6Hint []string
+
+	// Name is the owner name of the answer.
+	Name string
+
+	// Preference is the preference of an MX answer.
+	Preference uint16
+
+	// Priority is the priority of an HTTPS answer.
+	Priority uint16
+
+	// TTL is the answer TTL in seconds.
+	TTL uint32
+
+	// TXT contains the strings of a TXT answer.
+	TXT []string
+
+	// Type is the answer type (e.g. "A", "CNAME").
+	Type string
+}
+
+// DecodeReply implements ReplyDecoder.DecodeReply.
+func (d MiekgDecoder) DecodeReply(data []byte) (DNSReply, error) {
+	reply := new(dns.Msg)
+	if err := reply.Unpack(data); err != nil {
+		return DNSReply{}, err
+	}
+	out := DNSReply{
+		Flags: DNSFlags{
+			Authoritative:      reply.Authoritative,
+			AuthenticatedData:  reply.AuthenticatedData,
+			CheckingDisabled:   reply.CheckingDisabled,
+			RecursionAvailable: reply.RecursionAvailable,
+			RecursionDesired:   reply.RecursionDesired,
+			Truncated:          reply.Truncated,
+		},
+		Rcode: reply.Rcode,
+	}
+	for _, rr := range reply.Answer {
+		answer, ok := newDNSAnswer(rr)
+		if !ok {
+			continue
+		}
+		out.Answers = append(out.Answers, answer)
+	}
+	return out, nil
+}
+
+func newDNSAnswer(rr dns.RR) (DNSAnswer, bool) {
+	header := rr.Header()
+	answer := DNSAnswer{
+		Name: header.Name,
+		TTL:  header.Ttl,
+		Type: dns.TypeToString[header.Rrtype],
+	}
+	switch v := rr.(type) {
+	case *dns.A:
+		answer.IPv4 = v.A.String()
+	case *dns.AAAA:
+		answer.IPv6 = v.AAAA.String()
+	case *dns.CNAME:
+		answer.Hostname = v.Target
+	case *dns.NS:
+		answer.Hostname = v.Ns
+	case *dns.MX:
+		answer.Hostname = v.Mx
+		answer.Preference = v.Preference
+	case *dns.TXT:
+		answer.TXT = v.Txt
+	case *dns.RFC3597:
+		if header.Rrtype != TypeHTTPS {
+			return answer, false
+		}
+		answer.Type = "HTTPS"
+		if err := parseHTTPS(v.Rdata, &answer); err != nil {
+			return answer, false
+		}
+	default:
+		return answer, false
+	}
+	return answer, true
+}
+
+// errInvalidHTTPS indicates that we cannot parse an HTTPS answer.
+var errInvalidHTTPS = errors.New("ooniresolver: invalid HTTPS answer")
+
+// HTTPS service parameter keys we care about.
+const (
+	svcParamALPN     = 1
+	svcParamIPv4Hint = 4
+	svcParamIPv6Hint = 6
+)
+
+// parseHTTPS parses the hex encoded RDATA of an HTTPS answer.
+func parseHTTPS(rdata string, answer *DNSAnswer) error {
+	data, err := hex.DecodeString(rdata)
+	if err != nil || len(data) < 2 {
+		return errInvalidHTTPS
+	}
+	answer.Priority = binary.BigEndian.Uint16(data[0:2])
+	target, off, err := dns.UnpackDomainName(data, 2)
+	if err != nil {
+		return errInvalidHTTPS
+	}
+	answer.Hostname = target
+	params := data[off:]
+	for len(params) > 0 {
+		if len(params) < 4 {
+			return errInvalidHTTPS
+		}
+		key := binary.BigEndian.Uint16(params[0:2])
+		length := int(binary.BigEndian.Uint16(params[2:4]))
+		if len(params) < 4+length {
+			return errInvalidHTTPS
+		}
+		value := params[4 : 4+length]
+		switch key {
+		case svcParamALPN:
+			for len(value) > 0 {
+				size := int(value[0])
+				if len(value) < 1+size {
+					return errInvalidHTTPS
+				}
+				answer.ALPN = append(answer.ALPN, string(value[1:1+size]))
+				value = value[1+size:]
+			}
+		case svcParamIPv4Hint:
+			for ; len(value) >= net.IPv4len; value = value[net.IPv4len:] {
+				answer.IPv4Hint = append(answer.IPv4Hint, net.IP(value[:net.IPv4len]).String())
+			}
+		case svcParamIPv6Hint:
+			for ; len(value) >= net.IPv6len; value = value[net.IPv6len:] {
+				answer.IPv6Hint = append(answer.IPv6Hint, net.IP(value[:net.IPv6len]).String())
+			}
+		}
+		params = params[4+length:]
+	}
+	return nil
+}
@@ -44,7 +44,16 @@ type SaverDNSTransport struct {
 	Saver *trace.Saver
 }
 
-// RoundTrip implements RoundTripper.RoundTrip
+// UDPCollector is a transport that is able to collect the late replies
+// received in response to a query, e.g., DNSOverUDP.
+type UDPCollector interface {
+	RoundTripCollect(
+		ctx context.Context, query []byte, onLateReply func(UDPReply)) (UDPReply, error)
+}
+
+// RoundTrip implements RoundTripper.RoundTrip. If the underlying transport
+// is an UDPCollector, we save the first reply in dns_round_trip_done and
+// each late reply, as soon as we receive it, into a dns_late_reply event.
 func (txp SaverDNSTransport) RoundTrip(ctx context.Context, query []byte) ([]byte, error) {
 	start := time.Now()
 	txp.Saver.Write(trace.Event{
@@ -54,20 +63,61 @@ func (txp SaverDNSTransport) RoundTrip(ctx context.Context, query []byte) ([]byt
 		Proto:    txp.Network(),
 		Time:     start,
 	})
-	reply, err := txp.RoundTripper.RoundTrip(ctx, query)
+	// Late replies must follow dns_round_trip_done in the trace, otherwise
+	// we would not be able to attach them to their query when archiving.
+	ready := make(chan struct{})
+	reply, err := txp.roundTrip(ctx, query, func(late UDPReply) {
+		<-ready
+		txp.Saver.Write(trace.Event{
+			Address:  txp.Address(),
+			DNSQuery: query,
+			DNSReply: late.Data,
+			Duration: late.Time.Sub(start),
+			Name:     "dns_late_reply",
+			Proto:    txp.Network(),
+			Time:     late.Time,
+		})
+	})
 	stop := time.Now()
+	if err == nil {
+		stop = reply.Time
+	}
 	txp.Saver.Write(trace.Event{
 		Address:  txp.Address(),
 		DNSQuery: query,
-		DNSReply: reply,
+		DNSReply: reply.Data,
 		Duration: stop.Sub(start),
 		Err:      err,
 		Name:     "dns_round_trip_done",
 		Proto:    txp.Network(),
 		Time:     stop,
 	})
-	return reply, err
+	close(ready)
+	return reply.Data, err
+}
+
+func (txp SaverDNSTransport) roundTrip(
+	ctx context.Context, query []byte, onLateReply func(UDPReply)) (UDPReply, error) {
+	if collector, ok := txp.RoundTripper.(UDPCollector); ok {
+		return collector.RoundTripCollect(ctx, query, onLateReply)
+	}
+	reply, err := txp.RoundTripper.RoundTrip(ctx, query)
+	if err != nil {
+		return UDPReply{}, err
+	}
+	return UDPReply{Data: reply, Time: time.Now()}, nil
+}
+
+// TCPFallback implements TCPFallbacker.TCPFallback. The returned
+// transport, if any, saves events using the same Saver.
+func (txp SaverDNSTransport) TCPFallback() RoundTripper {
+	fallback := NewTCPFallback(txp.RoundTripper)
+	if fallback == nil {
+		return nil
+	}
+	return SaverDNSTransport{RoundTripper: fallback, Saver: txp.Saver}
 }
 
 var _ Resolver = SaverResolver{}
 var _ RoundTripper = SaverDNSTransport{}
+var _ TCPFallbacker = SaverDNSTransport{}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"net"
 	"reflect"
 	"testing"
 	"time"
@@ -209,3 +210,66 @@ func TestUnitSaverDNSTransportSuccess(t *testing.T) {
 		t.Fatal("the saved time is wrong")
 	}
 }
+
+func TestUnitSaverDNSTransportTCPFallback(t *testing.T) {
+	saver := new(trace.Saver)
+	txp := resolver.SaverDNSTransport{
+		RoundTripper: resolver.NewDNSOverUDP(resolver.FakeDialer{}, "9.9.9.9:53"),
+		Saver:        saver,
+	}
+	fallback, ok := txp.TCPFallback().(resolver.SaverDNSTransport)
+	if !ok {
+		t.Fatal("not the transport we expected")
+	}
+	if fallback.Saver != saver || fallback.Network() != "tcp" {
+		t.Fatal("not the fallback we expected")
+	}
+}
+
+func TestUnitSaverDNSTransportNoTCPFallback(t *testing.T) {
+	txp := resolver.SaverDNSTransport{
+		RoundTripper: resolver.FakeTransport{},
+		Saver:        new(trace.Saver),
+	}
+	if txp.TCPFallback() != nil {
+		t.Fatal("expected no fallback here")
+	}
+}
+
+func TestUnitSaverDNSTransportLateReplies(t *testing.T) {
+	first, second := []byte("first reply"), []byte("second reply")
+	pconn := startInjectingServer(t, first, second)
+	defer pconn.Close()
+	saver := new(trace.Saver)
+	udp := resolver.NewDNSOverUDPWithWindow(
+		new(net.Dialer), pconn.LocalAddr().String(), time.Second)
+	txp := resolver.SaverDNSTransport{RoundTripper: udp, Saver: saver}
+	query := []byte("query")
+	reply, err := txp.RoundTrip(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp.WaitLateReplies()
+	if !bytes.Equal(reply, first) {
+		t.Fatal("not the reply we expected")
+	}
+	ev := saver.Read()
+	if len(ev) != 3 {
+		t.Fatal("unexpected number of events")
+	}
+	if ev[1].Name != "dns_round_trip_done" || !bytes.Equal(ev[1].DNSReply, first) {
+		t.Fatal("unexpected dns_round_trip_done event")
+	}
+	if ev[2].Name != "dns_late_reply" || !bytes.Equal(ev[2].DNSReply, second) {
+		t.Fatal("unexpected dns_late_reply event")
+	}
+	if !bytes.Equal(ev[2].DNSQuery, query) || ev[2].Proto != "udp" {
+		t.Fatal("unexpected dns_late_reply fields")
+	}
+	if ev[2].Address != pconn.LocalAddr().String() {
+		t.Fatal("unexpected Address")
+	}
+	if ev[2].Duration < ev[1].Duration {
+		t.Fatal("unexpected Duration")
+	}
+}
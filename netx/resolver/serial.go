@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/atomicx"
@@ -26,23 +27,46 @@ type RoundTripper interface {
 
 // SerialResolver is a resolver that first issues an A query and then
 // issues an AAAA query for the requested domain.
+//
+// When Txp returns a truncated reply and FallbackTxp is not nil, we
+// send again the query using FallbackTxp, which typically is a DNS
+// over TCP transport towards the same server.
 type SerialResolver struct {
 	Encoder     Encoder
 	Decoder     Decoder
+	FallbackTxp RoundTripper
 	NumTimeouts *atomicx.Int64
 	Txp         RoundTripper
 }
 
-// NewSerialResolver creates a new OONI Resolver instance.
+// NewSerialResolver creates a new OONI Resolver instance. If t is able
+// to create a TCP fallback transport, we will use such transport when
+// we receive truncated replies.
 func NewSerialResolver(t RoundTripper) SerialResolver {
 	return SerialResolver{
 		Encoder:     MiekgEncoder{},
 		Decoder:     MiekgDecoder{},
+		FallbackTxp: NewTCPFallback(t),
 		NumTimeouts: atomicx.NewInt64(),
 		Txp:         t,
 	}
 }
 
+// TCPFallbacker is a transport that is able to create a DNS over TCP
+// transport towards the same server, for retrying truncated replies.
+type TCPFallbacker interface {
+	TCPFallback() RoundTripper
+}
+
+// NewTCPFallback returns the TCP fallback transport for t, or nil
+// if t is not able to create such transport.
+func NewTCPFallback(t RoundTripper) RoundTripper {
+	if f, ok := t.(TCPFallbacker); ok {
+		return f.TCPFallback()
+	}
+	return nil
+}
+
 // Transport returns the transport being used.
 func (r SerialResolver) Transport() RoundTripper {
 	return r.Txp
@@ -60,24 +84,48 @@ func (r SerialResolver) Address() string {
 
 // LookupHost implements Resolver.LookupHost.
 func (r SerialResolver) LookupHost(ctx context.Context, hostname string) ([]string, error) {
-	var addrs []string
-	addrsA, errA := r.roundTripWithRetry(ctx, hostname, dns.TypeA)
-	addrsAAAA, errAAAA := r.roundTripWithRetry(ctx, hostname, dns.TypeAAAA)
+	addrs, _, err := r.LookupHostWithTTL(ctx, hostname)
+	return addrs, err
+}
+
+// LookupHostWithTTL is like LookupHost but also returns the minimum
+// TTL of the answers to the A and AAAA queries.
+func (r SerialResolver) LookupHostWithTTL(
+	ctx context.Context, hostname string) ([]string, time.Duration, error) {
+	addrsA, ttlA, errA := r.roundTripWithRetry(ctx, hostname, dns.TypeA)
+	addrsAAAA, ttlAAAA, errAAAA := r.roundTripWithRetry(ctx, hostname, dns.TypeAAAA)
+	return mergeLookupResults(addrsA, ttlA, errA, addrsAAAA, ttlAAAA, errAAAA)
+}
+
+// mergeLookupResults merges the results of the A and AAAA queries. We
+// fail only if both queries failed, in which case we return errA.
+func mergeLookupResults(
+	addrsA []string, ttlA time.Duration, errA error,
+	addrsAAAA []string, ttlAAAA time.Duration, errAAAA error,
+) ([]string, time.Duration, error) {
 	if errA != nil && errAAAA != nil {
-		return nil, errA
+		return nil, 0, errA
 	}
+	var addrs []string
 	addrs = append(addrs, addrsA...)
 	addrs = append(addrs, addrsAAAA...)
-	return addrs, nil
+	switch {
+	case errA != nil:
+		return addrs, ttlAAAA, nil
+	case errAAAA != nil || ttlA < ttlAAAA:
+		return addrs, ttlA, nil
+	default:
+		return addrs, ttlAAAA, nil
+	}
 }
 
 func (r SerialResolver) roundTripWithRetry(
-	ctx context.Context, hostname string, qtype uint16) ([]string, error) {
+	ctx context.Context, hostname string, qtype uint16) ([]string, time.Duration, error) {
 	var errorslist []error
 	for i := 0; i < 3; i++ {
-		replies, err := r.roundTrip(ctx, hostname, qtype)
+		replies, ttl, err := r.roundTrip(ctx, hostname, qtype)
 		if err == nil {
-			return replies, nil
+			return replies, ttl, nil
 		}
 		errorslist = append(errorslist, err)
 		var operr *net.OpError
@@ -94,20 +142,95 @@ func (r SerialResolver) roundTripWithRetry(
 	// bugfix: we MUST return one of the errors otherwise we confuse the
 	// mechanism in errwrap that classifies the root cause operation, since
 	// it would not be able to find a child with a major operation error
-	return nil, errorslist[0]
+	return nil, 0, errorslist[0]
 }
 
 func (r SerialResolver) roundTrip(
-	ctx context.Context, hostname string, qtype uint16) ([]string, error) {
+	ctx context.Context, hostname string, qtype uint16) ([]string, time.Duration, error) {
 	querydata, err := r.Encoder.Encode(hostname, qtype, r.Txp.RequiresPadding())
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	replydata, err := r.roundTripQuery(ctx, querydata)
+	if err != nil {
+		return nil, 0, err
+	}
+	decoder, ok := r.Decoder.(ReplyDecoder)
+	if !ok {
+		// we cannot know the TTL, hence use the CacheResolver default
+		addrs, err := r.Decoder.Decode(qtype, replydata)
+		if err != nil {
+			return nil, 0, err
+		}
+		return addrs, DefaultCacheTTL, nil
+	}
+	reply, err := decoder.DecodeReply(replydata)
+	if err != nil {
+		return nil, 0, err
+	}
+	addrs, err := reply.Addresses(qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+	return addrs, reply.MinTTL(), nil
+}
+
+// LookupRecords performs a single query for the given hostname and
+// qtype (e.g. dns.TypeCNAME) and returns the decoded reply. When the
+// rcode indicates failure, we return an error along with the reply. When
+// the Decoder is not a ReplyDecoder, we decode using MiekgDecoder.
+func (r SerialResolver) LookupRecords(
+	ctx context.Context, hostname string, qtype uint16) (DNSReply, error) {
+	var errorslist []error
+	for i := 0; i < 3; i++ {
+		reply, err := r.roundTripRecords(ctx, hostname, qtype)
+		if err == nil {
+			return reply, reply.Err()
+		}
+		errorslist = append(errorslist, err)
+		var operr *net.OpError
+		if errors.As(err, &operr) == false || operr.Timeout() == false {
+			break // see roundTripWithRetry for why we return the first error
+		}
+		r.NumTimeouts.Add(1)
 	}
+	return DNSReply{}, errorslist[0]
+}
+
+func (r SerialResolver) roundTripRecords(
+	ctx context.Context, hostname string, qtype uint16) (DNSReply, error) {
+	querydata, err := r.Encoder.Encode(hostname, qtype, r.Txp.RequiresPadding())
+	if err != nil {
+		return DNSReply{}, err
+	}
+	replydata, err := r.roundTripQuery(ctx, querydata)
+	if err != nil {
+		return DNSReply{}, err
+	}
+	decoder, ok := r.Decoder.(ReplyDecoder)
+	if !ok {
+		decoder = MiekgDecoder{}
+	}
+	return decoder.DecodeReply(replydata)
+}
+
+// roundTripQuery sends the query using Txp and, if the reply is
+// truncated, sends it again using FallbackTxp, if possible.
+func (r SerialResolver) roundTripQuery(ctx context.Context, querydata []byte) ([]byte, error) {
 	replydata, err := r.Txp.RoundTrip(ctx, querydata)
 	if err != nil {
 		return nil, err
 	}
-	return r.Decoder.Decode(qtype, replydata)
+	if r.FallbackTxp != nil && isTruncated(replydata) {
+		return r.FallbackTxp.RoundTrip(ctx, querydata)
+	}
+	return replydata, nil
+}
+
+// isTruncated returns whether the TC bit is set. The flags are the
+// two bytes following the ID, and TC is 0x02 of the first byte.
+func isTruncated(replydata []byte) bool {
+	return len(replydata) > 2 && (replydata[2]&0x02) != 0
 }
 
 var _ Resolver = SerialResolver{}
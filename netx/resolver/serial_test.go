@@ -7,9 +7,11 @@ import (
 	"strings"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/netx/resolver"
+	"github.com/ooni/probe-engine/netx/trace"
 )
 
 func TestUnitOONIGettingTransport(t *testing.T) {
@@ -109,3 +111,163 @@ func TestUnitOONIWithTimeout(t *testing.T) {
 		t.Fatal("we didn't actually take the timeouts")
 	}
 }
+
+func TestUnitOONILookupRecordsWithCNAMEReply(t *testing.T) {
+	txp := resolver.FakeTransport{
+		Data: resolver.GenReplyWithRecords(t, dns.TypeCNAME, &dns.CNAME{
+			Hdr:    dns.RR_Header{Name: "x.org.", Rrtype: dns.TypeCNAME, Class: dns.ClassINET},
+			Target: "y.org.",
+		}),
+	}
+	r := resolver.NewSerialResolver(txp)
+	reply, err := r.LookupRecords(context.Background(), "x.org", dns.TypeCNAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Answers) != 1 || reply.Answers[0].Hostname != "y.org." {
+		t.Fatal("not the result we expected")
+	}
+}
+
+func TestUnitOONILookupRecordsWithNXDOMAIN(t *testing.T) {
+	txp := resolver.FakeTransport{
+		Data: resolver.GenReplyError(t, dns.RcodeNameError),
+	}
+	r := resolver.NewSerialResolver(txp)
+	reply, err := r.LookupRecords(context.Background(), "x.org", dns.TypeTXT)
+	if err == nil || !strings.HasSuffix(err.Error(), "no such host") {
+		t.Fatal("not the error we expected")
+	}
+	if reply.RcodeString() != "NXDOMAIN" {
+		t.Fatal("expected to see the rcode")
+	}
+}
+
+func TestUnitOONILookupRecordsWithTimeout(t *testing.T) {
+	txp := resolver.FakeTransport{
+		Err: &net.OpError{Err: syscall.ETIMEDOUT, Op: "dial"},
+	}
+	r := resolver.NewSerialResolver(txp)
+	_, err := r.LookupRecords(context.Background(), "x.org", dns.TypeMX)
+	if !errors.Is(err, syscall.ETIMEDOUT) {
+		t.Fatal("not the error we expected")
+	}
+	if r.NumTimeouts.Load() <= 0 {
+		t.Fatal("we didn't actually take the timeouts")
+	}
+}
+
+func TestUnitOONILookupHostWithTTL(t *testing.T) {
+	txp := resolver.FakeTransport{
+		Data: resolver.GenReplyWithRecords(t, dns.TypeA, &dns.A{
+			Hdr: dns.RR_Header{
+				Name: "x.org.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300,
+			},
+			A: net.ParseIP("8.8.8.8"),
+		}),
+	}
+	r := resolver.NewSerialResolver(txp)
+	addrs, ttl, err := r.LookupHostWithTTL(context.Background(), "x.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "8.8.8.8" {
+		t.Fatal("not the result we expected")
+	}
+	if ttl != 300*time.Second {
+		t.Fatal("not the TTL we expected")
+	}
+}
+
+func TestUnitOONILookupHostWithTTLAndDecoder(t *testing.T) {
+	txp := resolver.FakeTransport{Data: resolver.GenReplySuccess(t, dns.TypeA)}
+	r := resolver.NewSerialResolver(txp)
+	r.Decoder = resolver.FakeDecoder{Data: []string{"8.8.8.8"}}
+	addrs, ttl, err := r.LookupHostWithTTL(context.Background(), "x.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 2 || addrs[0] != "8.8.8.8" {
+		t.Fatal("not the result we expected")
+	}
+	if ttl != resolver.DefaultCacheTTL {
+		t.Fatal("not the TTL we expected")
+	}
+}
+
+func TestUnitOONILookupRecordsWithDecoder(t *testing.T) {
+	txp := resolver.FakeTransport{
+		Data: resolver.GenReplyWithRecords(t, dns.TypeCNAME, &dns.CNAME{
+			Hdr:    dns.RR_Header{Name: "x.org.", Rrtype: dns.TypeCNAME, Class: dns.ClassINET},
+			Target: "y.org.",
+		}),
+	}
+	r := resolver.NewSerialResolver(txp)
+	r.Decoder = resolver.FakeDecoder{Err: errors.New("mocked error")}
+	reply, err := r.LookupRecords(context.Background(), "x.org", dns.TypeCNAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Answers) != 1 || reply.Answers[0].Hostname != "y.org." {
+		t.Fatal("not the result we expected")
+	}
+}
+
+func genReplyTruncated(t *testing.T, qtype uint16) []byte {
+	data := resolver.GenReplySuccess(t, qtype)
+	data[2] |= 0x02 // set the TC bit
+	return data
+}
+
+func TestUnitOONINewSerialResolverTCPFallback(t *testing.T) {
+	r := resolver.NewSerialResolver(resolver.NewDNSOverUDP(new(net.Dialer), "8.8.8.8:53"))
+	if r.FallbackTxp == nil || r.FallbackTxp.Network() != "tcp" {
+		t.Fatal("expected a TCP fallback here")
+	}
+	r = resolver.NewSerialResolver(resolver.FakeTransport{})
+	if r.FallbackTxp != nil {
+		t.Fatal("expected no fallback here")
+	}
+}
+
+func TestUnitOONIWithTruncatedReply(t *testing.T) {
+	saver := new(trace.Saver)
+	r := resolver.NewSerialResolver(resolver.SaverDNSTransport{
+		RoundTripper: resolver.FakeTransport{Data: genReplyTruncated(t, dns.TypeA)},
+		Saver:        saver,
+	})
+	r.FallbackTxp = resolver.SaverDNSTransport{
+		RoundTripper: resolver.FakeTransport{
+			Data: resolver.GenReplySuccess(t, dns.TypeA, "8.8.8.8"),
+		},
+		Saver: saver,
+	}
+	addrs, err := r.LookupHost(context.Background(), "www.gogle.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "8.8.8.8" {
+		t.Fatal("not the result we expected")
+	}
+	var roundtrips int
+	for _, ev := range saver.Read() {
+		if ev.Name == "dns_round_trip_done" {
+			roundtrips++
+		}
+	}
+	if roundtrips != 4 {
+		t.Fatal("expected to see both legs for A and AAAA")
+	}
+}
+
+func TestUnitOONIWithTruncatedReplyAndNoFallback(t *testing.T) {
+	txp := resolver.FakeTransport{Data: genReplyTruncated(t, dns.TypeA)}
+	r := resolver.NewSerialResolver(txp)
+	addrs, err := r.LookupHost(context.Background(), "www.gogle.com")
+	if err == nil || !strings.HasSuffix(err.Error(), "no response returned") {
+		t.Fatal("not the error we expected")
+	}
+	if addrs != nil {
+		t.Fatal("expected nil address here")
+	}
+}
@@ -21,12 +21,15 @@ type Event struct {
 	HTTPStatusCode     int                 `json:",omitempty"`
 	HTTPURL            string              `json:",omitempty"`
 	Hostname           string              `json:",omitempty"`
+	LocalAddress       string              `json:",omitempty"`
 	Name               string              `json:",omitempty"`
 	NoTLSVerify        bool                `json:",omitempty"`
 	NumBytes           int                 `json:",omitempty"`
 	Proto              string              `json:",omitempty"`
+	ProxyURL           string              `json:",omitempty"`
 	TLSServerName      string              `json:",omitempty"`
 	TLSCipherSuite     string              `json:",omitempty"`
+	TLSClientHelloID   string              `json:",omitempty"`
 	TLSNegotiatedProto string              `json:",omitempty"`
 	TLSNextProtos      []string            `json:",omitempty"`
 	TLSPeerCerts       []*x509.Certificate `json:",omitempty"`
@@ -2,10 +2,62 @@ package trace
 
 import "sync"
 
-// The Saver saves a trace
+// The Saver saves a trace. The zero value is a valid Saver that keeps
+// all the events until you Read them.
+//
+// Because a Saver used to trace a large download could otherwise keep
+// millions of read events in memory, you can configure it to coalesce
+// read and write events and to cap the number of events and the number
+// of bytes of Data it keeps. Do not change such settings after you have
+// started using the Saver. You can also Subscribe to receive the events
+// as soon as they are written, e.g., to drive a progress UI.
 type Saver struct {
-	ops []Event
-	mu  sync.Mutex
+	// Coalesce indicates whether we should merge a read (or write) event
+	// into the previous event saved for the same connection, if that is
+	// also a read (or write) event. The merged event has the Time of the
+	// latest event and the sum of the NumBytes and of the Durations. We
+	// never merge events that contain an error.
+	Coalesce bool
+
+	// MaxBytes, if positive, is the maximum number of bytes of Data that
+	// we keep until the next Read. We truncate the Data of the events that
+	// would exceed this limit and we set their DataIsTruncated field.
+	MaxBytes int
+
+	// MaxEvents, if positive, is the maximum number of events we keep
+	// until the next Read. We discard the events that would exceed
+	// this limit and account for them in Dropped.
+	MaxEvents int
+
+	conns       map[connKey]*connState
+	dropped     int64
+	mu          sync.Mutex
+	nbytes      int
+	nextID      int64
+	ops         []Event
+	subscribers []subscriber
+}
+
+// connKey identifies a connection for the purpose of coalescing.
+type connKey struct {
+	Address      string
+	LocalAddress string
+	Proto        string
+}
+
+// connState is the coalescing state of a connection.
+type connState struct {
+	// index is the index in ops of the last event of the connection.
+	index int
+
+	// owned indicates whether we own the Data of such event, which
+	// otherwise may belong to the caller of Write.
+	owned bool
+}
+
+type subscriber struct {
+	fn func(Event)
+	id int64
 }
 
 // Read reads and returns events inside the trace. It advances
@@ -15,13 +67,138 @@ func (s *Saver) Read() []Event {
 	defer s.mu.Unlock()
 	v := s.ops
 	s.ops = nil
+	s.conns = nil
+	s.nbytes = 0
 	return v
 }
 
+// Dropped returns the number of events that we have discarded because
+// there were already MaxEvents events in the trace.
+func (s *Saver) Dropped() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Subscribe registers fn to be called with every event written into
+// the trace, including the events that we later coalesce or discard.
+// The function is called synchronously by the goroutine calling Write,
+// so it should return quickly and it must not call Write. The Data of
+// the event may be reused by the caller of Write after fn returns, so
+// you should copy it if you need to retain it. Call the returned function
+// to unsubscribe. Subscribers do not count towards the limits.
+func (s *Saver) Subscribe(fn func(Event)) (unsubscribe func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	id := s.nextID
+	// We copy the subscribers on write so that Write can call them
+	// without holding the mutex and without copying them.
+	subscribers := make([]subscriber, 0, len(s.subscribers)+1)
+	subscribers = append(subscribers, s.subscribers...)
+	s.subscribers = append(subscribers, subscriber{fn: fn, id: id})
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var subscribers []subscriber
+		for _, entry := range s.subscribers {
+			if entry.id != id {
+				subscribers = append(subscribers, entry)
+			}
+		}
+		s.subscribers = subscribers
+	}
+}
+
 // Write adds the given event to the trace. A subsequent call
 // to Read will read this event.
 func (s *Saver) Write(ev Event) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	subscribers := s.subscribers
+	s.save(ev)
+	s.mu.Unlock()
+	for _, entry := range subscribers {
+		entry.fn(ev)
+	}
+}
+
+// save saves the event. This method assumes we're holding the mutex.
+func (s *Saver) save(ev Event) {
+	if s.Coalesce && (ev.Name == "read" || ev.Name == "write") {
+		key := connKey{
+			Address:      ev.Address,
+			LocalAddress: ev.LocalAddress,
+			Proto:        ev.Proto,
+		}
+		if s.coalesce(key, ev) {
+			return
+		}
+		if !s.append(ev) {
+			// We must not merge the next event of the connection
+			// into an event that happened before this one.
+			delete(s.conns, key)
+			return
+		}
+		if s.conns == nil {
+			s.conns = make(map[connKey]*connState)
+		}
+		s.conns[key] = &connState{index: len(s.ops) - 1}
+		return
+	}
+	s.append(ev)
+}
+
+// coalesce merges ev into the last event of the connection, if possible,
+// and returns whether it did. This method assumes we're holding the mutex.
+func (s *Saver) coalesce(key connKey, ev Event) bool {
+	state, found := s.conns[key]
+	if !found || ev.Err != nil {
+		return false
+	}
+	prev := &s.ops[state.index]
+	if prev.Name != ev.Name || prev.Err != nil {
+		return false
+	}
+	data, truncated := s.truncate(ev.Data)
+	if len(data) > 0 {
+		if !state.owned {
+			prev.Data = append([]byte(nil), prev.Data...)
+			state.owned = true
+		}
+		prev.Data = append(prev.Data, data...)
+		s.nbytes += len(data)
+	}
+	prev.DataIsTruncated = prev.DataIsTruncated || truncated
+	prev.Duration += ev.Duration
+	prev.NumBytes += ev.NumBytes
+	prev.Time = ev.Time
+	return true
+}
+
+// append appends ev to the trace, if there is room for it, and returns
+// whether it did. This method assumes we're holding the mutex.
+func (s *Saver) append(ev Event) bool {
+	if s.MaxEvents > 0 && len(s.ops) >= s.MaxEvents {
+		s.dropped++
+		return false
+	}
+	data, truncated := s.truncate(ev.Data)
+	s.nbytes += len(data)
+	ev.Data = data
+	ev.DataIsTruncated = ev.DataIsTruncated || truncated
 	s.ops = append(s.ops, ev)
+	return true
+}
+
+// truncate returns the part of data that fits within MaxBytes and
+// whether it truncated data. This method assumes we're holding the mutex.
+func (s *Saver) truncate(data []byte) ([]byte, bool) {
+	if s.MaxBytes <= 0 || s.nbytes+len(data) <= s.MaxBytes {
+		return data, false
+	}
+	room := s.MaxBytes - s.nbytes
+	if room <= 0 {
+		return nil, true
+	}
+	return data[:room], true
 }
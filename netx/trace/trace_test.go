@@ -1,8 +1,10 @@
 package trace_test
 
 import (
+	"io"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/ooni/probe-engine/netx/trace"
 )
@@ -24,3 +26,103 @@ func TestIntegration(t *testing.T) {
 		t.Fatal("unexpected number of events read")
 	}
 }
+
+func TestUnitSaverCoalesce(t *testing.T) {
+	saver := trace.Saver{Coalesce: true}
+	first := trace.Event{Address: "1.1.1.1:443", LocalAddress: "10.0.0.1:5555", Proto: "tcp"}
+	second := trace.Event{Address: "1.1.1.1:443", LocalAddress: "10.0.0.1:5556", Proto: "tcp"}
+	write := func(conn trace.Event, name, data string, err error) {
+		conn.Data, conn.Duration, conn.Err = []byte(data), time.Second, err
+		conn.Name, conn.NumBytes, conn.Time = name, len(data), time.Now()
+		saver.Write(conn)
+	}
+	write(first, "write", "GET / HTTP/1.1\r\n\r\n", nil)
+	write(first, "read", "HTTP/1.1 200 Ok\r\n", nil)
+	write(second, "read", "abc", nil)
+	write(first, "read", "\r\n", nil)
+	write(first, "read", "", io.EOF)
+	write(first, "write", "GET ", nil)
+	write(first, "write", "/robots.txt", nil)
+	ev := saver.Read()
+	if len(ev) != 5 {
+		t.Fatal("unexpected number of events", len(ev))
+	}
+	if ev[1].Name != "read" || string(ev[1].Data) != "HTTP/1.1 200 Ok\r\n\r\n" {
+		t.Fatal("unexpected coalesced read")
+	}
+	if ev[1].NumBytes != 19 || ev[1].Duration != 2*time.Second {
+		t.Fatal("unexpected coalesced NumBytes or Duration")
+	}
+	if string(ev[2].Data) != "abc" || ev[2].LocalAddress != second.LocalAddress {
+		t.Fatal("merged events of different connections")
+	}
+	if ev[3].Err != io.EOF {
+		t.Fatal("merged an event containing an error")
+	}
+	if ev[4].Name != "write" || string(ev[4].Data) != "GET /robots.txt" {
+		t.Fatal("unexpected coalesced write")
+	}
+}
+
+func TestUnitSaverMaxEvents(t *testing.T) {
+	saver := trace.Saver{MaxEvents: 3}
+	for idx := 0; idx < 5; idx++ {
+		saver.Write(trace.Event{NumBytes: idx})
+	}
+	ev := saver.Read()
+	if len(ev) != 3 || ev[2].NumBytes != 2 {
+		t.Fatal("unexpected events")
+	}
+	if saver.Dropped() != 2 {
+		t.Fatal("unexpected number of dropped events")
+	}
+	saver.Write(trace.Event{})
+	if len(saver.Read()) != 1 {
+		t.Fatal("Read did not make room for new events")
+	}
+}
+
+func TestUnitSaverMaxBytes(t *testing.T) {
+	saver := trace.Saver{Coalesce: true, MaxBytes: 8}
+	saver.Write(trace.Event{Name: "read", Data: []byte("0123"), NumBytes: 4})
+	saver.Write(trace.Event{Name: "read", Data: []byte("456789"), NumBytes: 6})
+	saver.Write(trace.Event{Name: "read", Data: []byte("abc"), NumBytes: 3})
+	saver.Write(trace.Event{Name: "http_response_body_snapshot", Data: []byte("abc")})
+	ev := saver.Read()
+	if len(ev) != 2 {
+		t.Fatal("unexpected number of events")
+	}
+	if string(ev[0].Data) != "01234567" || !ev[0].DataIsTruncated {
+		t.Fatal("unexpected truncated read")
+	}
+	if ev[0].NumBytes != 13 {
+		t.Fatal("NumBytes should not be truncated")
+	}
+	if len(ev[1].Data) != 0 || !ev[1].DataIsTruncated {
+		t.Fatal("unexpected truncated snapshot")
+	}
+}
+
+func TestUnitSaverSubscribe(t *testing.T) {
+	saver := trace.Saver{Coalesce: true, MaxEvents: 1}
+	var received []trace.Event
+	unsubscribe := saver.Subscribe(func(ev trace.Event) {
+		received = append(received, ev)
+	})
+	saver.Write(trace.Event{Name: "read", NumBytes: 1})
+	saver.Write(trace.Event{Name: "read", NumBytes: 2})
+	saver.Write(trace.Event{Name: "write", NumBytes: 3})
+	unsubscribe()
+	saver.Write(trace.Event{Name: "write", NumBytes: 4})
+	if len(received) != 3 {
+		t.Fatal("unexpected number of received events")
+	}
+	for idx, ev := range received {
+		if ev.NumBytes != idx+1 {
+			t.Fatal("subscribers should see the original events")
+		}
+	}
+	if len(saver.Read()) != 1 {
+		t.Fatal("unexpected number of saved events")
+	}
+}
@@ -56,9 +56,6 @@ func (r *runner) hasUnsupportedSettings(logger *chanLogger) (unsupported bool) {
 	if r.settings.InputFilepaths != nil {
 		sadly("InputFilepaths: not supported")
 	}
-	if r.settings.Options.AllEndpoints != nil {
-		sadly("Options.AllEndpoints: not supported")
-	}
 	if r.settings.Options.Backend != "" {
 		sadly("Options.Backend: not supported")
 	}
@@ -219,6 +216,15 @@ func (r *runner) Run(ctx context.Context) {
 		r.emitter.EmitFailureStartup(err.Error())
 		return
 	}
+	if r.settings.Options.AllEndpoints != nil {
+		// Only WhatsApp has this option. Older versions of this library
+		// rejected it as unsupported, so apps may still set it for any
+		// experiment: we just warn rather than failing the run.
+		if err := builder.SetOptionBool("AllEndpoints",
+			*r.settings.Options.AllEndpoints); err != nil {
+			logger.Warn("Options.AllEndpoints: not supported")
+		}
+	}
 
 	if r.settings.Options.BouncerBaseURL != "" {
 		sess.AddAvailableHTTPSBouncer(r.settings.Options.BouncerBaseURL)
@@ -56,9 +56,8 @@ type settingsRecord struct {
 // settingsOptions contains the settings options
 type settingsOptions struct {
 	// AllEndpoints is a WhatsApp specific option indicating that we
-	// should test all endpoints rather than a random susbet. This
-	// library does not support this setting and fails if you provide
-	// it as input.
+	// should test all endpoints rather than a random susbet. Setting
+	// this option for other experiments just emits a warning.
 	AllEndpoints *bool `json:"all_endpoints,omitempty"`
 
 	// Backend is a test helper for a nettest. This
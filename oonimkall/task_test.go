@@ -157,6 +157,47 @@ func TestIntegrationUnsupportedSetting(t *testing.T) {
 	}
 }
 
+func TestIntegrationAllEndpointsWithOtherExperiment(t *testing.T) {
+	task, err := oonimkall.StartTask(`{
+		"assets_dir": "../testdata/oonimkall/assets",
+		"log_level": "DEBUG",
+		"name": "Example",
+		"options": {
+			"all_endpoints": true,
+			"no_bouncer": true,
+			"no_collector": true,
+			"no_geoip": true,
+			"no_resolver_lookup": true,
+			"software_name": "oonimkall-test",
+			"software_version": "0.1.0"
+		},
+		"state_dir": "../testdata/oonimkall/state",
+		"temp_dir": "../testdata/oonimkall/tmp"
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var warned bool
+	for !task.IsDone() {
+		eventstr := task.WaitForNextEvent()
+		var event eventlike
+		if err := json.Unmarshal([]byte(eventstr), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Key == "failure.startup" {
+			t.Fatalf("unexpected failure.startup: %+v", event)
+		}
+		if event.Key == "log" &&
+			event.Value["message"] == "Options.AllEndpoints: not supported" {
+			warned = true
+		}
+		t.Logf("%+v", event)
+	}
+	if !warned {
+		t.Fatal("did not see the warning")
+	}
+}
+
 func TestIntegrationEmptyStateDir(t *testing.T) {
 	task, err := oonimkall.StartTask(`{
 		"assets_dir": "../testdata/oonimkall/assets",
@@ -25,6 +25,17 @@ import (
 	"github.com/ooni/probe-engine/model"
 	"github.com/ooni/probe-engine/netx/bytecounter"
 	"github.com/ooni/probe-engine/netx/httptransport"
+	"github.com/ooni/probe-engine/netx/resolver"
+)
+
+const (
+	// resolverCacheMaxEntries is the maximum number of domains
+	// kept inside the session's persistent DNS cache.
+	resolverCacheMaxEntries = 256
+
+	// resolverCacheNegativeTTL is for how long the session's DNS
+	// cache remembers that a domain does not exist.
+	resolverCacheNegativeTTL = time.Minute
 )
 
 // SessionConfig contains the Session config
@@ -40,6 +51,7 @@ type SessionConfig struct {
 
 // Session is a measurement session
 type Session struct {
+	asnDatabase          *mmdblookup.ASNDatabase
 	assetsDir            string
 	availableBouncers    []model.Service
 	availableCollectors  []model.Service
@@ -52,6 +64,7 @@ type Session struct {
 	logger               model.Logger
 	proxyURL             *url.URL
 	queryBouncerCount    *atomicx.Int64
+	resolverCache        *resolver.CacheResolver
 	softwareName         string
 	softwareVersion      string
 	tempDir              string
@@ -89,11 +102,22 @@ func NewSession(config SessionConfig) (*Session, error) {
 		logger:            config.Logger,
 		proxyURL:          config.ProxyURL,
 		queryBouncerCount: atomicx.NewInt64(),
-		softwareName:      config.SoftwareName,
-		softwareVersion:   config.SoftwareVersion,
-		tempDir:           config.TempDir,
+		resolverCache: &resolver.CacheResolver{
+			KVStore:     config.KVStore,
+			MaxEntries:  resolverCacheMaxEntries,
+			NegativeTTL: resolverCacheNegativeTTL,
+			Resolver:    resolver.SystemResolver{},
+		},
+		softwareName:    config.SoftwareName,
+		softwareVersion: config.SoftwareVersion,
+		tempDir:         config.TempDir,
+	}
+	sess.asnDatabase = &mmdblookup.ASNDatabase{
+		Logger: sess.logger,
+		Path:   sess.ASNDatabasePath(),
 	}
 	sess.httpDefaultTransport = httptransport.New(httptransport.Config{
+		BaseResolver: sess.resolverCache,
 		ByteCounter:  sess.byteCounter,
 		BogonIsError: true,
 		Logger:       sess.logger,
@@ -144,10 +168,17 @@ func (s *Session) CABundlePath() string {
 
 // Close ensures that we close all the idle connections that the HTTP clients
 // we are currently using may have created. Not calling this function may likely
-// cause memory leaks in your application because of open idle connections.
+// cause memory leaks in your application because of open idle connections. This
+// function also persists the session's DNS cache into the KVStore.
 func (s *Session) Close() error {
 	s.httpDefaultTransport.CloseIdleConnections()
+	if err := s.resolverCache.Save(); err != nil {
+		s.logger.Debugf("cannot save the DNS cache: %s", err.Error())
+	}
 	s.tunnel.Stop() // safe if s.tunnel is nil
+	if err := s.asnDatabase.Close(); err != nil {
+		s.logger.Debugf("cannot close the ASN database: %s", err.Error())
+	}
 	return nil
 }
 
@@ -173,6 +204,12 @@ func (s *Session) Logger() model.Logger {
 	return s.logger
 }
 
+// LookupASN maps ip to its ASN using the ASN database. We open the
+// database the first time we need it and close it in s.Close.
+func (s *Session) LookupASN(ip string) (uint, error) {
+	return s.asnDatabase.LookupASN(ip)
+}
+
 // MaybeLookupLocation is a caching location lookup call.
 func (s *Session) MaybeLookupLocation() error {
 	return s.maybeLookupLocation(context.Background())
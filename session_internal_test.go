@@ -8,6 +8,7 @@ import (
 
 func (s *Session) SetAssetsDir(assetsDir string) {
 	s.assetsDir = assetsDir
+	s.asnDatabase.Path = s.ASNDatabasePath()
 }
 
 func (s *Session) FetchResourcesIdempotent(ctx context.Context) error {
@@ -260,6 +260,13 @@ func TestIntegrationSessionDownloadResources(t *testing.T) {
 	if err := readfile(sess.CountryDatabasePath()); err != nil {
 		t.Fatal(err)
 	}
+	asn, err := sess.LookupASN("35.204.49.125")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asn == model.DefaultProbeASN {
+		t.Fatal("expected a nonzero ASN")
+	}
 }
 
 func TestUnitGetAvailableBouncers(t *testing.T) {